@@ -1,11 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/Assada/consul-generator/config"
 	"github.com/Assada/consul-generator/logging"
 	"github.com/Assada/consul-generator/manager"
+	"github.com/Assada/consul-generator/processor"
 	"github.com/Assada/consul-generator/signals"
 	"github.com/Assada/consul-generator/version"
 	"io"
@@ -13,7 +15,9 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -25,11 +29,18 @@ const (
 	ExitCodeParseFlagsError
 	ExitCodeRunnerError
 	ExitCodeConfigError
+	_ // manager.ExitCodeStartupDeadline
+	ExitCodeSelfTestError
+	_ // processor.ExitCodePreflight
+	_ // processor.ExitCodeMissingPrefix
+	_ // processor.ExitCodeAuth
+	_ // processor.ExitCodeUnsafePath
 )
 
 type Cli struct {
 	sync.Mutex
 
+	inStream             io.Reader
 	outStream, errStream io.Writer
 
 	signalCh chan os.Signal
@@ -41,6 +52,7 @@ type Cli struct {
 
 func NewCli(out, err io.Writer) *Cli {
 	return &Cli{
+		inStream:  os.Stdin,
 		outStream: out,
 		errStream: err,
 		signalCh:  make(chan os.Signal, 1),
@@ -54,6 +66,9 @@ func (service *Cli) setup(conf *config.Config) (*config.Config, error) {
 		Level:          config.StringVal(conf.LogLevel),
 		Syslog:         config.BoolVal(conf.Syslog.Enabled),
 		SyslogFacility: config.StringVal(conf.Syslog.Facility),
+		FilePath:       config.StringVal(conf.LogFile.Path),
+		MaxSizeMB:      config.IntVal(conf.LogFile.MaxSizeMB),
+		MaxBackups:     config.IntVal(conf.LogFile.MaxBackups),
 		Writer:         service.errStream,
 	}); err != nil {
 		return nil, err
@@ -63,7 +78,7 @@ func (service *Cli) setup(conf *config.Config) (*config.Config, error) {
 }
 
 func (cli *Cli) Run(args []string) int {
-	config, paths, once, dry, isVersion, err := cli.ParseFlags(args[1:])
+	config, paths, once, dry, isValidate, isPrintConfig, isVersion, err := cli.ParseFlags(args[1:])
 	if err != nil {
 		if err == flag.ErrHelp {
 			fmt.Fprintf(cli.errStream, usage, version.Name)
@@ -95,13 +110,60 @@ func (cli *Cli) Run(args []string) int {
 		return ExitCodeOK
 	}
 
+	if isPrintConfig {
+		enc := json.NewEncoder(cli.outStream)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(config.Redacted()); err != nil {
+			return logError(err, ExitCodeConfigError)
+		}
+		return ExitCodeOK
+	}
+
+	if isValidate {
+		if err := processor.Validate(config); err != nil {
+			return logError(err, ExitCodeConfigError)
+		}
+		fmt.Fprintf(cli.errStream, "Configuration is valid\n")
+		return ExitCodeOK
+	}
+
+	if *config.SelfTest {
+		if err := processor.SelfTest(config); err != nil {
+			return logError(err, ExitCodeSelfTestError)
+		}
+		fmt.Fprintf(cli.errStream, "Self-test passed\n")
+		return ExitCodeOK
+	}
+
+	if *config.DetailedExitCode && !dry {
+		return logError(fmt.Errorf("cli: -detailed-exitcode requires -dry"), ExitCodeConfigError)
+	}
+
+	if *config.Interactive {
+		if !dry {
+			return logError(fmt.Errorf("cli: -interactive requires -dry"), ExitCodeConfigError)
+		}
+
+		in := cli.inStream
+		if !isTerminal(in) {
+			log.Printf("[WARN] (cli) stdin is not a terminal, declining to apply")
+			in = strings.NewReader("")
+		}
+		if err := processor.Interactive(config, in, cli.outStream); err != nil {
+			return logError(err, ExitCodeRunnerError)
+		}
+		return ExitCodeOK
+	}
+
 	runner, err := manager.NewRunner(config, dry, once)
 	if err != nil {
 		return logError(err, ExitCodeRunnerError)
 	}
+	runner.SetOutStream(cli.outStream)
 	go runner.Start()
 
-	signal.Notify(cli.signalCh)
+	cli.notifySignals(config)
 
 	for {
 		select {
@@ -121,24 +183,35 @@ func (cli *Cli) Run(args []string) int {
 			switch s {
 			case *config.ReloadSignal:
 				fmt.Fprintf(cli.errStream, "Reloading configuration...\n")
-				runner.Stop()
 
 				config, err = loadConfigs(paths, cliConfig)
 				if err != nil {
+					runner.Stop()
 					return logError(err, ExitCodeConfigError)
 				}
 				config.Finalize()
 
 				config, err = cli.setup(config)
 				if err != nil {
+					runner.Stop()
 					return logError(err, ExitCodeConfigError)
 				}
 
-				runner, err = manager.NewRunner(config, dry, once)
-				if err != nil {
-					return logError(err, ExitCodeRunnerError)
+				// The runner keeps its Consul connection unless the new
+				// config connects differently.
+				if err := runner.Reload(config); err != nil {
+					runner.Stop()
+					return logError(err, ExitCodeConfigError)
+				}
+				cli.notifySignals(config)
+			case *config.PauseSignal:
+				if runner.Paused() {
+					fmt.Fprintf(cli.errStream, "Resuming...\n")
+					runner.Resume()
+				} else {
+					fmt.Fprintf(cli.errStream, "Pausing...\n")
+					runner.Pause()
 				}
-				go runner.Start()
 			case *config.KillSignal:
 				fmt.Fprintf(cli.errStream, "Cleaning up...\n")
 				runner.Stop()
@@ -153,6 +226,23 @@ func (cli *Cli) Run(args []string) int {
 	}
 }
 
+// notifySignals registers the signals Run handles: the configured reload,
+// pause and kill signals, SIGINT and SIGTERM. Registering every signal would
+// also deliver the SIGCHLD of each command and value_filter child, and the
+// SIGURG the runtime preempts goroutines with, which Run takes for a
+// shutdown.
+func (cli *Cli) notifySignals(c *config.Config) {
+	sigs := []os.Signal{os.Interrupt, syscall.SIGTERM}
+	for _, s := range []*os.Signal{c.ReloadSignal, c.PauseSignal, c.KillSignal} {
+		if s != nil && *s != nil && *s != signals.SIGNIL {
+			sigs = append(sigs, *s)
+		}
+	}
+
+	signal.Stop(cli.signalCh)
+	signal.Notify(cli.signalCh, sigs...)
+}
+
 func (cli *Cli) stop() {
 	cli.Lock()
 	defer cli.Unlock()
@@ -165,15 +255,15 @@ func (cli *Cli) stop() {
 	cli.stopped = true
 }
 
-func (cli *Cli) ParseFlags(args []string) (*config.Config, []string, bool, bool, bool, error) {
-	var dry, once, isVersion bool
+func (cli *Cli) ParseFlags(args []string) (*config.Config, []string, bool, bool, bool, bool, bool, error) {
+	var dry, once, isValidate, isPrintConfig, isVersion bool
 
 	c := config.DefaultConfig()
 
 	if s := os.Getenv("CT_LOCAL_CONFIG"); s != "" {
 		envConfig, err := config.Parse(s)
 		if err != nil {
-			return nil, nil, false, false, false, err
+			return nil, nil, false, false, false, false, false, err
 		}
 		c = c.Merge(envConfig)
 	}
@@ -199,10 +289,48 @@ func (cli *Cli) ParseFlags(args []string) (*config.Config, []string, bool, bool,
 		return nil
 	}), "to", "")
 
-	flags.Var((funcIntVar)(func(s int) error {
+	flags.Var((funcVar)(func(s string) error {
+		c.Archive = config.String(s)
+		return nil
+	}), "archive", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		c.Filter = config.String(s)
+		return nil
+	}), "filter", "")
+
+	flags.Var((funcIntVar)(func(i int) error {
+		c.Concurrency = config.Int(i)
+		return nil
+	}), "concurrency", "")
+
+	interval := (funcIntVar)(func(s int) error {
 		c.Interval = config.TimeDuration(time.Duration(s) * time.Second)
 		return nil
-	}), "interval", "")
+	})
+	flags.Var(interval, "interval", "")
+	// -seconds is the name the flag had in the first releases.
+	flags.Var(interval, "seconds", "")
+
+	flags.Var((funcDurationVar)(func(d time.Duration) error {
+		c.IntervalJitter = config.TimeDuration(d)
+		return nil
+	}), "interval-jitter", "")
+
+	flags.Var((funcIntVar)(func(i int) error {
+		c.MaxPasses = config.Int(i)
+		return nil
+	}), "max-passes", "")
+
+	flags.Var((funcDurationVar)(func(d time.Duration) error {
+		c.WriteThrottle = config.TimeDuration(d)
+		return nil
+	}), "write-throttle", "")
+
+	flags.Var((funcDurationVar)(func(d time.Duration) error {
+		c.StartupDeadline = config.TimeDuration(d)
+		return nil
+	}), "startup-deadline", "")
 
 	flags.Var((funcVar)(func(s string) error {
 		c.Consul.Address = config.String(s)
@@ -238,6 +366,11 @@ func (cli *Cli) ParseFlags(args []string) (*config.Config, []string, bool, bool,
 		return nil
 	}), "consul-retry-max-backoff", "")
 
+	flags.Var((funcDurationVar)(func(d time.Duration) error {
+		c.Consul.Retry.MaxDuration = config.TimeDuration(d)
+		return nil
+	}), "consul-retry-max-duration", "")
+
 	flags.Var((funcBoolVar)(func(b bool) error {
 		c.Consul.SSL.Enabled = config.Bool(b)
 		return nil
@@ -278,6 +411,16 @@ func (cli *Cli) ParseFlags(args []string) (*config.Config, []string, bool, bool,
 		return nil
 	}), "consul-token", "")
 
+	flags.Var((funcVar)(func(s string) error {
+		c.Consul.Datacenter = config.String(s)
+		return nil
+	}), "consul-datacenter", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		c.Consul.Namespace = config.String(s)
+		return nil
+	}), "consul-namespace", "")
+
 	flags.Var((funcDurationVar)(func(d time.Duration) error {
 		c.Consul.Transport.DialKeepAlive = config.TimeDuration(d)
 		return nil
@@ -317,14 +460,88 @@ func (cli *Cli) ParseFlags(args []string) (*config.Config, []string, bool, bool,
 		return nil
 	}), "log-level", "")
 
+	flags.Var((funcVar)(func(s string) error {
+		c.LogFile.Path = config.String(s)
+		return nil
+	}), "log-file", "")
+
+	flags.Var((funcIntVar)(func(i int) error {
+		c.LogFile.MaxSizeMB = config.Int(i)
+		return nil
+	}), "log-file-max-size", "")
+
+	flags.Var((funcIntVar)(func(i int) error {
+		c.LogFile.MaxBackups = config.Int(i)
+		return nil
+	}), "log-file-max-backups", "")
+
 	flags.BoolVar(&once, "once", false, "")
 	flags.BoolVar(&dry, "dry", false, "")
 
+	flags.Var((funcVar)(func(s string) error {
+		c.Commands = &config.CommandConfigs{{Exec: config.String(s)}}
+		return nil
+	}), "exec", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.Explain = config.Bool(b)
+		return nil
+	}), "explain", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.Interactive = config.Bool(b)
+		return nil
+	}), "interactive", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.DetailedExitCode = config.Bool(b)
+		return nil
+	}), "detailed-exitcode", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.Single = config.Bool(b)
+		return nil
+	}), "single", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.Stdout = config.Bool(b)
+		return nil
+	}), "stdout", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		c.Separator = config.String(s)
+		return nil
+	}), "separator", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		sig, err := signals.Parse(s)
+		if err != nil {
+			return err
+		}
+		c.PauseSignal = config.Signal(sig)
+		return nil
+	}), "pause-signal", "")
+
 	flags.Var((funcVar)(func(s string) error {
 		c.PidFile = config.String(s)
 		return nil
 	}), "pid-file", "")
 
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.Preflight = config.Bool(b)
+		return nil
+	}), "preflight", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.PreserveStructure = config.Bool(b)
+		return nil
+	}), "preserve-structure", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.Push = config.Bool(b)
+		return nil
+	}), "push", "")
+
 	flags.Var((funcVar)(func(s string) error {
 		sig, err := signals.Parse(s)
 		if err != nil {
@@ -334,6 +551,16 @@ func (cli *Cli) ParseFlags(args []string) (*config.Config, []string, bool, bool,
 		return nil
 	}), "reload-signal", "")
 
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.SelfTest = config.Bool(b)
+		return nil
+	}), "self-test", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		c.SelfTestPrefix = config.String(s)
+		return nil
+	}), "self-test-prefix", "")
+
 	flags.Var((funcBoolVar)(func(b bool) error {
 		c.Syslog.Enabled = config.Bool(b)
 		return nil
@@ -344,19 +571,23 @@ func (cli *Cli) ParseFlags(args []string) (*config.Config, []string, bool, bool,
 		return nil
 	}), "syslog-facility", "")
 
+	flags.BoolVar(&isValidate, "validate", false, "")
+
+	flags.BoolVar(&isPrintConfig, "print-config", false, "")
+
 	flags.BoolVar(&isVersion, "v", false, "")
 	flags.BoolVar(&isVersion, "version", false, "")
 
 	if err := flags.Parse(args); err != nil {
-		return nil, nil, false, false, false, err
+		return nil, nil, false, false, false, false, false, err
 	}
 
 	args = flags.Args()
 	if len(args) > 0 {
-		return nil, nil, false, false, false, fmt.Errorf("cli: extra args: %q", args)
+		return nil, nil, false, false, false, false, false, fmt.Errorf("cli: extra args: %q", args)
 	}
 
-	return c, configPaths, once, dry, isVersion, nil
+	return c, configPaths, once, dry, isValidate, isPrintConfig, isVersion, nil
 }
 
 func loadConfigs(paths []string, o *config.Config) (*config.Config, error) {
@@ -376,6 +607,16 @@ func loadConfigs(paths []string, o *config.Config) (*config.Config, error) {
 	return finalC, nil
 }
 
+// isTerminal reports whether r is a character device such as a terminal.
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
 func logError(err error, status int) int {
 	log.Printf("[ERR] (cli) %s", err)
 	return status
@@ -402,6 +643,12 @@ Options:
       Set the basic authentication username and password for communicating
       with Consul.
 
+  -consul-datacenter=<string>
+      Sets the Consul datacenter to read from instead of the agent's
+
+  -consul-namespace=<string>
+      Sets the Consul Enterprise namespace to read from
+
   -consul-retry
       Use retry logic when communication with Consul fails
 
@@ -416,6 +663,10 @@ Options:
       The maximum limit of the retry backoff duration. Default is one minute.
       0 means infinite. The backoff will increase exponentially until given value.
 
+  -consul-retry-max-duration=<duration>
+      Stop retrying once this much time has passed since the first retry,
+      even if attempts are left. Default is 0 (only attempts count).
+
   -consul-ssl
       Use SSL when connecting to Consul
 
@@ -455,9 +706,28 @@ Options:
   -consul-transport-tls-handshake-timeout=<duration>
       Sets the handshake timeout
 
+  -concurrency=<int>
+      Compare and write up to this many keys in parallel. Defaults to 1
+
   -dry
       Print generated files to stdout instead of persist
 
+  -detailed-exitcode
+      With -dry, exit with 2 instead of 0 when at least one file would be
+      written or removed
+
+  -exec=<command>
+      Run the given shell command after every pass that changed at least
+      one file, e.g. "nginx -s reload". A non-zero exit stops the process
+
+  -explain
+      Print why each key was written, skipped or deferred to stdout on
+      every pass
+
+  -interactive
+      Together with -dry, run a single pass, show the planned writes and ask
+      whether to apply them. Declines when stdin is not a terminal
+
   -once
       Do not run the process as a daemon
 
@@ -467,6 +737,22 @@ Options:
   -log-level=<level>
       Set the logging level - values are "debug", "info", "warn", and "err"
 
+  -log-file=<path>
+      Also write the log to this file, rotating it once it grows past
+      -log-file-max-size megabytes (100 by default) and keeping
+      -log-file-max-backups rotated files (3 by default)
+
+  -log-file-max-size=<mb>
+      Size in megabytes after which the log file is rotated
+
+  -log-file-max-backups=<count>
+      Number of rotated log files kept, 0 keeps none
+
+  -pause-signal=<signal>
+      Signal to listen to toggle pausing writes. While paused the process
+      keeps running but skips every pass, resuming triggers an immediate pass.
+      Defaults to SIGUSR1
+
   -pid-file=<path>
       Path on disk to write the PID of the process
 
@@ -476,12 +762,70 @@ Options:
   -to=<path>
       Path on disk to write generated files
 
+  -single
+      Read only the key -from and write its value as it is to the file -to
+
+  -stdout
+      Write the values of all keys to stdout instead of files, each after
+      a -separator line naming its key
+
+  -separator=<format>
+      The line written before each value with -stdout, where {key} is
+      replaced by the key. Defaults to "# key: {key}"
+
+  -preserve-structure
+      Recreate the key hierarchy below -from as subdirectories of -to
+      instead of writing every key by its last segment
+
+  -archive=<path>
+      Write all files into a single archive instead of the -to directory.
+      A .zip extension produces a zip file, anything else a tar.gz
+
+  -filter=<expression>
+      Only sync keys matching a Consul filter expression, for example
+      'Key matches "\\.conf$" and Value is not empty'
+
   -interval=<int>
-      Key update rate interval 
+      Key update rate interval in seconds, must be positive. -seconds is an
+      alias
+
+  -interval-jitter=<duration>
+      Randomize each poll to fire at interval +/- the given duration
+
+  -max-passes=<int>
+      Exit cleanly after the given number of passes, failed passes included.
+      Works together with -dry. Defaults to 0 (run forever)
+
+  -write-throttle=<duration>
+      Wait the given duration between individual file writes to avoid
+      flooding file watchers downstream. Defaults to 0 (no delay)
+
+  -startup-deadline=<duration>
+      Exit with code 16 if no pass has written the keys within the given
+      duration after start, e.g. when Consul is unreachable or the prefix is
+      empty. Defaults to 0 (wait forever)
+
+  -preflight
+      Check before the first pass that the Consul token can read -from, and
+      write below it in push mode. Exits with code 18 when it cannot
+
+  -push
+      Reverse the sync direction: write files found in -to into Consul keys
+      under -from. This overwrites data in Consul, combine with -dry to
+      preview the changes first
 
   -reload-signal=<signal>
       Signal to listen to reload configuration
 
+  -self-test
+      Write a probe key under -self-test-prefix, run a single pass into a
+      temporary directory, verify the probe file and remove the key again.
+      Exits non-zero when any step fails. This writes to Consul
+
+  -self-test-prefix=<path>
+      Consul path the -self-test probe key is written under. Required for
+      -self-test
+
   -syslog
       Send the output to syslog instead of standard error and standard out. The
       syslog facility defaults to LOCAL0 and can be changed using a
@@ -493,4 +837,14 @@ Options:
 
   -v, -version
       Print the version of this daemon
+
+  -validate
+      Load and check the configuration and that the destination is writable,
+      then exit without connecting to Consul. Exits non-zero when the
+      configuration is invalid
+
+  -print-config
+      Print the effective configuration as JSON, after merging the config
+      files, the environment and the flags, and exit. Tokens, passwords and
+      private keys are redacted
 `
@@ -1,16 +1,24 @@
 package main
 
 import (
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
 
 	"github.com/Assada/consul-generator/config"
+	"github.com/Assada/consul-generator/manager"
+	"github.com/Assada/consul-generator/processor"
 	"github.com/Assada/consul-generator/test"
 	gatedio "github.com/hashicorp/go-gatedio"
 )
@@ -113,6 +121,18 @@ func TestCLI_ParseFlags(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"consul-retry-max-duration",
+			[]string{"-consul-retry-max-duration", "5m"},
+			&config.Config{
+				Consul: &config.ConsulConfig{
+					Retry: &config.RetryConfig{
+						MaxDuration: config.TimeDuration(5 * time.Minute),
+					},
+				},
+			},
+			false,
+		},
 		{
 			"consul-retry-max-backoff",
 			[]string{"-consul-retry-max-backoff", "60s"},
@@ -279,6 +299,50 @@ func TestCLI_ParseFlags(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"consul-datacenter",
+			[]string{"-consul-datacenter", "dc2"},
+			&config.Config{
+				Consul: &config.ConsulConfig{
+					Datacenter: config.String("dc2"),
+				},
+			},
+			false,
+		},
+		{
+			"consul-namespace",
+			[]string{"-consul-namespace", "team-a"},
+			&config.Config{
+				Consul: &config.ConsulConfig{
+					Namespace: config.String("team-a"),
+				},
+			},
+			false,
+		},
+		{
+			"interval",
+			[]string{"-interval", "5"},
+			&config.Config{
+				Interval: config.TimeDuration(5 * time.Second),
+			},
+			false,
+		},
+		{
+			"seconds",
+			[]string{"-seconds", "5"},
+			&config.Config{
+				Interval: config.TimeDuration(5 * time.Second),
+			},
+			false,
+		},
+		{
+			"interval-jitter",
+			[]string{"-interval-jitter", "2s"},
+			&config.Config{
+				IntervalJitter: config.TimeDuration(2 * time.Second),
+			},
+			false,
+		},
 		{
 			"kill-signal",
 			[]string{"-kill-signal", "SIGUSR1"},
@@ -295,6 +359,46 @@ func TestCLI_ParseFlags(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"pause-signal",
+			[]string{"-pause-signal", "SIGUSR2"},
+			&config.Config{
+				PauseSignal: config.Signal(syscall.SIGUSR2),
+			},
+			false,
+		},
+		{
+			"concurrency",
+			[]string{"-concurrency", "8"},
+			&config.Config{
+				Concurrency: config.Int(8),
+			},
+			false,
+		},
+		{
+			"exec",
+			[]string{"-exec", "nginx -s reload"},
+			&config.Config{
+				Commands: &config.CommandConfigs{{Exec: config.String("nginx -s reload")}},
+			},
+			false,
+		},
+		{
+			"archive",
+			[]string{"-archive", "/tmp/bundle.tar.gz"},
+			&config.Config{
+				Archive: config.String("/tmp/bundle.tar.gz"),
+			},
+			false,
+		},
+		{
+			"filter",
+			[]string{"-filter", `Key contains "db"`},
+			&config.Config{
+				Filter: config.String(`Key contains "db"`),
+			},
+			false,
+		},
 		{
 			"pid-file",
 			[]string{"-pid-file", "/var/pid/file"},
@@ -303,6 +407,97 @@ func TestCLI_ParseFlags(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"max-passes",
+			[]string{"-max-passes", "3"},
+			&config.Config{
+				MaxPasses: config.Int(3),
+			},
+			false,
+		},
+		{
+			"write-throttle",
+			[]string{"-write-throttle", "50ms"},
+			&config.Config{
+				WriteThrottle: config.TimeDuration(50 * time.Millisecond),
+			},
+			false,
+		},
+		{
+			"startup-deadline",
+			[]string{"-startup-deadline", "30s"},
+			&config.Config{
+				StartupDeadline: config.TimeDuration(30 * time.Second),
+			},
+			false,
+		},
+		{
+			"explain",
+			[]string{"-explain"},
+			&config.Config{
+				Explain: config.Bool(true),
+			},
+			false,
+		},
+		{
+			"detailed-exitcode",
+			[]string{"-detailed-exitcode"},
+			&config.Config{
+				DetailedExitCode: config.Bool(true),
+			},
+			false,
+		},
+		{
+			"single",
+			[]string{"-single", "-from", "app/db", "-to", "/etc/app/db.ini"},
+			&config.Config{
+				Single: config.Bool(true),
+				From:   config.String("app/db"),
+				To:     config.String("/etc/app/db.ini"),
+			},
+			false,
+		},
+		{
+			"stdout",
+			[]string{"-stdout", "-separator", "==> {key}"},
+			&config.Config{
+				Stdout:    config.Bool(true),
+				Separator: config.String("==> {key}"),
+			},
+			false,
+		},
+		{
+			"interactive",
+			[]string{"-interactive"},
+			&config.Config{
+				Interactive: config.Bool(true),
+			},
+			false,
+		},
+		{
+			"preflight",
+			[]string{"-preflight"},
+			&config.Config{
+				Preflight: config.Bool(true),
+			},
+			false,
+		},
+		{
+			"preserve-structure",
+			[]string{"-preserve-structure"},
+			&config.Config{
+				PreserveStructure: config.Bool(true),
+			},
+			false,
+		},
+		{
+			"push",
+			[]string{"-push"},
+			&config.Config{
+				Push: config.Bool(true),
+			},
+			false,
+		},
 		{
 			"reload-signal",
 			[]string{"-reload-signal", "SIGUSR1"},
@@ -311,6 +506,27 @@ func TestCLI_ParseFlags(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"self-test",
+			[]string{"-self-test", "-self-test-prefix", "smoke/"},
+			&config.Config{
+				SelfTest:       config.Bool(true),
+				SelfTestPrefix: config.String("smoke/"),
+			},
+			false,
+		},
+		{
+			"log-file",
+			[]string{"-log-file", "/var/log/consul-generator.log", "-log-file-max-size", "10", "-log-file-max-backups", "2"},
+			&config.Config{
+				LogFile: &config.LogFileConfig{
+					Path:       config.String("/var/log/consul-generator.log"),
+					MaxSizeMB:  config.Int(10),
+					MaxBackups: config.Int(2),
+				},
+			},
+			false,
+		},
 		{
 			"syslog",
 			[]string{"-syslog"},
@@ -338,7 +554,7 @@ func TestCLI_ParseFlags(t *testing.T) {
 			out := gatedio.NewByteBuffer()
 			cli := NewCli(out, out)
 
-			a, _, _, _, _, err := cli.ParseFlags(tc.f)
+			a, _, _, _, _, _, _, err := cli.ParseFlags(tc.f)
 			if (err != nil) != tc.err {
 				t.Fatal(err)
 			}
@@ -357,6 +573,21 @@ func TestCLI_ParseFlags(t *testing.T) {
 func TestCLI_Run(t *testing.T) {
 	t.Parallel()
 
+	authConfig, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(authConfig.Name())
+	if _, err := authConfig.WriteString(`consul {
+		auth_method {
+			enabled           = true
+			name              = "kubernetes"
+			bearer_token_file = "/nonexistent/token"
+		}
+	}`); err != nil {
+		t.Fatal(err)
+	}
+
 	cases := []struct {
 		name string
 		args []string
@@ -380,6 +611,90 @@ func TestCLI_Run(t *testing.T) {
 				}
 			},
 		},
+		{
+			"interactive_without_dry",
+			[]string{"-interactive"},
+			func(t *testing.T, i int, s string) {
+				if i != ExitCodeConfigError {
+					t.Errorf("expected exit code %d, got %d", ExitCodeConfigError, i)
+				}
+				if !strings.Contains(s, "-interactive requires -dry") {
+					t.Errorf("\nexp: %q\nact: %q", "-interactive requires -dry", s)
+				}
+			},
+		},
+		{
+			"detailed_exitcode_without_dry",
+			[]string{"-detailed-exitcode"},
+			func(t *testing.T, i int, s string) {
+				if i != ExitCodeConfigError {
+					t.Errorf("expected exit code %d, got %d", ExitCodeConfigError, i)
+				}
+				if !strings.Contains(s, "-detailed-exitcode requires -dry") {
+					t.Errorf("\nexp: %q\nact: %q", "-detailed-exitcode requires -dry", s)
+				}
+			},
+		},
+		{
+			"validate",
+			[]string{"-validate", "-to", os.TempDir()},
+			func(t *testing.T, i int, s string) {
+				if i != ExitCodeOK {
+					t.Errorf("expected exit code %d, got %d: %s", ExitCodeOK, i, s)
+				}
+				if !strings.Contains(s, "Configuration is valid") {
+					t.Errorf("\nexp: %q\nact: %q", "Configuration is valid", s)
+				}
+			},
+		},
+		{
+			"print_config",
+			[]string{"-print-config", "-from", "app/", "-consul-token", "s3cr3t"},
+			func(t *testing.T, i int, s string) {
+				if i != ExitCodeOK {
+					t.Errorf("expected exit code %d, got %d: %s", ExitCodeOK, i, s)
+				}
+				if !strings.Contains(s, `"From": "app/"`) {
+					t.Errorf("expected the effective from, got:\n%s", s)
+				}
+				if strings.Contains(s, "s3cr3t") || !strings.Contains(s, `"Token": "***"`) {
+					t.Errorf("expected the token to be redacted, got:\n%s", s)
+				}
+			},
+		},
+		{
+			"validate_invalid",
+			[]string{"-validate", "-to", os.TempDir(), "-concurrency", "0"},
+			func(t *testing.T, i int, s string) {
+				if i != ExitCodeConfigError {
+					t.Errorf("expected exit code %d, got %d", ExitCodeConfigError, i)
+				}
+				if !strings.Contains(s, "concurrency must be at least 1") {
+					t.Errorf("\nexp: %q\nact: %q", "concurrency must be at least 1", s)
+				}
+			},
+		},
+		{
+			"validate_missing_config",
+			[]string{"-validate", "-config", "/nonexistent/consul-generator.hcl"},
+			func(t *testing.T, i int, s string) {
+				if i != ExitCodeConfigError {
+					t.Errorf("expected exit code %d, got %d", ExitCodeConfigError, i)
+				}
+			},
+		},
+		{
+			"auth_failure",
+			[]string{"-once", "-to", os.TempDir(), "-config", authConfig.Name()},
+			func(t *testing.T, i int, s string) {
+				if i != processor.ExitCodeAuth {
+					t.Errorf("expected exit code %d, got %d: %s", processor.ExitCodeAuth, i, s)
+				}
+				if !strings.Contains(s, "login failed") {
+					t.Errorf("\nexp: %q\nact: %q", "login failed", s)
+				}
+			},
+		},
 		{
 			"too_many_args",
 			[]string{"foo", "bar", "baz"},
@@ -501,3 +816,135 @@ func TestCLI_Run(t *testing.T) {
 		}
 	})
 }
+
+func TestCLI_startupDeadline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := gatedio.NewByteBuffer()
+	cli := NewCli(out, out)
+	defer cli.stop()
+
+	// Nothing listens on port 1, so the first pass, a second after start,
+	// keeps retrying its List with the default consul.retry for minutes.
+	start := time.Now()
+	ch := make(chan int, 1)
+	go func() {
+		ch <- cli.Run([]string{"consul-generator",
+			"-consul-addr", "127.0.0.1:1",
+			"-to", dir,
+			"-startup-deadline", "1500ms",
+		})
+	}()
+
+	select {
+	case status := <-ch:
+		if status != manager.ExitCodeStartupDeadline {
+			t.Errorf("expected exit code %d, got %d: %s", manager.ExitCodeStartupDeadline, status, out.String())
+		}
+		if d := time.Since(start); d > 2500*time.Millisecond {
+			t.Errorf("expected to exit right at the deadline, took %s", d)
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("timeout: %q", out.String())
+	}
+}
+
+// TestCLI_children runs passes that start child processes. Their SIGCHLD
+// must not be taken for a signal to stop on.
+func TestCLI_children(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   string
+		filtered bool
+	}{
+		{
+			"command",
+			`command = "true"`,
+			false,
+		},
+		{
+			"value_filter",
+			`value_filter {
+				pattern = "*"
+				command = ["tr", "0-9", "a-j"]
+			}`,
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			// Every pass lists a new value, so every pass writes and runs
+			// its children.
+			var passes int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&passes, 1)
+				w.Header().Set("X-Consul-Index", strconv.Itoa(int(n)))
+				fmt.Fprintf(w, `[{"Key":"app/a","Value":%q}]`, base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(int(n)))))
+			}))
+			defer ts.Close()
+
+			configFile := filepath.Join(dir, "config.hcl")
+			if err := ioutil.WriteFile(configFile, []byte(tc.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+			to := filepath.Join(dir, "to")
+			if err := os.Mkdir(to, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			out := gatedio.NewByteBuffer()
+			cli := NewCli(out, out)
+			defer cli.stop()
+
+			ch := make(chan int, 1)
+			go func() {
+				ch <- cli.Run([]string{"consul-generator",
+					"-consul-addr", strings.TrimPrefix(ts.URL, "http://"),
+					"-from", "app/",
+					"-to", to,
+					"-config", configFile,
+				})
+			}()
+
+			// The first child exits during the first pass, so by the third
+			// its SIGCHLD has long been delivered.
+			deadline := time.After(5 * time.Second)
+			for atomic.LoadInt32(&passes) < 3 {
+				select {
+				case status := <-ch:
+					t.Fatalf("expected the daemon to keep running, exited with %d: %s", status, out.String())
+				case <-deadline:
+					t.Fatalf("timeout: %q", out.String())
+				case <-time.After(50 * time.Millisecond):
+				}
+			}
+
+			if tc.filtered {
+				if content, err := ioutil.ReadFile(filepath.Join(to, "a")); err != nil || string(content) == "" || strings.ContainsAny(string(content), "0123456789") {
+					t.Errorf("expected a filtered value, got %q, %v", content, err)
+				}
+			}
+
+			cli.stop()
+			select {
+			case status := <-ch:
+				if status != ExitCodeOK {
+					t.Errorf("\nexp: %#v\nact: %#v", ExitCodeOK, status)
+				}
+			case <-time.After(2 * time.Second):
+				t.Errorf("timeout: %q", out.String())
+			}
+		})
+	}
+}
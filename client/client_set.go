@@ -6,6 +6,7 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,6 +18,7 @@ type ClientSet struct {
 	sync.RWMutex
 
 	consul *consulClient
+	vault  *vaultClient
 }
 
 type consulClient struct {
@@ -24,9 +26,19 @@ type consulClient struct {
 	transport *http.Transport
 }
 
+type vaultClient struct {
+	client    *VaultClient
+	transport *http.Transport
+}
+
 type CreateConsulClientInput struct {
 	Address      string
+	Scheme       string
 	Token        string
+	Headers      map[string]string
+	TokenFunc    func() string
+	Datacenter   string
+	Namespace    string
 	AuthEnabled  bool
 	AuthUsername string
 	AuthPassword string
@@ -34,10 +46,16 @@ type CreateConsulClientInput struct {
 	SSLVerify    bool
 	SSLCert      string
 	SSLKey       string
+	SSLCertPEM   string
+	SSLKeyPEM    string
 	SSLCACert    string
 	SSLCAPath    string
 	ServerName   string
 
+	// ClientCertFunc, when set, supplies the client certificate for every
+	// TLS handshake. A nil certificate falls back to SSLCert.
+	ClientCertFunc func() *tls.Certificate
+
 	TransportDialKeepAlive       time.Duration
 	TransportDialTimeout         time.Duration
 	TransportDisableKeepAlives   bool
@@ -55,6 +73,8 @@ type CreateVaultClientInput struct {
 	SSLVerify   bool
 	SSLCert     string
 	SSLKey      string
+	SSLCertPEM  string
+	SSLKeyPEM   string
 	SSLCACert   string
 	SSLCAPath   string
 	ServerName  string
@@ -108,18 +128,12 @@ func (c *ClientSet) CreateConsulClient(i *CreateConsulClientInput) error {
 
 		var tlsConfig tls.Config
 
-		if i.SSLCert != "" && i.SSLKey != "" {
-			cert, err := tls.LoadX509KeyPair(i.SSLCert, i.SSLKey)
-			if err != nil {
-				return fmt.Errorf("client set: consul: %s", err)
-			}
-			tlsConfig.Certificates = []tls.Certificate{cert}
-		} else if i.SSLCert != "" {
-			cert, err := tls.LoadX509KeyPair(i.SSLCert, i.SSLCert)
-			if err != nil {
-				return fmt.Errorf("client set: consul: %s", err)
-			}
-			tlsConfig.Certificates = []tls.Certificate{cert}
+		cert, err := clientCertificate(i.SSLCert, i.SSLKey, i.SSLCertPEM, i.SSLKeyPEM)
+		if err != nil {
+			return fmt.Errorf("client set: consul: %s", err)
+		}
+		if cert != nil {
+			tlsConfig.Certificates = []tls.Certificate{*cert}
 		}
 
 		if i.SSLCACert != "" || i.SSLCAPath != "" {
@@ -132,6 +146,19 @@ func (c *ClientSet) CreateConsulClient(i *CreateConsulClientInput) error {
 			}
 		}
 
+		if i.ClientCertFunc != nil {
+			static := tlsConfig.Certificates
+			tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				if cert := i.ClientCertFunc(); cert != nil {
+					return cert, nil
+				}
+				if len(static) > 0 {
+					return &static[0], nil
+				}
+				return &tls.Certificate{}, nil
+			}
+		}
+
 		tlsConfig.BuildNameToCertificate()
 
 		if i.ServerName != "" {
@@ -146,7 +173,35 @@ func (c *ClientSet) CreateConsulClient(i *CreateConsulClientInput) error {
 		transport.TLSClientConfig = &tlsConfig
 	}
 
+	if err := consulScheme(consulConfig, i); err != nil {
+		return fmt.Errorf("client set: consul: %s", err)
+	}
+
 	consulConfig.Transport = transport
+	consulConfig.Datacenter = i.Datacenter
+
+	// This API predates namespaces, so the namespace is sent as the header
+	// Consul Enterprise reads it from. An explicit header still wins.
+	headers := i.Headers
+	if i.Namespace != "" {
+		headers = map[string]string{namespaceHeader: i.Namespace}
+		for k, v := range i.Headers {
+			headers[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+
+	if len(headers) > 0 || i.TokenFunc != nil {
+		httpClient, err := consulapi.NewHttpClient(transport, consulConfig.TLSConfig)
+		if err != nil {
+			return fmt.Errorf("client set: consul: %s", err)
+		}
+		httpClient.Transport = &headerTransport{
+			headers: headers,
+			token:   i.TokenFunc,
+			base:    httpClient.Transport,
+		}
+		consulConfig.HttpClient = httpClient
+	}
 
 	client, err := consulapi.NewClient(consulConfig)
 	if err != nil {
@@ -163,12 +218,100 @@ func (c *ClientSet) CreateConsulClient(i *CreateConsulClientInput) error {
 	return nil
 }
 
+func (c *ClientSet) CreateVaultClient(i *CreateVaultClientInput) error {
+	address := i.Address
+	if address == "" {
+		address = "https://127.0.0.1:8200"
+	} else if !strings.Contains(address, "://") {
+		if i.SSLEnabled {
+			address = "https://" + address
+		} else {
+			address = "http://" + address
+		}
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   i.TransportDialTimeout,
+			KeepAlive: i.TransportDialKeepAlive,
+		}).Dial,
+		DisableKeepAlives:   i.TransportDisableKeepAlives,
+		MaxIdleConns:        i.TransportMaxIdleConns,
+		IdleConnTimeout:     i.TransportIdleConnTimeout,
+		MaxIdleConnsPerHost: i.TransportMaxIdleConnsPerHost,
+		TLSHandshakeTimeout: i.TransportTLSHandshakeTimeout,
+	}
+
+	if i.SSLEnabled {
+		var tlsConfig tls.Config
+
+		cert, err := clientCertificate(i.SSLCert, i.SSLKey, i.SSLCertPEM, i.SSLKeyPEM)
+		if err != nil {
+			return fmt.Errorf("client set: vault: %s", err)
+		}
+		if cert != nil {
+			tlsConfig.Certificates = []tls.Certificate{*cert}
+		}
+
+		if i.SSLCACert != "" || i.SSLCAPath != "" {
+			rootConfig := &rootcerts.Config{
+				CAFile: i.SSLCACert,
+				CAPath: i.SSLCAPath,
+			}
+			if err := rootcerts.ConfigureTLS(&tlsConfig, rootConfig); err != nil {
+				return fmt.Errorf("client set: vault configuring TLS failed: %s", err)
+			}
+		}
+
+		tlsConfig.BuildNameToCertificate()
+
+		if i.ServerName != "" {
+			tlsConfig.ServerName = i.ServerName
+			tlsConfig.InsecureSkipVerify = false
+		}
+		if !i.SSLVerify {
+			log.Printf("[WARN] (clients) disabling vault SSL verification")
+			tlsConfig.InsecureSkipVerify = true
+		}
+
+		transport.TLSClientConfig = &tlsConfig
+	}
+
+	client := &VaultClient{
+		address: strings.TrimSuffix(address, "/"),
+		token:   i.Token,
+		http:    &http.Client{Transport: transport},
+	}
+
+	if i.UnwrapToken {
+		if err := client.unwrap(); err != nil {
+			return fmt.Errorf("client set: vault: %s", err)
+		}
+	}
+
+	c.Lock()
+	c.vault = &vaultClient{
+		client:    client,
+		transport: transport,
+	}
+	c.Unlock()
+
+	return nil
+}
+
 func (c *ClientSet) Consul() *consulapi.Client {
 	c.RLock()
 	defer c.RUnlock()
 	return c.consul.client
 }
 
+func (c *ClientSet) Vault() *VaultClient {
+	c.RLock()
+	defer c.RUnlock()
+	return c.vault.client
+}
+
 func (c *ClientSet) Stop() {
 	c.Lock()
 	defer c.Unlock()
@@ -176,4 +319,56 @@ func (c *ClientSet) Stop() {
 	if c.consul != nil {
 		c.consul.transport.CloseIdleConnections()
 	}
+
+	if c.vault != nil {
+		c.vault.transport.CloseIdleConnections()
+	}
+}
+
+// consulScheme applies an explicit scheme. It takes precedence over the
+// scheme derived from SSLEnabled, while a scheme given in the address must
+// agree with it, unless the address is a unix socket.
+// clientCertificate loads the client certificate from the PEM content when
+// given, else from the files. Without a key the certificate content or file
+// must hold the key as well. It returns nil when no certificate is set.
+func clientCertificate(certFile, keyFile, certPEM, keyPEM string) (*tls.Certificate, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case certPEM != "" && keyPEM != "":
+		cert, err = tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	case certPEM != "":
+		cert, err = tls.X509KeyPair([]byte(certPEM), []byte(certPEM))
+	case certFile != "" && keyFile != "":
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+	case certFile != "":
+		cert, err = tls.LoadX509KeyPair(certFile, certFile)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func consulScheme(consulConfig *consulapi.Config, i *CreateConsulClientInput) error {
+	if i.Scheme == "" {
+		return nil
+	}
+
+	if i.Scheme != "http" && i.Scheme != "https" {
+		return fmt.Errorf("invalid scheme %q, must be http or https", i.Scheme)
+	}
+
+	if parts := strings.SplitN(i.Address, "://", 2); len(parts) == 2 && parts[0] != "unix" && parts[0] != i.Scheme {
+		return fmt.Errorf("scheme %q conflicts with address %q", i.Scheme, i.Address)
+	}
+
+	if i.Scheme == "http" && i.SSLEnabled {
+		log.Printf("[WARN] (clients) consul scheme is http, ssl settings are ignored")
+	}
+
+	consulConfig.Scheme = i.Scheme
+	return nil
 }
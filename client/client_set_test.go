@@ -0,0 +1,330 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestCreateConsulClient_headers(t *testing.T) {
+	var got http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	clients := NewClientSet()
+	if err := clients.CreateConsulClient(&CreateConsulClientInput{
+		Address: ts.URL,
+		Token:   "token",
+		Headers: map[string]string{
+			"X-Api-Key":      "abcd",
+			"X-Consul-Token": "ignored",
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer clients.Stop()
+
+	if _, _, err := clients.Consul().KV().Get("foo", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		e    string
+	}{
+		{"X-Api-Key", "abcd"},
+		{"X-Consul-Token", "token"},
+	}
+
+	for _, tc := range cases {
+		if a := got.Get(tc.name); a != tc.e {
+			t.Errorf("%s\nexp: %q\nact: %q", tc.name, tc.e, a)
+		}
+	}
+}
+
+func TestCreateConsulClient_datacenterNamespace(t *testing.T) {
+	var got *http.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	cases := []struct {
+		name      string
+		headers   map[string]string
+		namespace string
+	}{
+		{"namespace", nil, "team-a"},
+		{"explicit_header", map[string]string{"x-consul-namespace": "team-b"}, "team-b"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			clients := NewClientSet()
+			if err := clients.CreateConsulClient(&CreateConsulClientInput{
+				Address:    ts.URL,
+				Headers:    tc.headers,
+				Datacenter: "dc2",
+				Namespace:  "team-a",
+			}); err != nil {
+				t.Fatal(err)
+			}
+			defer clients.Stop()
+
+			if _, _, err := clients.Consul().KV().Get("foo", nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if dc := got.URL.Query().Get("dc"); dc != "dc2" {
+				t.Errorf("expected dc %q, got %q", "dc2", dc)
+			}
+			if ns := got.Header.Get("X-Consul-Namespace"); ns != tc.namespace {
+				t.Errorf("expected namespace %q, got %q", tc.namespace, ns)
+			}
+		})
+	}
+}
+
+func TestCreateConsulClient_tokenFunc(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Consul-Token")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	token := "first"
+	clients := NewClientSet()
+	if err := clients.CreateConsulClient(&CreateConsulClientInput{
+		Address:   ts.URL,
+		TokenFunc: func() string { return token },
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer clients.Stop()
+
+	for _, e := range []string{"first", "second"} {
+		token = e
+		if _, _, err := clients.Consul().KV().Get("foo", nil); err != nil {
+			t.Fatal(err)
+		}
+		if got != e {
+			t.Errorf("\nexp: %q\nact: %q", e, got)
+		}
+	}
+}
+
+func TestConsulScheme(t *testing.T) {
+	cases := []struct {
+		name    string
+		address string
+		scheme  string
+		ssl     bool
+		err     bool
+		exp     string
+	}{
+		{"default", "127.0.0.1:8500", "", false, false, "http"},
+		{"ssl", "127.0.0.1:8500", "", true, false, "https"},
+		{"http_overrides_ssl", "127.0.0.1:8500", "http", true, false, "http"},
+		{"https_without_ssl", "127.0.0.1:8500", "https", false, false, "https"},
+		{"address_agrees", "http://127.0.0.1:8500", "http", false, false, "http"},
+		{"address_conflicts", "http://127.0.0.1:8500", "https", false, true, ""},
+		{"unix_socket", "unix:///var/run/consul.sock", "https", false, false, "https"},
+		{"invalid", "127.0.0.1:8500", "ftp", false, true, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			consulConfig := &consulapi.Config{Scheme: "http"}
+			if tc.ssl {
+				consulConfig.Scheme = "https"
+			}
+
+			err := consulScheme(consulConfig, &CreateConsulClientInput{
+				Address:    tc.address,
+				Scheme:     tc.scheme,
+				SSLEnabled: tc.ssl,
+			})
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error %t, got %v", tc.err, err)
+			}
+			if err == nil && consulConfig.Scheme != tc.exp {
+				t.Errorf("\nexp: %s\nact: %s", tc.exp, consulConfig.Scheme)
+			}
+		})
+	}
+}
+
+func TestCreateVaultClient(t *testing.T) {
+	var methods []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method+" "+r.URL.Path)
+		switch {
+		case r.URL.Path == "/v1/sys/wrapping/unwrap":
+			if r.Header.Get("X-Vault-Token") != "wrapped" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"auth":{"client_token":"token"}}`))
+		case r.Header.Get("X-Vault-Token") != "token":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+		case r.Method == "LIST" && r.URL.Path == "/v1/secret/app":
+			w.Write([]byte(`{"data":{"keys":["db","nested/"]}}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/secret/app/db":
+			w.Write([]byte(`{"data":{"value":"abcd"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	defer ts.Close()
+
+	clients := NewClientSet()
+	if err := clients.CreateVaultClient(&CreateVaultClientInput{
+		Address:     ts.URL,
+		Token:       "wrapped",
+		UnwrapToken: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer clients.Stop()
+	vault := clients.Vault()
+
+	keys, err := vault.List("secret/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := []string{"db", "nested/"}; !reflect.DeepEqual(e, keys) {
+		t.Errorf("expected %q, got %q", e, keys)
+	}
+
+	var data map[string]string
+	if found, err := vault.Read("secret/app/db", &data); err != nil || !found {
+		t.Fatalf("expected secret, got %t, %v", found, err)
+	}
+	if data["value"] != "abcd" {
+		t.Errorf("expected abcd, got %q", data["value"])
+	}
+
+	if found, err := vault.Read("secret/app/missing", &data); err != nil || found {
+		t.Errorf("expected missing secret, got %t, %v", found, err)
+	}
+	if keys, err := vault.List("secret/missing"); err != nil || keys != nil {
+		t.Errorf("expected empty listing, got %q, %v", keys, err)
+	}
+
+	vault.token = "revoked"
+	if _, err := vault.List("secret/app"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("expected permission denied, got %v", err)
+	}
+
+	if methods[0] != "PUT /v1/sys/wrapping/unwrap" {
+		t.Errorf("expected the token to be unwrapped first, got %q", methods)
+	}
+}
+
+// testKeyPair returns a self-signed certificate and its key as PEM.
+func testKeyPair(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "consul-generator"},
+	}, &x509.Certificate{SerialNumber: big.NewInt(1)}, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestClientCertificate(t *testing.T) {
+	certPEM, keyPEM := testKeyPair(t)
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, []byte(certPEM), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, []byte(keyPEM), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name              string
+		certFile, keyFile string
+		certPEM, keyPEM   string
+		cert              bool
+		err               bool
+	}{
+		{"none", "", "", "", "", false, false},
+		{"files", certFile, keyFile, "", "", true, false},
+		{"pem", "", "", certPEM, keyPEM, true, false},
+		{"pem_combined", "", "", certPEM + keyPEM, "", true, false},
+		{"pem_wins", filepath.Join(dir, "missing"), keyFile, certPEM, keyPEM, true, false},
+		{"pem_invalid", certFile, keyFile, "not a certificate", keyPEM, false, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cert, err := clientCertificate(tc.certFile, tc.keyFile, tc.certPEM, tc.keyPEM)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error %t, got %v", tc.err, err)
+			}
+			if (cert != nil) != tc.cert {
+				t.Errorf("expected certificate %t, got %v", tc.cert, cert)
+			}
+		})
+	}
+}
+
+func TestCreateConsulClient_certPEM(t *testing.T) {
+	certPEM, keyPEM := testKeyPair(t)
+
+	clients := NewClientSet()
+	if err := clients.CreateConsulClient(&CreateConsulClientInput{
+		SSLEnabled: true,
+		SSLVerify:  true,
+		SSLCertPEM: certPEM,
+		SSLKeyPEM:  keyPEM,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer clients.Stop()
+
+	if certs := clients.consul.transport.TLSClientConfig.Certificates; len(certs) != 1 {
+		t.Errorf("expected the PEM client certificate, got %d certificates", len(certs))
+	}
+}
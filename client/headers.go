@@ -0,0 +1,35 @@
+package client
+
+import "net/http"
+
+// namespaceHeader selects the Consul Enterprise namespace of a request.
+const namespaceHeader = "X-Consul-Namespace"
+
+type headerTransport struct {
+	headers map[string]string
+	token   func() string
+	base    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+len(t.headers)+1)
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+
+	if t.token != nil && r.Header.Get("X-Consul-Token") == "" {
+		if token := t.token(); token != "" {
+			r.Header.Set("X-Consul-Token", token)
+		}
+	}
+
+	for k, v := range t.headers {
+		if r.Header.Get(k) == "" {
+			r.Header.Set(k, v)
+		}
+	}
+
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// VaultClient talks to the Vault HTTP API with a static token.
+type VaultClient struct {
+	address string
+	token   string
+	http    *http.Client
+}
+
+type vaultResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Auth   *vaultAuth      `json:"auth"`
+	Errors []string        `json:"errors"`
+}
+
+type vaultAuth struct {
+	ClientToken string `json:"client_token"`
+}
+
+// List returns the keys directly below path, folders end with a slash. A
+// path without keys returns nil.
+func (c *VaultClient) List(path string) ([]string, error) {
+	var data struct {
+		Keys []string `json:"keys"`
+	}
+	found, err := c.request("LIST", path, &data)
+	if err != nil || !found {
+		return nil, err
+	}
+	return data.Keys, nil
+}
+
+// Read decodes the data of the secret at path into out and reports whether
+// the secret exists.
+func (c *VaultClient) Read(path string, out interface{}) (bool, error) {
+	return c.request(http.MethodGet, path, out)
+}
+
+func (c *VaultClient) unwrap() error {
+	resp, err := c.do(http.MethodPut, "sys/wrapping/unwrap")
+	if err != nil {
+		return err
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return fmt.Errorf("unwrapped response has no client token")
+	}
+	c.token = resp.Auth.ClientToken
+	return nil
+}
+
+func (c *VaultClient) request(method, path string, out interface{}) (bool, error) {
+	resp, err := c.do(method, path)
+	if err != nil || resp == nil {
+		return false, err
+	}
+	if len(resp.Data) == 0 {
+		return true, nil
+	}
+	if err := json.Unmarshal(resp.Data, out); err != nil {
+		return false, fmt.Errorf("vault: %s %s: %s", method, path, err)
+	}
+	return true, nil
+}
+
+// do returns a nil response for a 404 without errors, which is how Vault
+// reports a missing secret or an empty listing.
+func (c *VaultClient) do(method, path string) (*vaultResponse, error) {
+	u := c.address + "/v1/" + (&url.URL{Path: strings.TrimPrefix(path, "/")}).EscapedPath()
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %s", err)
+	}
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %s", err)
+	}
+	defer res.Body.Close()
+
+	var resp vaultResponse
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 32<<20))
+	if err != nil {
+		return nil, fmt.Errorf("vault: %s %s: %s", method, path, err)
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil, fmt.Errorf("vault: %s %s: unexpected response (%d)", method, path, res.StatusCode)
+		}
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound && len(resp.Errors) == 0:
+		return nil, nil
+	case res.StatusCode >= 400:
+		if len(resp.Errors) == 0 {
+			resp.Errors = []string{http.StatusText(res.StatusCode)}
+		}
+		return nil, fmt.Errorf("vault: %s %s: %s", method, path, strings.Join(resp.Errors, ", "))
+	}
+
+	return &resp, nil
+}
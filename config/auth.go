@@ -110,7 +110,7 @@ func (c *AuthConfig) GoString() string {
 		"}",
 		BoolGoString(c.Enabled),
 		StringGoString(c.Username),
-		StringGoString(c.Password),
+		SecretGoString(c.Password),
 	)
 }
 
@@ -0,0 +1,132 @@
+package config
+
+import "fmt"
+
+const (
+	AuthMethodTypeKubernetes = "kubernetes"
+	AuthMethodTypeJWT        = "jwt"
+
+	DefaultAuthMethodType = AuthMethodTypeKubernetes
+
+	DefaultKubernetesBearerTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+type AuthMethodConfig struct {
+	Enabled         *bool             `mapstructure:"enabled"`
+	Name            *string           `mapstructure:"name"`
+	Type            *string           `mapstructure:"type"`
+	BearerTokenFile *string           `mapstructure:"bearer_token_file"`
+	Meta            map[string]string `mapstructure:"meta"`
+}
+
+func DefaultAuthMethodConfig() *AuthMethodConfig {
+	return &AuthMethodConfig{}
+}
+
+func (c *AuthMethodConfig) Copy() *AuthMethodConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o AuthMethodConfig
+	o.Enabled = c.Enabled
+	o.Name = c.Name
+	o.Type = c.Type
+	o.BearerTokenFile = c.BearerTokenFile
+
+	if c.Meta != nil {
+		o.Meta = make(map[string]string, len(c.Meta))
+		for k, v := range c.Meta {
+			o.Meta[k] = v
+		}
+	}
+
+	return &o
+}
+
+func (c *AuthMethodConfig) Merge(o *AuthMethodConfig) *AuthMethodConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.Name != nil {
+		r.Name = o.Name
+	}
+
+	if o.Type != nil {
+		r.Type = o.Type
+	}
+
+	if o.BearerTokenFile != nil {
+		r.BearerTokenFile = o.BearerTokenFile
+	}
+
+	if o.Meta != nil {
+		if r.Meta == nil {
+			r.Meta = make(map[string]string, len(o.Meta))
+		}
+		for k, v := range o.Meta {
+			r.Meta[k] = v
+		}
+	}
+
+	return r
+}
+
+func (c *AuthMethodConfig) Finalize() {
+	if c.Enabled == nil {
+		c.Enabled = Bool(StringPresent(c.Name))
+	}
+
+	if c.Name == nil {
+		c.Name = String("")
+	}
+
+	if c.Type == nil {
+		c.Type = String(DefaultAuthMethodType)
+	}
+
+	if c.BearerTokenFile == nil {
+		c.BearerTokenFile = String("")
+		if *c.Type == AuthMethodTypeKubernetes {
+			c.BearerTokenFile = String(DefaultKubernetesBearerTokenFile)
+		}
+	}
+
+	if c.Meta == nil {
+		c.Meta = map[string]string{}
+	}
+}
+
+func (c *AuthMethodConfig) GoString() string {
+	if c == nil {
+		return "(*AuthMethodConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&AuthMethodConfig{"+
+		"Enabled:%s, "+
+		"Name:%s, "+
+		"Type:%s, "+
+		"BearerTokenFile:%s, "+
+		"Meta:%v"+
+		"}",
+		BoolGoString(c.Enabled),
+		StringGoString(c.Name),
+		StringGoString(c.Type),
+		StringGoString(c.BearerTokenFile),
+		c.Meta,
+	)
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestAuthMethodConfig_Copy(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *AuthMethodConfig
+	}{
+		{
+			"nil",
+			nil,
+		},
+		{
+			"empty",
+			&AuthMethodConfig{},
+		},
+		{
+			"copy",
+			&AuthMethodConfig{
+				Enabled:         Bool(true),
+				Name:            String("k8s"),
+				Type:            String(AuthMethodTypeKubernetes),
+				BearerTokenFile: String("/token"),
+				Meta:            map[string]string{"pod": "web-1"},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Copy()
+			if !reflect.DeepEqual(tc.a, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.a, r)
+			}
+		})
+	}
+}
+
+func TestAuthMethodConfig_Merge(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *AuthMethodConfig
+		b    *AuthMethodConfig
+		r    *AuthMethodConfig
+	}{
+		{
+			"nil_a",
+			nil,
+			&AuthMethodConfig{},
+			&AuthMethodConfig{},
+		},
+		{
+			"nil_b",
+			&AuthMethodConfig{},
+			nil,
+			&AuthMethodConfig{},
+		},
+		{
+			"name_overrides",
+			&AuthMethodConfig{Name: String("a")},
+			&AuthMethodConfig{Name: String("b")},
+			&AuthMethodConfig{Name: String("b")},
+		},
+		{
+			"meta_merges",
+			&AuthMethodConfig{Meta: map[string]string{"a": "1", "b": "2"}},
+			&AuthMethodConfig{Meta: map[string]string{"b": "3"}},
+			&AuthMethodConfig{Meta: map[string]string{"a": "1", "b": "3"}},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Merge(tc.b)
+			if !reflect.DeepEqual(tc.r, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.r, r)
+			}
+		})
+	}
+}
+
+func TestAuthMethodConfig_Finalize(t *testing.T) {
+	cases := []struct {
+		name string
+		i    *AuthMethodConfig
+		r    *AuthMethodConfig
+	}{
+		{
+			"empty",
+			&AuthMethodConfig{},
+			&AuthMethodConfig{
+				Enabled:         Bool(false),
+				Name:            String(""),
+				Type:            String(AuthMethodTypeKubernetes),
+				BearerTokenFile: String(DefaultKubernetesBearerTokenFile),
+				Meta:            map[string]string{},
+			},
+		},
+		{
+			"jwt",
+			&AuthMethodConfig{
+				Name: String("jwt"),
+				Type: String(AuthMethodTypeJWT),
+			},
+			&AuthMethodConfig{
+				Enabled:         Bool(true),
+				Name:            String("jwt"),
+				Type:            String(AuthMethodTypeJWT),
+				BearerTokenFile: String(""),
+				Meta:            map[string]string{},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			tc.i.Finalize()
+			if !reflect.DeepEqual(tc.r, tc.i) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.r, tc.i)
+			}
+		})
+	}
+}
@@ -278,3 +278,40 @@ func TestAuthConfig_String(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthConfig_GoString(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *AuthConfig
+		r    string
+	}{
+		{
+			"nil",
+			nil,
+			"(*AuthConfig)(nil)",
+		},
+		{
+			"empty_password",
+			&AuthConfig{Password: String("")},
+			`&AuthConfig{Enabled:(*bool)(nil), Username:(*string)(nil), Password:""}`,
+		},
+		{
+			"password",
+			&AuthConfig{
+				Enabled:  Bool(true),
+				Username: String("username"),
+				Password: String("password"),
+			},
+			`&AuthConfig{Enabled:true, Username:"username", Password:"***"}`,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			result := fmt.Sprintf("%#v", tc.a)
+			if tc.r != result {
+				t.Errorf("\nexp: %s\nact: %s", tc.r, result)
+			}
+		})
+	}
+}
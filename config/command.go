@@ -0,0 +1,168 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// CommandConfig is a command run through the shell after a pass that changed
+// files. With a trigger it only runs when one of the changed files matches
+// the glob.
+type CommandConfig struct {
+	Trigger *string `mapstructure:"trigger"`
+	Exec    *string `mapstructure:"exec"`
+}
+
+func DefaultCommandConfig() *CommandConfig {
+	return &CommandConfig{}
+}
+
+func (c *CommandConfig) Copy() *CommandConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o CommandConfig
+
+	o.Trigger = c.Trigger
+
+	o.Exec = c.Exec
+
+	return &o
+}
+
+func (c *CommandConfig) Merge(o *CommandConfig) *CommandConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Trigger != nil {
+		r.Trigger = o.Trigger
+	}
+
+	if o.Exec != nil {
+		r.Exec = o.Exec
+	}
+
+	return r
+}
+
+func (c *CommandConfig) Finalize() {
+	if c.Trigger == nil {
+		c.Trigger = String("")
+	}
+
+	if c.Exec == nil {
+		c.Exec = String("")
+	}
+}
+
+func (c *CommandConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	if StringVal(c.Exec) == "" {
+		return fmt.Errorf("command: missing exec")
+	}
+
+	if _, err := path.Match(StringVal(c.Trigger), ""); err != nil {
+		return fmt.Errorf("command: invalid trigger %q: %s", StringVal(c.Trigger), err)
+	}
+
+	return nil
+}
+
+func (c *CommandConfig) GoString() string {
+	if c == nil {
+		return "(*CommandConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&CommandConfig{"+
+		"Trigger:%s, "+
+		"Exec:%s"+
+		"}",
+		StringGoString(c.Trigger),
+		StringGoString(c.Exec),
+	)
+}
+
+type CommandConfigs []*CommandConfig
+
+func DefaultCommandConfigs() *CommandConfigs {
+	return &CommandConfigs{}
+}
+
+func (c *CommandConfigs) Copy() *CommandConfigs {
+	if c == nil {
+		return nil
+	}
+
+	o := make(CommandConfigs, len(*c))
+	for i, t := range *c {
+		o[i] = t.Copy()
+	}
+	return &o
+}
+
+func (c *CommandConfigs) Merge(o *CommandConfigs) *CommandConfigs {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	*r = append(*r, *o...)
+
+	return r
+}
+
+func (c *CommandConfigs) Finalize() {
+	for _, t := range *c {
+		t.Finalize()
+	}
+}
+
+func (c *CommandConfigs) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	for _, t := range *c {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *CommandConfigs) GoString() string {
+	if c == nil {
+		return "(*CommandConfigs)(nil)"
+	}
+
+	s := make([]string, len(*c))
+	for i, t := range *c {
+		s[i] = t.GoString()
+	}
+
+	return "{" + strings.Join(s, ", ") + "}"
+}
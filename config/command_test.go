@@ -0,0 +1,146 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCommandConfigs_Copy(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *CommandConfigs
+	}{
+		{
+			"nil",
+			nil,
+		},
+		{
+			"empty",
+			&CommandConfigs{},
+		},
+		{
+			"same_enabled",
+			&CommandConfigs{
+				&CommandConfig{Trigger: String("nginx/*"), Exec: String("nginx -s reload")},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Copy()
+			if !reflect.DeepEqual(tc.a, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.a, r)
+			}
+		})
+	}
+}
+
+func TestCommandConfigs_Merge(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *CommandConfigs
+		b    *CommandConfigs
+		r    *CommandConfigs
+	}{
+		{
+			"nil_a",
+			nil,
+			&CommandConfigs{},
+			&CommandConfigs{},
+		},
+		{
+			"nil_b",
+			&CommandConfigs{},
+			nil,
+			&CommandConfigs{},
+		},
+		{
+			"nil_both",
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"appends",
+			&CommandConfigs{
+				&CommandConfig{Trigger: String("nginx/*"), Exec: String("nginx -s reload")},
+			},
+			&CommandConfigs{
+				&CommandConfig{Exec: String("systemctl reload app")},
+			},
+			&CommandConfigs{
+				&CommandConfig{Trigger: String("nginx/*"), Exec: String("nginx -s reload")},
+				&CommandConfig{Exec: String("systemctl reload app")},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Merge(tc.b)
+			if !reflect.DeepEqual(tc.r, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.r, r)
+			}
+		})
+	}
+}
+
+func TestCommandConfigs_Finalize(t *testing.T) {
+	c := &CommandConfigs{
+		&CommandConfig{Exec: String("nginx -s reload")},
+	}
+	c.Finalize()
+
+	e := &CommandConfigs{
+		&CommandConfig{Trigger: String(""), Exec: String("nginx -s reload")},
+	}
+	if !reflect.DeepEqual(e, c) {
+		t.Errorf("\nexp: %#v\nact: %#v", e, c)
+	}
+}
+
+func TestCommandConfigs_Validate(t *testing.T) {
+	cases := []struct {
+		name string
+		c    *CommandConfigs
+		err  bool
+	}{
+		{
+			"nil",
+			nil,
+			false,
+		},
+		{
+			"valid",
+			&CommandConfigs{
+				&CommandConfig{Trigger: String("nginx/*"), Exec: String("nginx -s reload")},
+				&CommandConfig{Exec: String("systemctl reload app")},
+			},
+			false,
+		},
+		{
+			"missing_exec",
+			&CommandConfigs{
+				&CommandConfig{Trigger: String("nginx/*")},
+			},
+			true,
+		},
+		{
+			"invalid_trigger",
+			&CommandConfigs{
+				&CommandConfig{Trigger: String("["), Exec: String("true")},
+			},
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			if err := tc.c.Validate(); (err != nil) != tc.err {
+				t.Errorf("expected error %t, got %v", tc.err, err)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -13,6 +14,8 @@ import (
 
 	"github.com/hashicorp/consul-template/signals"
 	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/token"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/mitchellh/mapstructure"
 
@@ -25,22 +28,223 @@ const (
 	DefaultReloadSignal = syscall.SIGHUP
 
 	DefaultKillSignal = syscall.SIGINT
+
+	MissingPrefixWarn   = "warn"
+	MissingPrefixError  = "error"
+	MissingPrefixIgnore = "ignore"
+
+	DefaultOnMissingPrefix = MissingPrefixWarn
+
+	PushConflictSkip  = "skip"
+	PushConflictRetry = "retry"
+
+	DefaultPushConflict = PushConflictSkip
+
+	OnWriteErrorAbort    = "abort"
+	OnWriteErrorContinue = "continue"
+
+	DefaultOnWriteError = OnWriteErrorAbort
+
+	CaseTransformNone  = "none"
+	CaseTransformLower = "lower"
+	CaseTransformUpper = "upper"
+
+	DefaultCaseTransform = CaseTransformNone
+
+	DefaultFlapWindow = 1 * time.Minute
+
+	DirConflictError   = "error"
+	DirConflictReplace = "replace"
+	DirConflictSkip    = "skip"
+
+	DefaultOnDirConflict = DirConflictError
+
+	FolderWithValueError  = "error"
+	FolderWithValueIndex  = "index"
+	FolderWithValueSkip   = "skip"
+	FolderWithValueSuffix = "suffix"
+
+	DefaultFolderWithValue = FolderWithValueError
+
+	CompressionNone    = "none"
+	CompressionGzip    = "gzip"
+	CompressionDeflate = "deflate"
+
+	DefaultCompression = CompressionNone
+
+	DefaultOutputCompressionSuffix = ".gz"
+
+	DefaultConcurrency = 1
+
+	// DefaultSeparator is the line written before each value with stdout,
+	// with {key} replaced by the key.
+	DefaultSeparator = "# key: {key}"
+
+	DefaultInterval = 1 * time.Second
+
+	// MinInterval is the shortest interval between passes; shorter positive
+	// intervals are raised to it.
+	MinInterval = 100 * time.Millisecond
 )
 
 var (
 	homePath, _ = homedir.Dir()
+
+	DefaultPauseSignal = signals.SignalLookup["SIGUSR1"]
 )
 
 type Config struct {
-	Consul       *ConsulConfig  `mapstructure:"consul"`
-	KillSignal   *os.Signal     `mapstructure:"kill_signal"`
-	LogLevel     *string        `mapstructure:"log_level"`
-	PidFile      *string        `mapstructure:"pid_file"`
-	ReloadSignal *os.Signal     `mapstructure:"reload_signal"`
-	Syslog       *SyslogConfig  `mapstructure:"syslog"`
-	From         *string        `mapstructure:"from"`
-	To           *string        `mapstructure:"to"`
-	Interval     *time.Duration `mapstructure:"interval"`
+	Consul               *ConsulConfig    `mapstructure:"consul"`
+	KillSignal           *os.Signal       `mapstructure:"kill_signal"`
+	PauseSignal          *os.Signal       `mapstructure:"pause_signal"`
+	LogLevel             *string          `mapstructure:"log_level"`
+	PidFile              *string          `mapstructure:"pid_file"`
+	ReloadSignal         *os.Signal       `mapstructure:"reload_signal"`
+	Syslog               *SyslogConfig    `mapstructure:"syslog"`
+	LogFile              *LogFileConfig   `mapstructure:"log_file"`
+	From                 *string          `mapstructure:"from"`
+	To                   *string          `mapstructure:"to"`
+	Interval             *time.Duration   `mapstructure:"interval"`
+	IntervalJitter       *time.Duration   `mapstructure:"interval_jitter"`
+	MaxTotalBytes        *int             `mapstructure:"max_total_bytes"`
+	SwapDir              *bool            `mapstructure:"swap_dir"`
+	OnMissingPrefix      *string          `mapstructure:"on_missing_prefix"`
+	RequireKeys          *bool            `mapstructure:"require_keys"`
+	LeaderKey            *string          `mapstructure:"leader_key"`
+	Push                 *bool            `mapstructure:"push"`
+	PushConflict         *string          `mapstructure:"push_conflict"`
+	Filter               *string          `mapstructure:"filter"`
+	Archive              *string          `mapstructure:"archive"`
+	StartupDeadline      *time.Duration   `mapstructure:"startup_deadline"`
+	DedupeIdentical      *bool            `mapstructure:"dedupe_identical"`
+	WriteThrottle        *time.Duration   `mapstructure:"write_throttle"`
+	Watermark            *bool            `mapstructure:"watermark"`
+	WatermarkFile        *string          `mapstructure:"watermark_file"`
+	MaxPasses            *int             `mapstructure:"max_passes"`
+	Perms                *os.FileMode     `mapstructure:"perms"`
+	FileModes            *FileModeConfigs `mapstructure:"file_mode"`
+	SelfTest             *bool            `mapstructure:"self_test"`
+	SelfTestPrefix       *string          `mapstructure:"self_test_prefix"`
+	QuietSkips           *bool            `mapstructure:"quiet_skips"`
+	Preflight            *bool            `mapstructure:"preflight"`
+	FlapThreshold        *int             `mapstructure:"flap_threshold"`
+	FlapWindow           *time.Duration   `mapstructure:"flap_window"`
+	FlapHold             *bool            `mapstructure:"flap_hold"`
+	EnvFile              *bool            `mapstructure:"env_file"`
+	EnvFilePath          *string          `mapstructure:"env_file_path"`
+	CreateDirsForFolders *bool            `mapstructure:"create_dirs_for_folders"`
+	VersionFile          *string          `mapstructure:"version_file"`
+	OnWriteError         *string          `mapstructure:"on_write_error"`
+	MaxFilesPerPass      *int             `mapstructure:"max_files_per_pass"`
+	Explain              *bool            `mapstructure:"explain"`
+	CaseTransform        *string          `mapstructure:"case_transform"`
+	StripBOM             *bool            `mapstructure:"strip_bom"`
+	DefaultExtension     *string          `mapstructure:"default_extension"`
+
+	// ExtensionMap maps file name patterns to the extension added to
+	// matching names that have none.
+	ExtensionMap      map[string]string `mapstructure:"extension_map"`
+	Interactive       *bool             `mapstructure:"interactive"`
+	ResolveReferences *bool             `mapstructure:"resolve_references"`
+	CacheByIndex      *bool             `mapstructure:"cache_by_index"`
+
+	// FlagsRoute maps the decimal Flags value of a key to the directory its
+	// file is written to instead of to.
+	FlagsRoute map[string]string `mapstructure:"flags_route"`
+
+	// KeyPattern is matched against every key, and ToTemplate renders the
+	// path of its file from the named groups of the match.
+	KeyPattern *string `mapstructure:"key_pattern"`
+	ToTemplate *string `mapstructure:"to_template"`
+
+	// LowMemory lists only the key names and gets each value right before
+	// its file is written, so the values of a pass are never all held.
+	LowMemory *bool `mapstructure:"low_memory"`
+
+	// Redact lists key patterns whose values are never logged.
+	Redact []string `mapstructure:"redact"`
+
+	OnDirConflict   *string `mapstructure:"on_dir_conflict"`
+	ForceDirReplace *bool   `mapstructure:"force_dir_replace"`
+
+	ValueFilters *ValueFilterConfigs `mapstructure:"value_filter"`
+
+	PreserveStructure *bool `mapstructure:"preserve_structure"`
+
+	// FolderWithValue is what preserve_structure does with a key that has
+	// both a value and keys below it.
+	FolderWithValue *string `mapstructure:"folder_with_value"`
+
+	Prune *bool `mapstructure:"prune"`
+
+	// PrunePatterns limits prune to the files matching one of the globs.
+	PrunePatterns []string `mapstructure:"prune_patterns"`
+
+	// Commands are run after a pass that changed files, each only when a
+	// changed file matches its trigger if it has one.
+	Commands *CommandConfigs `mapstructure:"command"`
+
+	// CommandSplay delays command by a random duration below it, so a
+	// fleet of generators does not run it at the same instant.
+	CommandSplay *time.Duration `mapstructure:"command_splay"`
+
+	Watch *bool `mapstructure:"watch"`
+
+	Telemetry *TelemetryConfig `mapstructure:"telemetry"`
+
+	Vault *VaultConfig `mapstructure:"vault"`
+
+	Compression *string `mapstructure:"compression"`
+
+	// OutputCompression compresses files as they are written, and
+	// OutputCompressionSuffix is appended to their names when it does.
+	OutputCompression       *string `mapstructure:"output_compression"`
+	OutputCompressionSuffix *string `mapstructure:"output_compression_suffix"`
+
+	// Concurrency is the number of keys compared and written in parallel.
+	Concurrency *int `mapstructure:"concurrency"`
+
+	Wait *WaitConfig `mapstructure:"wait"`
+
+	// Syncs replace from, to and perms with several mappings when set.
+	Syncs *SyncConfigs `mapstructure:"sync"`
+
+	// Template renders values with text/template before they are written,
+	// with TemplateLeftDelim and TemplateRightDelim in place of {{ and }}
+	// when set.
+	Template           *bool      `mapstructure:"template"`
+	TemplateLeftDelim  *string    `mapstructure:"template_left_delim"`
+	TemplateRightDelim *string    `mapstructure:"template_right_delim"`
+	Env                *EnvConfig `mapstructure:"env"`
+
+	// Renames rewrite file names with regexps, in order.
+	Renames *RenameConfigs `mapstructure:"rename"`
+
+	// Include and Exclude are globs on the full key. Keys must match an
+	// include glob, when there are any, and no exclude glob.
+	Include []string `mapstructure:"include"`
+	Exclude []string `mapstructure:"exclude"`
+
+	// Manifest is the path of a JSON map of file names to sha256 hashes.
+	Manifest *string `mapstructure:"manifest"`
+
+	// DetailedExitCode makes a dry run that would change files exit with
+	// a code of its own.
+	DetailedExitCode *bool `mapstructure:"detailed_exitcode"`
+
+	// Single writes the value of the key from to the file to.
+	Single *bool `mapstructure:"single"`
+
+	Notify *NotifyConfig `mapstructure:"notify"`
+
+	// PreserveMtime sets the mtime of a written file to the time its key
+	// was changed rather than the time of the write.
+	PreserveMtime *bool `mapstructure:"preserve_mtime"`
+
+	// Stdout writes the values of all keys to the out stream instead of
+	// files, each after a Separator line naming its key.
+	Stdout    *bool   `mapstructure:"stdout"`
+	Separator *string `mapstructure:"separator"`
 }
 
 func (c *Config) Copy() *Config {
@@ -54,14 +258,170 @@ func (c *Config) Copy() *Config {
 
 	o.KillSignal = c.KillSignal
 
+	o.PauseSignal = c.PauseSignal
+
 	o.LogLevel = c.LogLevel
 
 	o.From = c.From
 
 	o.Interval = c.Interval
 
+	o.IntervalJitter = c.IntervalJitter
+
 	o.To = c.To
 
+	o.MaxTotalBytes = c.MaxTotalBytes
+
+	o.SwapDir = c.SwapDir
+
+	o.OnMissingPrefix = c.OnMissingPrefix
+
+	o.LeaderKey = c.LeaderKey
+
+	o.Push = c.Push
+
+	o.PushConflict = c.PushConflict
+
+	o.Filter = c.Filter
+
+	o.Archive = c.Archive
+
+	o.StartupDeadline = c.StartupDeadline
+
+	o.DedupeIdentical = c.DedupeIdentical
+
+	o.WriteThrottle = c.WriteThrottle
+
+	o.Watermark = c.Watermark
+
+	o.WatermarkFile = c.WatermarkFile
+
+	o.MaxPasses = c.MaxPasses
+
+	o.Perms = c.Perms
+
+	if c.FileModes != nil {
+		o.FileModes = c.FileModes.Copy()
+	}
+
+	if c.ValueFilters != nil {
+		o.ValueFilters = c.ValueFilters.Copy()
+	}
+
+	o.SelfTest = c.SelfTest
+
+	o.SelfTestPrefix = c.SelfTestPrefix
+
+	o.QuietSkips = c.QuietSkips
+
+	o.Preflight = c.Preflight
+
+	o.FlapThreshold = c.FlapThreshold
+
+	o.FlapWindow = c.FlapWindow
+
+	o.FlapHold = c.FlapHold
+
+	o.EnvFile = c.EnvFile
+
+	o.EnvFilePath = c.EnvFilePath
+
+	o.CreateDirsForFolders = c.CreateDirsForFolders
+
+	o.VersionFile = c.VersionFile
+
+	o.OnWriteError = c.OnWriteError
+
+	o.MaxFilesPerPass = c.MaxFilesPerPass
+
+	o.Explain = c.Explain
+
+	o.CaseTransform = c.CaseTransform
+
+	o.StripBOM = c.StripBOM
+
+	o.DefaultExtension = c.DefaultExtension
+
+	if c.ExtensionMap != nil {
+		o.ExtensionMap = make(map[string]string, len(c.ExtensionMap))
+		for k, v := range c.ExtensionMap {
+			o.ExtensionMap[k] = v
+		}
+	}
+
+	if c.FlagsRoute != nil {
+		o.FlagsRoute = make(map[string]string, len(c.FlagsRoute))
+		for k, v := range c.FlagsRoute {
+			o.FlagsRoute[k] = v
+		}
+	}
+
+	o.KeyPattern = c.KeyPattern
+
+	o.ToTemplate = c.ToTemplate
+
+	o.LowMemory = c.LowMemory
+
+	o.Interactive = c.Interactive
+
+	o.ResolveReferences = c.ResolveReferences
+
+	o.CacheByIndex = c.CacheByIndex
+
+	if c.Redact != nil {
+		o.Redact = append([]string{}, c.Redact...)
+	}
+
+	o.OnDirConflict = c.OnDirConflict
+
+	o.ForceDirReplace = c.ForceDirReplace
+
+	o.PreserveStructure = c.PreserveStructure
+
+	o.FolderWithValue = c.FolderWithValue
+
+	o.Prune = c.Prune
+
+	if c.PrunePatterns != nil {
+		o.PrunePatterns = append([]string{}, c.PrunePatterns...)
+	}
+
+	if c.Commands != nil {
+		o.Commands = c.Commands.Copy()
+	}
+
+	o.Watch = c.Watch
+
+	o.Compression = c.Compression
+
+	o.OutputCompression = c.OutputCompression
+
+	o.OutputCompressionSuffix = c.OutputCompressionSuffix
+
+	o.Concurrency = c.Concurrency
+
+	o.Template = c.Template
+
+	o.TemplateLeftDelim = c.TemplateLeftDelim
+
+	o.TemplateRightDelim = c.TemplateRightDelim
+
+	o.Manifest = c.Manifest
+
+	o.DetailedExitCode = c.DetailedExitCode
+
+	o.Single = c.Single
+
+	o.PreserveMtime = c.PreserveMtime
+
+	o.Stdout = c.Stdout
+
+	o.Separator = c.Separator
+
+	o.RequireKeys = c.RequireKeys
+
+	o.CommandSplay = c.CommandSplay
+
 	o.PidFile = c.PidFile
 
 	o.ReloadSignal = c.ReloadSignal
@@ -70,6 +430,46 @@ func (c *Config) Copy() *Config {
 		o.Syslog = c.Syslog.Copy()
 	}
 
+	if c.LogFile != nil {
+		o.LogFile = c.LogFile.Copy()
+	}
+
+	if c.Telemetry != nil {
+		o.Telemetry = c.Telemetry.Copy()
+	}
+
+	if c.Notify != nil {
+		o.Notify = c.Notify.Copy()
+	}
+
+	if c.Vault != nil {
+		o.Vault = c.Vault.Copy()
+	}
+
+	if c.Wait != nil {
+		o.Wait = c.Wait.Copy()
+	}
+
+	if c.Env != nil {
+		o.Env = c.Env.Copy()
+	}
+
+	if c.Renames != nil {
+		o.Renames = c.Renames.Copy()
+	}
+
+	if c.Include != nil {
+		o.Include = append([]string{}, c.Include...)
+	}
+
+	if c.Exclude != nil {
+		o.Exclude = append([]string{}, c.Exclude...)
+	}
+
+	if c.Syncs != nil {
+		o.Syncs = c.Syncs.Copy()
+	}
+
 	return &o
 }
 
@@ -99,14 +499,296 @@ func (c *Config) Merge(o *Config) *Config {
 		r.Interval = o.Interval
 	}
 
+	if o.IntervalJitter != nil {
+		r.IntervalJitter = o.IntervalJitter
+	}
+
 	if o.To != nil {
 		r.To = o.To
 	}
 
+	if o.MaxTotalBytes != nil {
+		r.MaxTotalBytes = o.MaxTotalBytes
+	}
+
+	if o.SwapDir != nil {
+		r.SwapDir = o.SwapDir
+	}
+
+	if o.OnMissingPrefix != nil {
+		r.OnMissingPrefix = o.OnMissingPrefix
+	}
+
+	if o.LeaderKey != nil {
+		r.LeaderKey = o.LeaderKey
+	}
+
+	if o.Push != nil {
+		r.Push = o.Push
+	}
+
+	if o.PushConflict != nil {
+		r.PushConflict = o.PushConflict
+	}
+
+	if o.Filter != nil {
+		r.Filter = o.Filter
+	}
+
+	if o.Archive != nil {
+		r.Archive = o.Archive
+	}
+
+	if o.StartupDeadline != nil {
+		r.StartupDeadline = o.StartupDeadline
+	}
+
+	if o.DedupeIdentical != nil {
+		r.DedupeIdentical = o.DedupeIdentical
+	}
+
+	if o.WriteThrottle != nil {
+		r.WriteThrottle = o.WriteThrottle
+	}
+
+	if o.Watermark != nil {
+		r.Watermark = o.Watermark
+	}
+
+	if o.WatermarkFile != nil {
+		r.WatermarkFile = o.WatermarkFile
+	}
+
+	if o.MaxPasses != nil {
+		r.MaxPasses = o.MaxPasses
+	}
+
+	if o.Perms != nil {
+		r.Perms = o.Perms
+	}
+
+	if o.FileModes != nil {
+		r.FileModes = r.FileModes.Merge(o.FileModes)
+	}
+
+	if o.ValueFilters != nil {
+		r.ValueFilters = r.ValueFilters.Merge(o.ValueFilters)
+	}
+
+	if o.SelfTest != nil {
+		r.SelfTest = o.SelfTest
+	}
+
+	if o.SelfTestPrefix != nil {
+		r.SelfTestPrefix = o.SelfTestPrefix
+	}
+
+	if o.QuietSkips != nil {
+		r.QuietSkips = o.QuietSkips
+	}
+
+	if o.Preflight != nil {
+		r.Preflight = o.Preflight
+	}
+
+	if o.FlapThreshold != nil {
+		r.FlapThreshold = o.FlapThreshold
+	}
+
+	if o.FlapWindow != nil {
+		r.FlapWindow = o.FlapWindow
+	}
+
+	if o.FlapHold != nil {
+		r.FlapHold = o.FlapHold
+	}
+
+	if o.EnvFile != nil {
+		r.EnvFile = o.EnvFile
+	}
+
+	if o.EnvFilePath != nil {
+		r.EnvFilePath = o.EnvFilePath
+	}
+
+	if o.CreateDirsForFolders != nil {
+		r.CreateDirsForFolders = o.CreateDirsForFolders
+	}
+
+	if o.VersionFile != nil {
+		r.VersionFile = o.VersionFile
+	}
+
+	if o.OnWriteError != nil {
+		r.OnWriteError = o.OnWriteError
+	}
+
+	if o.MaxFilesPerPass != nil {
+		r.MaxFilesPerPass = o.MaxFilesPerPass
+	}
+
+	if o.Explain != nil {
+		r.Explain = o.Explain
+	}
+
+	if o.CaseTransform != nil {
+		r.CaseTransform = o.CaseTransform
+	}
+
+	if o.StripBOM != nil {
+		r.StripBOM = o.StripBOM
+	}
+
+	if o.DefaultExtension != nil {
+		r.DefaultExtension = o.DefaultExtension
+	}
+
+	if o.ExtensionMap != nil {
+		if r.ExtensionMap == nil {
+			r.ExtensionMap = make(map[string]string, len(o.ExtensionMap))
+		}
+		for k, v := range o.ExtensionMap {
+			r.ExtensionMap[k] = v
+		}
+	}
+
+	if o.FlagsRoute != nil {
+		if r.FlagsRoute == nil {
+			r.FlagsRoute = make(map[string]string, len(o.FlagsRoute))
+		}
+		for k, v := range o.FlagsRoute {
+			r.FlagsRoute[k] = v
+		}
+	}
+
+	if o.KeyPattern != nil {
+		r.KeyPattern = o.KeyPattern
+	}
+
+	if o.ToTemplate != nil {
+		r.ToTemplate = o.ToTemplate
+	}
+
+	if o.LowMemory != nil {
+		r.LowMemory = o.LowMemory
+	}
+
+	if o.Interactive != nil {
+		r.Interactive = o.Interactive
+	}
+
+	if o.ResolveReferences != nil {
+		r.ResolveReferences = o.ResolveReferences
+	}
+
+	if o.CacheByIndex != nil {
+		r.CacheByIndex = o.CacheByIndex
+	}
+
+	if o.Redact != nil {
+		r.Redact = append(r.Redact, o.Redact...)
+	}
+
+	if o.OnDirConflict != nil {
+		r.OnDirConflict = o.OnDirConflict
+	}
+
+	if o.ForceDirReplace != nil {
+		r.ForceDirReplace = o.ForceDirReplace
+	}
+
+	if o.PreserveStructure != nil {
+		r.PreserveStructure = o.PreserveStructure
+	}
+
+	if o.FolderWithValue != nil {
+		r.FolderWithValue = o.FolderWithValue
+	}
+
+	if o.Prune != nil {
+		r.Prune = o.Prune
+	}
+
+	if o.PrunePatterns != nil {
+		r.PrunePatterns = append(r.PrunePatterns, o.PrunePatterns...)
+	}
+
+	if o.Commands != nil {
+		r.Commands = r.Commands.Merge(o.Commands)
+	}
+
+	if o.Watch != nil {
+		r.Watch = o.Watch
+	}
+
+	if o.Compression != nil {
+		r.Compression = o.Compression
+	}
+
+	if o.OutputCompression != nil {
+		r.OutputCompression = o.OutputCompression
+	}
+
+	if o.OutputCompressionSuffix != nil {
+		r.OutputCompressionSuffix = o.OutputCompressionSuffix
+	}
+
+	if o.Concurrency != nil {
+		r.Concurrency = o.Concurrency
+	}
+
+	if o.Template != nil {
+		r.Template = o.Template
+	}
+
+	if o.TemplateLeftDelim != nil {
+		r.TemplateLeftDelim = o.TemplateLeftDelim
+	}
+
+	if o.TemplateRightDelim != nil {
+		r.TemplateRightDelim = o.TemplateRightDelim
+	}
+
+	if o.Manifest != nil {
+		r.Manifest = o.Manifest
+	}
+
+	if o.DetailedExitCode != nil {
+		r.DetailedExitCode = o.DetailedExitCode
+	}
+
+	if o.Single != nil {
+		r.Single = o.Single
+	}
+
+	if o.PreserveMtime != nil {
+		r.PreserveMtime = o.PreserveMtime
+	}
+
+	if o.Stdout != nil {
+		r.Stdout = o.Stdout
+	}
+
+	if o.Separator != nil {
+		r.Separator = o.Separator
+	}
+
+	if o.RequireKeys != nil {
+		r.RequireKeys = o.RequireKeys
+	}
+
+	if o.CommandSplay != nil {
+		r.CommandSplay = o.CommandSplay
+	}
+
 	if o.KillSignal != nil {
 		r.KillSignal = o.KillSignal
 	}
 
+	if o.PauseSignal != nil {
+		r.PauseSignal = o.PauseSignal
+	}
+
 	if o.LogLevel != nil {
 		r.LogLevel = o.LogLevel
 	}
@@ -123,6 +805,46 @@ func (c *Config) Merge(o *Config) *Config {
 		r.Syslog = r.Syslog.Merge(o.Syslog)
 	}
 
+	if o.LogFile != nil {
+		r.LogFile = r.LogFile.Merge(o.LogFile)
+	}
+
+	if o.Telemetry != nil {
+		r.Telemetry = r.Telemetry.Merge(o.Telemetry)
+	}
+
+	if o.Notify != nil {
+		r.Notify = r.Notify.Merge(o.Notify)
+	}
+
+	if o.Vault != nil {
+		r.Vault = r.Vault.Merge(o.Vault)
+	}
+
+	if o.Wait != nil {
+		r.Wait = r.Wait.Merge(o.Wait)
+	}
+
+	if o.Env != nil {
+		r.Env = r.Env.Merge(o.Env)
+	}
+
+	if o.Renames != nil {
+		r.Renames = r.Renames.Merge(o.Renames)
+	}
+
+	if o.Include != nil {
+		r.Include = append(r.Include, o.Include...)
+	}
+
+	if o.Exclude != nil {
+		r.Exclude = append(r.Exclude, o.Exclude...)
+	}
+
+	if o.Syncs != nil {
+		r.Syncs = r.Syncs.Merge(o.Syncs)
+	}
+
 	return r
 }
 
@@ -141,6 +863,9 @@ func Parse(s string) (*Config, error) {
 		"auth",
 		"consul",
 		"consul.auth",
+		"consul.auth_method",
+		"consul.auth_method.meta",
+		"consul.headers",
 		"consul.retry",
 		"consul.ssl",
 		"consul.transport",
@@ -148,8 +873,17 @@ func Parse(s string) (*Config, error) {
 		"env",
 		"exec",
 		"exec.env",
+		"extension_map",
+		"flags_route",
+		"log_file",
+		"notify",
 		"ssl",
 		"syslog",
+		"telemetry",
+		"vault",
+		"vault.ssl",
+		"vault.transport",
+		"wait",
 		"from",
 		"to",
 		"interval",
@@ -161,12 +895,13 @@ func Parse(s string) (*Config, error) {
 	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
 		DecodeHook: mapstructure.ComposeDecodeHookFunc(
 			ConsulStringToStructFunc(),
+			CommandStringToStructFunc(),
 			StringToFileModeFunc(),
 			signals.StringToSignalFunc(),
 			mapstructure.StringToSliceHookFunc(","),
 			mapstructure.StringToTimeDurationHookFunc(),
 		),
-		ErrorUnused: true,
+		ErrorUnused: false,
 		Metadata:    &md,
 		Result:      &c,
 	})
@@ -177,9 +912,83 @@ func Parse(s string) (*Config, error) {
 		return nil, errors.Wrap(err, "mapstructure decode failed")
 	}
 
+	if len(md.Unused) > 0 {
+		return nil, unusedKeysError(s, md.Unused)
+	}
+
 	return &c, nil
 }
 
+func unusedKeysError(s string, keys []string) error {
+	sort.Strings(keys)
+
+	var root *ast.ObjectList
+	if f, err := hcl.Parse(s); err == nil {
+		root, _ = f.Node.(*ast.ObjectList)
+	}
+
+	msgs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		msg := fmt.Sprintf("invalid key %q", key)
+		if pos, ok := keyPos(root, strings.Split(key, ".")); ok {
+			msg = fmt.Sprintf("%s at line %d, column %d", msg, pos.Line, pos.Column)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+func keyPos(list *ast.ObjectList, path []string) (token.Pos, bool) {
+	if list == nil || len(path) == 0 {
+		return token.Pos{}, false
+	}
+
+	name, index := path[0], 0
+	if i := strings.Index(name, "["); i > 0 && strings.HasSuffix(name, "]") {
+		index, _ = strconv.Atoi(name[i+1 : len(name)-1])
+		name = name[:i]
+	}
+
+	for _, item := range list.Items {
+		if len(item.Keys) == 0 || strings.Trim(item.Keys[0].Token.Text, `"`) != name {
+			continue
+		}
+		if index > 0 {
+			index--
+			continue
+		}
+
+		if len(path) == 1 {
+			return item.Keys[0].Pos(), true
+		}
+
+		rest, matched := path[1:], true
+		for _, k := range item.Keys[1:] {
+			if len(rest) == 0 || strings.Trim(k.Token.Text, `"`) != rest[0] {
+				matched = false
+				break
+			}
+			rest = rest[1:]
+		}
+		if !matched {
+			continue
+		}
+		if len(rest) == 0 {
+			return item.Keys[len(item.Keys)-1].Pos(), true
+		}
+
+		if obj, ok := item.Val.(*ast.ObjectType); ok {
+			if pos, ok := keyPos(obj.List, rest); ok {
+				return pos, true
+			}
+		}
+		return item.Keys[0].Pos(), true
+	}
+
+	return token.Pos{}, false
+}
+
 func Must(s string) *Config {
 	c, err := Parse(s)
 	if err != nil {
@@ -244,7 +1053,7 @@ func FromPath(path string) (*Config, error) {
 		})
 
 		if err != nil {
-			return nil, errors.Wrap(err, "walk error")
+			return nil, errors.Wrap(err, "failed loading config dir "+path)
 		}
 
 		return c, nil
@@ -255,6 +1064,42 @@ func FromPath(path string) (*Config, error) {
 	return nil, fmt.Errorf("unknown filetype: %q", stat.Mode().String())
 }
 
+// RedactedValue replaces secrets wherever a configuration is logged or
+// printed.
+const RedactedValue = "***"
+
+// Redacted returns a copy of c with the tokens, passwords and private keys
+// that are set replaced by RedactedValue, safe to log or print.
+func (c *Config) Redacted() *Config {
+	redact := func(s **string) {
+		if StringPresent(*s) {
+			*s = String(RedactedValue)
+		}
+	}
+
+	o := c.Copy()
+	if o.Consul != nil {
+		redact(&o.Consul.Token)
+		if o.Consul.Auth != nil {
+			redact(&o.Consul.Auth.Password)
+		}
+		if o.Consul.SSL != nil {
+			redact(&o.Consul.SSL.KeyPEM)
+		}
+		// Headers usually carry credentials, Authorization or X-Consul-Token.
+		for k := range o.Consul.Headers {
+			o.Consul.Headers[k] = RedactedValue
+		}
+	}
+	if o.Vault != nil {
+		redact(&o.Vault.Token)
+		if o.Vault.SSL != nil {
+			redact(&o.Vault.SSL.KeyPEM)
+		}
+	}
+	return o
+}
+
 func (c *Config) GoString() string {
 	if c == nil {
 		return "(*Config)(nil)"
@@ -263,23 +1108,179 @@ func (c *Config) GoString() string {
 	return fmt.Sprintf("&Config{"+
 		"Consul:%#v, "+
 		"KillSignal:%s, "+
+		"PauseSignal:%s, "+
 		"LogLevel:%s, "+
 		"PidFile:%s, "+
 		"ReloadSignal:%s, "+
 		"Syslog:%#v, "+
+		"LogFile:%#v, "+
 		"From:%#v, "+
 		"To:%#v, "+
 		"Interval:%#v, "+
+		"IntervalJitter:%s, "+
+		"MaxTotalBytes:%s, "+
+		"SwapDir:%s, "+
+		"OnMissingPrefix:%s, "+
+		"LeaderKey:%s, "+
+		"Push:%s, "+
+		"PushConflict:%s, "+
+		"Filter:%s, "+
+		"Archive:%s, "+
+		"StartupDeadline:%s, "+
+		"DedupeIdentical:%s, "+
+		"WriteThrottle:%s, "+
+		"Watermark:%s, "+
+		"WatermarkFile:%s, "+
+		"MaxPasses:%s, "+
+		"Perms:%s, "+
+		"FileModes:%#v, "+
+		"SelfTest:%s, "+
+		"SelfTestPrefix:%s, "+
+		"QuietSkips:%s, "+
+		"Preflight:%s, "+
+		"FlapThreshold:%s, "+
+		"FlapWindow:%s, "+
+		"FlapHold:%s, "+
+		"EnvFile:%s, "+
+		"EnvFilePath:%s, "+
+		"CreateDirsForFolders:%s, "+
+		"VersionFile:%s, "+
+		"OnWriteError:%s, "+
+		"MaxFilesPerPass:%s, "+
+		"Explain:%s, "+
+		"CaseTransform:%s, "+
+		"StripBOM:%s, "+
+		"DefaultExtension:%s, "+
+		"ExtensionMap:%v, "+
+		"FlagsRoute:%v, "+
+		"KeyPattern:%s, "+
+		"ToTemplate:%s, "+
+		"LowMemory:%s, "+
+		"Interactive:%s, "+
+		"ResolveReferences:%s, "+
+		"CacheByIndex:%s, "+
+		"Redact:%v, "+
+		"OnDirConflict:%s, "+
+		"ForceDirReplace:%s, "+
+		"ValueFilters:%#v, "+
+		"PreserveStructure:%s, "+
+		"FolderWithValue:%s, "+
+		"Prune:%s, "+
+		"PrunePatterns:%v, "+
+		"Commands:%#v, "+
+		"Watch:%s, "+
+		"Telemetry:%#v, "+
+		"Vault:%#v, "+
+		"Compression:%s, "+
+		"OutputCompression:%s, "+
+		"OutputCompressionSuffix:%s, "+
+		"Concurrency:%s, "+
+		"Wait:%#v, "+
+		"Syncs:%#v, "+
+		"Template:%s, "+
+		"TemplateLeftDelim:%s, "+
+		"TemplateRightDelim:%s, "+
+		"Env:%#v, "+
+		"Renames:%#v, "+
+		"Include:%v, "+
+		"Exclude:%v, "+
+		"Manifest:%s, "+
+		"DetailedExitCode:%s, "+
+		"Single:%s, "+
+		"Notify:%#v, "+
+		"PreserveMtime:%s, "+
+		"Stdout:%s, "+
+		"Separator:%s, "+
+		"RequireKeys:%s, "+
+		"CommandSplay:%s, "+
 		"}",
 		c.Consul,
 		SignalGoString(c.KillSignal),
+		SignalGoString(c.PauseSignal),
 		StringGoString(c.LogLevel),
 		StringGoString(c.PidFile),
 		SignalGoString(c.ReloadSignal),
 		c.Syslog,
+		c.LogFile,
 		c.From,
 		c.To,
 		c.Interval,
+		TimeDurationGoString(c.IntervalJitter),
+		IntGoString(c.MaxTotalBytes),
+		BoolGoString(c.SwapDir),
+		StringGoString(c.OnMissingPrefix),
+		StringGoString(c.LeaderKey),
+		BoolGoString(c.Push),
+		StringGoString(c.PushConflict),
+		StringGoString(c.Filter),
+		StringGoString(c.Archive),
+		TimeDurationGoString(c.StartupDeadline),
+		BoolGoString(c.DedupeIdentical),
+		TimeDurationGoString(c.WriteThrottle),
+		BoolGoString(c.Watermark),
+		StringGoString(c.WatermarkFile),
+		IntGoString(c.MaxPasses),
+		FileModeGoString(c.Perms),
+		c.FileModes,
+		BoolGoString(c.SelfTest),
+		StringGoString(c.SelfTestPrefix),
+		BoolGoString(c.QuietSkips),
+		BoolGoString(c.Preflight),
+		IntGoString(c.FlapThreshold),
+		TimeDurationGoString(c.FlapWindow),
+		BoolGoString(c.FlapHold),
+		BoolGoString(c.EnvFile),
+		StringGoString(c.EnvFilePath),
+		BoolGoString(c.CreateDirsForFolders),
+		StringGoString(c.VersionFile),
+		StringGoString(c.OnWriteError),
+		IntGoString(c.MaxFilesPerPass),
+		BoolGoString(c.Explain),
+		StringGoString(c.CaseTransform),
+		BoolGoString(c.StripBOM),
+		StringGoString(c.DefaultExtension),
+		c.ExtensionMap,
+		c.FlagsRoute,
+		StringGoString(c.KeyPattern),
+		StringGoString(c.ToTemplate),
+		BoolGoString(c.LowMemory),
+		BoolGoString(c.Interactive),
+		BoolGoString(c.ResolveReferences),
+		BoolGoString(c.CacheByIndex),
+		c.Redact,
+		StringGoString(c.OnDirConflict),
+		BoolGoString(c.ForceDirReplace),
+		c.ValueFilters,
+		BoolGoString(c.PreserveStructure),
+		StringGoString(c.FolderWithValue),
+		BoolGoString(c.Prune),
+		c.PrunePatterns,
+		c.Commands,
+		BoolGoString(c.Watch),
+		c.Telemetry,
+		c.Vault,
+		StringGoString(c.Compression),
+		StringGoString(c.OutputCompression),
+		StringGoString(c.OutputCompressionSuffix),
+		IntGoString(c.Concurrency),
+		c.Wait,
+		c.Syncs,
+		BoolGoString(c.Template),
+		StringGoString(c.TemplateLeftDelim),
+		StringGoString(c.TemplateRightDelim),
+		c.Env,
+		c.Renames,
+		c.Include,
+		c.Exclude,
+		StringGoString(c.Manifest),
+		BoolGoString(c.DetailedExitCode),
+		BoolGoString(c.Single),
+		c.Notify,
+		BoolGoString(c.PreserveMtime),
+		BoolGoString(c.Stdout),
+		StringGoString(c.Separator),
+		BoolGoString(c.RequireKeys),
+		TimeDurationGoString(c.CommandSplay),
 	)
 }
 
@@ -287,9 +1288,10 @@ func DefaultConfig() *Config {
 	return &Config{
 		Consul:   DefaultConsulConfig(),
 		Syslog:   DefaultSyslogConfig(),
+		LogFile:  DefaultLogFileConfig(),
 		From:     String("/"),
 		To:       String("./"),
-		Interval: TimeDuration(1 * time.Second),
+		Interval: TimeDuration(DefaultInterval),
 	}
 }
 
@@ -303,6 +1305,286 @@ func (c *Config) Finalize() {
 		c.From = String("/")
 	}
 
+	if c.Interval == nil {
+		c.Interval = TimeDuration(DefaultInterval)
+	}
+	// A zero or negative interval is left for validation to reject.
+	if d := TimeDurationVal(c.Interval); d > 0 && d < MinInterval {
+		log.Printf("[WARN] (config) interval %s is below the minimum of %s, using %s", d, MinInterval, MinInterval)
+		c.Interval = TimeDuration(MinInterval)
+	}
+
+	if c.IntervalJitter == nil {
+		c.IntervalJitter = TimeDuration(0)
+	}
+
+	if c.MaxTotalBytes == nil {
+		c.MaxTotalBytes = Int(0)
+	}
+
+	if c.SwapDir == nil {
+		c.SwapDir = Bool(false)
+	}
+
+	if c.OnMissingPrefix == nil {
+		c.OnMissingPrefix = String(DefaultOnMissingPrefix)
+	}
+
+	if c.LeaderKey == nil {
+		c.LeaderKey = String("")
+	}
+
+	if c.Push == nil {
+		c.Push = Bool(false)
+	}
+
+	if c.PushConflict == nil {
+		c.PushConflict = String(DefaultPushConflict)
+	}
+
+	if c.Filter == nil {
+		c.Filter = String("")
+	}
+
+	if c.Archive == nil {
+		c.Archive = String("")
+	}
+
+	if c.StartupDeadline == nil {
+		c.StartupDeadline = TimeDuration(0)
+	}
+
+	if c.DedupeIdentical == nil {
+		c.DedupeIdentical = Bool(false)
+	}
+
+	if c.WriteThrottle == nil {
+		c.WriteThrottle = TimeDuration(0)
+	}
+
+	if c.Watermark == nil {
+		c.Watermark = Bool(false)
+	}
+
+	if c.WatermarkFile == nil {
+		c.WatermarkFile = String("")
+	}
+
+	if c.MaxPasses == nil {
+		c.MaxPasses = Int(0)
+	}
+
+	if c.Perms == nil {
+		c.Perms = FileMode(0)
+	}
+
+	if c.FileModes == nil {
+		c.FileModes = DefaultFileModeConfigs()
+	}
+	c.FileModes.Finalize()
+
+	if c.ValueFilters == nil {
+		c.ValueFilters = DefaultValueFilterConfigs()
+	}
+	c.ValueFilters.Finalize()
+
+	if c.SelfTest == nil {
+		c.SelfTest = Bool(false)
+	}
+
+	if c.SelfTestPrefix == nil {
+		c.SelfTestPrefix = String("")
+	}
+
+	if c.QuietSkips == nil {
+		c.QuietSkips = Bool(false)
+	}
+
+	if c.Preflight == nil {
+		c.Preflight = Bool(false)
+	}
+
+	if c.FlapThreshold == nil {
+		c.FlapThreshold = Int(0)
+	}
+
+	if c.FlapWindow == nil {
+		c.FlapWindow = TimeDuration(DefaultFlapWindow)
+	}
+
+	if c.FlapHold == nil {
+		c.FlapHold = Bool(false)
+	}
+
+	if c.EnvFile == nil {
+		c.EnvFile = Bool(false)
+	}
+
+	if c.EnvFilePath == nil {
+		c.EnvFilePath = String("")
+	}
+
+	if c.CreateDirsForFolders == nil {
+		c.CreateDirsForFolders = Bool(false)
+	}
+
+	if c.VersionFile == nil {
+		c.VersionFile = String("")
+	}
+
+	if c.OnWriteError == nil {
+		c.OnWriteError = String(DefaultOnWriteError)
+	}
+
+	if c.MaxFilesPerPass == nil {
+		c.MaxFilesPerPass = Int(0)
+	}
+
+	if c.Explain == nil {
+		c.Explain = Bool(false)
+	}
+
+	if c.CaseTransform == nil {
+		c.CaseTransform = String(DefaultCaseTransform)
+	}
+
+	if c.StripBOM == nil {
+		c.StripBOM = Bool(false)
+	}
+
+	if c.DefaultExtension == nil {
+		c.DefaultExtension = String("")
+	}
+
+	if c.ExtensionMap == nil {
+		c.ExtensionMap = make(map[string]string)
+	}
+
+	if c.FlagsRoute == nil {
+		c.FlagsRoute = make(map[string]string)
+	}
+
+	if c.KeyPattern == nil {
+		c.KeyPattern = String("")
+	}
+
+	if c.ToTemplate == nil {
+		c.ToTemplate = String("")
+	}
+
+	if c.LowMemory == nil {
+		c.LowMemory = Bool(false)
+	}
+
+	if c.Interactive == nil {
+		c.Interactive = Bool(false)
+	}
+
+	if c.ResolveReferences == nil {
+		c.ResolveReferences = Bool(false)
+	}
+
+	if c.CacheByIndex == nil {
+		c.CacheByIndex = Bool(false)
+	}
+
+	if c.Redact == nil {
+		c.Redact = []string{}
+	}
+
+	if c.OnDirConflict == nil {
+		c.OnDirConflict = String(DefaultOnDirConflict)
+	}
+
+	if c.ForceDirReplace == nil {
+		c.ForceDirReplace = Bool(false)
+	}
+
+	if c.PreserveStructure == nil {
+		c.PreserveStructure = Bool(false)
+	}
+
+	if c.FolderWithValue == nil {
+		c.FolderWithValue = String(DefaultFolderWithValue)
+	}
+
+	if c.Prune == nil {
+		c.Prune = Bool(false)
+	}
+
+	if c.PrunePatterns == nil {
+		c.PrunePatterns = []string{}
+	}
+
+	if c.Commands == nil {
+		c.Commands = DefaultCommandConfigs()
+	}
+	c.Commands.Finalize()
+
+	if c.Watch == nil {
+		c.Watch = Bool(false)
+	}
+
+	if c.Compression == nil {
+		c.Compression = String(DefaultCompression)
+	}
+
+	if c.OutputCompression == nil {
+		c.OutputCompression = String(CompressionNone)
+	}
+
+	if c.OutputCompressionSuffix == nil {
+		c.OutputCompressionSuffix = String(DefaultOutputCompressionSuffix)
+	}
+
+	if c.Concurrency == nil {
+		c.Concurrency = Int(DefaultConcurrency)
+	}
+
+	if c.Template == nil {
+		c.Template = Bool(false)
+	}
+
+	if c.TemplateLeftDelim == nil {
+		c.TemplateLeftDelim = String("")
+	}
+
+	if c.TemplateRightDelim == nil {
+		c.TemplateRightDelim = String("")
+	}
+
+	if c.Manifest == nil {
+		c.Manifest = String("")
+	}
+
+	if c.DetailedExitCode == nil {
+		c.DetailedExitCode = Bool(false)
+	}
+
+	if c.Single == nil {
+		c.Single = Bool(false)
+	}
+
+	if c.PreserveMtime == nil {
+		c.PreserveMtime = Bool(false)
+	}
+
+	if c.Stdout == nil {
+		c.Stdout = Bool(false)
+	}
+
+	if c.Separator == nil {
+		c.Separator = String(DefaultSeparator)
+	}
+
+	if c.RequireKeys == nil {
+		c.RequireKeys = Bool(false)
+	}
+
+	if c.CommandSplay == nil {
+		c.CommandSplay = TimeDuration(0)
+	}
+
 	if c.Consul == nil {
 		c.Consul = DefaultConsulConfig()
 	}
@@ -312,6 +1594,10 @@ func (c *Config) Finalize() {
 		c.KillSignal = Signal(DefaultKillSignal)
 	}
 
+	if c.PauseSignal == nil {
+		c.PauseSignal = Signal(DefaultPauseSignal)
+	}
+
 	if c.LogLevel == nil {
 		c.LogLevel = stringFromEnv([]string{
 			"CT_LOG",
@@ -331,6 +1617,54 @@ func (c *Config) Finalize() {
 		c.Syslog = DefaultSyslogConfig()
 	}
 	c.Syslog.Finalize()
+
+	if c.LogFile == nil {
+		c.LogFile = DefaultLogFileConfig()
+	}
+	c.LogFile.Finalize()
+
+	if c.Telemetry == nil {
+		c.Telemetry = DefaultTelemetryConfig()
+	}
+	c.Telemetry.Finalize()
+
+	if c.Notify == nil {
+		c.Notify = DefaultNotifyConfig()
+	}
+	c.Notify.Finalize()
+
+	if c.Vault == nil {
+		c.Vault = DefaultVaultConfig()
+	}
+	c.Vault.Finalize()
+
+	if c.Wait == nil {
+		c.Wait = DefaultWaitConfig()
+	}
+	c.Wait.Finalize()
+
+	if c.Env == nil {
+		c.Env = DefaultEnvConfig()
+	}
+	c.Env.Finalize()
+
+	if c.Renames == nil {
+		c.Renames = DefaultRenameConfigs()
+	}
+	c.Renames.Finalize()
+
+	if c.Include == nil {
+		c.Include = []string{}
+	}
+
+	if c.Exclude == nil {
+		c.Exclude = []string{}
+	}
+
+	if c.Syncs == nil {
+		c.Syncs = DefaultSyncConfigs()
+	}
+	c.Syncs.Finalize()
 }
 
 func stringFromEnv(list []string, def string) *string {
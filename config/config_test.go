@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"reflect"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
@@ -29,6 +30,32 @@ func TestParse(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"consul_datacenter_namespace",
+			`consul {
+				datacenter = "dc2"
+				namespace = "team-a"
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					Datacenter: String("dc2"),
+					Namespace:  String("team-a"),
+				},
+			},
+			false,
+		},
+		{
+			"consul_query_timeout",
+			`consul {
+				query_timeout = "10s"
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					QueryTimeout: TimeDuration(10 * time.Second),
+				},
+			},
+			false,
+		},
 		{
 			"consul_auth",
 			`consul {
@@ -48,300 +75,1108 @@ func TestParse(t *testing.T) {
 			false,
 		},
 		{
-			"consul_retry",
-			`consul {
-				retry {
-					backoff  = "2s"
-					attempts = 10
-				}
+			"consul_retry",
+			`consul {
+				retry {
+					backoff  = "2s"
+					attempts = 10
+				}
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					Retry: &RetryConfig{
+						Attempts: Int(10),
+						Backoff:  TimeDuration(2 * time.Second),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_ssl",
+			`consul {
+				ssl {}
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					SSL: &SSLConfig{},
+				},
+			},
+			false,
+		},
+		{
+			"consul_ssl_enabled",
+			`consul {
+				ssl {
+					enabled = true
+				}
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					SSL: &SSLConfig{
+						Enabled: Bool(true),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_ssl_verify",
+			`consul {
+				ssl {
+					verify = true
+				}
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					SSL: &SSLConfig{
+						Verify: Bool(true),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_ssl_pem",
+			"consul {\n" +
+				"  ssl {\n" +
+				"    cert_pem = <<EOF\n-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\nEOF\n" +
+				"    key_pem = \"key\"\n" +
+				"  }\n" +
+				"}",
+			&Config{
+				Consul: &ConsulConfig{
+					SSL: &SSLConfig{
+						CertPEM: String("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"),
+						KeyPEM:  String("key"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_ssl_use_connect_leaf",
+			`consul {
+				ssl {
+					use_connect_leaf = true
+					connect_service  = "web"
+				}
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					SSL: &SSLConfig{
+						UseConnectLeaf: Bool(true),
+						ConnectService: String("web"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_retry_max_duration",
+			`consul {
+				retry {
+					max_duration = "5m"
+				}
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					Retry: &RetryConfig{
+						MaxDuration: TimeDuration(5 * time.Minute),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_ssl_cert",
+			`consul {
+				ssl {
+					cert = "cert"
+				}
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					SSL: &SSLConfig{
+						Cert: String("cert"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_ssl_key",
+			`consul {
+				ssl {
+					key = "key"
+				}
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					SSL: &SSLConfig{
+						Key: String("key"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_ssl_ca_cert",
+			`consul {
+				ssl {
+					ca_cert = "ca_cert"
+				}
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					SSL: &SSLConfig{
+						CaCert: String("ca_cert"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_ssl_ca_path",
+			`consul {
+				ssl {
+					ca_path = "ca_path"
+				}
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					SSL: &SSLConfig{
+						CaPath: String("ca_path"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_ssl_server_name",
+			`consul {
+				ssl {
+					server_name = "server_name"
+				}
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					SSL: &SSLConfig{
+						ServerName: String("server_name"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_auth_method",
+			`consul {
+				auth_method {
+					name = "k8s"
+					bearer_token_file = "/token"
+					meta {
+						pod = "web-1"
+					}
+				}
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					AuthMethod: &AuthMethodConfig{
+						Name:            String("k8s"),
+						BearerTokenFile: String("/token"),
+						Meta:            map[string]string{"pod": "web-1"},
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_headers",
+			`consul {
+				headers {
+					X-Api-Key = "abcd"
+					"X-Gateway" = "edge"
+				}
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					Headers: map[string]string{
+						"X-Api-Key": "abcd",
+						"X-Gateway": "edge",
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_consistency",
+			`consul {
+				consistency = "stale"
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					Consistency: String(ConsistencyStale),
+				},
+			},
+			false,
+		},
+		{
+			"consul_scheme",
+			`consul {
+				scheme = "https"
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					Scheme: String("https"),
+				},
+			},
+			false,
+		},
+		{
+			"consul_token",
+			`consul {
+				token = "token"
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					Token: String("token"),
+				},
+			},
+			false,
+		},
+		{
+			"consul_transport_dial_keep_alive",
+			`consul {
+				transport {
+					dial_keep_alive = "10s"
+				}
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					Transport: &TransportConfig{
+						DialKeepAlive: TimeDuration(10 * time.Second),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_transport_dial_timeout",
+			`consul {
+				transport {
+					dial_timeout = "10s"
+				}
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					Transport: &TransportConfig{
+						DialTimeout: TimeDuration(10 * time.Second),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_transport_disable_keep_alives",
+			`consul {
+				transport {
+					disable_keep_alives = true
+				}
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					Transport: &TransportConfig{
+						DisableKeepAlives: Bool(true),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_transport_max_idle_conns_per_host",
+			`consul {
+				transport {
+					max_idle_conns_per_host = 100
+				}
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					Transport: &TransportConfig{
+						MaxIdleConnsPerHost: Int(100),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"consul_transport_tls_handshake_timeout",
+			`consul {
+				transport {
+					tls_handshake_timeout = "30s"
+				}
+			}`,
+			&Config{
+				Consul: &ConsulConfig{
+					Transport: &TransportConfig{
+						TLSHandshakeTimeout: TimeDuration(30 * time.Second),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"kill_signal",
+			`kill_signal = "SIGUSR1"`,
+			&Config{
+				KillSignal: Signal(syscall.SIGUSR1),
+			},
+			false,
+		},
+		{
+			"log_level",
+			`log_level = "WARN"`,
+			&Config{
+				LogLevel: String("WARN"),
+			},
+			false,
+		},
+		{
+			"pid_file",
+			`pid_file = "/var/pid"`,
+			&Config{
+				PidFile: String("/var/pid"),
+			},
+			false,
+		},
+		{
+			"archive",
+			`archive = "/tmp/bundle.zip"`,
+			&Config{
+				Archive: String("/tmp/bundle.zip"),
+			},
+			false,
+		},
+		{
+			"filter",
+			`filter = "Key contains \"db\""`,
+			&Config{
+				Filter: String(`Key contains "db"`),
+			},
+			false,
+		},
+		{
+			"pause_signal",
+			`pause_signal = "SIGUSR2"`,
+			&Config{
+				PauseSignal: Signal(syscall.SIGUSR2),
+			},
+			false,
+		},
+		{
+			"reload_signal",
+			`reload_signal = "SIGUSR1"`,
+			&Config{
+				ReloadSignal: Signal(syscall.SIGUSR1),
+			},
+			false,
+		},
+		{
+			"syslog",
+			`syslog {}`,
+			&Config{
+				Syslog: &SyslogConfig{},
+			},
+			false,
+		},
+		{
+			"syslog_enabled",
+			`syslog {
+				enabled = true
+			}`,
+			&Config{
+				Syslog: &SyslogConfig{
+					Enabled: Bool(true),
+				},
+			},
+			false,
+		},
+		{
+			"syslog_facility",
+			`syslog {
+				facility = "facility"
+			}`,
+			&Config{
+				Syslog: &SyslogConfig{
+					Facility: String("facility"),
+				},
+			},
+			false,
+		},
+		{
+			"max_total_bytes",
+			`max_total_bytes = 1048576`,
+			&Config{
+				MaxTotalBytes: Int(1048576),
+			},
+			false,
+		},
+		{
+			"swap_dir",
+			`swap_dir = true`,
+			&Config{
+				SwapDir: Bool(true),
+			},
+			false,
+		},
+		{
+			"on_missing_prefix",
+			`on_missing_prefix = "error"`,
+			&Config{
+				OnMissingPrefix: String("error"),
+			},
+			false,
+		},
+		{
+			"leader_key",
+			`leader_key = "service/consul-generator/leader"`,
+			&Config{
+				LeaderKey: String("service/consul-generator/leader"),
+			},
+			false,
+		},
+		{
+			"interval_jitter",
+			`interval_jitter = "2s"`,
+			&Config{
+				IntervalJitter: TimeDuration(2 * time.Second),
+			},
+			false,
+		},
+		{
+			"dedupe_identical",
+			`dedupe_identical = true`,
+			&Config{
+				DedupeIdentical: Bool(true),
+			},
+			false,
+		},
+		{
+			"perms",
+			`perms = "0640"`,
+			&Config{
+				Perms: FileMode(0640),
+			},
+			false,
+		},
+		{
+			"file_mode",
+			`file_mode {
+				pattern = "*.key"
+				mode = "0600"
+			}
+			file_mode {
+				pattern = "*.pem"
+				mode = "0644"
+			}`,
+			&Config{
+				FileModes: &FileModeConfigs{
+					&FileModeConfig{
+						Pattern: String("*.key"),
+						Mode:    FileMode(0600),
+					},
+					&FileModeConfig{
+						Pattern: String("*.pem"),
+						Mode:    FileMode(0644),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"self_test",
+			`self_test = true
+			self_test_prefix = "smoke/"`,
+			&Config{
+				SelfTest:       Bool(true),
+				SelfTestPrefix: String("smoke/"),
+			},
+			false,
+		},
+		{
+			"flap",
+			`flap_threshold = 4
+			flap_window = "30s"
+			flap_hold = true`,
+			&Config{
+				FlapThreshold: Int(4),
+				FlapWindow:    TimeDuration(30 * time.Second),
+				FlapHold:      Bool(true),
+			},
+			false,
+		},
+		{
+			"env_file",
+			`env_file = true
+			env_file_path = "/app/.env"`,
+			&Config{
+				EnvFile:     Bool(true),
+				EnvFilePath: String("/app/.env"),
+			},
+			false,
+		},
+		{
+			"create_dirs_for_folders",
+			`create_dirs_for_folders = true`,
+			&Config{
+				CreateDirsForFolders: Bool(true),
+			},
+			false,
+		},
+		{
+			"version_file",
+			`version_file = "/srv/www/version"`,
+			&Config{
+				VersionFile: String("/srv/www/version"),
+			},
+			false,
+		},
+		{
+			"on_write_error",
+			`on_write_error = "continue"`,
+			&Config{
+				OnWriteError: String("continue"),
+			},
+			false,
+		},
+		{
+			"max_files_per_pass",
+			`max_files_per_pass = 100`,
+			&Config{
+				MaxFilesPerPass: Int(100),
+			},
+			false,
+		},
+		{
+			"explain",
+			`explain = true`,
+			&Config{
+				Explain: Bool(true),
+			},
+			false,
+		},
+		{
+			"case_transform",
+			`case_transform = "lower"`,
+			&Config{
+				CaseTransform: String("lower"),
+			},
+			false,
+		},
+		{
+			"strip_bom",
+			`strip_bom = true`,
+			&Config{
+				StripBOM: Bool(true),
+			},
+			false,
+		},
+		{
+			"extension_map",
+			`default_extension = ".txt"
+			extension_map {
+				"nginx*" = ".conf"
+			}`,
+			&Config{
+				DefaultExtension: String(".txt"),
+				ExtensionMap:     map[string]string{"nginx*": ".conf"},
+			},
+			false,
+		},
+		{
+			"to_template",
+			`key_pattern = "teams/(?P<team>[^/]+)/(?P<file>.+)"
+			to_template = "/etc/app/{{.team}}/{{.file}}"`,
+			&Config{
+				KeyPattern: String("teams/(?P<team>[^/]+)/(?P<file>.+)"),
+				ToTemplate: String("/etc/app/{{.team}}/{{.file}}"),
+			},
+			false,
+		},
+		{
+			"flags_route",
+			`flags_route {
+				"1" = "/etc/a"
+				"2" = "/etc/b"
+			}`,
+			&Config{
+				FlagsRoute: map[string]string{"1": "/etc/a", "2": "/etc/b"},
+			},
+			false,
+		},
+		{
+			"interactive",
+			`interactive = true`,
+			&Config{
+				Interactive: Bool(true),
+			},
+			false,
+		},
+		{
+			"resolve_references",
+			`resolve_references = true`,
+			&Config{
+				ResolveReferences: Bool(true),
+			},
+			false,
+		},
+		{
+			"low_memory",
+			`low_memory = true`,
+			&Config{
+				LowMemory: Bool(true),
+			},
+			false,
+		},
+		{
+			"cache_by_index",
+			`cache_by_index = true`,
+			&Config{
+				CacheByIndex: Bool(true),
+			},
+			false,
+		},
+		{
+			"redact",
+			`redact = ["*.key", "secret*"]`,
+			&Config{
+				Redact: []string{"*.key", "secret*"},
+			},
+			false,
+		},
+		{
+			"on_dir_conflict",
+			`on_dir_conflict = "replace"
+			force_dir_replace = true`,
+			&Config{
+				OnDirConflict:   String("replace"),
+				ForceDirReplace: Bool(true),
+			},
+			false,
+		},
+		{
+			"value_filter",
+			`value_filter {
+				pattern = "*.json"
+				command = ["jq", "-S", "."]
+				timeout = "5s"
+			}
+			value_filter {
+				pattern = "*.yml"
+				command = ["yq"]
+			}`,
+			&Config{
+				ValueFilters: &ValueFilterConfigs{
+					&ValueFilterConfig{
+						Pattern: String("*.json"),
+						Command: []string{"jq", "-S", "."},
+						Timeout: TimeDuration(5 * time.Second),
+					},
+					&ValueFilterConfig{
+						Pattern: String("*.yml"),
+						Command: []string{"yq"},
+					},
+				},
+			},
+			false,
+		},
+		{
+			"sync",
+			`sync {
+				from = "app/"
+				to = "/etc/app"
+				perms = "0640"
+				interval = "30s"
+			}
+			sync {
+				from = "web/"
+				to = "/etc/web"
+			}`,
+			&Config{
+				Syncs: &SyncConfigs{
+					&SyncConfig{
+						From:     String("app/"),
+						To:       String("/etc/app"),
+						Perms:    FileMode(0640),
+						Interval: TimeDuration(30 * time.Second),
+					},
+					&SyncConfig{
+						From: String("web/"),
+						To:   String("/etc/web"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"preserve_structure",
+			`preserve_structure = true`,
+			&Config{
+				PreserveStructure: Bool(true),
+			},
+			false,
+		},
+		{
+			"folder_with_value",
+			`folder_with_value = "index"`,
+			&Config{
+				FolderWithValue: String("index"),
+			},
+			false,
+		},
+		{
+			"prune",
+			`prune = true`,
+			&Config{
+				Prune: Bool(true),
+			},
+			false,
+		},
+		{
+			"prune_patterns",
+			`prune_patterns = ["*.conf", "nginx/*"]`,
+			&Config{
+				PrunePatterns: []string{"*.conf", "nginx/*"},
+			},
+			false,
+		},
+		{
+			"command",
+			`command = "nginx -s reload"`,
+			&Config{
+				Commands: &CommandConfigs{
+					&CommandConfig{Exec: String("nginx -s reload")},
+				},
+			},
+			false,
+		},
+		{
+			"command_stanzas",
+			`command {
+				trigger = "nginx/*"
+				exec = "nginx -s reload"
+			}
+			command {
+				exec = "systemctl reload app"
+			}`,
+			&Config{
+				Commands: &CommandConfigs{
+					&CommandConfig{Trigger: String("nginx/*"), Exec: String("nginx -s reload")},
+					&CommandConfig{Exec: String("systemctl reload app")},
+				},
+			},
+			false,
+		},
+		{
+			"watch",
+			`watch = true`,
+			&Config{
+				Watch: Bool(true),
+			},
+			false,
+		},
+		{
+			"telemetry",
+			`telemetry {
+				address = ":9000"
+			}`,
+			&Config{
+				Telemetry: &TelemetryConfig{
+					Address: String(":9000"),
+				},
+			},
+			false,
+		},
+		{
+			"vault",
+			`vault {
+				address = "https://vault.service.consul:8200"
+				token = "s.abcd"
+				kv_version = 1
+			}`,
+			&Config{
+				Vault: &VaultConfig{
+					Address:   String("https://vault.service.consul:8200"),
+					Token:     String("s.abcd"),
+					KVVersion: Int(1),
+				},
+			},
+			false,
+		},
+		{
+			"vault_ssl",
+			`vault {
+				ssl {
+					enabled = true
+					verify = false
+				}
+			}`,
+			&Config{
+				Vault: &VaultConfig{
+					SSL: &SSLConfig{
+						Enabled: Bool(true),
+						Verify:  Bool(false),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"compression",
+			`compression = "gzip"`,
+			&Config{
+				Compression: String(CompressionGzip),
+			},
+			false,
+		},
+		{
+			"output_compression",
+			`output_compression = "gzip"
+			output_compression_suffix = ".gzip"`,
+			&Config{
+				OutputCompression:       String(CompressionGzip),
+				OutputCompressionSuffix: String(".gzip"),
+			},
+			false,
+		},
+		{
+			"concurrency",
+			`concurrency = 8`,
+			&Config{
+				Concurrency: Int(8),
+			},
+			false,
+		},
+		{
+			"wait",
+			`wait {
+				min = "2s"
+				max = "10s"
 			}`,
 			&Config{
-				Consul: &ConsulConfig{
-					Retry: &RetryConfig{
-						Attempts: Int(10),
-						Backoff:  TimeDuration(2 * time.Second),
-					},
+				Wait: &WaitConfig{
+					Min: TimeDuration(2 * time.Second),
+					Max: TimeDuration(10 * time.Second),
 				},
 			},
 			false,
 		},
 		{
-			"consul_ssl",
-			`consul {
-				ssl {}
+			"template",
+			`template = true
+			env {
+				pristine = true
+				custom = ["REGION=eu"]
 			}`,
 			&Config{
-				Consul: &ConsulConfig{
-					SSL: &SSLConfig{},
+				Template: Bool(true),
+				Env: &EnvConfig{
+					Pristine: Bool(true),
+					Custom:   []string{"REGION=eu"},
 				},
 			},
 			false,
 		},
 		{
-			"consul_ssl_enabled",
-			`consul {
-				ssl {
-					enabled = true
-				}
-			}`,
+			"template_delims",
+			`template_left_delim = "[["
+			template_right_delim = "]]"`,
 			&Config{
-				Consul: &ConsulConfig{
-					SSL: &SSLConfig{
-						Enabled: Bool(true),
-					},
-				},
+				TemplateLeftDelim:  String("[["),
+				TemplateRightDelim: String("]]"),
 			},
 			false,
 		},
 		{
-			"consul_ssl_verify",
-			`consul {
-				ssl {
-					verify = true
-				}
+			"rename",
+			`rename {
+				match = "\\.tmpl$"
+			}
+			rename {
+				match = "^(.*)\\.yml$"
+				replace = "$1.yaml"
 			}`,
 			&Config{
-				Consul: &ConsulConfig{
-					SSL: &SSLConfig{
-						Verify: Bool(true),
+				Renames: &RenameConfigs{
+					&RenameConfig{
+						Match: String(`\.tmpl$`),
+					},
+					&RenameConfig{
+						Match:   String(`^(.*)\.yml$`),
+						Replace: String("$1.yaml"),
 					},
 				},
 			},
 			false,
 		},
 		{
-			"consul_ssl_cert",
-			`consul {
-				ssl {
-					cert = "cert"
-				}
-			}`,
+			"include_exclude",
+			`include = ["app/*"]
+			exclude = ["*/secrets/*"]`,
 			&Config{
-				Consul: &ConsulConfig{
-					SSL: &SSLConfig{
-						Cert: String("cert"),
-					},
-				},
+				Include: []string{"app/*"},
+				Exclude: []string{"*/secrets/*"},
 			},
 			false,
 		},
 		{
-			"consul_ssl_key",
-			`consul {
-				ssl {
-					key = "key"
-				}
-			}`,
+			"manifest",
+			`manifest = "/srv/www/manifest.json"`,
 			&Config{
-				Consul: &ConsulConfig{
-					SSL: &SSLConfig{
-						Key: String("key"),
-					},
-				},
+				Manifest: String("/srv/www/manifest.json"),
 			},
 			false,
 		},
 		{
-			"consul_ssl_ca_cert",
-			`consul {
-				ssl {
-					ca_cert = "ca_cert"
-				}
+			"log_file",
+			`log_file {
+				path = "/var/log/consul-generator.log"
+				max_size_mb = 10
+				max_backups = 2
 			}`,
 			&Config{
-				Consul: &ConsulConfig{
-					SSL: &SSLConfig{
-						CaCert: String("ca_cert"),
-					},
+				LogFile: &LogFileConfig{
+					Path:       String("/var/log/consul-generator.log"),
+					MaxSizeMB:  Int(10),
+					MaxBackups: Int(2),
 				},
 			},
 			false,
 		},
 		{
-			"consul_ssl_ca_path",
-			`consul {
-				ssl {
-					ca_path = "ca_path"
-				}
-			}`,
+			"single",
+			`single = true`,
 			&Config{
-				Consul: &ConsulConfig{
-					SSL: &SSLConfig{
-						CaPath: String("ca_path"),
-					},
-				},
+				Single: Bool(true),
 			},
 			false,
 		},
 		{
-			"consul_ssl_server_name",
-			`consul {
-				ssl {
-					server_name = "server_name"
-				}
+			"notify",
+			`notify {
+				url = "https://example.com/hook"
+				timeout = "2s"
 			}`,
 			&Config{
-				Consul: &ConsulConfig{
-					SSL: &SSLConfig{
-						ServerName: String("server_name"),
-					},
+				Notify: &NotifyConfig{
+					URL:     String("https://example.com/hook"),
+					Timeout: TimeDuration(2 * time.Second),
 				},
 			},
 			false,
 		},
 		{
-			"consul_token",
-			`consul {
-				token = "token"
-			}`,
+			"preserve_mtime",
+			`preserve_mtime = true`,
 			&Config{
-				Consul: &ConsulConfig{
-					Token: String("token"),
-				},
+				PreserveMtime: Bool(true),
 			},
 			false,
 		},
 		{
-			"consul_transport_dial_keep_alive",
-			`consul {
-				transport {
-					dial_keep_alive = "10s"
-				}
-			}`,
+			"stdout",
+			`stdout = true`,
 			&Config{
-				Consul: &ConsulConfig{
-					Transport: &TransportConfig{
-						DialKeepAlive: TimeDuration(10 * time.Second),
-					},
-				},
+				Stdout: Bool(true),
 			},
 			false,
 		},
 		{
-			"consul_transport_dial_timeout",
-			`consul {
-				transport {
-					dial_timeout = "10s"
-				}
-			}`,
+			"separator",
+			`separator = "==> {key}"`,
 			&Config{
-				Consul: &ConsulConfig{
-					Transport: &TransportConfig{
-						DialTimeout: TimeDuration(10 * time.Second),
-					},
-				},
+				Separator: String("==> {key}"),
 			},
 			false,
 		},
 		{
-			"consul_transport_disable_keep_alives",
-			`consul {
-				transport {
-					disable_keep_alives = true
-				}
-			}`,
+			"require_keys",
+			`require_keys = true`,
 			&Config{
-				Consul: &ConsulConfig{
-					Transport: &TransportConfig{
-						DisableKeepAlives: Bool(true),
-					},
-				},
+				RequireKeys: Bool(true),
 			},
 			false,
 		},
 		{
-			"consul_transport_max_idle_conns_per_host",
-			`consul {
-				transport {
-					max_idle_conns_per_host = 100
-				}
-			}`,
+			"command_splay",
+			`command_splay = "5s"`,
 			&Config{
-				Consul: &ConsulConfig{
-					Transport: &TransportConfig{
-						MaxIdleConnsPerHost: Int(100),
-					},
-				},
+				CommandSplay: TimeDuration(5 * time.Second),
 			},
 			false,
 		},
 		{
-			"consul_transport_tls_handshake_timeout",
-			`consul {
-				transport {
-					tls_handshake_timeout = "30s"
-				}
-			}`,
+			"quiet_skips",
+			`quiet_skips = true`,
 			&Config{
-				Consul: &ConsulConfig{
-					Transport: &TransportConfig{
-						TLSHandshakeTimeout: TimeDuration(30 * time.Second),
-					},
-				},
+				QuietSkips: Bool(true),
 			},
 			false,
 		},
 		{
-			"kill_signal",
-			`kill_signal = "SIGUSR1"`,
+			"max_passes",
+			`max_passes = 3`,
 			&Config{
-				KillSignal: Signal(syscall.SIGUSR1),
+				MaxPasses: Int(3),
 			},
 			false,
 		},
 		{
-			"log_level",
-			`log_level = "WARN"`,
+			"write_throttle",
+			`write_throttle = "50ms"`,
 			&Config{
-				LogLevel: String("WARN"),
+				WriteThrottle: TimeDuration(50 * time.Millisecond),
 			},
 			false,
 		},
 		{
-			"pid_file",
-			`pid_file = "/var/pid"`,
+			"watermark",
+			`watermark = true
+			watermark_file = "/var/lib/consul-generator/watermark"`,
 			&Config{
-				PidFile: String("/var/pid"),
+				Watermark:     Bool(true),
+				WatermarkFile: String("/var/lib/consul-generator/watermark"),
 			},
 			false,
 		},
 		{
-			"reload_signal",
-			`reload_signal = "SIGUSR1"`,
+			"startup_deadline",
+			`startup_deadline = "30s"`,
 			&Config{
-				ReloadSignal: Signal(syscall.SIGUSR1),
+				StartupDeadline: TimeDuration(30 * time.Second),
 			},
 			false,
 		},
 		{
-			"syslog",
-			`syslog {}`,
+			"preflight",
+			`preflight = true`,
 			&Config{
-				Syslog: &SyslogConfig{},
+				Preflight: Bool(true),
 			},
 			false,
 		},
 		{
-			"syslog_enabled",
-			`syslog {
-				enabled = true
-			}`,
+			"push",
+			`push = true`,
 			&Config{
-				Syslog: &SyslogConfig{
-					Enabled: Bool(true),
-				},
+				Push: Bool(true),
 			},
 			false,
 		},
 		{
-			"syslog_facility",
-			`syslog {
-				facility = "facility"
-			}`,
+			"push_conflict",
+			`push_conflict = "retry"`,
 			&Config{
-				Syslog: &SyslogConfig{
-					Facility: String("facility"),
-				},
+				PushConflict: String("retry"),
 			},
 			false,
 		},
@@ -495,6 +1330,33 @@ func TestConfig_Merge(t *testing.T) {
 				},
 			},
 		},
+		{
+			"redact",
+			&Config{
+				Redact: []string{"*.key"},
+			},
+			&Config{
+				Redact: []string{"secret*"},
+			},
+			&Config{
+				Redact: []string{"*.key", "secret*"},
+			},
+		},
+		{
+			"include_exclude",
+			&Config{
+				Include: []string{"app/*"},
+				Exclude: []string{"*/secrets/*"},
+			},
+			&Config{
+				Include: []string{"web/*"},
+				Exclude: []string{"*/tmp/*"},
+			},
+			&Config{
+				Include: []string{"app/*", "web/*"},
+				Exclude: []string{"*/secrets/*", "*/tmp/*"},
+			},
+		},
 	}
 
 	for i, tc := range cases {
@@ -600,6 +1462,66 @@ func TestFromPath(t *testing.T) {
 	}
 }
 
+func TestFromPath_errors(t *testing.T) {
+	configDir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(configDir)
+
+	good, err := ioutil.TempFile(configDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(good.Name(), []byte(`log_level = "INFO"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bad, err := ioutil.TempFile(configDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := []byte(`
+consul {
+  address = "1.2.3.4"
+  adress  = "5.6.7.8"
+}
+`)
+	if err := ioutil.WriteFile(bad.Name(), d, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		path string
+		e    []string
+	}{
+		{
+			"file",
+			bad.Name(),
+			[]string{bad.Name(), `"consul.adress"`, "line 4, column 3"},
+		},
+		{
+			"config_dir",
+			configDir,
+			[]string{configDir, bad.Name(), `"consul.adress"`, "line 4, column 3"},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			_, err := FromPath(tc.path)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			for _, e := range tc.e {
+				if !strings.Contains(err.Error(), e) {
+					t.Errorf("\nexp: %q\nact: %q", e, err.Error())
+				}
+			}
+		})
+	}
+}
+
 func TestDefaultConfig(t *testing.T) {
 	cases := []struct {
 		env string
@@ -654,3 +1576,65 @@ func TestDefaultConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_Redacted(t *testing.T) {
+	c := DefaultConfig()
+	c.Finalize()
+	c.Consul.Token = String("s3cr3t")
+	c.Consul.Auth = &AuthConfig{Username: String("user"), Password: String("pass")}
+	c.Consul.SSL.KeyPEM = String("key")
+	c.Vault.Token = String("vault-token")
+	c.Consul.Headers = map[string]string{"Authorization": "Bearer SECRET"}
+
+	r := c.Redacted()
+	if v := r.Consul.Headers["Authorization"]; v != RedactedValue {
+		t.Errorf("expected consul.headers values to be redacted, got %q", v)
+	}
+	if v := c.Consul.Headers["Authorization"]; v != "Bearer SECRET" {
+		t.Errorf("expected the headers of the config itself to be left alone, got %q", v)
+	}
+	for name, v := range map[string]*string{
+		"consul.token":         r.Consul.Token,
+		"consul.auth.password": r.Consul.Auth.Password,
+		"consul.ssl.key_pem":   r.Consul.SSL.KeyPEM,
+		"vault.token":          r.Vault.Token,
+	} {
+		if StringVal(v) != RedactedValue {
+			t.Errorf("expected %s to be redacted, got %q", name, StringVal(v))
+		}
+	}
+	if StringVal(r.Consul.Auth.Username) != "user" {
+		t.Errorf("expected the username to be kept, got %q", StringVal(r.Consul.Auth.Username))
+	}
+	if StringVal(r.Vault.SSL.KeyPEM) != "" {
+		t.Errorf("expected an empty key_pem to stay empty, got %q", StringVal(r.Vault.SSL.KeyPEM))
+	}
+	if StringVal(c.Consul.Token) != "s3cr3t" {
+		t.Errorf("expected the config itself to be left alone, got %q", StringVal(c.Consul.Token))
+	}
+}
+
+func TestConfig_FinalizeInterval(t *testing.T) {
+	cases := []struct {
+		name string
+		i    *time.Duration
+		e    time.Duration
+	}{
+		{"unset", nil, DefaultInterval},
+		{"zero", TimeDuration(0), 0},
+		{"negative", TimeDuration(-time.Second), -time.Second},
+		{"below_minimum", TimeDuration(time.Millisecond), MinInterval},
+		{"minimum", TimeDuration(MinInterval), MinInterval},
+		{"seconds", TimeDuration(5 * time.Second), 5 * time.Second},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Config{Interval: tc.i}
+			c.Finalize()
+			if d := TimeDurationVal(c.Interval); d != tc.e {
+				t.Errorf("expected %s, got %s", tc.e, d)
+			}
+		})
+	}
+}
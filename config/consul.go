@@ -1,14 +1,52 @@
 package config
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultQueryTimeout bounds a list request on top of the time a
+	// blocking query may wait.
+	DefaultQueryTimeout = 30 * time.Second
+
+	// ConsistencyDefault, ConsistencyStale and ConsistencyConsistent are the
+	// consistency modes of list requests, as described in the Consul API.
+	ConsistencyDefault    = "default"
+	ConsistencyStale      = "stale"
+	ConsistencyConsistent = "consistent"
+)
 
 type ConsulConfig struct {
 	Address *string
 
 	Auth *AuthConfig `mapstructure:"auth"`
 
+	AuthMethod *AuthMethodConfig `mapstructure:"auth_method"`
+
+	// Consistency lets any server answer list requests with stale, or
+	// requires the leader to confirm it is still the leader with consistent.
+	Consistency *string `mapstructure:"consistency"`
+
+	// Datacenter and Namespace select the datacenter and the Consul
+	// Enterprise namespace of every request instead of the agent's.
+	Datacenter *string `mapstructure:"datacenter"`
+
+	Headers map[string]string `mapstructure:"headers"`
+
+	Namespace *string `mapstructure:"namespace"`
+
+	// QueryTimeout aborts a list request that got no answer in time, zero
+	// waits forever.
+	QueryTimeout *time.Duration `mapstructure:"query_timeout"`
+
 	Retry *RetryConfig `mapstructure:"retry"`
 
+	// Scheme overrides the scheme derived from ssl.enabled. A scheme in
+	// Address must agree with it.
+	Scheme *string `mapstructure:"scheme"`
+
 	SSL *SSLConfig `mapstructure:"ssl"`
 
 	Token *string
@@ -18,10 +56,11 @@ type ConsulConfig struct {
 
 func DefaultConsulConfig() *ConsulConfig {
 	return &ConsulConfig{
-		Auth:      DefaultAuthConfig(),
-		Retry:     DefaultRetryConfig(),
-		SSL:       DefaultSSLConfig(),
-		Transport: DefaultTransportConfig(),
+		Auth:       DefaultAuthConfig(),
+		AuthMethod: DefaultAuthMethodConfig(),
+		Retry:      DefaultRetryConfig(),
+		SSL:        DefaultSSLConfig(),
+		Transport:  DefaultTransportConfig(),
 	}
 }
 
@@ -38,10 +77,31 @@ func (c *ConsulConfig) Copy() *ConsulConfig {
 		o.Auth = c.Auth.Copy()
 	}
 
+	if c.AuthMethod != nil {
+		o.AuthMethod = c.AuthMethod.Copy()
+	}
+
+	o.Consistency = c.Consistency
+
+	o.Datacenter = c.Datacenter
+
+	if c.Headers != nil {
+		o.Headers = make(map[string]string, len(c.Headers))
+		for k, v := range c.Headers {
+			o.Headers[k] = v
+		}
+	}
+
+	o.Namespace = c.Namespace
+
+	o.QueryTimeout = c.QueryTimeout
+
 	if c.Retry != nil {
 		o.Retry = c.Retry.Copy()
 	}
 
+	o.Scheme = c.Scheme
+
 	if c.SSL != nil {
 		o.SSL = c.SSL.Copy()
 	}
@@ -77,10 +137,43 @@ func (c *ConsulConfig) Merge(o *ConsulConfig) *ConsulConfig {
 		r.Auth = r.Auth.Merge(o.Auth)
 	}
 
+	if o.AuthMethod != nil {
+		r.AuthMethod = r.AuthMethod.Merge(o.AuthMethod)
+	}
+
+	if o.Consistency != nil {
+		r.Consistency = o.Consistency
+	}
+
+	if o.Datacenter != nil {
+		r.Datacenter = o.Datacenter
+	}
+
+	if o.Headers != nil {
+		if r.Headers == nil {
+			r.Headers = make(map[string]string, len(o.Headers))
+		}
+		for k, v := range o.Headers {
+			r.Headers[k] = v
+		}
+	}
+
+	if o.Namespace != nil {
+		r.Namespace = o.Namespace
+	}
+
+	if o.QueryTimeout != nil {
+		r.QueryTimeout = o.QueryTimeout
+	}
+
 	if o.Retry != nil {
 		r.Retry = r.Retry.Merge(o.Retry)
 	}
 
+	if o.Scheme != nil {
+		r.Scheme = o.Scheme
+	}
+
 	if o.SSL != nil {
 		r.SSL = r.SSL.Merge(o.SSL)
 	}
@@ -108,11 +201,38 @@ func (c *ConsulConfig) Finalize() {
 	}
 	c.Auth.Finalize()
 
+	if c.AuthMethod == nil {
+		c.AuthMethod = DefaultAuthMethodConfig()
+	}
+	c.AuthMethod.Finalize()
+
+	if c.Consistency == nil {
+		c.Consistency = String(ConsistencyDefault)
+	}
+
+	if c.Datacenter == nil {
+		c.Datacenter = String("")
+	}
+
+	if c.Namespace == nil {
+		c.Namespace = stringFromEnv([]string{
+			"CONSUL_NAMESPACE",
+		}, "")
+	}
+
+	if c.QueryTimeout == nil {
+		c.QueryTimeout = TimeDuration(DefaultQueryTimeout)
+	}
+
 	if c.Retry == nil {
 		c.Retry = DefaultRetryConfig()
 	}
 	c.Retry.Finalize()
 
+	if c.Scheme == nil {
+		c.Scheme = String("")
+	}
+
 	if c.SSL == nil {
 		c.SSL = DefaultSSLConfig()
 	}
@@ -139,16 +259,39 @@ func (c *ConsulConfig) GoString() string {
 	return fmt.Sprintf("&ConsulConfig{"+
 		"Address:%s, "+
 		"Auth:%#v, "+
+		"AuthMethod:%#v, "+
+		"Consistency:%s, "+
+		"Datacenter:%s, "+
+		"Headers:%v, "+
+		"Namespace:%s, "+
+		"QueryTimeout:%s, "+
 		"Retry:%#v, "+
+		"Scheme:%s, "+
 		"SSL:%#v, "+
 		"Token:%t, "+
 		"Transport:%#v"+
 		"}",
 		StringGoString(c.Address),
 		c.Auth,
+		c.AuthMethod,
+		StringGoString(c.Consistency),
+		StringGoString(c.Datacenter),
+		c.headerNames(),
+		StringGoString(c.Namespace),
+		TimeDurationGoString(c.QueryTimeout),
 		c.Retry,
+		StringGoString(c.Scheme),
 		c.SSL,
 		StringPresent(c.Token),
 		c.Transport,
 	)
 }
+
+func (c *ConsulConfig) headerNames() []string {
+	names := make([]string, 0, len(c.Headers))
+	for k := range c.Headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
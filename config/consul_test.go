@@ -25,6 +25,7 @@ func TestConsulConfig_Copy(t *testing.T) {
 			&ConsulConfig{
 				Address: String("1.2.3.4"),
 				Auth:    &AuthConfig{Enabled: Bool(true)},
+				Headers: map[string]string{"X-Api-Key": "abcd"},
 				Retry:   &RetryConfig{Enabled: Bool(true)},
 				SSL:     &SSLConfig{Enabled: Bool(true)},
 				Token:   String("abcd1234"),
@@ -124,6 +125,36 @@ func TestConsulConfig_Merge(t *testing.T) {
 			&ConsulConfig{Auth: &AuthConfig{Enabled: Bool(true)}},
 			&ConsulConfig{Auth: &AuthConfig{Enabled: Bool(true)}},
 		},
+		{
+			"consistency_overrides",
+			&ConsulConfig{Consistency: String(ConsistencyStale)},
+			&ConsulConfig{Consistency: String(ConsistencyConsistent)},
+			&ConsulConfig{Consistency: String(ConsistencyConsistent)},
+		},
+		{
+			"consistency_empty_one",
+			&ConsulConfig{Consistency: String(ConsistencyStale)},
+			&ConsulConfig{},
+			&ConsulConfig{Consistency: String(ConsistencyStale)},
+		},
+		{
+			"headers_merge",
+			&ConsulConfig{Headers: map[string]string{"X-A": "a", "X-B": "b"}},
+			&ConsulConfig{Headers: map[string]string{"X-B": "c", "X-D": "d"}},
+			&ConsulConfig{Headers: map[string]string{"X-A": "a", "X-B": "c", "X-D": "d"}},
+		},
+		{
+			"headers_empty_one",
+			&ConsulConfig{Headers: map[string]string{"X-A": "a"}},
+			&ConsulConfig{},
+			&ConsulConfig{Headers: map[string]string{"X-A": "a"}},
+		},
+		{
+			"headers_empty_two",
+			&ConsulConfig{},
+			&ConsulConfig{Headers: map[string]string{"X-A": "a"}},
+			&ConsulConfig{Headers: map[string]string{"X-A": "a"}},
+		},
 		{
 			"retry_overrides",
 			&ConsulConfig{Retry: &RetryConfig{Enabled: Bool(true)}},
@@ -172,6 +203,24 @@ func TestConsulConfig_Merge(t *testing.T) {
 			&ConsulConfig{SSL: &SSLConfig{Enabled: Bool(true)}},
 			&ConsulConfig{SSL: &SSLConfig{Enabled: Bool(true)}},
 		},
+		{
+			"datacenter_overrides",
+			&ConsulConfig{Datacenter: String("dc1")},
+			&ConsulConfig{Datacenter: String("dc2")},
+			&ConsulConfig{Datacenter: String("dc2")},
+		},
+		{
+			"namespace_empty_two",
+			&ConsulConfig{},
+			&ConsulConfig{Namespace: String("team-a")},
+			&ConsulConfig{Namespace: String("team-a")},
+		},
+		{
+			"query_timeout_overrides",
+			&ConsulConfig{QueryTimeout: TimeDuration(10 * time.Second)},
+			&ConsulConfig{QueryTimeout: TimeDuration(0)},
+			&ConsulConfig{QueryTimeout: TimeDuration(0)},
+		},
 		{
 			"token_overrides",
 			&ConsulConfig{Token: String("same")},
@@ -248,12 +297,26 @@ func TestConsulConfig_Finalize(t *testing.T) {
 					Username: String(""),
 					Password: String(""),
 				},
+				AuthMethod: &AuthMethodConfig{
+					Enabled:         Bool(false),
+					Name:            String(""),
+					Type:            String(DefaultAuthMethodType),
+					BearerTokenFile: String(DefaultKubernetesBearerTokenFile),
+					Meta:            map[string]string{},
+				},
+				Consistency:  String(ConsistencyDefault),
+				Datacenter:   String(""),
+				Namespace:    String(""),
+				QueryTimeout: TimeDuration(DefaultQueryTimeout),
 				Retry: &RetryConfig{
 					Backoff:    TimeDuration(DefaultRetryBackoff),
 					MaxBackoff: TimeDuration(DefaultRetryMaxBackoff),
 					Enabled:    Bool(true),
 					Attempts:   Int(DefaultRetryAttempts),
+
+					MaxDuration: TimeDuration(0),
 				},
+				Scheme: String(""),
 				SSL: &SSLConfig{
 					CaCert:     String(""),
 					CaPath:     String(""),
@@ -262,6 +325,11 @@ func TestConsulConfig_Finalize(t *testing.T) {
 					Key:        String(""),
 					ServerName: String(""),
 					Verify:     Bool(true),
+					CertPEM:    String(""),
+					KeyPEM:     String(""),
+
+					UseConnectLeaf: Bool(false),
+					ConnectService: String(DefaultConnectService),
 				},
 				Token: String(""),
 				Transport: &TransportConfig{
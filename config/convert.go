@@ -129,6 +129,15 @@ func StringGoString(s *string) string {
 	return fmt.Sprintf("%q", *s)
 }
 
+// SecretGoString is StringGoString for tokens and passwords: a set value is
+// printed as RedactedValue instead of verbatim.
+func SecretGoString(s *string) string {
+	if !StringPresent(s) {
+		return StringGoString(s)
+	}
+	return fmt.Sprintf("%q", RedactedValue)
+}
+
 func StringPresent(s *string) bool {
 	if s == nil {
 		return false
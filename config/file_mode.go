@@ -0,0 +1,186 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type FileModeConfig struct {
+	Pattern *string      `mapstructure:"pattern"`
+	Mode    *os.FileMode `mapstructure:"mode"`
+}
+
+func DefaultFileModeConfig() *FileModeConfig {
+	return &FileModeConfig{}
+}
+
+func (c *FileModeConfig) Copy() *FileModeConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o FileModeConfig
+
+	o.Pattern = c.Pattern
+
+	o.Mode = c.Mode
+
+	return &o
+}
+
+func (c *FileModeConfig) Merge(o *FileModeConfig) *FileModeConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Pattern != nil {
+		r.Pattern = o.Pattern
+	}
+
+	if o.Mode != nil {
+		r.Mode = o.Mode
+	}
+
+	return r
+}
+
+func (c *FileModeConfig) Finalize() {
+	if c.Pattern == nil {
+		c.Pattern = String("")
+	}
+
+	if c.Mode == nil {
+		c.Mode = FileMode(0)
+	}
+}
+
+func (c *FileModeConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	pattern := StringVal(c.Pattern)
+	if pattern == "" {
+		return fmt.Errorf("file_mode: missing pattern")
+	}
+
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("file_mode: invalid pattern %q: %s", pattern, err)
+	}
+
+	if !FileModePresent(c.Mode) {
+		return fmt.Errorf("file_mode: missing mode for pattern %q", pattern)
+	}
+
+	return nil
+}
+
+func (c *FileModeConfig) GoString() string {
+	if c == nil {
+		return "(*FileModeConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&FileModeConfig{"+
+		"Pattern:%s, "+
+		"Mode:%s"+
+		"}",
+		StringGoString(c.Pattern),
+		FileModeGoString(c.Mode),
+	)
+}
+
+type FileModeConfigs []*FileModeConfig
+
+func DefaultFileModeConfigs() *FileModeConfigs {
+	return &FileModeConfigs{}
+}
+
+func (c *FileModeConfigs) Copy() *FileModeConfigs {
+	if c == nil {
+		return nil
+	}
+
+	o := make(FileModeConfigs, len(*c))
+	for i, t := range *c {
+		o[i] = t.Copy()
+	}
+	return &o
+}
+
+func (c *FileModeConfigs) Merge(o *FileModeConfigs) *FileModeConfigs {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	*r = append(*r, *o...)
+
+	return r
+}
+
+func (c *FileModeConfigs) Finalize() {
+	for _, t := range *c {
+		t.Finalize()
+	}
+}
+
+func (c *FileModeConfigs) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	for _, t := range *c {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Mode returns the mode of the first stanza whose pattern matches name.
+func (c *FileModeConfigs) Mode(name string) (os.FileMode, bool) {
+	if c == nil {
+		return 0, false
+	}
+
+	for _, t := range *c {
+		if matched, _ := filepath.Match(StringVal(t.Pattern), name); matched {
+			return FileModeVal(t.Mode), true
+		}
+	}
+
+	return 0, false
+}
+
+func (c *FileModeConfigs) GoString() string {
+	if c == nil {
+		return "(*FileModeConfigs)(nil)"
+	}
+
+	s := make([]string, len(*c))
+	for i, t := range *c {
+		s[i] = t.GoString()
+	}
+
+	return "{" + strings.Join(s, ", ") + "}"
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFileModeConfigs_Copy(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *FileModeConfigs
+	}{
+		{
+			"nil",
+			nil,
+		},
+		{
+			"empty",
+			&FileModeConfigs{},
+		},
+		{
+			"same_enabled",
+			&FileModeConfigs{
+				&FileModeConfig{
+					Pattern: String("*.key"),
+					Mode:    FileMode(0600),
+				},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Copy()
+			if !reflect.DeepEqual(tc.a, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.a, r)
+			}
+		})
+	}
+}
+
+func TestFileModeConfigs_Merge(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *FileModeConfigs
+		b    *FileModeConfigs
+		r    *FileModeConfigs
+	}{
+		{
+			"nil_a",
+			nil,
+			&FileModeConfigs{},
+			&FileModeConfigs{},
+		},
+		{
+			"nil_b",
+			&FileModeConfigs{},
+			nil,
+			&FileModeConfigs{},
+		},
+		{
+			"nil_both",
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"empty",
+			&FileModeConfigs{},
+			&FileModeConfigs{},
+			&FileModeConfigs{},
+		},
+		{
+			"appends",
+			&FileModeConfigs{
+				&FileModeConfig{Pattern: String("*.key")},
+			},
+			&FileModeConfigs{
+				&FileModeConfig{Pattern: String("*.pem")},
+			},
+			&FileModeConfigs{
+				&FileModeConfig{Pattern: String("*.key")},
+				&FileModeConfig{Pattern: String("*.pem")},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Merge(tc.b)
+			if !reflect.DeepEqual(tc.r, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.r, r)
+			}
+		})
+	}
+}
+
+func TestFileModeConfigs_Mode(t *testing.T) {
+	c := &FileModeConfigs{
+		&FileModeConfig{Pattern: String("*.key"), Mode: FileMode(0600)},
+		&FileModeConfig{Pattern: String("tls.*"), Mode: FileMode(0640)},
+	}
+
+	cases := []struct {
+		name string
+		file string
+		mode os.FileMode
+		ok   bool
+	}{
+		{"match", "server.key", 0600, true},
+		{"first_match_wins", "tls.key", 0600, true},
+		{"second", "tls.crt", 0640, true},
+		{"no_match", "app.conf", 0, false},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			mode, ok := c.Mode(tc.file)
+			if mode != tc.mode || ok != tc.ok {
+				t.Errorf("\nexp: %o %t\nact: %o %t", tc.mode, tc.ok, mode, ok)
+			}
+		})
+	}
+}
+
+func TestFileModeConfig_Validate(t *testing.T) {
+	cases := []struct {
+		name string
+		c    *FileModeConfig
+		err  bool
+	}{
+		{"valid", &FileModeConfig{Pattern: String("*.key"), Mode: FileMode(0600)}, false},
+		{"missing_pattern", &FileModeConfig{Mode: FileMode(0600)}, true},
+		{"bad_pattern", &FileModeConfig{Pattern: String("[*.key"), Mode: FileMode(0600)}, true},
+		{"missing_mode", &FileModeConfig{Pattern: String("*.key")}, true},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			if err := tc.c.Validate(); (err != nil) != tc.err {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package config
+
+import "fmt"
+
+const (
+	DefaultLogFileMaxSizeMB  = 100
+	DefaultLogFileMaxBackups = 3
+)
+
+// LogFileConfig writes the log to Path as well, rotating it once it grows
+// past MaxSizeMB and keeping MaxBackups rotated files.
+type LogFileConfig struct {
+	Path       *string `mapstructure:"path"`
+	MaxSizeMB  *int    `mapstructure:"max_size_mb"`
+	MaxBackups *int    `mapstructure:"max_backups"`
+}
+
+func DefaultLogFileConfig() *LogFileConfig {
+	return &LogFileConfig{}
+}
+
+func (c *LogFileConfig) Copy() *LogFileConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o LogFileConfig
+	o.Path = c.Path
+	o.MaxSizeMB = c.MaxSizeMB
+	o.MaxBackups = c.MaxBackups
+	return &o
+}
+
+func (c *LogFileConfig) Merge(o *LogFileConfig) *LogFileConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Path != nil {
+		r.Path = o.Path
+	}
+
+	if o.MaxSizeMB != nil {
+		r.MaxSizeMB = o.MaxSizeMB
+	}
+
+	if o.MaxBackups != nil {
+		r.MaxBackups = o.MaxBackups
+	}
+
+	return r
+}
+
+func (c *LogFileConfig) Finalize() {
+	if c.Path == nil {
+		c.Path = String("")
+	}
+
+	if c.MaxSizeMB == nil {
+		c.MaxSizeMB = Int(DefaultLogFileMaxSizeMB)
+	}
+
+	if c.MaxBackups == nil {
+		c.MaxBackups = Int(DefaultLogFileMaxBackups)
+	}
+}
+
+func (c *LogFileConfig) GoString() string {
+	if c == nil {
+		return "(*LogFileConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&LogFileConfig{"+
+		"Path:%s, "+
+		"MaxSizeMB:%s, "+
+		"MaxBackups:%s"+
+		"}",
+		StringGoString(c.Path),
+		IntGoString(c.MaxSizeMB),
+		IntGoString(c.MaxBackups),
+	)
+}
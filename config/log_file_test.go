@@ -0,0 +1,146 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestLogFileConfig_Copy(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *LogFileConfig
+	}{
+		{
+			"nil",
+			nil,
+		},
+		{
+			"empty",
+			&LogFileConfig{},
+		},
+		{
+			"same_enabled",
+			&LogFileConfig{
+				Path:       String("/var/log/consul-generator.log"),
+				MaxSizeMB:  Int(10),
+				MaxBackups: Int(2),
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Copy()
+			if !reflect.DeepEqual(tc.a, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.a, r)
+			}
+		})
+	}
+}
+
+func TestLogFileConfig_Merge(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *LogFileConfig
+		b    *LogFileConfig
+		r    *LogFileConfig
+	}{
+		{
+			"nil_a",
+			nil,
+			&LogFileConfig{},
+			&LogFileConfig{},
+		},
+		{
+			"nil_b",
+			&LogFileConfig{},
+			nil,
+			&LogFileConfig{},
+		},
+		{
+			"nil_both",
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"empty",
+			&LogFileConfig{},
+			&LogFileConfig{},
+			&LogFileConfig{},
+		},
+		{
+			"path_overrides",
+			&LogFileConfig{Path: String("a.log")},
+			&LogFileConfig{Path: String("")},
+			&LogFileConfig{Path: String("")},
+		},
+		{
+			"path_empty_one",
+			&LogFileConfig{Path: String("a.log")},
+			&LogFileConfig{},
+			&LogFileConfig{Path: String("a.log")},
+		},
+		{
+			"max_size_mb_overrides",
+			&LogFileConfig{MaxSizeMB: Int(10)},
+			&LogFileConfig{MaxSizeMB: Int(20)},
+			&LogFileConfig{MaxSizeMB: Int(20)},
+		},
+		{
+			"max_backups_empty_two",
+			&LogFileConfig{},
+			&LogFileConfig{MaxBackups: Int(0)},
+			&LogFileConfig{MaxBackups: Int(0)},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Merge(tc.b)
+			if !reflect.DeepEqual(tc.r, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.r, r)
+			}
+		})
+	}
+}
+
+func TestLogFileConfig_Finalize(t *testing.T) {
+	cases := []struct {
+		name string
+		i    *LogFileConfig
+		r    *LogFileConfig
+	}{
+		{
+			"empty",
+			&LogFileConfig{},
+			&LogFileConfig{
+				Path:       String(""),
+				MaxSizeMB:  Int(DefaultLogFileMaxSizeMB),
+				MaxBackups: Int(DefaultLogFileMaxBackups),
+			},
+		},
+		{
+			"with_path",
+			&LogFileConfig{
+				Path:       String("a.log"),
+				MaxBackups: Int(0),
+			},
+			&LogFileConfig{
+				Path:       String("a.log"),
+				MaxSizeMB:  Int(DefaultLogFileMaxSizeMB),
+				MaxBackups: Int(0),
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			tc.i.Finalize()
+			if !reflect.DeepEqual(tc.r, tc.i) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.r, tc.i)
+			}
+		})
+	}
+}
@@ -46,3 +46,18 @@ func ConsulStringToStructFunc() mapstructure.DecodeHookFunc {
 		return data, nil
 	}
 }
+
+// CommandStringToStructFunc decodes command = "..." as a single command
+// stanza without a trigger.
+func CommandStringToStructFunc() mapstructure.DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{}) (interface{}, error) {
+		if t == reflect.TypeOf(CommandConfigs{}) && f.Kind() == reflect.String {
+			return []map[string]interface{}{{"exec": data}}, nil
+		}
+
+		return data, nil
+	}
+}
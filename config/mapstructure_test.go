@@ -95,3 +95,41 @@ func TestConsulStringToStructFunc(t *testing.T) {
 		})
 	}
 }
+
+func TestCommandStringToStructFunc(t *testing.T) {
+	f := CommandStringToStructFunc()
+	strType := reflect.TypeOf("")
+	commandsType := reflect.TypeOf(CommandConfigs{})
+
+	cases := []struct {
+		name     string
+		f, t     reflect.Type
+		data     interface{}
+		expected interface{}
+	}{
+		{
+			"string",
+			strType, commandsType,
+			"nginx -s reload",
+			[]map[string]interface{}{{"exec": "nginx -s reload"}},
+		},
+		{
+			"not_command",
+			strType, strType,
+			"test",
+			"test",
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			actual, err := mapstructure.DecodeHookExec(f, tc.f, tc.t, tc.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.expected, actual)
+			}
+		})
+	}
+}
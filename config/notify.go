@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultNotifyTimeout = 5 * time.Second
+)
+
+// NotifyConfig posts the files written or pruned by a pass to URL.
+type NotifyConfig struct {
+	URL     *string        `mapstructure:"url"`
+	Timeout *time.Duration `mapstructure:"timeout"`
+}
+
+func DefaultNotifyConfig() *NotifyConfig {
+	return &NotifyConfig{}
+}
+
+func (c *NotifyConfig) Copy() *NotifyConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o NotifyConfig
+	o.URL = c.URL
+	o.Timeout = c.Timeout
+	return &o
+}
+
+func (c *NotifyConfig) Merge(o *NotifyConfig) *NotifyConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.URL != nil {
+		r.URL = o.URL
+	}
+
+	if o.Timeout != nil {
+		r.Timeout = o.Timeout
+	}
+
+	return r
+}
+
+func (c *NotifyConfig) Finalize() {
+	if c.URL == nil {
+		c.URL = String("")
+	}
+
+	if c.Timeout == nil {
+		c.Timeout = TimeDuration(DefaultNotifyTimeout)
+	}
+}
+
+func (c *NotifyConfig) GoString() string {
+	if c == nil {
+		return "(*NotifyConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&NotifyConfig{"+
+		"URL:%s, "+
+		"Timeout:%s"+
+		"}",
+		StringGoString(c.URL),
+		TimeDurationGoString(c.Timeout),
+	)
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNotifyConfig_Copy(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *NotifyConfig
+	}{
+		{
+			"nil",
+			nil,
+		},
+		{
+			"empty",
+			&NotifyConfig{},
+		},
+		{
+			"same_enabled",
+			&NotifyConfig{
+				URL:     String("https://example.com/hook"),
+				Timeout: TimeDuration(time.Second),
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Copy()
+			if !reflect.DeepEqual(tc.a, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.a, r)
+			}
+		})
+	}
+}
+
+func TestNotifyConfig_Merge(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *NotifyConfig
+		b    *NotifyConfig
+		r    *NotifyConfig
+	}{
+		{
+			"nil_a",
+			nil,
+			&NotifyConfig{},
+			&NotifyConfig{},
+		},
+		{
+			"nil_b",
+			&NotifyConfig{},
+			nil,
+			&NotifyConfig{},
+		},
+		{
+			"nil_both",
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"url_overrides",
+			&NotifyConfig{URL: String("https://a")},
+			&NotifyConfig{URL: String("")},
+			&NotifyConfig{URL: String("")},
+		},
+		{
+			"url_empty_one",
+			&NotifyConfig{URL: String("https://a")},
+			&NotifyConfig{},
+			&NotifyConfig{URL: String("https://a")},
+		},
+		{
+			"timeout_overrides",
+			&NotifyConfig{Timeout: TimeDuration(time.Second)},
+			&NotifyConfig{Timeout: TimeDuration(time.Minute)},
+			&NotifyConfig{Timeout: TimeDuration(time.Minute)},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Merge(tc.b)
+			if !reflect.DeepEqual(tc.r, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.r, r)
+			}
+		})
+	}
+}
+
+func TestNotifyConfig_Finalize(t *testing.T) {
+	cases := []struct {
+		name string
+		i    *NotifyConfig
+		r    *NotifyConfig
+	}{
+		{
+			"empty",
+			&NotifyConfig{},
+			&NotifyConfig{
+				URL:     String(""),
+				Timeout: TimeDuration(DefaultNotifyTimeout),
+			},
+		},
+		{
+			"with_url",
+			&NotifyConfig{
+				URL: String("https://example.com/hook"),
+			},
+			&NotifyConfig{
+				URL:     String("https://example.com/hook"),
+				Timeout: TimeDuration(DefaultNotifyTimeout),
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			tc.i.Finalize()
+			if !reflect.DeepEqual(tc.r, tc.i) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.r, tc.i)
+			}
+		})
+	}
+}
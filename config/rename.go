@@ -0,0 +1,168 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RenameConfig replaces matches of the regexp Match in a file name with
+// Replace, which may refer to submatches as $1.
+type RenameConfig struct {
+	Match   *string `mapstructure:"match"`
+	Replace *string `mapstructure:"replace"`
+}
+
+func DefaultRenameConfig() *RenameConfig {
+	return &RenameConfig{}
+}
+
+func (c *RenameConfig) Copy() *RenameConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o RenameConfig
+
+	o.Match = c.Match
+
+	o.Replace = c.Replace
+
+	return &o
+}
+
+func (c *RenameConfig) Merge(o *RenameConfig) *RenameConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Match != nil {
+		r.Match = o.Match
+	}
+
+	if o.Replace != nil {
+		r.Replace = o.Replace
+	}
+
+	return r
+}
+
+func (c *RenameConfig) Finalize() {
+	if c.Match == nil {
+		c.Match = String("")
+	}
+
+	if c.Replace == nil {
+		c.Replace = String("")
+	}
+}
+
+func (c *RenameConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	match := StringVal(c.Match)
+	if match == "" {
+		return fmt.Errorf("rename: missing match")
+	}
+
+	if _, err := regexp.Compile(match); err != nil {
+		return fmt.Errorf("rename: invalid match %q: %s", match, err)
+	}
+
+	return nil
+}
+
+func (c *RenameConfig) GoString() string {
+	if c == nil {
+		return "(*RenameConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&RenameConfig{"+
+		"Match:%s, "+
+		"Replace:%s"+
+		"}",
+		StringGoString(c.Match),
+		StringGoString(c.Replace),
+	)
+}
+
+type RenameConfigs []*RenameConfig
+
+func DefaultRenameConfigs() *RenameConfigs {
+	return &RenameConfigs{}
+}
+
+func (c *RenameConfigs) Copy() *RenameConfigs {
+	if c == nil {
+		return nil
+	}
+
+	o := make(RenameConfigs, len(*c))
+	for i, t := range *c {
+		o[i] = t.Copy()
+	}
+	return &o
+}
+
+func (c *RenameConfigs) Merge(o *RenameConfigs) *RenameConfigs {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	*r = append(*r, *o...)
+
+	return r
+}
+
+func (c *RenameConfigs) Finalize() {
+	for _, t := range *c {
+		t.Finalize()
+	}
+}
+
+func (c *RenameConfigs) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	for _, t := range *c {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *RenameConfigs) GoString() string {
+	if c == nil {
+		return "(*RenameConfigs)(nil)"
+	}
+
+	s := make([]string, len(*c))
+	for i, t := range *c {
+		s[i] = t.GoString()
+	}
+
+	return "{" + strings.Join(s, ", ") + "}"
+}
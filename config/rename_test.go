@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestRenameConfigs_Copy(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *RenameConfigs
+	}{
+		{
+			"nil",
+			nil,
+		},
+		{
+			"empty",
+			&RenameConfigs{},
+		},
+		{
+			"same_enabled",
+			&RenameConfigs{
+				&RenameConfig{
+					Match:   String(`\.tmpl$`),
+					Replace: String(""),
+				},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Copy()
+			if !reflect.DeepEqual(tc.a, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.a, r)
+			}
+		})
+	}
+}
+
+func TestRenameConfigs_Merge(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *RenameConfigs
+		b    *RenameConfigs
+		r    *RenameConfigs
+	}{
+		{
+			"nil_a",
+			nil,
+			&RenameConfigs{},
+			&RenameConfigs{},
+		},
+		{
+			"nil_b",
+			&RenameConfigs{},
+			nil,
+			&RenameConfigs{},
+		},
+		{
+			"nil_both",
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"appends",
+			&RenameConfigs{
+				&RenameConfig{Match: String(`\.tmpl$`)},
+			},
+			&RenameConfigs{
+				&RenameConfig{Match: String(`\.yml$`)},
+			},
+			&RenameConfigs{
+				&RenameConfig{Match: String(`\.tmpl$`)},
+				&RenameConfig{Match: String(`\.yml$`)},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Merge(tc.b)
+			if !reflect.DeepEqual(tc.r, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.r, r)
+			}
+		})
+	}
+}
+
+func TestRenameConfig_Finalize(t *testing.T) {
+	c := &RenameConfig{Match: String(`\.tmpl$`)}
+	c.Finalize()
+
+	e := &RenameConfig{Match: String(`\.tmpl$`), Replace: String("")}
+	if !reflect.DeepEqual(e, c) {
+		t.Errorf("\nexp: %#v\nact: %#v", e, c)
+	}
+}
+
+func TestRenameConfig_Validate(t *testing.T) {
+	cases := []struct {
+		name string
+		c    *RenameConfig
+		err  bool
+	}{
+		{"valid", &RenameConfig{Match: String(`\.tmpl$`)}, false},
+		{"missing_match", &RenameConfig{Replace: String("x")}, true},
+		{"bad_match", &RenameConfig{Match: String(`(\.tmpl$`)}, true},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			if err := tc.c.Validate(); (err != nil) != tc.err {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
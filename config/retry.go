@@ -14,11 +14,18 @@ const (
 
 type RetryFunc func(int) (bool, time.Duration)
 
+var now = time.Now
+
 type RetryConfig struct {
 	Attempts   *int
 	Backoff    *time.Duration
 	MaxBackoff *time.Duration `mapstructure:"max_backoff"`
 	Enabled    *bool
+
+	// MaxDuration caps the total time spent retrying, counted from the
+	// first retry. Whichever of Attempts and MaxDuration is hit first stops
+	// the retries.
+	MaxDuration *time.Duration `mapstructure:"max_duration"`
 }
 
 func DefaultRetryConfig() *RetryConfig {
@@ -40,6 +47,8 @@ func (c *RetryConfig) Copy() *RetryConfig {
 
 	o.Enabled = c.Enabled
 
+	o.MaxDuration = c.MaxDuration
+
 	return &o
 }
 
@@ -73,10 +82,15 @@ func (c *RetryConfig) Merge(o *RetryConfig) *RetryConfig {
 		r.Enabled = o.Enabled
 	}
 
+	if o.MaxDuration != nil {
+		r.MaxDuration = o.MaxDuration
+	}
+
 	return r
 }
 
 func (c *RetryConfig) RetryFunc() RetryFunc {
+	var start time.Time
 	return func(retry int) (bool, time.Duration) {
 		if !BoolVal(c.Enabled) {
 			return false, 0
@@ -86,21 +100,40 @@ func (c *RetryConfig) RetryFunc() RetryFunc {
 			return false, 0
 		}
 
-		baseSleep := TimeDurationVal(c.Backoff)
-		maxSleep := TimeDurationVal(c.MaxBackoff)
-
-		if maxSleep > 0 {
-			attemptsTillMaxBackoff := int(math.Log2(maxSleep.Seconds() / baseSleep.Seconds()))
-			if retry > attemptsTillMaxBackoff {
-				return true, maxSleep
-			}
+		if retry == 0 || start.IsZero() {
+			start = now()
+		}
+		if max := TimeDurationVal(c.MaxDuration); max > 0 && now().Sub(start) >= max {
+			return false, 0
 		}
 
-		base := math.Pow(2, float64(retry))
-		sleep := time.Duration(base) * baseSleep
+		return true, backoff(TimeDurationVal(c.Backoff), TimeDurationVal(c.MaxBackoff), retry)
+	}
+}
 
-		return true, sleep
+// backoff returns base doubled retry times, clamped to [0, max] unless max
+// is 0. It saturates instead of overflowing for large retry counts.
+func backoff(base, max time.Duration, retry int) time.Duration {
+	if base <= 0 {
+		return 0
 	}
+
+	if retry < 0 {
+		retry = 0
+	}
+	if retry > 62 {
+		retry = 62
+	}
+
+	sleep := time.Duration(math.MaxInt64)
+	if base <= sleep>>uint(retry) {
+		sleep = base << uint(retry)
+	}
+
+	if max > 0 && sleep > max {
+		sleep = max
+	}
+	return sleep
 }
 
 func (c *RetryConfig) Finalize() {
@@ -119,6 +152,10 @@ func (c *RetryConfig) Finalize() {
 	if c.Enabled == nil {
 		c.Enabled = Bool(true)
 	}
+
+	if c.MaxDuration == nil {
+		c.MaxDuration = TimeDuration(0)
+	}
 }
 
 func (c *RetryConfig) GoString() string {
@@ -130,11 +167,13 @@ func (c *RetryConfig) GoString() string {
 		"Attempts:%s, "+
 		"Backoff:%s, "+
 		"MaxBackoff:%s, "+
-		"Enabled:%s"+
+		"Enabled:%s, "+
+		"MaxDuration:%s"+
 		"}",
 		IntGoString(c.Attempts),
 		TimeDurationGoString(c.Backoff),
 		TimeDurationGoString(c.MaxBackoff),
 		BoolGoString(c.Enabled),
+		TimeDurationGoString(c.MaxDuration),
 	)
 }
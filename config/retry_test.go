@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 	"testing"
 	"time"
@@ -138,6 +139,44 @@ func TestRetryFunc(t *testing.T) {
 			Bool(true),
 			TimeDuration(2 * time.Millisecond),
 		},
+		{
+			"zero backoff",
+			&RetryConfig{
+				Backoff: TimeDuration(0),
+			},
+			Int(3),
+			Bool(true),
+			TimeDuration(0),
+		},
+		{
+			"max backoff below backoff",
+			&RetryConfig{
+				Backoff:    TimeDuration(10 * time.Second),
+				MaxBackoff: TimeDuration(1 * time.Second),
+			},
+			Int(0),
+			Bool(true),
+			TimeDuration(1 * time.Second),
+		},
+		{
+			"max backoff, unlimited attempt 60",
+			&RetryConfig{
+				Attempts: Int(0),
+			},
+			Int(60),
+			Bool(true),
+			TimeDuration(1 * time.Minute),
+		},
+		{
+			"no max backoff, unlimited attempt 60",
+			&RetryConfig{
+				Attempts:   Int(0),
+				MaxBackoff: TimeDuration(0),
+			},
+			Int(60),
+			Bool(true),
+			TimeDuration(math.MaxInt64),
+		},
 	}
 
 	for i, tc := range cases {
@@ -155,6 +194,66 @@ func TestRetryFunc(t *testing.T) {
 
 }
 
+func TestRetryFunc_maxDuration(t *testing.T) {
+	defer func(orig func() time.Time) { now = orig }(now)
+
+	cases := []struct {
+		name  string
+		c     *RetryConfig
+		tries int
+	}{
+		{
+			"attempts_first",
+			&RetryConfig{
+				Attempts:    Int(3),
+				MaxDuration: TimeDuration(time.Hour),
+			},
+			3,
+		},
+		{
+			"duration_first",
+			&RetryConfig{
+				Attempts:    Int(100),
+				MaxDuration: TimeDuration(5 * time.Second),
+			},
+			5,
+		},
+		{
+			"duration_only",
+			&RetryConfig{
+				Attempts:    Int(0),
+				MaxDuration: TimeDuration(3 * time.Second),
+			},
+			3,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			clock := time.Now()
+			now = func() time.Time { return clock }
+
+			tc.c.Finalize()
+			f := tc.c.RetryFunc()
+
+			tries := 0
+			for retry := 0; retry < 1000; retry++ {
+				ok, _ := f(retry)
+				if !ok {
+					break
+				}
+				tries++
+				// Every attempt takes a second, whatever the backoff.
+				clock = clock.Add(time.Second)
+			}
+
+			if tries != tc.tries {
+				t.Errorf("\nexp: %d\nact: %d", tc.tries, tries)
+			}
+		})
+	}
+}
+
 func TestRetryConfig_Copy(t *testing.T) {
 	cases := []struct {
 		name string
@@ -353,6 +452,8 @@ func TestRetryConfig_Finalize(t *testing.T) {
 				Backoff:    TimeDuration(DefaultRetryBackoff),
 				MaxBackoff: TimeDuration(DefaultRetryMaxBackoff),
 				Enabled:    Bool(true),
+
+				MaxDuration: TimeDuration(0),
 			},
 		},
 	}
@@ -4,6 +4,8 @@ import "fmt"
 
 const (
 	DefaultSSLVerify = true
+
+	DefaultConnectService = "consul-generator"
 )
 
 type SSLConfig struct {
@@ -14,6 +16,16 @@ type SSLConfig struct {
 	Key        *string `mapstructure:"key"`
 	ServerName *string `mapstructure:"server_name"`
 	Verify     *bool   `mapstructure:"verify"`
+
+	// CertPEM and KeyPEM are the client certificate and key as PEM
+	// content. They take precedence over the Cert and Key files.
+	CertPEM *string `mapstructure:"cert_pem"`
+	KeyPEM  *string `mapstructure:"key_pem"`
+
+	// UseConnectLeaf uses a leaf certificate from the Connect CA, issued for
+	// ConnectService, as the client certificate.
+	UseConnectLeaf *bool   `mapstructure:"use_connect_leaf"`
+	ConnectService *string `mapstructure:"connect_service"`
 }
 
 func DefaultSSLConfig() *SSLConfig {
@@ -33,6 +45,10 @@ func (c *SSLConfig) Copy() *SSLConfig {
 	o.Key = c.Key
 	o.ServerName = c.ServerName
 	o.Verify = c.Verify
+	o.CertPEM = c.CertPEM
+	o.KeyPEM = c.KeyPEM
+	o.UseConnectLeaf = c.UseConnectLeaf
+	o.ConnectService = c.ConnectService
 	return &o
 }
 
@@ -78,6 +94,22 @@ func (c *SSLConfig) Merge(o *SSLConfig) *SSLConfig {
 		r.Verify = o.Verify
 	}
 
+	if o.CertPEM != nil {
+		r.CertPEM = o.CertPEM
+	}
+
+	if o.KeyPEM != nil {
+		r.KeyPEM = o.KeyPEM
+	}
+
+	if o.UseConnectLeaf != nil {
+		r.UseConnectLeaf = o.UseConnectLeaf
+	}
+
+	if o.ConnectService != nil {
+		r.ConnectService = o.ConnectService
+	}
+
 	return r
 }
 
@@ -89,7 +121,10 @@ func (c *SSLConfig) Finalize() {
 			StringPresent(c.CaPath) ||
 			StringPresent(c.Key) ||
 			StringPresent(c.ServerName) ||
-			BoolPresent(c.Verify))
+			BoolPresent(c.Verify) ||
+			StringPresent(c.CertPEM) ||
+			StringPresent(c.KeyPEM) ||
+			BoolVal(c.UseConnectLeaf))
 	}
 
 	if c.Cert == nil {
@@ -115,6 +150,22 @@ func (c *SSLConfig) Finalize() {
 	if c.Verify == nil {
 		c.Verify = Bool(DefaultSSLVerify)
 	}
+
+	if c.CertPEM == nil {
+		c.CertPEM = String("")
+	}
+
+	if c.KeyPEM == nil {
+		c.KeyPEM = String("")
+	}
+
+	if c.UseConnectLeaf == nil {
+		c.UseConnectLeaf = Bool(false)
+	}
+
+	if c.ConnectService == nil {
+		c.ConnectService = String(DefaultConnectService)
+	}
 }
 
 func (c *SSLConfig) GoString() string {
@@ -129,7 +180,11 @@ func (c *SSLConfig) GoString() string {
 		"Enabled:%s, "+
 		"Key:%s, "+
 		"ServerName:%s, "+
-		"Verify:%s"+
+		"Verify:%s, "+
+		"CertPEM:%t, "+
+		"KeyPEM:%t, "+
+		"UseConnectLeaf:%s, "+
+		"ConnectService:%s"+
 		"}",
 		StringGoString(c.CaCert),
 		StringGoString(c.CaPath),
@@ -138,5 +193,9 @@ func (c *SSLConfig) GoString() string {
 		StringGoString(c.Key),
 		StringGoString(c.ServerName),
 		BoolGoString(c.Verify),
+		StringPresent(c.CertPEM),
+		StringPresent(c.KeyPEM),
+		BoolGoString(c.UseConnectLeaf),
+		StringGoString(c.ConnectService),
 	)
 }
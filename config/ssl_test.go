@@ -29,6 +29,8 @@ func TestSSLConfig_Copy(t *testing.T) {
 				Cert:       String("cert"),
 				Key:        String("key"),
 				ServerName: String("server_name"),
+				CertPEM:    String("cert_pem"),
+				KeyPEM:     String("key_pem"),
 			},
 		},
 	}
@@ -218,6 +220,30 @@ func TestSSLConfig_Merge(t *testing.T) {
 			&SSLConfig{CaPath: String("ca_path")},
 			&SSLConfig{CaPath: String("ca_path")},
 		},
+		{
+			"cert_pem_overrides",
+			&SSLConfig{CertPEM: String("cert_pem")},
+			&SSLConfig{CertPEM: String("")},
+			&SSLConfig{CertPEM: String("")},
+		},
+		{
+			"cert_pem_empty_one",
+			&SSLConfig{CertPEM: String("cert_pem")},
+			&SSLConfig{},
+			&SSLConfig{CertPEM: String("cert_pem")},
+		},
+		{
+			"key_pem_overrides",
+			&SSLConfig{KeyPEM: String("key_pem")},
+			&SSLConfig{KeyPEM: String("")},
+			&SSLConfig{KeyPEM: String("")},
+		},
+		{
+			"key_pem_empty_one",
+			&SSLConfig{KeyPEM: String("key_pem")},
+			&SSLConfig{},
+			&SSLConfig{KeyPEM: String("key_pem")},
+		},
 		{
 			"server_name_overrides",
 			&SSLConfig{ServerName: String("server_name")},
@@ -271,6 +297,11 @@ func TestSSLConfig_Finalize(t *testing.T) {
 				Key:        String(""),
 				ServerName: String(""),
 				Verify:     Bool(true),
+				CertPEM:    String(""),
+				KeyPEM:     String(""),
+
+				UseConnectLeaf: Bool(false),
+				ConnectService: String(DefaultConnectService),
 			},
 		},
 		{
@@ -286,6 +317,11 @@ func TestSSLConfig_Finalize(t *testing.T) {
 				Key:        String(""),
 				ServerName: String(""),
 				Verify:     Bool(true),
+				CertPEM:    String(""),
+				KeyPEM:     String(""),
+
+				UseConnectLeaf: Bool(false),
+				ConnectService: String(DefaultConnectService),
 			},
 		},
 		{
@@ -301,6 +337,11 @@ func TestSSLConfig_Finalize(t *testing.T) {
 				Key:        String(""),
 				ServerName: String(""),
 				Verify:     Bool(true),
+				CertPEM:    String(""),
+				KeyPEM:     String(""),
+
+				UseConnectLeaf: Bool(false),
+				ConnectService: String(DefaultConnectService),
 			},
 		},
 		{
@@ -316,6 +357,11 @@ func TestSSLConfig_Finalize(t *testing.T) {
 				Key:        String(""),
 				ServerName: String(""),
 				Verify:     Bool(true),
+				CertPEM:    String(""),
+				KeyPEM:     String(""),
+
+				UseConnectLeaf: Bool(false),
+				ConnectService: String(DefaultConnectService),
 			},
 		},
 		{
@@ -331,6 +377,32 @@ func TestSSLConfig_Finalize(t *testing.T) {
 				Key:        String("key"),
 				ServerName: String(""),
 				Verify:     Bool(true),
+				CertPEM:    String(""),
+				KeyPEM:     String(""),
+
+				UseConnectLeaf: Bool(false),
+				ConnectService: String(DefaultConnectService),
+			},
+		},
+		{
+			"with_cert_pem",
+			&SSLConfig{
+				CertPEM: String("cert_pem"),
+				KeyPEM:  String("key_pem"),
+			},
+			&SSLConfig{
+				Enabled:    Bool(true),
+				Cert:       String(""),
+				CaCert:     String(""),
+				CaPath:     String(""),
+				Key:        String(""),
+				ServerName: String(""),
+				Verify:     Bool(true),
+				CertPEM:    String("cert_pem"),
+				KeyPEM:     String("key_pem"),
+
+				UseConnectLeaf: Bool(false),
+				ConnectService: String(DefaultConnectService),
 			},
 		},
 		{
@@ -346,6 +418,31 @@ func TestSSLConfig_Finalize(t *testing.T) {
 				Key:        String(""),
 				ServerName: String("server_name"),
 				Verify:     Bool(true),
+				CertPEM:    String(""),
+				KeyPEM:     String(""),
+
+				UseConnectLeaf: Bool(false),
+				ConnectService: String(DefaultConnectService),
+			},
+		},
+		{
+			"with_use_connect_leaf",
+			&SSLConfig{
+				UseConnectLeaf: Bool(true),
+			},
+			&SSLConfig{
+				Enabled:    Bool(true),
+				Cert:       String(""),
+				CaCert:     String(""),
+				CaPath:     String(""),
+				Key:        String(""),
+				ServerName: String(""),
+				Verify:     Bool(true),
+				CertPEM:    String(""),
+				KeyPEM:     String(""),
+
+				UseConnectLeaf: Bool(true),
+				ConnectService: String(DefaultConnectService),
 			},
 		},
 	}
@@ -0,0 +1,209 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// SyncConfig maps a Consul prefix to a local directory. Unset perms fall
+// back to the top level perms, and a zero interval to the top level
+// interval.
+type SyncConfig struct {
+	From     *string        `mapstructure:"from"`
+	To       *string        `mapstructure:"to"`
+	Perms    *os.FileMode   `mapstructure:"perms"`
+	Interval *time.Duration `mapstructure:"interval"`
+}
+
+func DefaultSyncConfig() *SyncConfig {
+	return &SyncConfig{}
+}
+
+func (c *SyncConfig) Copy() *SyncConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o SyncConfig
+
+	o.From = c.From
+
+	o.To = c.To
+
+	o.Perms = c.Perms
+
+	o.Interval = c.Interval
+
+	return &o
+}
+
+func (c *SyncConfig) Merge(o *SyncConfig) *SyncConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.From != nil {
+		r.From = o.From
+	}
+
+	if o.To != nil {
+		r.To = o.To
+	}
+
+	if o.Perms != nil {
+		r.Perms = o.Perms
+	}
+
+	if o.Interval != nil {
+		r.Interval = o.Interval
+	}
+
+	return r
+}
+
+func (c *SyncConfig) Finalize() {
+	if c.From == nil {
+		c.From = String("")
+	}
+
+	if c.To == nil {
+		c.To = String("")
+	}
+
+	if c.Interval == nil {
+		c.Interval = TimeDuration(0)
+	}
+	// A negative interval is left for validation to reject.
+	if d := TimeDurationVal(c.Interval); d > 0 && d < MinInterval {
+		log.Printf("[WARN] (config) sync interval %s is below the minimum of %s, using %s", d, MinInterval, MinInterval)
+		c.Interval = TimeDuration(MinInterval)
+	}
+}
+
+func (c *SyncConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	if StringVal(c.From) == "" {
+		return fmt.Errorf("sync: missing from")
+	}
+
+	if StringVal(c.To) == "" {
+		return fmt.Errorf("sync: missing to for from %q", StringVal(c.From))
+	}
+
+	if d := TimeDurationVal(c.Interval); d < 0 {
+		return fmt.Errorf("sync: interval for from %q must not be negative, got %s", StringVal(c.From), d)
+	}
+
+	return nil
+}
+
+func (c *SyncConfig) GoString() string {
+	if c == nil {
+		return "(*SyncConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&SyncConfig{"+
+		"From:%s, "+
+		"To:%s, "+
+		"Perms:%s, "+
+		"Interval:%s"+
+		"}",
+		StringGoString(c.From),
+		StringGoString(c.To),
+		FileModeGoString(c.Perms),
+		TimeDurationGoString(c.Interval),
+	)
+}
+
+type SyncConfigs []*SyncConfig
+
+func DefaultSyncConfigs() *SyncConfigs {
+	return &SyncConfigs{}
+}
+
+func (c *SyncConfigs) Copy() *SyncConfigs {
+	if c == nil {
+		return nil
+	}
+
+	o := make(SyncConfigs, len(*c))
+	for i, t := range *c {
+		o[i] = t.Copy()
+	}
+	return &o
+}
+
+func (c *SyncConfigs) Merge(o *SyncConfigs) *SyncConfigs {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	*r = append(*r, *o...)
+
+	return r
+}
+
+func (c *SyncConfigs) Finalize() {
+	for _, t := range *c {
+		t.Finalize()
+	}
+}
+
+// Validate checks every mapping, and that no two mappings share a to.
+func (c *SyncConfigs) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	seen := make(map[string]bool, len(*c))
+	for _, t := range *c {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+
+		to := StringVal(t.To)
+		if seen[to] {
+			return fmt.Errorf("sync: to %q is used by more than one mapping", to)
+		}
+		seen[to] = true
+	}
+
+	return nil
+}
+
+func (c *SyncConfigs) GoString() string {
+	if c == nil {
+		return "(*SyncConfigs)(nil)"
+	}
+
+	s := make([]string, len(*c))
+	for i, t := range *c {
+		s[i] = t.GoString()
+	}
+
+	return "{" + strings.Join(s, ", ") + "}"
+}
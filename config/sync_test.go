@@ -0,0 +1,175 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSyncConfigs_Copy(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *SyncConfigs
+	}{
+		{
+			"nil",
+			nil,
+		},
+		{
+			"empty",
+			&SyncConfigs{},
+		},
+		{
+			"same_enabled",
+			&SyncConfigs{
+				&SyncConfig{
+					From:     String("app/"),
+					To:       String("/etc/app"),
+					Perms:    FileMode(0640),
+					Interval: TimeDuration(time.Minute),
+				},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Copy()
+			if !reflect.DeepEqual(tc.a, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.a, r)
+			}
+		})
+	}
+}
+
+func TestSyncConfigs_Merge(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *SyncConfigs
+		b    *SyncConfigs
+		r    *SyncConfigs
+	}{
+		{
+			"nil_a",
+			nil,
+			&SyncConfigs{},
+			&SyncConfigs{},
+		},
+		{
+			"nil_b",
+			&SyncConfigs{},
+			nil,
+			&SyncConfigs{},
+		},
+		{
+			"nil_both",
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"empty",
+			&SyncConfigs{},
+			&SyncConfigs{},
+			&SyncConfigs{},
+		},
+		{
+			"appends",
+			&SyncConfigs{
+				&SyncConfig{From: String("app/"), To: String("/etc/app")},
+			},
+			&SyncConfigs{
+				&SyncConfig{From: String("web/"), To: String("/etc/web")},
+			},
+			&SyncConfigs{
+				&SyncConfig{From: String("app/"), To: String("/etc/app")},
+				&SyncConfig{From: String("web/"), To: String("/etc/web")},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Merge(tc.b)
+			if !reflect.DeepEqual(tc.r, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.r, r)
+			}
+		})
+	}
+}
+
+func TestSyncConfigs_Finalize(t *testing.T) {
+	c := &SyncConfigs{
+		&SyncConfig{From: String("app/")},
+		&SyncConfig{From: String("web/"), Interval: TimeDuration(time.Millisecond)},
+	}
+	c.Finalize()
+
+	e := &SyncConfigs{
+		&SyncConfig{From: String("app/"), To: String(""), Interval: TimeDuration(0)},
+		&SyncConfig{From: String("web/"), To: String(""), Interval: TimeDuration(MinInterval)},
+	}
+	if !reflect.DeepEqual(e, c) {
+		t.Errorf("\nexp: %#v\nact: %#v", e, c)
+	}
+}
+
+func TestSyncConfigs_Validate(t *testing.T) {
+	cases := []struct {
+		name string
+		c    *SyncConfigs
+		err  bool
+	}{
+		{
+			"nil",
+			nil,
+			false,
+		},
+		{
+			"valid",
+			&SyncConfigs{
+				&SyncConfig{From: String("app/"), To: String("/etc/app")},
+				&SyncConfig{From: String("web/"), To: String("/etc/web")},
+			},
+			false,
+		},
+		{
+			"missing_from",
+			&SyncConfigs{
+				&SyncConfig{From: String(""), To: String("/etc/app")},
+			},
+			true,
+		},
+		{
+			"missing_to",
+			&SyncConfigs{
+				&SyncConfig{From: String("app/"), To: String("")},
+			},
+			true,
+		},
+		{
+			"negative_interval",
+			&SyncConfigs{
+				&SyncConfig{From: String("app/"), To: String("/etc/app"), Interval: TimeDuration(-time.Second)},
+			},
+			true,
+		},
+		{
+			"same_to",
+			&SyncConfigs{
+				&SyncConfig{From: String("app/"), To: String("/etc/app")},
+				&SyncConfig{From: String("web/"), To: String("/etc/app")},
+			},
+			true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			if err := tc.c.Validate(); (err != nil) != tc.err {
+				t.Errorf("expected error %t, got %v", tc.err, err)
+			}
+		})
+	}
+}
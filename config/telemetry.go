@@ -0,0 +1,60 @@
+package config
+
+import "fmt"
+
+type TelemetryConfig struct {
+	Address *string `mapstructure:"address"`
+}
+
+func DefaultTelemetryConfig() *TelemetryConfig {
+	return &TelemetryConfig{}
+}
+
+func (c *TelemetryConfig) Copy() *TelemetryConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o TelemetryConfig
+	o.Address = c.Address
+	return &o
+}
+
+func (c *TelemetryConfig) Merge(o *TelemetryConfig) *TelemetryConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Address != nil {
+		r.Address = o.Address
+	}
+
+	return r
+}
+
+func (c *TelemetryConfig) Finalize() {
+	if c.Address == nil {
+		c.Address = String("")
+	}
+}
+
+func (c *TelemetryConfig) GoString() string {
+	if c == nil {
+		return "(*TelemetryConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&TelemetryConfig{"+
+		"Address:%s"+
+		"}",
+		StringGoString(c.Address),
+	)
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestTelemetryConfig_Copy(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *TelemetryConfig
+	}{
+		{
+			"nil",
+			nil,
+		},
+		{
+			"empty",
+			&TelemetryConfig{},
+		},
+		{
+			"same_enabled",
+			&TelemetryConfig{
+				Address: String(":9000"),
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Copy()
+			if !reflect.DeepEqual(tc.a, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.a, r)
+			}
+		})
+	}
+}
+
+func TestTelemetryConfig_Merge(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *TelemetryConfig
+		b    *TelemetryConfig
+		r    *TelemetryConfig
+	}{
+		{
+			"nil_a",
+			nil,
+			&TelemetryConfig{},
+			&TelemetryConfig{},
+		},
+		{
+			"nil_b",
+			&TelemetryConfig{},
+			nil,
+			&TelemetryConfig{},
+		},
+		{
+			"nil_both",
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"empty",
+			&TelemetryConfig{},
+			&TelemetryConfig{},
+			&TelemetryConfig{},
+		},
+		{
+			"address_overrides",
+			&TelemetryConfig{Address: String(":9000")},
+			&TelemetryConfig{Address: String("")},
+			&TelemetryConfig{Address: String("")},
+		},
+		{
+			"address_empty_one",
+			&TelemetryConfig{Address: String(":9000")},
+			&TelemetryConfig{},
+			&TelemetryConfig{Address: String(":9000")},
+		},
+		{
+			"address_empty_two",
+			&TelemetryConfig{},
+			&TelemetryConfig{Address: String(":9000")},
+			&TelemetryConfig{Address: String(":9000")},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Merge(tc.b)
+			if !reflect.DeepEqual(tc.r, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.r, r)
+			}
+		})
+	}
+}
+
+func TestTelemetryConfig_Finalize(t *testing.T) {
+	cases := []struct {
+		name string
+		i    *TelemetryConfig
+		r    *TelemetryConfig
+	}{
+		{
+			"empty",
+			&TelemetryConfig{},
+			&TelemetryConfig{
+				Address: String(""),
+			},
+		},
+		{
+			"with_address",
+			&TelemetryConfig{
+				Address: String(":9000"),
+			},
+			&TelemetryConfig{
+				Address: String(":9000"),
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			tc.i.Finalize()
+			if !reflect.DeepEqual(tc.r, tc.i) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.r, tc.i)
+			}
+		})
+	}
+}
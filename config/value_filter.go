@@ -0,0 +1,207 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const DefaultValueFilterTimeout = 10 * time.Second
+
+type ValueFilterConfig struct {
+	Pattern *string        `mapstructure:"pattern"`
+	Command []string       `mapstructure:"command"`
+	Timeout *time.Duration `mapstructure:"timeout"`
+}
+
+func DefaultValueFilterConfig() *ValueFilterConfig {
+	return &ValueFilterConfig{}
+}
+
+func (c *ValueFilterConfig) Copy() *ValueFilterConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o ValueFilterConfig
+
+	o.Pattern = c.Pattern
+
+	if c.Command != nil {
+		o.Command = append([]string{}, c.Command...)
+	}
+
+	o.Timeout = c.Timeout
+
+	return &o
+}
+
+func (c *ValueFilterConfig) Merge(o *ValueFilterConfig) *ValueFilterConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Pattern != nil {
+		r.Pattern = o.Pattern
+	}
+
+	if o.Command != nil {
+		r.Command = append([]string{}, o.Command...)
+	}
+
+	if o.Timeout != nil {
+		r.Timeout = o.Timeout
+	}
+
+	return r
+}
+
+func (c *ValueFilterConfig) Finalize() {
+	if c.Pattern == nil {
+		c.Pattern = String("")
+	}
+
+	if c.Command == nil {
+		c.Command = []string{}
+	}
+
+	if c.Timeout == nil {
+		c.Timeout = TimeDuration(DefaultValueFilterTimeout)
+	}
+}
+
+func (c *ValueFilterConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	pattern := StringVal(c.Pattern)
+	if pattern == "" {
+		return fmt.Errorf("value_filter: missing pattern")
+	}
+
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("value_filter: invalid pattern %q: %s", pattern, err)
+	}
+
+	if len(c.Command) == 0 || c.Command[0] == "" {
+		return fmt.Errorf("value_filter: missing command for pattern %q", pattern)
+	}
+
+	if TimeDurationVal(c.Timeout) <= 0 {
+		return fmt.Errorf("value_filter: timeout for pattern %q must be positive", pattern)
+	}
+
+	return nil
+}
+
+func (c *ValueFilterConfig) GoString() string {
+	if c == nil {
+		return "(*ValueFilterConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&ValueFilterConfig{"+
+		"Pattern:%s, "+
+		"Command:%q, "+
+		"Timeout:%s"+
+		"}",
+		StringGoString(c.Pattern),
+		c.Command,
+		TimeDurationGoString(c.Timeout),
+	)
+}
+
+type ValueFilterConfigs []*ValueFilterConfig
+
+func DefaultValueFilterConfigs() *ValueFilterConfigs {
+	return &ValueFilterConfigs{}
+}
+
+func (c *ValueFilterConfigs) Copy() *ValueFilterConfigs {
+	if c == nil {
+		return nil
+	}
+
+	o := make(ValueFilterConfigs, len(*c))
+	for i, t := range *c {
+		o[i] = t.Copy()
+	}
+	return &o
+}
+
+func (c *ValueFilterConfigs) Merge(o *ValueFilterConfigs) *ValueFilterConfigs {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	*r = append(*r, *o...)
+
+	return r
+}
+
+func (c *ValueFilterConfigs) Finalize() {
+	for _, t := range *c {
+		t.Finalize()
+	}
+}
+
+func (c *ValueFilterConfigs) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	for _, t := range *c {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Match returns the first stanza whose pattern matches name.
+func (c *ValueFilterConfigs) Match(name string) (*ValueFilterConfig, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	for _, t := range *c {
+		if matched, _ := filepath.Match(StringVal(t.Pattern), name); matched {
+			return t, true
+		}
+	}
+
+	return nil, false
+}
+
+func (c *ValueFilterConfigs) GoString() string {
+	if c == nil {
+		return "(*ValueFilterConfigs)(nil)"
+	}
+
+	s := make([]string, len(*c))
+	for i, t := range *c {
+		s[i] = t.GoString()
+	}
+
+	return "{" + strings.Join(s, ", ") + "}"
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValueFilterConfigs_Copy(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *ValueFilterConfigs
+	}{
+		{
+			"nil",
+			nil,
+		},
+		{
+			"empty",
+			&ValueFilterConfigs{},
+		},
+		{
+			"same_enabled",
+			&ValueFilterConfigs{
+				&ValueFilterConfig{
+					Pattern: String("*.json"),
+					Command: []string{"jq", "."},
+					Timeout: TimeDuration(5 * time.Second),
+				},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Copy()
+			if !reflect.DeepEqual(tc.a, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.a, r)
+			}
+		})
+	}
+}
+
+func TestValueFilterConfigs_Merge(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *ValueFilterConfigs
+		b    *ValueFilterConfigs
+		r    *ValueFilterConfigs
+	}{
+		{
+			"nil_a",
+			nil,
+			&ValueFilterConfigs{},
+			&ValueFilterConfigs{},
+		},
+		{
+			"nil_b",
+			&ValueFilterConfigs{},
+			nil,
+			&ValueFilterConfigs{},
+		},
+		{
+			"nil_both",
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"empty",
+			&ValueFilterConfigs{},
+			&ValueFilterConfigs{},
+			&ValueFilterConfigs{},
+		},
+		{
+			"appends",
+			&ValueFilterConfigs{
+				&ValueFilterConfig{Pattern: String("*.json")},
+			},
+			&ValueFilterConfigs{
+				&ValueFilterConfig{Pattern: String("*.yml")},
+			},
+			&ValueFilterConfigs{
+				&ValueFilterConfig{Pattern: String("*.json")},
+				&ValueFilterConfig{Pattern: String("*.yml")},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Merge(tc.b)
+			if !reflect.DeepEqual(tc.r, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.r, r)
+			}
+		})
+	}
+}
+
+func TestValueFilterConfigs_Match(t *testing.T) {
+	c := &ValueFilterConfigs{
+		&ValueFilterConfig{Pattern: String("*.json"), Command: []string{"jq", "."}},
+		&ValueFilterConfig{Pattern: String("app.*"), Command: []string{"cat"}},
+	}
+
+	cases := []struct {
+		name    string
+		file    string
+		command string
+		ok      bool
+	}{
+		{"match", "db.json", "jq", true},
+		{"first_match_wins", "app.json", "jq", true},
+		{"second", "app.conf", "cat", true},
+		{"no_match", "db.conf", "", false},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			f, ok := c.Match(tc.file)
+			var command string
+			if ok {
+				command = f.Command[0]
+			}
+			if command != tc.command || ok != tc.ok {
+				t.Errorf("\nexp: %s %t\nact: %s %t", tc.command, tc.ok, command, ok)
+			}
+		})
+	}
+}
+
+func TestValueFilterConfig_Validate(t *testing.T) {
+	cases := []struct {
+		name string
+		c    *ValueFilterConfig
+		err  bool
+	}{
+		{"valid", &ValueFilterConfig{Pattern: String("*.json"), Command: []string{"jq", "."}, Timeout: TimeDuration(time.Second)}, false},
+		{"missing_pattern", &ValueFilterConfig{Command: []string{"jq"}, Timeout: TimeDuration(time.Second)}, true},
+		{"bad_pattern", &ValueFilterConfig{Pattern: String("[*.json"), Command: []string{"jq"}, Timeout: TimeDuration(time.Second)}, true},
+		{"missing_command", &ValueFilterConfig{Pattern: String("*.json"), Timeout: TimeDuration(time.Second)}, true},
+		{"zero_timeout", &ValueFilterConfig{Pattern: String("*.json"), Command: []string{"jq"}, Timeout: TimeDuration(0)}, true},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			if err := tc.c.Validate(); (err != nil) != tc.err {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package config
+
+import "fmt"
+
+const (
+	// DefaultVaultKVVersion is the version of the KV secrets engine mounted
+	// at the first segment of a vault:// from.
+	DefaultVaultKVVersion = 2
+)
+
+type VaultConfig struct {
+	Address *string `mapstructure:"address"`
+
+	// KVVersion selects between the KV version 1 and 2 secrets engine API.
+	KVVersion *int `mapstructure:"kv_version"`
+
+	SSL *SSLConfig `mapstructure:"ssl"`
+
+	Token *string `mapstructure:"token"`
+
+	Transport *TransportConfig `mapstructure:"transport"`
+
+	// UnwrapToken treats Token as a response wrapping token and unwraps it
+	// once when the client is created.
+	UnwrapToken *bool `mapstructure:"unwrap_token"`
+}
+
+func DefaultVaultConfig() *VaultConfig {
+	return &VaultConfig{
+		SSL:       DefaultSSLConfig(),
+		Transport: DefaultTransportConfig(),
+	}
+}
+
+func (c *VaultConfig) Copy() *VaultConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o VaultConfig
+
+	o.Address = c.Address
+
+	o.KVVersion = c.KVVersion
+
+	if c.SSL != nil {
+		o.SSL = c.SSL.Copy()
+	}
+
+	o.Token = c.Token
+
+	if c.Transport != nil {
+		o.Transport = c.Transport.Copy()
+	}
+
+	o.UnwrapToken = c.UnwrapToken
+
+	return &o
+}
+
+func (c *VaultConfig) Merge(o *VaultConfig) *VaultConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Address != nil {
+		r.Address = o.Address
+	}
+
+	if o.KVVersion != nil {
+		r.KVVersion = o.KVVersion
+	}
+
+	if o.SSL != nil {
+		r.SSL = r.SSL.Merge(o.SSL)
+	}
+
+	if o.Token != nil {
+		r.Token = o.Token
+	}
+
+	if o.Transport != nil {
+		r.Transport = r.Transport.Merge(o.Transport)
+	}
+
+	if o.UnwrapToken != nil {
+		r.UnwrapToken = o.UnwrapToken
+	}
+
+	return r
+}
+
+func (c *VaultConfig) Finalize() {
+	if c.Address == nil {
+		c.Address = stringFromEnv([]string{
+			"VAULT_ADDR",
+		}, "")
+	}
+
+	if c.KVVersion == nil {
+		c.KVVersion = Int(DefaultVaultKVVersion)
+	}
+
+	if c.SSL == nil {
+		c.SSL = DefaultSSLConfig()
+	}
+	c.SSL.Finalize()
+
+	if c.Token == nil {
+		c.Token = stringFromEnv([]string{
+			"VAULT_TOKEN",
+		}, "")
+	}
+
+	if c.Transport == nil {
+		c.Transport = DefaultTransportConfig()
+	}
+	c.Transport.Finalize()
+
+	if c.UnwrapToken == nil {
+		c.UnwrapToken = Bool(false)
+	}
+}
+
+func (c *VaultConfig) GoString() string {
+	if c == nil {
+		return "(*VaultConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&VaultConfig{"+
+		"Address:%s, "+
+		"KVVersion:%s, "+
+		"SSL:%#v, "+
+		"Token:%t, "+
+		"Transport:%#v, "+
+		"UnwrapToken:%s"+
+		"}",
+		StringGoString(c.Address),
+		IntGoString(c.KVVersion),
+		c.SSL,
+		StringPresent(c.Token),
+		c.Transport,
+		BoolGoString(c.UnwrapToken),
+	)
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestVaultConfig_Copy(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *VaultConfig
+	}{
+		{
+			"nil",
+			nil,
+		},
+		{
+			"empty",
+			&VaultConfig{},
+		},
+		{
+			"same_enabled",
+			&VaultConfig{
+				Address:     String("https://vault.service.consul:8200"),
+				KVVersion:   Int(1),
+				SSL:         &SSLConfig{Enabled: Bool(true)},
+				Token:       String("s.abcd"),
+				Transport:   &TransportConfig{DialTimeout: TimeDuration(10)},
+				UnwrapToken: Bool(true),
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Copy()
+			if !reflect.DeepEqual(tc.a, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.a, r)
+			}
+		})
+	}
+}
+
+func TestVaultConfig_Merge(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *VaultConfig
+		b    *VaultConfig
+		r    *VaultConfig
+	}{
+		{
+			"nil_a",
+			nil,
+			&VaultConfig{},
+			&VaultConfig{},
+		},
+		{
+			"nil_b",
+			&VaultConfig{},
+			nil,
+			&VaultConfig{},
+		},
+		{
+			"nil_both",
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"empty",
+			&VaultConfig{},
+			&VaultConfig{},
+			&VaultConfig{},
+		},
+		{
+			"address_overrides",
+			&VaultConfig{Address: String("a")},
+			&VaultConfig{Address: String("b")},
+			&VaultConfig{Address: String("b")},
+		},
+		{
+			"address_empty_one",
+			&VaultConfig{Address: String("a")},
+			&VaultConfig{},
+			&VaultConfig{Address: String("a")},
+		},
+		{
+			"kv_version_overrides",
+			&VaultConfig{KVVersion: Int(2)},
+			&VaultConfig{KVVersion: Int(1)},
+			&VaultConfig{KVVersion: Int(1)},
+		},
+		{
+			"ssl_merges",
+			&VaultConfig{SSL: &SSLConfig{Enabled: Bool(true)}},
+			&VaultConfig{SSL: &SSLConfig{Verify: Bool(false)}},
+			&VaultConfig{SSL: &SSLConfig{Enabled: Bool(true), Verify: Bool(false)}},
+		},
+		{
+			"token_overrides",
+			&VaultConfig{Token: String("a")},
+			&VaultConfig{Token: String("b")},
+			&VaultConfig{Token: String("b")},
+		},
+		{
+			"token_empty_one",
+			&VaultConfig{Token: String("a")},
+			&VaultConfig{},
+			&VaultConfig{Token: String("a")},
+		},
+		{
+			"unwrap_token_overrides",
+			&VaultConfig{UnwrapToken: Bool(true)},
+			&VaultConfig{UnwrapToken: Bool(false)},
+			&VaultConfig{UnwrapToken: Bool(false)},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Merge(tc.b)
+			if !reflect.DeepEqual(tc.r, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.r, r)
+			}
+		})
+	}
+}
+
+func TestVaultConfig_Finalize(t *testing.T) {
+	cases := []struct {
+		name string
+		i    *VaultConfig
+		r    *VaultConfig
+	}{
+		{
+			"empty",
+			&VaultConfig{},
+			&VaultConfig{
+				Address:   String(""),
+				KVVersion: Int(DefaultVaultKVVersion),
+				SSL: &SSLConfig{
+					CaCert:     String(""),
+					CaPath:     String(""),
+					Cert:       String(""),
+					Enabled:    Bool(false),
+					Key:        String(""),
+					ServerName: String(""),
+					Verify:     Bool(true),
+					CertPEM:    String(""),
+					KeyPEM:     String(""),
+
+					UseConnectLeaf: Bool(false),
+					ConnectService: String(DefaultConnectService),
+				},
+				Token: String(""),
+				Transport: &TransportConfig{
+					DialKeepAlive:       TimeDuration(DefaultDialKeepAlive),
+					DialTimeout:         TimeDuration(DefaultDialTimeout),
+					DisableKeepAlives:   Bool(false),
+					IdleConnTimeout:     TimeDuration(DefaultIdleConnTimeout),
+					MaxIdleConns:        Int(DefaultMaxIdleConns),
+					MaxIdleConnsPerHost: Int(DefaultMaxIdleConnsPerHost),
+					TLSHandshakeTimeout: TimeDuration(DefaultTLSHandshakeTimeout),
+				},
+				UnwrapToken: Bool(false),
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			tc.i.Finalize()
+			if !reflect.DeepEqual(tc.r, tc.i) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.r, tc.i)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// WaitConfig delays writing a change until keys stopped changing for Min,
+// but no longer than Max after the first change.
+type WaitConfig struct {
+	Enabled *bool          `mapstructure:"enabled"`
+	Min     *time.Duration `mapstructure:"min"`
+	Max     *time.Duration `mapstructure:"max"`
+}
+
+func DefaultWaitConfig() *WaitConfig {
+	return &WaitConfig{}
+}
+
+func (c *WaitConfig) Copy() *WaitConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o WaitConfig
+
+	o.Enabled = c.Enabled
+
+	o.Min = c.Min
+
+	o.Max = c.Max
+
+	return &o
+}
+
+func (c *WaitConfig) Merge(o *WaitConfig) *WaitConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Enabled != nil {
+		r.Enabled = o.Enabled
+	}
+
+	if o.Min != nil {
+		r.Min = o.Min
+	}
+
+	if o.Max != nil {
+		r.Max = o.Max
+	}
+
+	return r
+}
+
+func (c *WaitConfig) Finalize() {
+	if c.Enabled == nil {
+		c.Enabled = Bool(TimeDurationVal(c.Min) > 0)
+	}
+
+	if c.Min == nil {
+		c.Min = TimeDuration(0)
+	}
+
+	if c.Max == nil {
+		c.Max = TimeDuration(4 * *c.Min)
+	}
+}
+
+func (c *WaitConfig) GoString() string {
+	if c == nil {
+		return "(*WaitConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&WaitConfig{"+
+		"Enabled:%s, "+
+		"Min:%s, "+
+		"Max:%s"+
+		"}",
+		BoolGoString(c.Enabled),
+		TimeDurationGoString(c.Min),
+		TimeDurationGoString(c.Max),
+	)
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWaitConfig_Copy(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *WaitConfig
+	}{
+		{
+			"nil",
+			nil,
+		},
+		{
+			"empty",
+			&WaitConfig{},
+		},
+		{
+			"same_enabled",
+			&WaitConfig{
+				Enabled: Bool(true),
+				Min:     TimeDuration(2 * time.Second),
+				Max:     TimeDuration(10 * time.Second),
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Copy()
+			if !reflect.DeepEqual(tc.a, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.a, r)
+			}
+		})
+	}
+}
+
+func TestWaitConfig_Merge(t *testing.T) {
+	cases := []struct {
+		name string
+		a    *WaitConfig
+		b    *WaitConfig
+		r    *WaitConfig
+	}{
+		{
+			"nil_a",
+			nil,
+			&WaitConfig{},
+			&WaitConfig{},
+		},
+		{
+			"nil_b",
+			&WaitConfig{},
+			nil,
+			&WaitConfig{},
+		},
+		{
+			"nil_both",
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"empty",
+			&WaitConfig{},
+			&WaitConfig{},
+			&WaitConfig{},
+		},
+		{
+			"enabled_overrides",
+			&WaitConfig{Enabled: Bool(true)},
+			&WaitConfig{Enabled: Bool(false)},
+			&WaitConfig{Enabled: Bool(false)},
+		},
+		{
+			"min_overrides",
+			&WaitConfig{Min: TimeDuration(1 * time.Second)},
+			&WaitConfig{Min: TimeDuration(2 * time.Second)},
+			&WaitConfig{Min: TimeDuration(2 * time.Second)},
+		},
+		{
+			"min_empty_one",
+			&WaitConfig{Min: TimeDuration(1 * time.Second)},
+			&WaitConfig{},
+			&WaitConfig{Min: TimeDuration(1 * time.Second)},
+		},
+		{
+			"max_overrides",
+			&WaitConfig{Max: TimeDuration(1 * time.Second)},
+			&WaitConfig{Max: TimeDuration(2 * time.Second)},
+			&WaitConfig{Max: TimeDuration(2 * time.Second)},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := tc.a.Merge(tc.b)
+			if !reflect.DeepEqual(tc.r, r) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.r, r)
+			}
+		})
+	}
+}
+
+func TestWaitConfig_Finalize(t *testing.T) {
+	cases := []struct {
+		name string
+		i    *WaitConfig
+		r    *WaitConfig
+	}{
+		{
+			"empty",
+			&WaitConfig{},
+			&WaitConfig{
+				Enabled: Bool(false),
+				Min:     TimeDuration(0),
+				Max:     TimeDuration(0),
+			},
+		},
+		{
+			"min",
+			&WaitConfig{Min: TimeDuration(2 * time.Second)},
+			&WaitConfig{
+				Enabled: Bool(true),
+				Min:     TimeDuration(2 * time.Second),
+				Max:     TimeDuration(8 * time.Second),
+			},
+		},
+		{
+			"min_max",
+			&WaitConfig{Min: TimeDuration(2 * time.Second), Max: TimeDuration(10 * time.Second)},
+			&WaitConfig{
+				Enabled: Bool(true),
+				Min:     TimeDuration(2 * time.Second),
+				Max:     TimeDuration(10 * time.Second),
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			tc.i.Finalize()
+			if !reflect.DeepEqual(tc.r, tc.i) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.r, tc.i)
+			}
+		})
+	}
+}
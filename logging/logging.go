@@ -13,6 +13,9 @@ import (
 
 var Levels = []logutils.LogLevel{"TRACE", "DEBUG", "INFO", "WARN", "ERR"}
 
+// logFile is the file of the last Setup, closed when Setup runs again.
+var logFile *RotatingFile
+
 type Config struct {
 	Name string `json:"name"`
 
@@ -21,11 +24,17 @@ type Config struct {
 	Syslog         bool   `json:"syslog"`
 	SyslogFacility string `json:"syslog_facility"`
 
+	// FilePath is a file the log is written to as well, rotated once it
+	// grows past MaxSizeMB with MaxBackups rotated files kept.
+	FilePath   string `json:"file_path"`
+	MaxSizeMB  int    `json:"max_size_mb"`
+	MaxBackups int    `json:"max_backups"`
+
 	Writer io.Writer `json:"-"`
 }
 
 func Setup(config *Config) error {
-	var logOutput io.Writer
+	var writers []io.Writer
 
 	logFilter := NewLogFilter()
 	logFilter.MinLevel = logutils.LogLevel(strings.ToUpper(config.Level))
@@ -47,13 +56,31 @@ func Setup(config *Config) error {
 			return fmt.Errorf("error setting up syslog logger: %s", err)
 		}
 		syslog := &SyslogWrapper{l, logFilter}
-		logOutput = io.MultiWriter(logFilter, syslog)
+		writers = append(writers, logFilter, syslog)
 	} else {
-		logOutput = io.MultiWriter(logFilter)
+		writers = append(writers, logFilter)
+	}
+
+	var file *RotatingFile
+	if config.FilePath != "" {
+		var err error
+		file, err = NewRotatingFile(config.FilePath, config.MaxSizeMB, config.MaxBackups)
+		if err != nil {
+			return err
+		}
+		fileFilter := NewLogFilter()
+		fileFilter.MinLevel = logFilter.MinLevel
+		fileFilter.Writer = file
+		writers = append(writers, fileFilter)
 	}
 
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.LUTC)
-	log.SetOutput(logOutput)
+	log.SetOutput(io.MultiWriter(writers...))
+
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = file
 
 	return nil
 }
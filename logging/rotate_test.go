@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "out.log")
+	f, err := NewRotatingFile(path, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Each line is half the maximum size, so every third write rotates.
+	line := func(c string) []byte {
+		return []byte(strings.Repeat(c, 1<<19-1) + "\n")
+	}
+	for _, c := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		if _, err := f.Write(line(c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]string{
+		path:        "g",
+		path + ".1": "ef",
+		path + ".2": "cd",
+	}
+	for p, e := range expected {
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		act := ""
+		for _, l := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+			act += l[:1]
+		}
+		if act != e {
+			t.Errorf("%s: expected lines %q, got %q", filepath.Base(p), e, act)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected backups beyond max backups to be removed, got %v", err)
+	}
+}
+
+func TestRotatingFile_noBackups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "out.log")
+	if err := ioutil.WriteFile(path, []byte(strings.Repeat("x", 1<<20)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewRotatingFile(path, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write([]byte("new\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "new\n" {
+		t.Errorf("expected the full file to be dropped, got %d bytes", len(content))
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("expected no backup, got %v", err)
+	}
+}
+
+func TestNewRotatingFile_invalid(t *testing.T) {
+	if _, err := NewRotatingFile(filepath.Join(os.TempDir(), "out.log"), 0, 1); err == nil {
+		t.Error("expected a zero max size to be rejected")
+	}
+	if _, err := NewRotatingFile(filepath.Join(os.TempDir(), "out.log"), 1, -1); err == nil {
+		t.Error("expected negative max backups to be rejected")
+	}
+}
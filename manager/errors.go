@@ -1,6 +1,9 @@
 package manager
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 type ErrExitable interface {
 	ExitStatus() int
@@ -24,3 +27,24 @@ func (e *ErrChildDied) Error() string {
 func (e *ErrChildDied) ExitStatus() int {
 	return e.code
 }
+
+const ExitCodeStartupDeadline = 16
+
+var _ error = new(ErrStartupDeadline)
+var _ ErrExitable = new(ErrStartupDeadline)
+
+type ErrStartupDeadline struct {
+	deadline time.Duration
+}
+
+func NewErrStartupDeadline(d time.Duration) *ErrStartupDeadline {
+	return &ErrStartupDeadline{deadline: d}
+}
+
+func (e *ErrStartupDeadline) Error() string {
+	return fmt.Sprintf("first pass did not complete within startup_deadline (%s)", e.deadline)
+}
+
+func (e *ErrStartupDeadline) ExitStatus() int {
+	return ExitCodeStartupDeadline
+}
@@ -0,0 +1,188 @@
+package manager
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/Assada/consul-generator/processor"
+)
+
+// Reload applies c from the next pass on without stopping the runner. The
+// processor, with its Consul and Vault clients and the index of its blocking
+// query, is kept when only settings of the runner itself changed, such as
+// interval. It is reloaded in place when the connection stayed the same and
+// only replaced when the connection changed.
+func (r *Runner) Reload(c *config.Config) error {
+	c = config.DefaultConfig().Merge(c)
+	c.Finalize()
+
+	if err := processor.Validate(c); err != nil {
+		return err
+	}
+
+	r.stopLock.Lock()
+	defer r.stopLock.Unlock()
+
+	if r.stopped {
+		return fmt.Errorf("runner: cannot reload a stopped runner")
+	}
+
+	// Only the latest config matters when the loop has not picked up the
+	// previous one yet.
+	req := reloadRequest{config: c}
+	select {
+	case pending := <-r.reloadCh:
+		req.aborted = pending.aborted
+	default:
+	}
+
+	// A blocking query only returns once a key changes, so it is aborted for
+	// the new config to apply right away. The aborted processor is replaced.
+	if config.BoolVal(r.config.Watch) && !sameProcessing(r.config, c) && r.cancel != nil {
+		r.cancel()
+		req.aborted = true
+	}
+
+	r.reloadCh <- req
+
+	return nil
+}
+
+type reloadRequest struct {
+	config *config.Config
+
+	// aborted is set when the processor was cancelled for the reload.
+	aborted bool
+}
+
+// reload swaps the config of the running loop for the one of req and
+// returns the processor to use from now on, or nil when the runner was
+// stopped while replacing it.
+func (r *Runner) reload(pr passProcessor, req reloadRequest) (passProcessor, error) {
+	old, c := r.config, req.config
+
+	r.stopLock.Lock()
+	if r.stopped {
+		r.stopLock.Unlock()
+		return pr, nil
+	}
+	if config.StringVal(old.PidFile) != config.StringVal(c.PidFile) {
+		if err := r.deletePid(); err != nil {
+			log.Printf("[WARN] (runner) could not remove pid at %q: %s", config.StringVal(old.PidFile), err)
+		}
+	}
+	r.config = c
+	r.stopLock.Unlock()
+
+	if config.StringVal(old.PidFile) != config.StringVal(c.PidFile) {
+		if err := r.storePid(); err != nil {
+			return pr, err
+		}
+	}
+
+	if err := r.reloadTelemetry(old); err != nil {
+		return pr, err
+	}
+
+	replace := req.aborted || !processor.SameConnection(old, c)
+	if !replace && !sameProcessing(old, c) {
+		if rp, ok := pr.(interface{ Reload(*config.Config) error }); ok {
+			if err := rp.Reload(c); err != nil {
+				return pr, err
+			}
+			if s, ok := pr.(interface{ SetOutStream(io.Writer) }); ok {
+				s.SetOutStream(r.outStream)
+			}
+		} else {
+			replace = true
+		}
+	}
+
+	if replace {
+		log.Printf("[INFO] (runner) replacing the processor")
+		pr.Stop()
+		var err error
+		if pr, err = r.newPass(); pr == nil || err != nil {
+			return pr, err
+		}
+	}
+
+	r.resetTimer()
+
+	return pr, nil
+}
+
+// resetTimer schedules the next pass with the reloaded interval.
+func (r *Runner) resetTimer() {
+	if !r.timer.Stop() {
+		select {
+		case <-r.timer.C:
+		default:
+		}
+	}
+
+	next := r.nextInterval()
+	if config.BoolVal(r.config.Watch) {
+		next = 0
+	}
+	r.timer.Reset(next)
+}
+
+// reloadTelemetry restarts the telemetry listener when its address differs
+// from the one of old.
+func (r *Runner) reloadTelemetry(old *config.Config) error {
+	address := config.StringVal(r.config.Telemetry.Address)
+	if address == config.StringVal(old.Telemetry.Address) {
+		return nil
+	}
+
+	r.stopLock.Lock()
+	t := r.telemetry
+	r.telemetry = nil
+	r.stopLock.Unlock()
+	if t != nil {
+		t.stop()
+	}
+
+	if address == "" {
+		return nil
+	}
+
+	t = newTelemetry()
+	if err := t.start(address); err != nil {
+		return err
+	}
+	r.stopLock.Lock()
+	r.telemetry = t
+	stopped := r.stopped
+	r.stopLock.Unlock()
+	if stopped {
+		t.stop()
+	}
+
+	return nil
+}
+
+// sameProcessing reports whether a and b differ at most in the settings the
+// runner applies itself, so the processor can keep all of its state.
+// startup_deadline only matters before the first pass and is not reapplied.
+func sameProcessing(a, b *config.Config) bool {
+	o := *b
+	o.Interval = a.Interval
+	o.IntervalJitter = a.IntervalJitter
+	o.MaxPasses = a.MaxPasses
+	o.StartupDeadline = a.StartupDeadline
+	o.PidFile = a.PidFile
+	o.KillSignal = a.KillSignal
+	o.PauseSignal = a.PauseSignal
+	o.ReloadSignal = a.ReloadSignal
+	o.LogLevel = a.LogLevel
+	o.Syslog = a.Syslog
+	o.LogFile = a.LogFile
+	o.Telemetry = a.Telemetry
+
+	return reflect.DeepEqual(a, &o)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"os"
 	"sync"
 	"time"
@@ -16,13 +17,48 @@ import (
 type Runner struct {
 	ErrCh                chan error
 	DoneCh               chan bool
-	ticker               *time.Ticker
+	timer                *time.Timer
 	config               *config.Config
 	dry, once            bool
 	outStream, errStream io.Writer
 	inStream             io.Reader
 	stopLock             sync.Mutex
 	stopped              bool
+	pauseLock            sync.Mutex
+	paused               bool
+	resumeCh             chan struct{}
+	reloadCh             chan reloadRequest
+	passes               int
+	lastCode             int
+
+	// started is closed by the first successful pass and expired when
+	// startup_deadline passes before it.
+	started, expired chan struct{}
+	startOnce        sync.Once
+
+	// settle is how long the last pass held back changed keys for wait.
+	settle time.Duration
+
+	// renderEvents holds the latest decision for every key processed, merged
+	// after each pass.
+	renderLock   sync.RWMutex
+	renderEvents map[string]*RenderEvent
+
+	// cancel aborts a watch query of the running processor on Stop.
+	cancel    func()
+	telemetry *telemetry
+
+	// rand is seeded per runner, so instances started together do not
+	// share a jitter sequence.
+	rand *rand.Rand
+}
+
+// RenderEvent is the latest decision a pass made for a key.
+type RenderEvent struct {
+	processor.KeyResult
+
+	// Time is when the pass that made the decision finished.
+	Time time.Time
 }
 
 func NewRunner(config *config.Config, dry, once bool) (*Runner, error) {
@@ -32,7 +68,6 @@ func NewRunner(config *config.Config, dry, once bool) (*Runner, error) {
 		config: config,
 		dry:    dry,
 		once:   once,
-		ticker: time.NewTicker(*config.Interval),
 	}
 
 	if err := runner.init(); err != nil {
@@ -42,28 +77,110 @@ func NewRunner(config *config.Config, dry, once bool) (*Runner, error) {
 	return runner, nil
 }
 
+type passProcessor interface {
+	Process() int
+	Stop()
+}
+
+var newProcessor = func(c *config.Config, once, dry bool, errCh chan error, doneCh chan bool) (passProcessor, error) {
+	return processor.NewProcessor(c, once, dry, errCh, doneCh)
+}
+
 func (r *Runner) Start() {
 	log.Printf("[INFO] (runner) starting")
 
+	if d := config.TimeDurationVal(r.config.StartupDeadline); d > 0 {
+		r.started, r.expired = make(chan struct{}), make(chan struct{})
+		go r.watchDeadline(d)
+	}
+
 	if err := r.storePid(); err != nil {
-		r.ErrCh <- err
+		r.sendError(err)
 		return
 	}
 
+	if address := config.StringVal(r.config.Telemetry.Address); address != "" {
+		t := newTelemetry()
+		if err := t.start(address); err != nil {
+			r.sendError(err)
+			return
+		}
+		r.stopLock.Lock()
+		r.telemetry = t
+		stopped := r.stopped
+		r.stopLock.Unlock()
+		if stopped {
+			t.stop()
+			return
+		}
+	}
+
 	log.Printf("[DEBUG] (runner) running initial templates")
 	if err := r.Run(); err != nil {
-		r.ErrCh <- err
+		r.sendError(err)
 		return
 	}
 
-	pr, _ := processor.NewProcessor(r.config, r.once, r.dry, r.ErrCh, r.DoneCh)
+	pr, err := r.newPass()
+	if r.deadlineExpired() {
+		if pr != nil {
+			pr.Stop()
+		}
+		return
+	}
+	if err != nil {
+		r.sendError(err)
+		return
+	}
+	if pr == nil {
+		return
+	}
+	defer func() {
+		if pr != nil {
+			pr.Stop()
+		}
+	}()
 
 	for {
 		select {
-		case <-r.ErrCh:
+		case <-r.timer.C:
+			if r.Paused() {
+				log.Printf("[INFO] (runner) paused, skipping pass")
+				r.settle = 0
+			} else if r.process(pr) || r.deadlineExpired() {
+				return
+			}
+
+			next := r.nextInterval()
+			if r.watching() {
+				next = 0
+			}
+			// Sync stanzas with their own interval are run as they come due.
+			if n, ok := pr.(interface{ Next() (time.Duration, bool) }); ok && !r.Paused() {
+				if d, ok := n.Next(); ok {
+					next = d
+				}
+			}
+			if r.settle > 0 && (next == 0 || r.settle < next) {
+				next = r.settle
+			}
+			log.Printf("[DEBUG] (runner) next poll in %s", next)
+			r.timer.Reset(next)
+		case req := <-r.reloadCh:
+			if pr, err = r.reload(pr, req); err != nil {
+				r.sendError(err)
+				return
+			}
+			if pr == nil {
+				return
+			}
+		case <-r.resumeCh:
+			log.Printf("[INFO] (runner) resumed, running catch-up pass")
+			if r.process(pr) || r.deadlineExpired() {
+				return
+			}
+		case <-r.expired:
 			return
-		case <-r.ticker.C:
-			pr.Process()
 		case <-r.DoneCh:
 			log.Printf("[INFO] (runner) received finish")
 			return
@@ -72,6 +189,100 @@ func (r *Runner) Start() {
 
 }
 
+// watchDeadline fails the runner when no pass completed within d. It runs
+// apart from the passes, as a pass against an unreachable Consul blocks in
+// its login or in the retries of its List for far longer, and cancels the
+// pass in progress.
+func (r *Runner) watchDeadline(d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+	case <-r.started:
+		return
+	case <-r.DoneCh:
+		return
+	}
+
+	close(r.expired)
+	r.sendError(NewErrStartupDeadline(d))
+
+	r.stopLock.Lock()
+	defer r.stopLock.Unlock()
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
+
+// deadlineExpired reports whether startup_deadline failed the runner.
+func (r *Runner) deadlineExpired() bool {
+	select {
+	case <-r.expired:
+		return true
+	default:
+		return false
+	}
+}
+
+// newPass creates the processor of the passes. It returns nil once the
+// runner is stopped.
+func (r *Runner) newPass() (passProcessor, error) {
+	pr, err := newProcessor(r.config, r.once, r.dry, r.ErrCh, r.DoneCh)
+	if err != nil {
+		return nil, err
+	}
+
+	if s, ok := pr.(interface{ SetOutStream(io.Writer) }); ok {
+		s.SetOutStream(r.outStream)
+	}
+
+	if c, ok := pr.(interface{ Cancel() }); ok {
+		r.stopLock.Lock()
+		r.cancel = c.Cancel
+		stopped := r.stopped
+		r.stopLock.Unlock()
+		if stopped {
+			pr.Stop()
+			return nil, nil
+		}
+	}
+
+	return pr, nil
+}
+
+// process runs a single pass and reports whether the runner is finished
+// because max_passes was reached.
+func (r *Runner) process(pr passProcessor) bool {
+	r.lastCode = pr.Process()
+	if r.telemetry != nil {
+		r.telemetry.observe(r.lastCode, pr)
+	}
+	if rp, ok := pr.(interface{ Result() processor.Result }); ok {
+		r.mergeRenderEvents(rp.Result())
+	}
+	if w, ok := pr.(interface{ Wait() time.Duration }); ok {
+		r.settle = w.Wait()
+	}
+	if r.lastCode == processor.ExitCodeOK && r.started != nil && !r.deadlineExpired() {
+		r.startOnce.Do(func() {
+			log.Printf("[DEBUG] (runner) first pass completed, startup deadline disarmed")
+			close(r.started)
+		})
+	}
+
+	r.passes++
+
+	max := config.IntVal(r.config.MaxPasses)
+	if max <= 0 || r.passes < max {
+		return false
+	}
+
+	log.Printf("[INFO] (runner) completed %d passes, finishing", r.passes)
+	r.Stop()
+	return true
+}
+
 func (r *Runner) Stop() {
 	r.stopLock.Lock()
 	defer r.stopLock.Unlock()
@@ -89,20 +300,122 @@ func (r *Runner) Stop() {
 
 	r.stopped = true
 
+	if r.cancel != nil {
+		r.cancel()
+	}
+
+	if r.telemetry != nil {
+		r.telemetry.stop()
+	}
+
 	close(r.DoneCh)
 }
 
+func (r *Runner) sendError(err error) {
+	select {
+	case r.ErrCh <- err:
+	default:
+		log.Printf("[WARN] (runner) nobody is listening, dropping error: %s", err)
+	}
+}
+
+func (r *Runner) Pause() {
+	r.pauseLock.Lock()
+	defer r.pauseLock.Unlock()
+
+	if r.paused {
+		return
+	}
+
+	log.Printf("[INFO] (runner) pausing")
+	r.paused = true
+}
+
+func (r *Runner) Resume() {
+	r.pauseLock.Lock()
+	defer r.pauseLock.Unlock()
+
+	if !r.paused {
+		return
+	}
+
+	log.Printf("[INFO] (runner) resuming")
+	r.paused = false
+
+	select {
+	case r.resumeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Runner) Paused() bool {
+	r.pauseLock.Lock()
+	defer r.pauseLock.Unlock()
+
+	return r.paused
+}
+
+// RenderEvents returns the latest decision for every key processed so far,
+// by key.
+func (r *Runner) RenderEvents() map[string]*RenderEvent {
+	r.renderLock.RLock()
+	defer r.renderLock.RUnlock()
+
+	events := make(map[string]*RenderEvent, len(r.renderEvents))
+	for k, e := range r.renderEvents {
+		cp := *e
+		events[k] = &cp
+	}
+	return events
+}
+
+func (r *Runner) mergeRenderEvents(result processor.Result) {
+	r.renderLock.Lock()
+	defer r.renderLock.Unlock()
+
+	now := time.Now()
+	for _, k := range result.Keys {
+		r.renderEvents[k.Key] = &RenderEvent{KeyResult: k, Time: now}
+	}
+}
+
 func (r *Runner) Run() error {
 	log.Printf("[DEBUG] (runner) initiating run")
 
 	return nil
 }
 
+// watching reports whether the next pass starts right away, as its blocking
+// query waits for changes itself. Failed and standby passes return at once,
+// so they still wait one interval.
+func (r *Runner) watching() bool {
+	if !config.BoolVal(r.config.Watch) || r.Paused() {
+		return false
+	}
+	return r.lastCode == processor.ExitCodeOK || r.lastCode == processor.ExitCodeEmpty
+}
+
+func (r *Runner) nextInterval() time.Duration {
+	return jitter(r.rand, config.TimeDurationVal(r.config.Interval),
+		config.TimeDurationVal(r.config.IntervalJitter))
+}
+
+func jitter(rnd *rand.Rand, interval, j time.Duration) time.Duration {
+	if j <= 0 {
+		return interval
+	}
+	if j > interval {
+		j = interval
+	}
+
+	return interval - j + time.Duration(rnd.Int63n(int64(2*j)+1))
+}
+
 func (r *Runner) init() error {
 	r.config = config.DefaultConfig().Merge(r.config)
 	r.config.Finalize()
 
-	result, err := json.Marshal(r.config)
+	result, err := json.Marshal(r.config.Redacted())
 	if err != nil {
 		return err
 	}
@@ -112,8 +425,18 @@ func (r *Runner) init() error {
 	r.outStream = os.Stdout
 	r.errStream = os.Stderr
 
-	r.ErrCh = make(chan error)
+	r.ErrCh = make(chan error, 1)
 	r.DoneCh = make(chan bool)
+	r.resumeCh = make(chan struct{}, 1)
+	r.reloadCh = make(chan reloadRequest, 1)
+	r.renderEvents = make(map[string]*RenderEvent)
+	r.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	next := r.nextInterval()
+	if config.BoolVal(r.config.Watch) {
+		next = 0
+	}
+	r.timer = time.NewTimer(next)
 
 	return nil
 }
@@ -0,0 +1,888 @@
+package manager
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/Assada/consul-generator/processor"
+)
+
+func TestJitter(t *testing.T) {
+	cases := []struct {
+		name     string
+		interval time.Duration
+		jitter   time.Duration
+		min      time.Duration
+		max      time.Duration
+	}{
+		{
+			"disabled",
+			10 * time.Second,
+			0,
+			10 * time.Second,
+			10 * time.Second,
+		},
+		{
+			"jitter",
+			10 * time.Second,
+			2 * time.Second,
+			8 * time.Second,
+			12 * time.Second,
+		},
+		{
+			"jitter_larger_than_interval",
+			1 * time.Second,
+			5 * time.Second,
+			0,
+			2 * time.Second,
+		},
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			for n := 0; n < 1000; n++ {
+				d := jitter(rnd, tc.interval, tc.jitter)
+				if d < tc.min || d > tc.max {
+					t.Fatalf("\nexp: [%s, %s]\nact: %s", tc.min, tc.max, d)
+				}
+			}
+		})
+	}
+}
+
+func TestRunner_Pause(t *testing.T) {
+	r := &Runner{resumeCh: make(chan struct{}, 1)}
+
+	if r.Paused() {
+		t.Fatal("expected runner not to be paused")
+	}
+
+	r.Pause()
+	r.Pause()
+	if !r.Paused() {
+		t.Fatal("expected runner to be paused")
+	}
+
+	r.Resume()
+	if r.Paused() {
+		t.Fatal("expected runner to be resumed")
+	}
+
+	select {
+	case <-r.resumeCh:
+	default:
+		t.Fatal("expected a catch-up pass to be scheduled")
+	}
+
+	r.Resume()
+	select {
+	case <-r.resumeCh:
+		t.Fatal("expected no catch-up pass when not paused")
+	default:
+	}
+}
+
+func TestErrStartupDeadline(t *testing.T) {
+	var err error = NewErrStartupDeadline(5 * time.Second)
+
+	typed, ok := err.(ErrExitable)
+	if !ok {
+		t.Fatal("expected startup deadline error to be exitable")
+	}
+	if typed.ExitStatus() != ExitCodeStartupDeadline {
+		t.Errorf("\nexp: %d\nact: %d", ExitCodeStartupDeadline, typed.ExitStatus())
+	}
+	if !strings.Contains(err.Error(), "5s") {
+		t.Errorf("expected %q to mention the deadline", err.Error())
+	}
+}
+
+type fakeProcessor struct {
+	passes int
+}
+
+func (p *fakeProcessor) Process() int {
+	p.passes++
+	return processor.ExitCodeOK
+}
+
+func (p *fakeProcessor) Stop() {}
+
+func TestRunner_maxPasses(t *testing.T) {
+	pr := &fakeProcessor{}
+	orig := newProcessor
+	newProcessor = func(*config.Config, bool, bool, chan error, chan bool) (passProcessor, error) {
+		return pr, nil
+	}
+	defer func() { newProcessor = orig }()
+
+	r, err := NewRunner(&config.Config{
+		Interval:  config.TimeDuration(time.Millisecond),
+		MaxPasses: config.Int(3),
+	}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go r.Start()
+
+	select {
+	case <-r.DoneCh:
+	case err := <-r.ErrCh:
+		t.Fatal(err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("runner did not finish")
+	}
+
+	if pr.passes != 3 {
+		t.Errorf("expected 3 passes, got %d", pr.passes)
+	}
+}
+
+type scheduledProcessor struct {
+	fakeProcessor
+}
+
+func (p *scheduledProcessor) Next() (time.Duration, bool) { return time.Hour, true }
+
+func TestRunner_next(t *testing.T) {
+	pr := &scheduledProcessor{}
+	orig := newProcessor
+	newProcessor = func(*config.Config, bool, bool, chan error, chan bool) (passProcessor, error) {
+		return pr, nil
+	}
+	defer func() { newProcessor = orig }()
+
+	r, err := NewRunner(&config.Config{
+		Interval:  config.TimeDuration(time.Millisecond),
+		MaxPasses: config.Int(3),
+	}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go r.Start()
+	defer r.Stop()
+
+	select {
+	case <-r.DoneCh:
+		t.Fatal("expected the passes to follow Next rather than interval")
+	case err := <-r.ErrCh:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+	}
+}
+
+type codeProcessor struct {
+	code int
+}
+
+func (p *codeProcessor) Process() int { return p.code }
+
+func (p *codeProcessor) Stop() {}
+
+func TestRunner_startupDeadline(t *testing.T) {
+	cases := []struct {
+		name  string
+		code  int
+		fires bool
+	}{
+		{"ok", processor.ExitCodeOK, false},
+		{"error", processor.ExitCodeError, true},
+		{"empty", processor.ExitCodeEmpty, true},
+		{"standby", processor.ExitCodeStandby, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			orig := newProcessor
+			newProcessor = func(*config.Config, bool, bool, chan error, chan bool) (passProcessor, error) {
+				return &codeProcessor{code: tc.code}, nil
+			}
+			defer func() { newProcessor = orig }()
+
+			r, err := NewRunner(&config.Config{
+				Interval:        config.TimeDuration(config.MinInterval),
+				StartupDeadline: config.TimeDuration(300 * time.Millisecond),
+			}, false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			doneCh := make(chan struct{})
+			go func() {
+				r.Start()
+				close(doneCh)
+			}()
+			defer func() {
+				r.Stop()
+				<-doneCh
+			}()
+
+			select {
+			case err := <-r.ErrCh:
+				if _, ok := err.(*ErrStartupDeadline); !tc.fires || !ok {
+					t.Errorf("unexpected error %v", err)
+				}
+			case <-time.After(600 * time.Millisecond):
+				if tc.fires {
+					t.Error("expected the startup deadline to fire")
+				}
+			}
+		})
+	}
+}
+
+type errProcessor struct {
+	errCh chan error
+}
+
+func (p *errProcessor) Process() int {
+	select {
+	case p.errCh <- fmt.Errorf("boom"):
+	default:
+	}
+	return processor.ExitCodeError
+}
+
+func (p *errProcessor) Stop() {}
+
+func TestRunner_errorAfterStop(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	orig := newProcessor
+	newProcessor = func(_ *config.Config, _, _ bool, errCh chan error, _ chan bool) (passProcessor, error) {
+		return &errProcessor{errCh: errCh}, nil
+	}
+	defer func() { newProcessor = orig }()
+
+	r, err := NewRunner(&config.Config{
+		Interval: config.TimeDuration(time.Millisecond),
+	}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Start()
+		close(doneCh)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	r.Stop()
+	r.sendError(fmt.Errorf("late error"))
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("runner did not stop")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("goroutines leaked: %d before, %d after", before, after)
+	}
+}
+
+// watchProcessor returns at once for the first passes and then blocks like
+// a watch query until it is cancelled.
+type watchProcessor struct {
+	sync.Mutex
+	passes   int
+	cancelCh chan struct{}
+	once     sync.Once
+}
+
+func (p *watchProcessor) Process() int {
+	p.Lock()
+	p.passes++
+	passes := p.passes
+	p.Unlock()
+
+	if passes >= 3 {
+		<-p.cancelCh
+	}
+	return processor.ExitCodeOK
+}
+
+func (p *watchProcessor) Cancel() { p.once.Do(func() { close(p.cancelCh) }) }
+
+func (p *watchProcessor) Stop() {}
+
+func TestRunner_watch(t *testing.T) {
+	pr := &watchProcessor{cancelCh: make(chan struct{})}
+
+	orig := newProcessor
+	newProcessor = func(*config.Config, bool, bool, chan error, chan bool) (passProcessor, error) {
+		return pr, nil
+	}
+	defer func() { newProcessor = orig }()
+
+	r, err := NewRunner(&config.Config{
+		Interval: config.TimeDuration(time.Hour),
+		Watch:    config.Bool(true),
+	}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Start()
+		close(doneCh)
+	}()
+
+	// Passes follow each other without waiting for the interval.
+	deadline := time.Now().Add(time.Second)
+	for {
+		pr.Lock()
+		passes := pr.passes
+		pr.Unlock()
+		if passes >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 3 passes, got %d", passes)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Stopping aborts the blocked pass.
+	r.Stop()
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("expected the runner to stop while the pass was blocked")
+	}
+}
+
+type statsProcessor struct {
+	codeProcessor
+}
+
+func (p *statsProcessor) Stats() processor.Stats {
+	return processor.Stats{Keys: 3, Written: 2, Skipped: 1}
+}
+
+func TestTelemetry(t *testing.T) {
+	cases := []struct {
+		name   string
+		code   int
+		passes int
+		health int
+	}{
+		{"no_pass", processor.ExitCodeOK, 0, http.StatusServiceUnavailable},
+		{"failed", processor.ExitCodeError, 1, http.StatusServiceUnavailable},
+		{"completed", processor.ExitCodeOK, 1, http.StatusOK},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tel := newTelemetry()
+			for i := 0; i < tc.passes; i++ {
+				tel.observe(tc.code, &statsProcessor{})
+			}
+
+			srv := httptest.NewServer(tel.server.Handler)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/health")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.health {
+				t.Errorf("expected /health to return %d, got %d", tc.health, resp.StatusCode)
+			}
+
+			resp, err = http.Get(srv.URL + "/metrics")
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			exp := fmt.Sprintf("consul_generator_passes_total %d\n", tc.passes)
+			if !strings.Contains(string(body), exp) {
+				t.Errorf("expected %q in:\n%s", exp, body)
+			}
+			if tc.passes > 0 && !strings.Contains(string(body), "consul_generator_files_written_total 2\n") {
+				t.Errorf("expected the processor stats in:\n%s", body)
+			}
+		})
+	}
+}
+
+func TestRunner_telemetry(t *testing.T) {
+	orig := newProcessor
+	newProcessor = func(*config.Config, bool, bool, chan error, chan bool) (passProcessor, error) {
+		return &statsProcessor{}, nil
+	}
+	defer func() { newProcessor = orig }()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+
+	r, err := NewRunner(&config.Config{
+		Interval:  config.TimeDuration(time.Millisecond),
+		Telemetry: &config.TelemetryConfig{Address: config.String(address)},
+	}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Start()
+		close(doneCh)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		resp, err := http.Get("http://" + address + "/health")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected /health to turn healthy, last error %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	resp, err := http.Get("http://" + address + "/debug/vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"consul_generator_cycles_total"`) {
+		t.Errorf("expected the expvar counters at /debug/vars, got:\n%s", body)
+	}
+
+	r.Stop()
+	<-doneCh
+
+	if _, err := http.Get("http://" + address + "/health"); err == nil {
+		t.Error("expected the telemetry server to be shut down by Stop")
+	}
+}
+
+type resultProcessor struct {
+	results []processor.Result
+	passes  int
+}
+
+func (p *resultProcessor) Process() int {
+	p.passes++
+	return processor.ExitCodeOK
+}
+
+func (p *resultProcessor) Result() processor.Result { return p.results[p.passes-1] }
+
+func (p *resultProcessor) Stop() {}
+
+func TestRunner_renderEvents(t *testing.T) {
+	pr := &resultProcessor{results: []processor.Result{
+		{Keys: []processor.KeyResult{
+			{Key: "app/a", Path: "/tmp/a", Decision: "write", Reason: "file missing"},
+			{Key: "app/b", Path: "/tmp/b", Decision: "skip", Reason: "content unchanged"},
+		}},
+		{Keys: []processor.KeyResult{
+			{Key: "app/a", Path: "/tmp/a", Decision: "error", Reason: "permission denied"},
+		}},
+	}}
+	orig := newProcessor
+	newProcessor = func(*config.Config, bool, bool, chan error, chan bool) (passProcessor, error) {
+		return pr, nil
+	}
+	defer func() { newProcessor = orig }()
+
+	r, err := NewRunner(&config.Config{
+		Interval:  config.TimeDuration(time.Millisecond),
+		MaxPasses: config.Int(2),
+	}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if events := r.RenderEvents(); len(events) != 0 {
+		t.Errorf("expected no events before the first pass, got %d", len(events))
+	}
+
+	go r.Start()
+
+	select {
+	case <-r.DoneCh:
+	case err := <-r.ErrCh:
+		t.Fatal(err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("runner did not finish")
+	}
+
+	events := r.RenderEvents()
+	e := map[string]string{
+		"app/a": "error",
+		"app/b": "skip",
+	}
+	if len(events) != len(e) {
+		t.Fatalf("expected %d events, got %d", len(e), len(events))
+	}
+	for key, decision := range e {
+		event, ok := events[key]
+		if !ok {
+			t.Errorf("expected an event for %s", key)
+			continue
+		}
+		if event.Decision != decision {
+			t.Errorf("%s: expected %s, got %s", key, decision, event.Decision)
+		}
+		if event.Time.IsZero() {
+			t.Errorf("%s: expected the time of the pass", key)
+		}
+	}
+}
+
+type waitProcessor struct {
+	passes int
+}
+
+func (p *waitProcessor) Process() int {
+	p.passes++
+	return processor.ExitCodeOK
+}
+
+func (p *waitProcessor) Wait() time.Duration {
+	if p.passes == 1 {
+		return 10 * time.Millisecond
+	}
+	return 0
+}
+
+func (p *waitProcessor) Stop() {}
+
+func TestRunner_wait(t *testing.T) {
+	pr := &waitProcessor{}
+	orig := newProcessor
+	newProcessor = func(*config.Config, bool, bool, chan error, chan bool) (passProcessor, error) {
+		return pr, nil
+	}
+	defer func() { newProcessor = orig }()
+
+	r, err := NewRunner(&config.Config{
+		Interval:  config.TimeDuration(time.Hour),
+		MaxPasses: config.Int(2),
+	}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.timer.Reset(0)
+
+	go r.Start()
+
+	select {
+	case <-r.DoneCh:
+	case err := <-r.ErrCh:
+		t.Fatal(err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the held back pass to run before the interval")
+	}
+
+	if pr.passes != 2 {
+		t.Errorf("expected 2 passes, got %d", pr.passes)
+	}
+}
+
+type reloadProcessor struct {
+	sync.Mutex
+	reloads []string
+	stopped bool
+}
+
+func (p *reloadProcessor) Process() int { return processor.ExitCodeOK }
+
+func (p *reloadProcessor) Reload(c *config.Config) error {
+	p.Lock()
+	defer p.Unlock()
+	p.reloads = append(p.reloads, config.StringVal(c.To))
+	return nil
+}
+
+func (p *reloadProcessor) Stop() {
+	p.Lock()
+	defer p.Unlock()
+	p.stopped = true
+}
+
+func TestRunner_reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runner-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := func() *config.Config {
+		c := config.DefaultConfig()
+		c.To = config.String(dir)
+		c.Interval = config.TimeDuration(time.Hour)
+		c.Consul.Address = config.String("127.0.0.1:8500")
+		return c
+	}
+
+	cases := []struct {
+		name       string
+		change     func(*config.Config)
+		processors int
+		reloads    []string
+	}{
+		{
+			"interval",
+			func(c *config.Config) { c.Interval = config.TimeDuration(time.Minute) },
+			1,
+			nil,
+		},
+		{
+			"to",
+			func(c *config.Config) { c.To = config.String(filepath.Join(dir, "other")) },
+			1,
+			[]string{filepath.Join(dir, "other")},
+		},
+		{
+			"address",
+			func(c *config.Config) { c.Consul.Address = config.String("127.0.0.2:8500") },
+			2,
+			nil,
+		},
+		{
+			"token",
+			func(c *config.Config) { c.Consul.Token = config.String("secret") },
+			2,
+			nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var prs []*reloadProcessor
+			orig := newProcessor
+			newProcessor = func(*config.Config, bool, bool, chan error, chan bool) (passProcessor, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				prs = append(prs, &reloadProcessor{})
+				return prs[len(prs)-1], nil
+			}
+			defer func() { newProcessor = orig }()
+
+			r, err := NewRunner(base(), false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			doneCh := make(chan struct{})
+			go func() {
+				r.Start()
+				close(doneCh)
+			}()
+
+			c := base()
+			tc.change(c)
+			if err := r.Reload(c); err != nil {
+				t.Fatal(err)
+			}
+
+			// The loop swaps the config, after which the timer of the
+			// reloaded interval is armed.
+			deadline := time.Now().Add(time.Second)
+			for {
+				r.stopLock.Lock()
+				swapped := r.config != nil && config.StringVal(r.config.To) == config.StringVal(c.To) &&
+					config.TimeDurationVal(r.config.Interval) == config.TimeDurationVal(c.Interval) &&
+					config.StringVal(r.config.Consul.Address) == config.StringVal(c.Consul.Address) &&
+					config.StringVal(r.config.Consul.Token) == config.StringVal(c.Consul.Token)
+				r.stopLock.Unlock()
+				mu.Lock()
+				n := len(prs)
+				mu.Unlock()
+				if swapped && n == tc.processors {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("expected the config to be swapped with %d processors, got %d", tc.processors, n)
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			r.Stop()
+			<-doneCh
+
+			mu.Lock()
+			defer mu.Unlock()
+			first := prs[0]
+			first.Lock()
+			if !reflect.DeepEqual(tc.reloads, first.reloads) {
+				t.Errorf("expected reloads %q, got %q", tc.reloads, first.reloads)
+			}
+			if tc.processors > 1 && !first.stopped {
+				t.Error("expected the replaced processor to be stopped")
+			}
+			first.Unlock()
+		})
+	}
+}
+
+func TestRunner_reloadInvalid(t *testing.T) {
+	r, err := NewRunner(&config.Config{}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.DefaultConfig()
+	c.OnMissingPrefix = config.String("explode")
+	if err := r.Reload(c); err == nil {
+		t.Fatal("expected an invalid config to be rejected")
+	}
+
+	r.Stop()
+	if err := r.Reload(config.DefaultConfig()); err == nil {
+		t.Fatal("expected reloading a stopped runner to fail")
+	}
+}
+
+func TestRunner_initRedactsSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	c := config.DefaultConfig()
+	c.Consul.Token = config.String("s3cr3t-token")
+	c.Consul.Auth.Enabled = config.Bool(true)
+	c.Consul.Auth.Username = config.String("user")
+	c.Consul.Auth.Password = config.String("s3cr3t-password")
+
+	r, err := NewRunner(c, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	out := buf.String()
+	if !strings.Contains(out, "final config") {
+		t.Fatalf("expected the config to be logged, got:\n%s", out)
+	}
+	for _, secret := range []string{"s3cr3t-token", "s3cr3t-password"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("expected %q to be masked, got:\n%s", secret, out)
+		}
+	}
+	if config.StringVal(r.config.Consul.Token) != "s3cr3t-token" {
+		t.Errorf("expected the runner config to keep the token")
+	}
+}
+
+func TestRunner_reloadWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runner-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	var prs []*watchProcessor
+	orig := newProcessor
+	newProcessor = func(*config.Config, bool, bool, chan error, chan bool) (passProcessor, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		prs = append(prs, &watchProcessor{cancelCh: make(chan struct{})})
+		return prs[len(prs)-1], nil
+	}
+	defer func() { newProcessor = orig }()
+
+	c := config.DefaultConfig()
+	c.To = config.String(dir)
+	c.Watch = config.Bool(true)
+	r, err := NewRunner(c, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		r.Start()
+		close(doneCh)
+	}()
+	defer func() {
+		r.Stop()
+		<-doneCh
+	}()
+
+	// Wait for the pass blocked on changes.
+	deadline := time.Now().Add(time.Second)
+	for {
+		passes := 0
+		mu.Lock()
+		if len(prs) > 0 {
+			prs[0].Lock()
+			passes = prs[0].passes
+			prs[0].Unlock()
+		}
+		mu.Unlock()
+		if passes >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the pass to block")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A new destination aborts the blocked pass and replaces the processor.
+	reloaded := c.Copy()
+	reloaded.To = config.String(filepath.Join(dir, "other"))
+	if err := r.Reload(reloaded); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		mu.Lock()
+		n := len(prs)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline.Add(time.Second)) {
+			t.Fatal("expected the blocked pass to be aborted for the reload")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
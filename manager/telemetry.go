@@ -0,0 +1,117 @@
+package manager
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Assada/consul-generator/processor"
+)
+
+const telemetryShutdownTimeout = 5 * time.Second
+
+// telemetry serves /health and /metrics for the passes of a runner, and the
+// expvar counters at /debug/vars.
+type telemetry struct {
+	sync.Mutex
+	healthy bool
+	passes  uint64
+	stats   processor.Stats
+
+	server *http.Server
+}
+
+func newTelemetry() *telemetry {
+	t := &telemetry{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", t.health)
+	mux.HandleFunc("/metrics", t.metrics)
+	mux.Handle("/debug/vars", expvar.Handler())
+	t.server = &http.Server{Handler: mux}
+
+	return t
+}
+
+// start listens on address and serves in the background, so an address in
+// use fails the runner right away.
+func (t *telemetry) start(address string) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("runner: telemetry: %s", err)
+	}
+
+	log.Printf("[INFO] (runner) serving telemetry on %s", ln.Addr())
+	go func() {
+		if err := t.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("[ERR] (runner) telemetry: %s", err)
+		}
+	}()
+
+	return nil
+}
+
+func (t *telemetry) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), telemetryShutdownTimeout)
+	defer cancel()
+
+	if err := t.server.Shutdown(ctx); err != nil {
+		log.Printf("[WARN] (runner) could not shut down telemetry: %s", err)
+	}
+}
+
+// observe records a pass. The runner is healthy once a pass completed.
+func (t *telemetry) observe(code int, pr passProcessor) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.passes++
+	if code == processor.ExitCodeOK || code == processor.ExitCodeEmpty {
+		t.healthy = true
+	}
+	if s, ok := pr.(interface{ Stats() processor.Stats }); ok {
+		t.stats = s.Stats()
+	}
+}
+
+func (t *telemetry) health(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	healthy := t.healthy
+	t.Unlock()
+
+	if !healthy {
+		http.Error(w, "no pass completed yet", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (t *telemetry) metrics(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	passes, stats := t.passes, t.stats
+	t.Unlock()
+
+	var lastError float64
+	if !stats.LastError.IsZero() {
+		lastError = float64(stats.LastError.UnixNano()) / float64(time.Second)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range []struct {
+		name, help, kind string
+		value            float64
+	}{
+		{"consul_generator_passes_total", "Passes run.", "counter", float64(passes)},
+		{"consul_generator_keys_processed_total", "Keys listed by passes that ran.", "counter", float64(stats.Keys)},
+		{"consul_generator_files_written_total", "Files written.", "counter", float64(stats.Written)},
+		{"consul_generator_files_skipped_total", "Files skipped as unchanged.", "counter", float64(stats.Skipped)},
+		{"consul_generator_last_error_timestamp_seconds", "Unix time of the last error, 0 if none.", "gauge", lastError},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", m.name, m.help, m.name, m.kind, m.name, m.value)
+	}
+}
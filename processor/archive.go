@@ -0,0 +1,203 @@
+package processor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func isZipArchive(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".zip")
+}
+
+func (p *Processor) writeArchive(keys api.KVPairs) error {
+	path := *p.config.Archive
+	files := p.keyFiles(keys)
+
+	// An unreadable archive, e.g. one truncated by a full disk, is simply
+	// rewritten.
+	current, err := readArchive(path)
+	if err != nil {
+		log.Printf("[WARN] (processor) could not read archive %s, rewriting it: %s", path, err)
+	} else if p.treeHash(current) == p.treeHash(files) {
+		log.Printf("[INFO] (processor) Skipping archive, contents unchanged: %s", path)
+		return nil
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if p.dry {
+		p.mu.Lock()
+		p.drift++
+		p.mu.Unlock()
+		log.Printf("Archive %s will be created with entries:", path)
+		for _, name := range names {
+			log.Printf("  %s (%d bytes)", name, len(files[name]))
+		}
+		return nil
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if isZipArchive(path) {
+		err = writeZip(tmp, names, files)
+	} else {
+		err = writeTarGz(tmp, names, files)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] (processor) Saved archive: %s (%d entries)", path, len(names))
+	p.changed = true
+
+	return nil
+}
+
+func writeTarGz(w io.Writer, names []string, files map[string][]byte) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	now := time.Now()
+	for _, name := range names {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(files[name])),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func writeZip(w io.Writer, names []string, files map[string][]byte) error {
+	zw := zip.NewWriter(w)
+
+	for _, name := range names {
+		hdr := &zip.FileHeader{
+			Name:   name,
+			Method: zip.Deflate,
+		}
+		hdr.SetMode(0644)
+		hdr.Modified = time.Now()
+
+		f, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(files[name]); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func readArchive(path string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return files, nil
+	}
+
+	if isZipArchive(path) {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			content, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+			files[f.Name] = content
+		}
+		return files, nil
+	}
+
+	fo, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fo.Close()
+
+	gr, err := gzip.NewReader(fo)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[hdr.Name] = content
+	}
+
+	return files, nil
+}
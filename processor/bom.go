@@ -0,0 +1,34 @@
+package processor
+
+import (
+	"bytes"
+	"log"
+	"unicode/utf8"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// stripBOM removes a leading UTF-8 byte order mark from text values when
+// strip_bom is set. Binary values are left untouched. Pairs are copied so
+// the listed values are never modified.
+func (p *Processor) stripBOM(keys api.KVPairs) api.KVPairs {
+	if !config.BoolVal(p.config.StripBOM) {
+		return keys
+	}
+
+	stripped := make(api.KVPairs, 0, len(keys))
+	for _, pair := range keys {
+		if bytes.HasPrefix(pair.Value, utf8BOM) && utf8.Valid(pair.Value) {
+			log.Printf("[DEBUG] (processor) Stripping UTF-8 BOM: %s", pair.Key)
+			cp := *pair
+			cp.Value = pair.Value[len(utf8BOM):]
+			pair = &cp
+		}
+		stripped = append(stripped, pair)
+	}
+
+	return stripped
+}
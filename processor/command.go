@@ -0,0 +1,134 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Assada/consul-generator/config"
+)
+
+var (
+	splayLock sync.Mutex
+	splayRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// runCommand runs the commands through the shell once a pass changed at
+// least one file, in order, and waits for each to exit. A command with a
+// trigger only runs when one of the changed files matches it.
+func (p *Processor) runCommand() error {
+	if p.config.Commands == nil || !p.changed || p.dry {
+		return nil
+	}
+
+	var commands []string
+	changed := p.changedFiles()
+	for _, c := range *p.config.Commands {
+		command, trigger := config.StringVal(c.Exec), config.StringVal(c.Trigger)
+		if trigger != "" && !anyPathMatch(trigger, changed) {
+			log.Printf("[DEBUG] (processor) no changed file matches %q, not running: %s", trigger, command)
+			continue
+		}
+		commands = append(commands, command)
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+
+	if !p.splay() {
+		log.Printf("[INFO] (processor) stopped during command_splay, not running: %s", strings.Join(commands, "; "))
+		return nil
+	}
+
+	for _, command := range commands {
+		cmd := exec.Command(shell, flag, command)
+		cmd.Env = os.Environ()
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		log.Printf("[INFO] (processor) files changed, running: %s", command)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("processor: command %q failed: %s", command, err)
+		}
+	}
+
+	return nil
+}
+
+// triggered reports whether a command has a trigger.
+func (p *Processor) triggered() bool {
+	if p.config.Commands == nil {
+		return false
+	}
+	for _, c := range *p.config.Commands {
+		if config.StringVal(c.Trigger) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// changedFiles returns the files the pass in progress wrote or pruned,
+// slash separated below to.
+func (p *Processor) changedFiles() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	to := config.StringVal(p.config.To)
+	files := make([]string, 0, len(p.events))
+	for _, e := range p.events {
+		rel, err := filepath.Rel(to, e.Path)
+		if err != nil {
+			continue
+		}
+		files = append(files, filepath.ToSlash(rel))
+	}
+	return files
+}
+
+func anyPathMatch(pattern string, names []string) bool {
+	for _, name := range names {
+		if pathMatch(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// splay waits a random duration in [0, command_splay). It reports false
+// when the processor is stopped while waiting.
+func (p *Processor) splay() bool {
+	max := config.TimeDurationVal(p.config.CommandSplay)
+	if max <= 0 {
+		return true
+	}
+
+	splayLock.Lock()
+	d := time.Duration(splayRand.Int63n(int64(max)))
+	splayLock.Unlock()
+
+	log.Printf("[DEBUG] (processor) waiting %s of command_splay", d)
+	var done <-chan struct{}
+	if p.ctx != nil {
+		done = p.ctx.Done()
+	}
+
+	select {
+	case <-time.After(d):
+		return true
+	case <-done:
+		return false
+	}
+}
@@ -0,0 +1,107 @@
+package processor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// decompress decompresses the value of every key as configured by
+// compression, before it is compared or written. A value that does not
+// decompress fails the pass. Pairs are copied so the listed values are
+// never modified.
+func (p *Processor) decompress(keys api.KVPairs) (api.KVPairs, error) {
+	compression := config.StringVal(p.config.Compression)
+	if compression == config.CompressionNone {
+		return keys, nil
+	}
+
+	decompressed := make(api.KVPairs, 0, len(keys))
+	for _, pair := range keys {
+		if p.fileName(pair.Key) == "" {
+			decompressed = append(decompressed, pair)
+			continue
+		}
+
+		var r io.ReadCloser
+		var err error
+		switch compression {
+		case config.CompressionGzip:
+			r, err = gzip.NewReader(bytes.NewReader(pair.Value))
+		case config.CompressionDeflate:
+			r, err = zlib.NewReader(bytes.NewReader(pair.Value))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("processor: %s is not %s compressed: %s", pair.Key, compression, err)
+		}
+
+		value, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("processor: decompressing %s: %s", pair.Key, err)
+		}
+
+		cp := *pair
+		cp.Value = value
+		decompressed = append(decompressed, &cp)
+	}
+
+	return decompressed, nil
+}
+
+func (p *Processor) validateOutputCompression() error {
+	switch compression := config.StringVal(p.config.OutputCompression); compression {
+	case config.CompressionNone:
+		return nil
+	case config.CompressionGzip:
+	default:
+		return fmt.Errorf("processor: invalid output_compression %q: only gzip is supported, as its header can be "+
+			"fixed so that an unchanged value always compresses to the same bytes", compression)
+	}
+
+	if config.BoolVal(p.config.Push) || config.BoolVal(p.config.EnvFile) || config.BoolVal(p.config.Stdout) {
+		return fmt.Errorf("processor: output_compression cannot be combined with push, env_file or stdout")
+	}
+
+	return nil
+}
+
+// compressOutput gzips the value of every key for output_compression, once
+// every other transformation is done, so the bytes hashed and compared with
+// the file are the bytes written. The header has no name and a zero
+// modification time, so an unchanged value compresses to the same bytes
+// for as long as the compress/flate of the Go release used does not change.
+func (p *Processor) compressOutput(keys api.KVPairs) (api.KVPairs, error) {
+	if config.StringVal(p.config.OutputCompression) != config.CompressionGzip {
+		return keys, nil
+	}
+
+	compressed := make(api.KVPairs, 0, len(keys))
+	for _, pair := range keys {
+		if p.fileName(pair.Key) == "" {
+			compressed = append(compressed, pair)
+			continue
+		}
+
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(pair.Value); err != nil {
+			return nil, fmt.Errorf("processor: compressing %s: %s", pair.Key, err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("processor: compressing %s: %s", pair.Key, err)
+		}
+
+		cp := *pair
+		cp.Value = buf.Bytes()
+		compressed = append(compressed, &cp)
+	}
+
+	return compressed, nil
+}
@@ -0,0 +1,111 @@
+package processor
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+const connectLeafRetry = 10 * time.Second
+
+// connectLeaf keeps a client certificate issued by the Connect CA and
+// renews it before it expires.
+type connectLeaf struct {
+	sync.RWMutex
+
+	service string
+	cert    *tls.Certificate
+	valid   time.Time
+	expires time.Time
+	stopCh  chan struct{}
+}
+
+func newConnectLeaf(c *config.Config) *connectLeaf {
+	if c.Consul == nil || c.Consul.SSL == nil || !config.BoolVal(c.Consul.SSL.UseConnectLeaf) {
+		return nil
+	}
+
+	return &connectLeaf{
+		service: config.StringVal(c.Consul.SSL.ConnectService),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+func (l *connectLeaf) certFunc() func() *tls.Certificate {
+	if l == nil {
+		return nil
+	}
+	return l.certificate
+}
+
+func (l *connectLeaf) certificate() *tls.Certificate {
+	l.RLock()
+	defer l.RUnlock()
+	return l.cert
+}
+
+func (l *connectLeaf) fetch(client *api.Client) error {
+	leaf, _, err := client.Agent().ConnectCALeaf(l.service, nil)
+	if err != nil {
+		return fmt.Errorf("processor: connect leaf certificate for %q: %s", l.service, err)
+	}
+
+	cert, err := tls.X509KeyPair([]byte(leaf.CertPEM), []byte(leaf.PrivateKeyPEM))
+	if err != nil {
+		return fmt.Errorf("processor: connect leaf certificate for %q: %s", l.service, err)
+	}
+
+	l.Lock()
+	l.cert = &cert
+	l.valid = leaf.ValidAfter
+	l.expires = leaf.ValidBefore
+	l.Unlock()
+
+	log.Printf("[INFO] (processor) using connect leaf certificate %s for %q, valid until %s",
+		leaf.SerialNumber, l.service, leaf.ValidBefore)
+
+	return nil
+}
+
+// renew fetches a new leaf once two thirds of the certificate lifetime have
+// passed. Failures are retried while the current certificate stays in use.
+func (l *connectLeaf) renew(client *api.Client) {
+	for {
+		l.RLock()
+		valid, expires := l.valid, l.expires
+		l.RUnlock()
+
+		wait := time.Until(valid.Add(expires.Sub(valid) * 2 / 3))
+		if wait < time.Second {
+			wait = time.Second
+		}
+
+		for {
+			select {
+			case <-l.stopCh:
+				return
+			case <-time.After(wait):
+			}
+
+			err := l.fetch(client)
+			if err == nil {
+				break
+			}
+			if time.Now().After(expires) {
+				log.Printf("[ERR] %s, certificate expired at %s, retrying in %s", err, expires, connectLeafRetry)
+			} else {
+				log.Printf("[WARN] %s, keeping the current certificate, retrying in %s", err, connectLeafRetry)
+			}
+			wait = connectLeafRetry
+		}
+	}
+}
+
+func (l *connectLeaf) stop() {
+	close(l.stopCh)
+}
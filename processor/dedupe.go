@@ -0,0 +1,95 @@
+package processor
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const dedupeStoreDir = ".dedupe"
+
+func (p *Processor) dedupeTree(keys api.KVPairs) error {
+	store := filepath.Join(*p.config.To, dedupeStoreDir)
+	used := make(map[string]bool)
+
+	for _, pair := range keys {
+		filename := p.fileName(pair.Key)
+		if filename == "" || filename == dedupeStoreDir {
+			continue
+		}
+
+		hash := p.getHash(pair.Value)
+		used[hash] = true
+
+		if err := p.saveDeduped(filepath.Join(*p.config.To, filename), store, hash, pair.Value); err != nil {
+			return err
+		}
+	}
+
+	if p.dry {
+		return nil
+	}
+
+	infos, err := ioutil.ReadDir(store)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if used[info.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(store, info.Name())); err != nil {
+			log.Printf("[WARN] (processor) could not remove unused store entry %s: %s", info.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+func (p *Processor) saveDeduped(file, store, hash string, content []byte) error {
+	target := filepath.Join(dedupeStoreDir, hash)
+
+	if link, err := os.Readlink(file); err == nil && link == target {
+		if _, err := os.Stat(filepath.Join(store, hash)); err == nil {
+			p.logSkip(file)
+			return nil
+		}
+	}
+
+	if p.dry {
+		log.Printf("File %s will be linked to %s", file, target)
+		return nil
+	}
+
+	if err := os.MkdirAll(store, os.ModePerm); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(filepath.Join(store, hash)); os.IsNotExist(err) {
+		if err := p.save(filepath.Join(store, hash), string(content)); err != nil {
+			return err
+		}
+	}
+
+	tmp := file + ".link"
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	log.Printf("[INFO] (processor) Linked: %s -> %s", file, target)
+	p.changed = true
+	p.countWritten()
+
+	return nil
+}
@@ -0,0 +1,208 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Assada/consul-generator/config"
+)
+
+const (
+	// diffContext is the number of unchanged lines around a change.
+	diffContext = 3
+	// diffNewFileLines is the number of lines shown of a new file.
+	diffNewFileLines = 10
+	// diffMaxCells bounds the table of the line matching. Larger changes
+	// are shown as removing all old lines and adding all new ones.
+	diffMaxCells = 4 << 20
+)
+
+// dryDiff describes what a dry run would write to path: a unified diff
+// against the current file, or the first lines of a new file. keys are the
+// keys content comes from, for redact.
+func (p *Processor) dryDiff(path string, content []byte, keys ...string) string {
+	if p.redacts(keys...) {
+		return fmt.Sprintf("File %s will be written: %s", path, p.loggable(content, keys...))
+	}
+	if compression := config.StringVal(p.config.OutputCompression); compression == config.CompressionGzip {
+		return fmt.Sprintf("File %s will be written, %s %s compressed", path, formatBytes(len(content)), compression)
+	}
+
+	current, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Sprintf("File %s (new file):\n%s", path, head(string(content), diffNewFileLines))
+	}
+
+	diff := unifiedDiff(path, splitLines(string(current)), splitLines(string(content)))
+	if diff == "" {
+		return fmt.Sprintf("File %s will be rewritten without changes", path)
+	}
+	return fmt.Sprintf("File %s will be changed:\n%s", path, diff)
+}
+
+// head returns the first n lines of s and a note on how many were left out.
+func head(s string, n int) string {
+	lines := splitLines(s)
+	if len(lines) <= n {
+		return s
+	}
+	return strings.Join(lines[:n], "") + fmt.Sprintf("... (%d more lines)\n", len(lines)-n)
+}
+
+// splitLines splits s after every newline. The last line has none if s
+// does not end in one.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// diffLines returns the edit script turning a into b, matching the longest
+// common subsequence of lines.
+func diffLines(a, b []string) []diffOp {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, l := range a[:pre] {
+		ops = append(ops, diffOp{' ', l})
+	}
+
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	if len(ma)*len(mb) > diffMaxCells {
+		for _, l := range ma {
+			ops = append(ops, diffOp{'-', l})
+		}
+		for _, l := range mb {
+			ops = append(ops, diffOp{'+', l})
+		}
+	} else {
+		// lcs[i*w+j] is the length of the common subsequence of ma[i:]
+		// and mb[j:].
+		w := len(mb) + 1
+		lcs := make([]int, (len(ma)+1)*w)
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+				} else if lcs[(i+1)*w+j] >= lcs[i*w+j+1] {
+					lcs[i*w+j] = lcs[(i+1)*w+j]
+				} else {
+					lcs[i*w+j] = lcs[i*w+j+1]
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(ma) || j < len(mb) {
+			switch {
+			case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+				ops = append(ops, diffOp{' ', ma[i]})
+				i++
+				j++
+			case j == len(mb) || (i < len(ma) && lcs[(i+1)*w+j] >= lcs[i*w+j+1]):
+				ops = append(ops, diffOp{'-', ma[i]})
+				i++
+			default:
+				ops = append(ops, diffOp{'+', mb[j]})
+				j++
+			}
+		}
+	}
+
+	for _, l := range a[len(a)-suf:] {
+		ops = append(ops, diffOp{' ', l})
+	}
+
+	return ops
+}
+
+// unifiedDiff returns the unified diff from a to b of the file name, or ""
+// when they are equal.
+func unifiedDiff(name string, a, b []string) string {
+	ops := diffLines(a, b)
+
+	// aLine and bLine are the line numbers in a and b of each op.
+	aLine, bLine := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	aLine[0], bLine[0] = 1, 1
+	for i, op := range ops {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if op.kind != '+' {
+			aLine[i+1]++
+		}
+		if op.kind != '-' {
+			bLine[i+1]++
+		}
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i + 1
+		for j := i + 1; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			} else if j-end >= 2*diffContext {
+				break
+			}
+		}
+		stop := end + diffContext
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", name, name)
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n",
+			diffRange(aLine[start], aLine[stop]-aLine[start]),
+			diffRange(bLine[start], bLine[stop]-bLine[start]))
+		for _, op := range ops[start:stop] {
+			buf.WriteByte(op.kind)
+			buf.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		i = stop
+	}
+
+	return buf.String()
+}
+
+func diffRange(start, length int) string {
+	if length == 1 {
+		return fmt.Sprint(start)
+	}
+	if length == 0 {
+		start--
+	}
+	return fmt.Sprintf("%d,%d", start, length)
+}
@@ -0,0 +1,104 @@
+package processor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+const envFileName = ".env"
+
+func (p *Processor) envFilePath() string {
+	if path := config.StringVal(p.config.EnvFilePath); path != "" {
+		return path
+	}
+	return filepath.Join(config.StringVal(p.config.To), envFileName)
+}
+
+func (p *Processor) writeEnvFile(keys api.KVPairs) error {
+	path := p.envFilePath()
+	content := renderEnvFile(keys)
+
+	current, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil && p.getHash(current) == p.getHash(content) {
+		log.Printf("[INFO] (processor) Skipping env file, contents unchanged: %s", path)
+		return nil
+	}
+
+	if !p.dry {
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, pair := range keys {
+		names = append(names, pair.Key)
+	}
+	return p.save(path, string(content), names...)
+}
+
+func renderEnvFile(keys api.KVPairs) []byte {
+	var buf bytes.Buffer
+	seen := make(map[string]string, len(keys))
+	for _, pair := range keys {
+		leaf := keyFileName(pair.Key)
+		if leaf == "" {
+			continue
+		}
+
+		name := envName(leaf)
+		if prev, ok := seen[name]; ok {
+			log.Printf("[WARN] (processor) %s and %s both map to %s, keeping %s",
+				prev, pair.Key, name, prev)
+			continue
+		}
+		seen[name] = pair.Key
+
+		buf.WriteString(name)
+		buf.WriteByte('=')
+		buf.WriteString(envQuote(string(pair.Value)))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// envName turns a key leaf into an environment variable name, e.g.
+// "db.host" becomes "DB_HOST".
+func envName(leaf string) string {
+	name := []byte(strings.ToUpper(leaf))
+	for i, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			name[i] = '_'
+		}
+	}
+	if name[0] >= '0' && name[0] <= '9' {
+		return "_" + string(name)
+	}
+	return string(name)
+}
+
+func envQuote(v string) string {
+	safe := true
+	for _, c := range v {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("_-.,:/@%+", c)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return v
+	}
+
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`, "\r", `\r`)
+	return `"` + r.Replace(v) + `"`
+}
@@ -0,0 +1,124 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExitCodeMissingPrefix is the exit code when from lists no keys and
+// on_missing_prefix or require_keys make that fatal.
+const ExitCodeMissingPrefix = 19
+
+var _ error = new(ErrMissingPrefix)
+
+type ErrMissingPrefix struct {
+	prefix string
+}
+
+func NewErrMissingPrefix(prefix string) *ErrMissingPrefix {
+	return &ErrMissingPrefix{prefix: prefix}
+}
+
+func (e *ErrMissingPrefix) Error() string {
+	return fmt.Sprintf("consul path (%s) empty or does not exists", e.prefix)
+}
+
+func (e *ErrMissingPrefix) ExitStatus() int {
+	return ExitCodeMissingPrefix
+}
+
+// ExitCodeDrift is the exit code of a dry run with detailed_exitcode that
+// would change files.
+const ExitCodeDrift = 2
+
+var _ error = new(ErrDrift)
+
+type ErrDrift struct {
+	files int
+}
+
+func NewErrDrift(files int) *ErrDrift {
+	return &ErrDrift{files: files}
+}
+
+func (e *ErrDrift) Error() string {
+	return fmt.Sprintf("dry run found %d files that would change", e.files)
+}
+
+func (e *ErrDrift) ExitStatus() int {
+	return ExitCodeDrift
+}
+
+const ExitCodePreflight = 18
+
+var _ error = new(ErrPreflight)
+
+type ErrPreflight struct {
+	capability string
+	prefix     string
+	err        error
+}
+
+func NewErrPreflight(capability, prefix string, err error) *ErrPreflight {
+	return &ErrPreflight{capability: capability, prefix: prefix, err: err}
+}
+
+func (e *ErrPreflight) Error() string {
+	return fmt.Sprintf("consul token lacks %s permission on %q: %s", e.capability, e.prefix, e.err)
+}
+
+func (e *ErrPreflight) ExitStatus() int {
+	return ExitCodePreflight
+}
+
+// ExitCodeAuth is the exit code when Consul rejects the credentials, be it
+// the login with auth_method or the token on a list request.
+const ExitCodeAuth = 20
+
+var _ error = new(ErrAuth)
+
+type ErrAuth struct {
+	err error
+}
+
+func NewErrAuth(err error) *ErrAuth {
+	return &ErrAuth{err: err}
+}
+
+func (e *ErrAuth) Error() string {
+	return e.err.Error()
+}
+
+func (e *ErrAuth) ExitStatus() int {
+	return ExitCodeAuth
+}
+
+// ExitCodeUnsafePath is the exit code when a key maps to a file outside of
+// its destination directory.
+const ExitCodeUnsafePath = 21
+
+var _ error = new(ErrUnsafePath)
+
+type ErrUnsafePath struct {
+	key  string
+	path string
+	root string
+}
+
+func NewErrUnsafePath(key, path, root string) *ErrUnsafePath {
+	return &ErrUnsafePath{key: key, path: path, root: root}
+}
+
+func (e *ErrUnsafePath) Error() string {
+	return fmt.Sprintf("key %q maps to %q outside of %s", e.key, e.path, e.root)
+}
+
+func (e *ErrUnsafePath) ExitStatus() int {
+	return ExitCodeUnsafePath
+}
+
+// permissionDenied reports whether err is Consul refusing a request for
+// lack of permissions.
+func permissionDenied(err error) bool {
+	return strings.Contains(err.Error(), "403") || strings.Contains(strings.ToLower(err.Error()), "permission denied")
+}
@@ -0,0 +1,77 @@
+package processor
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// explanation is the decision chain for a single key, written to the out
+// stream when explain is enabled.
+type explanation struct {
+	Key      string
+	Path     string
+	Source   string
+	Disk     string
+	Decision string
+	Reason   string
+}
+
+// SetOutStream sets where explanations and, with stdout, the values of the
+// keys are written to.
+func (p *Processor) SetOutStream(out io.Writer) {
+	p.out = out
+	if p.explain != nil {
+		p.explain = out
+	}
+	for _, s := range p.syncs {
+		s.SetOutStream(out)
+	}
+}
+
+// explainKey records the decision for a key in the result of the pass and
+// writes it to the out stream when explain is enabled.
+func (p *Processor) explainKey(e explanation) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.result = append(p.result, KeyResult{Key: e.Key, Path: e.Path, Decision: e.Decision, Reason: e.Reason})
+
+	if p.explain == nil {
+		return
+	}
+
+	fmt.Fprintf(p.explain, "key %s\n", e.Key)
+	if e.Path != "" {
+		fmt.Fprintf(p.explain, "  path:     %s\n", e.Path)
+	}
+	if e.Source != "" {
+		fmt.Fprintf(p.explain, "  source:   %s\n", e.Source)
+	}
+	if e.Path != "" && e.Source != "" {
+		disk := e.Disk
+		if disk == "" {
+			disk = "(missing)"
+		}
+		fmt.Fprintf(p.explain, "  disk:     %s\n", disk)
+	}
+	fmt.Fprintf(p.explain, "  decision: %s (%s)\n", e.Decision, e.Reason)
+}
+
+// explainDropped explains every key of before that is missing from after.
+func (p *Processor) explainDropped(before, after api.KVPairs, reason string) {
+	if len(before) == len(after) {
+		return
+	}
+
+	kept := make(map[string]bool, len(after))
+	for _, pair := range after {
+		kept[pair.Key] = true
+	}
+	for _, pair := range before {
+		if !kept[pair.Key] {
+			p.explainKey(explanation{Key: pair.Key, Decision: "skip", Reason: reason})
+		}
+	}
+}
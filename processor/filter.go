@@ -0,0 +1,334 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// filter is a client-side evaluator for Consul's filter expression syntax
+// (https://www.consul.io/api/features/filtering.html). Selectors are resolved
+// through a lookup function so the same expression can be applied to any
+// source, KV pairs use Key, Value and Flags.
+type filter struct {
+	expr string
+	root filterNode
+}
+
+type filterNode interface {
+	eval(lookup func(string) (string, bool)) (bool, error)
+}
+
+type filterAnd struct{ l, r filterNode }
+
+type filterOr struct{ l, r filterNode }
+
+type filterNot struct{ n filterNode }
+
+type filterMatch struct {
+	selector string
+	op       string
+	value    string
+	re       *regexp.Regexp
+}
+
+func newFilter(expr string) (*filter, error) {
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, fmt.Errorf("processor: invalid filter %q: %s", expr, err)
+	}
+
+	fp := &filterParser{tokens: tokens}
+	root, err := fp.parseOr()
+	if err == nil && fp.pos < len(fp.tokens) {
+		err = fmt.Errorf("unexpected %q", fp.tokens[fp.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("processor: invalid filter %q: %s", expr, err)
+	}
+
+	return &filter{expr: expr, root: root}, nil
+}
+
+func (f *filter) match(lookup func(string) (string, bool)) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+	return f.root.eval(lookup)
+}
+
+func (f *filter) filterKV(keys api.KVPairs) (api.KVPairs, error) {
+	if f == nil {
+		return keys, nil
+	}
+
+	filtered := make(api.KVPairs, 0, len(keys))
+	for _, pair := range keys {
+		ok, err := f.match(kvSelector(pair))
+		if err != nil {
+			return nil, fmt.Errorf("processor: filter %q: %s", f.expr, err)
+		}
+		if ok {
+			filtered = append(filtered, pair)
+		}
+	}
+
+	return filtered, nil
+}
+
+func kvSelector(pair *api.KVPair) func(string) (string, bool) {
+	return func(selector string) (string, bool) {
+		switch selector {
+		case "Key":
+			return pair.Key, true
+		case "Value":
+			return string(pair.Value), true
+		case "Flags":
+			return strconv.FormatUint(pair.Flags, 10), true
+		}
+		return "", false
+	}
+}
+
+func (n *filterAnd) eval(lookup func(string) (string, bool)) (bool, error) {
+	ok, err := n.l.eval(lookup)
+	if err != nil || !ok {
+		return false, err
+	}
+	return n.r.eval(lookup)
+}
+
+func (n *filterOr) eval(lookup func(string) (string, bool)) (bool, error) {
+	ok, err := n.l.eval(lookup)
+	if err != nil || ok {
+		return ok, err
+	}
+	return n.r.eval(lookup)
+}
+
+func (n *filterNot) eval(lookup func(string) (string, bool)) (bool, error) {
+	ok, err := n.n.eval(lookup)
+	return !ok, err
+}
+
+func (n *filterMatch) eval(lookup func(string) (string, bool)) (bool, error) {
+	v, ok := lookup(n.selector)
+	if !ok {
+		return false, fmt.Errorf("unknown selector %q", n.selector)
+	}
+
+	switch n.op {
+	case "==":
+		return v == n.value, nil
+	case "!=":
+		return v != n.value, nil
+	case "contains", "in":
+		return strings.Contains(v, n.value), nil
+	case "not contains", "not in":
+		return !strings.Contains(v, n.value), nil
+	case "matches":
+		return n.re.MatchString(v), nil
+	case "not matches":
+		return !n.re.MatchString(v), nil
+	case "is empty":
+		return v == "", nil
+	case "is not empty":
+		return v != "", nil
+	}
+
+	return false, fmt.Errorf("unknown operator %q", n.op)
+}
+
+type filterToken struct {
+	text   string
+	quoted bool
+}
+
+func lexFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+
+	r := []rune(expr)
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, filterToken{text: string(c)})
+			i++
+		case c == '=' || c == '!':
+			if i+1 >= len(r) || r[i+1] != '=' {
+				return nil, fmt.Errorf("unexpected %q", string(c))
+			}
+			tokens = append(tokens, filterToken{text: string(r[i : i+2])})
+			i += 2
+		case c == '"' || c == '`':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(r) && r[j] != c; j++ {
+				if c == '"' && r[j] == '\\' && j+1 < len(r) {
+					j++
+				}
+				b.WriteRune(r[j])
+			}
+			if j >= len(r) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, filterToken{text: b.String(), quoted: true})
+			i = j + 1
+		default:
+			j := i
+			for j < len(r) && !unicode.IsSpace(r[j]) && !strings.ContainsRune("()=!\"`", r[j]) {
+				j++
+			}
+			tokens = append(tokens, filterToken{text: string(r[i:j])})
+			i = j
+		}
+	}
+
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (fp *filterParser) peek(words ...string) bool {
+	for i, w := range words {
+		if fp.pos+i >= len(fp.tokens) {
+			return false
+		}
+		t := fp.tokens[fp.pos+i]
+		if t.quoted || t.text != w {
+			return false
+		}
+	}
+	return true
+}
+
+func (fp *filterParser) next() (filterToken, error) {
+	if fp.pos >= len(fp.tokens) {
+		return filterToken{}, fmt.Errorf("unexpected end of expression")
+	}
+	t := fp.tokens[fp.pos]
+	fp.pos++
+	return t, nil
+}
+
+func (fp *filterParser) parseOr() (filterNode, error) {
+	l, err := fp.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for fp.peek("or") {
+		fp.pos++
+		r, err := fp.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &filterOr{l, r}
+	}
+	return l, nil
+}
+
+func (fp *filterParser) parseAnd() (filterNode, error) {
+	l, err := fp.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for fp.peek("and") {
+		fp.pos++
+		r, err := fp.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &filterAnd{l, r}
+	}
+	return l, nil
+}
+
+func (fp *filterParser) parseUnary() (filterNode, error) {
+	if fp.peek("not") {
+		fp.pos++
+		n, err := fp.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &filterNot{n}, nil
+	}
+
+	if fp.peek("(") {
+		fp.pos++
+		n, err := fp.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !fp.peek(")") {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		fp.pos++
+		return n, nil
+	}
+
+	return fp.parseMatch()
+}
+
+func (fp *filterParser) parseMatch() (filterNode, error) {
+	first, err := fp.next()
+	if err != nil {
+		return nil, err
+	}
+
+	// <value> in <selector>
+	for _, op := range []string{"in", "not in"} {
+		words := strings.Fields(op)
+		if fp.peek(words...) {
+			fp.pos += len(words)
+			sel, err := fp.next()
+			if err != nil {
+				return nil, err
+			}
+			return &filterMatch{selector: sel.text, op: op, value: first.text}, nil
+		}
+	}
+
+	if first.quoted {
+		return nil, fmt.Errorf("expected selector, got %q", first.text)
+	}
+
+	for _, op := range []string{"is not empty", "is empty"} {
+		words := strings.Fields(op)
+		if fp.peek(words...) {
+			fp.pos += len(words)
+			return &filterMatch{selector: first.text, op: op}, nil
+		}
+	}
+
+	for _, op := range []string{"==", "!=", "contains", "not contains", "matches", "not matches"} {
+		words := strings.Fields(op)
+		if !fp.peek(words...) {
+			continue
+		}
+		fp.pos += len(words)
+
+		v, err := fp.next()
+		if err != nil {
+			return nil, err
+		}
+
+		m := &filterMatch{selector: first.text, op: op, value: v.text}
+		if op == "matches" || op == "not matches" {
+			if m.re, err = regexp.Compile(v.text); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+
+	return nil, fmt.Errorf("expected operator after %q", first.text)
+}
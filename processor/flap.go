@@ -0,0 +1,91 @@
+package processor
+
+import (
+	"log"
+	"time"
+
+	"github.com/Assada/consul-generator/config"
+)
+
+// flapDetector keeps a short history of content changes per file. A file
+// that changed to flap_threshold distinct values within flap_window is
+// flapping; with flap_hold its last written value is kept until fewer
+// distinct values fall within the window again.
+type flapDetector struct {
+	threshold int
+	window    time.Duration
+	hold      bool
+	now       func() time.Time
+	files     map[string]*flapHistory
+}
+
+type flapHistory struct {
+	last     string
+	changes  []flapChange
+	flapping bool
+}
+
+type flapChange struct {
+	hash string
+	at   time.Time
+}
+
+func newFlapDetector(c *config.Config) *flapDetector {
+	threshold := config.IntVal(c.FlapThreshold)
+	if threshold <= 0 {
+		return nil
+	}
+
+	return &flapDetector{
+		threshold: threshold,
+		window:    config.TimeDurationVal(c.FlapWindow),
+		hold:      config.BoolVal(c.FlapHold),
+		now:       time.Now,
+		files:     make(map[string]*flapHistory),
+	}
+}
+
+// observe records the current hash of file and reports whether writing it
+// should be held back.
+func (f *flapDetector) observe(file, hash string) bool {
+	if f == nil {
+		return false
+	}
+
+	h, ok := f.files[file]
+	if !ok {
+		f.files[file] = &flapHistory{last: hash}
+		return false
+	}
+
+	now := f.now()
+	if hash != h.last {
+		h.last = hash
+		h.changes = append(h.changes, flapChange{hash: hash, at: now})
+	}
+
+	recent := h.changes[:0]
+	distinct := make(map[string]bool)
+	for _, c := range h.changes {
+		if now.Sub(c.at) < f.window {
+			recent = append(recent, c)
+			distinct[c.hash] = true
+		}
+	}
+	h.changes = recent
+
+	flapping := len(distinct) >= f.threshold
+	switch {
+	case flapping && !h.flapping && f.hold:
+		log.Printf("[WARN] (processor) %s is flapping (%d distinct values within %s), holding its last value",
+			file, len(distinct), f.window)
+	case flapping && !h.flapping:
+		log.Printf("[WARN] (processor) %s is flapping (%d distinct values within %s)",
+			file, len(distinct), f.window)
+	case !flapping && h.flapping:
+		log.Printf("[INFO] (processor) %s stabilized", file)
+	}
+	h.flapping = flapping
+
+	return flapping && f.hold
+}
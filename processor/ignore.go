@@ -0,0 +1,40 @@
+package processor
+
+import (
+	"log"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const ignoreMarkerSuffix = ".ignore"
+
+func isIgnoreMarker(key string) bool {
+	return strings.HasSuffix(keyFileName(key), ignoreMarkerSuffix)
+}
+
+func filterIgnored(keys api.KVPairs) api.KVPairs {
+	ignored := make(map[string]bool)
+	for _, pair := range keys {
+		if !isIgnoreMarker(pair.Key) {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(string(pair.Value)), "true") {
+			ignored[strings.TrimSuffix(normalizeKey(pair.Key), ignoreMarkerSuffix)] = true
+		}
+	}
+
+	filtered := make(api.KVPairs, 0, len(keys))
+	for _, pair := range keys {
+		if isIgnoreMarker(pair.Key) {
+			continue
+		}
+		if ignored[normalizeKey(pair.Key)] {
+			log.Printf("[INFO] (processor) Ignoring: %s", pair.Key)
+			continue
+		}
+		filtered = append(filtered, pair)
+	}
+
+	return filtered
+}
@@ -0,0 +1,60 @@
+package processor
+
+import (
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// included reports whether key matches an include glob, when there are any,
+// and no exclude glob. Globs match the full key.
+func (p *Processor) included(key string) bool {
+	key = normalizeKey(key)
+
+	if len(p.config.Include) > 0 && !anyGlobMatch(key, p.config.Include) {
+		return false
+	}
+
+	return !anyGlobMatch(key, p.config.Exclude)
+}
+
+// filterIncluded drops the keys that are not included.
+func (p *Processor) filterIncluded(keys api.KVPairs) api.KVPairs {
+	if len(p.config.Include) == 0 && len(p.config.Exclude) == 0 {
+		return keys
+	}
+
+	filtered := make(api.KVPairs, 0, len(keys))
+	for _, pair := range keys {
+		if !p.included(pair.Key) {
+			log.Printf("[DEBUG] (processor) %s excluded by include/exclude", pair.Key)
+			continue
+		}
+		filtered = append(filtered, pair)
+	}
+
+	return filtered
+}
+
+// pathMatch reports whether the slash separated name below to matches
+// pattern. A pattern without a slash matches the last segment of name, so
+// "*.conf" also matches nested files.
+func pathMatch(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+func anyGlobMatch(s string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, s); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package processor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Assada/consul-generator/config"
+)
+
+// plannedWrite is a file a dry pass would have written.
+type plannedWrite struct {
+	Path    string
+	Content string
+}
+
+// Interactive runs a single dry pass, prints the planned writes to out and
+// asks on in whether to apply them. Only the confirmed plan is written, so
+// what is shown is exactly what is applied.
+func Interactive(c *config.Config, in io.Reader, out io.Writer) error {
+	if config.BoolVal(c.Push) || config.StringVal(c.Archive) != "" ||
+		config.BoolVal(c.SwapDir) || config.BoolVal(c.DedupeIdentical) || hasSyncs(c) {
+		return fmt.Errorf("processor: interactive cannot be combined with push, archive, swap_dir, dedupe_identical or sync")
+	}
+
+	errCh := make(chan error, 1)
+	p, err := NewProcessor(c, true, true, errCh, make(chan bool, 1))
+	if err != nil {
+		return err
+	}
+	defer p.Stop()
+
+	p.plan = []plannedWrite{}
+	if code := p.Process(); code == ExitCodeError {
+		select {
+		case err := <-errCh:
+			return err
+		default:
+			return fmt.Errorf("processor: dry pass failed")
+		}
+	}
+
+	if len(p.plan) == 0 {
+		fmt.Fprintf(out, "No changes to apply\n")
+		return nil
+	}
+
+	// Workers of a concurrent pass plan writes in any order.
+	sort.Slice(p.plan, func(i, j int) bool { return p.plan[i].Path < p.plan[j].Path })
+
+	fmt.Fprintf(out, "Planned changes:\n")
+	for _, w := range p.plan {
+		fmt.Fprintf(out, "  write %s (%d bytes)\n", w.Path, len(w.Content))
+	}
+	fmt.Fprintf(out, "Apply these changes? [y/N]: ")
+
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+	default:
+		fmt.Fprintf(out, "Not applied\n")
+		return nil
+	}
+
+	p.dry = false
+	for _, w := range p.plan {
+		if err := os.MkdirAll(filepath.Dir(w.Path), os.ModePerm); err != nil {
+			return err
+		}
+		if err := p.save(w.Path, w.Content); err != nil {
+			return err
+		}
+		p.recordChange(w.Path, "", changeWrite, []byte(w.Content))
+	}
+	fmt.Fprintf(out, "Applied %d changes\n", len(p.plan))
+
+	return p.runCommand()
+}
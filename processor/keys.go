@@ -0,0 +1,350 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+func normalizeKey(key string) string {
+	key = strings.TrimSpace(key)
+	for strings.Contains(key, "//") {
+		key = strings.Replace(key, "//", "/", -1)
+	}
+	return strings.TrimPrefix(key, "/")
+}
+
+func keyFileName(key string) string {
+	parts := strings.Split(normalizeKey(key), "/")
+	return parts[len(parts)-1]
+}
+
+// fileName is the destination file name of key after case_transform, with
+// the extension from extension_map or default_extension added to names
+// that have none, the rename rules applied and, with output_compression,
+// output_compression_suffix added. With preserve_structure it is the slash
+// separated path of key below from, and only its last segment is
+// transformed. With single it is the file name of to, as it is, and with
+// to_template the rendered path below the fixed directory of the template.
+func (p *Processor) fileName(key string) string {
+	if p.single != "" {
+		return p.single
+	}
+
+	if p.paths != nil {
+		name := p.paths.name(key)
+		if name != "" && config.StringVal(p.config.OutputCompression) == config.CompressionGzip {
+			name += config.StringVal(p.config.OutputCompressionSuffix)
+		}
+		return name
+	}
+
+	name := keyFileName(key)
+	if name == "" {
+		return ""
+	}
+	ext := p.extension(name)
+
+	switch config.StringVal(p.config.CaseTransform) {
+	case config.CaseTransformLower:
+		name = strings.ToLower(name)
+	case config.CaseTransformUpper:
+		name = strings.ToUpper(name)
+	}
+
+	if name = p.rename(name + ext); name == "" {
+		return ""
+	}
+
+	index := false
+	if p.folders[normalizeKey(key)] {
+		switch config.StringVal(p.config.FolderWithValue) {
+		case config.FolderWithValueIndex:
+			name, index = folderIndexName, true
+		case config.FolderWithValueSuffix:
+			name += folderValueSuffix
+		}
+	}
+
+	if config.StringVal(p.config.OutputCompression) == config.CompressionGzip {
+		name += config.StringVal(p.config.OutputCompressionSuffix)
+	}
+
+	if config.BoolVal(p.config.PreserveStructure) {
+		dir := path.Dir(p.folderPath(key))
+		if index {
+			dir = p.folderPath(key)
+		}
+		if dir != "." {
+			name = path.Join(dir, name)
+		}
+	}
+
+	return name
+}
+
+const (
+	// folderIndexName is the file in its own directory that the value of
+	// a key with keys below it is written to with folder_with_value index.
+	folderIndexName = "index"
+
+	// folderValueSuffix is added to the file name of a key with keys below
+	// it with folder_with_value suffix.
+	folderValueSuffix = ".value"
+)
+
+// folderValues finds the keys with a value that are also the folder of
+// other keys, which preserve_structure would have to write as both a file
+// and a directory, and applies folder_with_value to them. With skip they
+// are dropped, with index and suffix fileName moves them aside. With error
+// they are left to checkFolderCollisions.
+func (p *Processor) folderValues(keys api.KVPairs) api.KVPairs {
+	p.folders = nil
+	policy := config.StringVal(p.config.FolderWithValue)
+	if !config.BoolVal(p.config.PreserveStructure) || p.paths != nil || policy == config.FolderWithValueError {
+		return keys
+	}
+
+	parents := make(map[string]bool)
+	for _, pair := range keys {
+		key := normalizeKey(pair.Key)
+		if keyFileName(key) == "" {
+			continue
+		}
+		for i := strings.LastIndex(key, "/"); i > 0; i = strings.LastIndex(key[:i], "/") {
+			parents[key[:i]] = true
+		}
+	}
+
+	folders := make(map[string]bool)
+	kept := make(api.KVPairs, 0, len(keys))
+	for _, pair := range keys {
+		key := normalizeKey(pair.Key)
+		if keyFileName(key) == "" || !parents[key] {
+			kept = append(kept, pair)
+			continue
+		}
+		if policy == config.FolderWithValueSkip {
+			log.Printf("[WARN] (processor) %s has keys below it, skipping its value", pair.Key)
+			continue
+		}
+		folders[key] = true
+		kept = append(kept, pair)
+	}
+
+	if len(folders) > 0 {
+		p.folders = folders
+	}
+	return kept
+}
+
+// extension returns the extension to add to name. Patterns of
+// extension_map are tried in sorted order and the first match wins.
+func (p *Processor) extension(name string) string {
+	if filepath.Ext(name) != "" {
+		return ""
+	}
+
+	patterns := make([]string, 0, len(p.config.ExtensionMap))
+	for pattern := range p.config.ExtensionMap {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return dotted(p.config.ExtensionMap[pattern])
+		}
+	}
+
+	return dotted(config.StringVal(p.config.DefaultExtension))
+}
+
+func dotted(ext string) string {
+	if ext == "" || strings.HasPrefix(ext, ".") {
+		return ext
+	}
+	return "." + ext
+}
+
+// checkNameCollisions refuses distinct key names that case_transform, an
+// added extension or folder_with_value would write to the same file, and
+// with preserve_structure keys whose ".." segments would write outside of to.
+func (p *Processor) checkNameCollisions(keys api.KVPairs) error {
+	if config.BoolVal(p.config.PreserveStructure) {
+		for _, pair := range keys {
+			if name := p.fileName(pair.Key); name != "" && escapesTo(name) {
+				return NewErrUnsafePath(pair.Key, name, config.StringVal(p.config.To))
+			}
+		}
+	}
+
+	switch config.StringVal(p.config.CaseTransform) {
+	case config.CaseTransformLower, config.CaseTransformUpper:
+	default:
+		if len(p.config.ExtensionMap) == 0 && config.StringVal(p.config.DefaultExtension) == "" && p.folders == nil {
+			return nil
+		}
+	}
+
+	seen := make(map[string]string, len(keys))
+	for _, pair := range keys {
+		name := p.fileName(pair.Key)
+		if name == "" {
+			continue
+		}
+		if prev, ok := seen[name]; ok && keyFileName(prev) != keyFileName(pair.Key) {
+			return fmt.Errorf("processor: keys %q and %q both map to file %q", prev, pair.Key, name)
+		}
+		seen[name] = pair.Key
+	}
+
+	return nil
+}
+
+// checkDestinations refuses keys whose file, or with create_dirs_for_folders
+// whose directory, would resolve outside of to, or of the flags_route
+// directory of the key, whether through ".." segments or through a
+// symlinked directory below it. The file itself may be a symlink, as writes
+// replace it rather than follow it.
+func (p *Processor) checkDestinations(keys api.KVPairs) error {
+	if config.BoolVal(p.config.EnvFile) || config.BoolVal(p.config.Stdout) {
+		return nil
+	}
+
+	dirs := make(map[string]string)
+	resolve := func(dir string) (string, error) {
+		if resolved, ok := dirs[dir]; ok {
+			return resolved, nil
+		}
+		resolved, err := resolvePath(dir)
+		if err != nil {
+			return "", err
+		}
+		dirs[dir] = resolved
+		return resolved, nil
+	}
+
+	for _, pair := range keys {
+		root := *p.config.To
+		name := p.fileName(pair.Key)
+		if name != "" {
+			root = p.destination(pair)
+		}
+		to, err := resolve(root)
+		if err != nil {
+			return err
+		}
+
+		dir := filepath.Join(root, filepath.FromSlash(path.Dir(name)))
+		if name == "" {
+			if !config.BoolVal(p.config.CreateDirsForFolders) || p.folderPath(pair.Key) == "" {
+				continue
+			}
+			dir = filepath.Join(root, filepath.FromSlash(p.folderPath(pair.Key)))
+		}
+
+		resolved, err := resolve(dir)
+		if err != nil {
+			return err
+		}
+
+		file := resolved
+		if name != "" {
+			file = filepath.Join(resolved, path.Base(name))
+		}
+		if rel, err := filepath.Rel(to, file); err != nil || rel == "." || rel == ".." ||
+			strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return NewErrUnsafePath(pair.Key, file, to)
+		}
+	}
+
+	return nil
+}
+
+// resolvePath returns the absolute form of file with the symlinks of its
+// longest resolvable leading part resolved.
+func resolvePath(file string) (string, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+
+	var rest []string
+	for dir := file; ; dir = filepath.Dir(dir) {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			for i := len(rest) - 1; i >= 0; i-- {
+				resolved = filepath.Join(resolved, rest[i])
+			}
+			return resolved, nil
+		}
+		if filepath.Dir(dir) == dir {
+			return "", err
+		}
+		rest = append(rest, filepath.Base(dir))
+	}
+}
+
+// checkFolderCollisions refuses files that would take the place of a
+// directory, either one create_dirs_for_folders creates or, with
+// preserve_structure, the parent directory of another key's file.
+func (p *Processor) checkFolderCollisions(keys api.KVPairs) error {
+	folders := config.BoolVal(p.config.CreateDirsForFolders)
+	nested := p.nested()
+	if !folders && !nested {
+		return nil
+	}
+
+	dirs := make(map[string]string)
+	for _, pair := range keys {
+		var dir string
+		switch name := p.fileName(pair.Key); {
+		case name == "" && folders:
+			dir = p.folderPath(pair.Key)
+		case name != "" && nested:
+			dir = path.Dir(name)
+		}
+		for ; dir != "" && dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = pair.Key
+		}
+	}
+
+	for _, pair := range keys {
+		name := p.fileName(pair.Key)
+		if other, ok := dirs[name]; ok && name != "" {
+			return fmt.Errorf("processor: key %q maps to file %q, which is a directory for key %q", pair.Key, name, other)
+		}
+	}
+
+	return nil
+}
+
+// nested reports whether file names may have directories, which are then
+// created as needed.
+func (p *Processor) nested() bool {
+	return config.BoolVal(p.config.PreserveStructure) || p.paths != nil
+}
+
+// escapesTo reports whether the slash separated name would resolve outside
+// the to directory.
+func escapesTo(name string) bool {
+	name = path.Clean(name)
+	return name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name)
+}
+
+func (p *Processor) keyFiles(keys api.KVPairs) map[string][]byte {
+	files := make(map[string][]byte, len(keys))
+	for _, pair := range keys {
+		if filename := p.fileName(pair.Key); filename != "" {
+			files[filename] = pair.Value
+		}
+	}
+	return files
+}
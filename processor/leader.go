@@ -0,0 +1,107 @@
+package processor
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const DefaultLeaderTTL = 15 * time.Second
+
+// leaderKV and leaderSession are the parts of the Consul API leader
+// election needs.
+type leaderKV interface {
+	Acquire(*api.KVPair, *api.WriteOptions) (bool, *api.WriteMeta, error)
+}
+
+type leaderSession interface {
+	Create(*api.SessionEntry, *api.WriteOptions) (string, *api.WriteMeta, error)
+	RenewPeriodic(string, string, *api.WriteOptions, <-chan struct{}) error
+}
+
+type leader struct {
+	sync.Mutex
+
+	kv      leaderKV
+	session leaderSession
+	key     string
+	ttl     string
+
+	id     string
+	doneCh chan struct{}
+}
+
+func newLeader(client *api.Client, key string) *leader {
+	return &leader{
+		kv:      client.KV(),
+		session: client.Session(),
+		key:     key,
+		ttl:     DefaultLeaderTTL.String(),
+	}
+}
+
+func (l *leader) acquire() (bool, error) {
+	id, err := l.sessionID()
+	if err != nil {
+		return false, err
+	}
+
+	ok, _, err := l.kv.Acquire(&api.KVPair{Key: l.key, Session: id}, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
+func (l *leader) sessionID() (string, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.id != "" {
+		return l.id, nil
+	}
+
+	id, _, err := l.session.Create(&api.SessionEntry{
+		Name:     "consul-generator",
+		TTL:      l.ttl,
+		Behavior: api.SessionBehaviorRelease,
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("[DEBUG] (processor) created leader session %s", id)
+
+	doneCh := make(chan struct{})
+	l.id = id
+	l.doneCh = doneCh
+
+	go func() {
+		if err := l.session.RenewPeriodic(l.ttl, id, nil, doneCh); err != nil {
+			log.Printf("[WARN] (processor) leader session %s lost: %s", id, err)
+		}
+
+		l.Lock()
+		if l.id == id {
+			l.id = ""
+			l.doneCh = nil
+		}
+		l.Unlock()
+	}()
+
+	return id, nil
+}
+
+func (l *leader) stop() {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.doneCh != nil {
+		close(l.doneCh)
+		l.doneCh = nil
+		l.id = ""
+	}
+}
@@ -0,0 +1,93 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+type lister interface {
+	List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error)
+}
+
+var _ lister = (*api.KV)(nil)
+
+// retryLister retries a failed List as consul.retry allows before the pass
+// gives up. An attempt that takes longer than timeout, on top of the wait of
+// a blocking query, fails as well. Cancelling ctx aborts the attempt in
+// progress and stops waiting for the next one.
+type retryLister struct {
+	lister
+	retry   config.RetryFunc
+	timeout time.Duration
+	ctx     context.Context
+}
+
+func (l *retryLister) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	for retry := 0; ; retry++ {
+		pairs, meta, err := l.list(prefix, q)
+		if err == nil {
+			return pairs, meta, nil
+		}
+		if (q != nil && q.Context().Err() != nil) || (l.ctx != nil && l.ctx.Err() != nil) {
+			return nil, nil, err
+		}
+
+		ok, sleep := l.retry(retry)
+		if !ok {
+			return nil, nil, err
+		}
+		log.Printf("[WARN] (processor) listing %s failed, retrying in %s: %s", prefix, sleep, err)
+
+		if l.ctx == nil {
+			time.Sleep(sleep)
+			continue
+		}
+		select {
+		case <-time.After(sleep):
+		case <-l.ctx.Done():
+			return nil, nil, err
+		}
+	}
+}
+
+// list makes a single attempt, bounded by timeout.
+func (l *retryLister) list(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	if l.timeout <= 0 {
+		return l.lister.List(prefix, q)
+	}
+
+	parent := l.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	if q == nil {
+		q = &api.QueryOptions{}
+	}
+	// Consul adds up to a sixteenth of the wait time as jitter.
+	wait := q.WaitTime + q.WaitTime/16
+
+	ctx, cancel := context.WithTimeout(parent, l.timeout+wait)
+	defer cancel()
+
+	pairs, meta, err := l.lister.List(prefix, q.WithContext(ctx))
+	if err != nil && parent.Err() == nil && ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("processor: listing %s timed out after %s", prefix, l.timeout+wait)
+	}
+	return pairs, meta, err
+}
+
+// kvWriter is the part of the KV API used to write keys back to Consul.
+type kvWriter interface {
+	Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	Keys(prefix, separator string, q *api.QueryOptions) ([]string, *api.QueryMeta, error)
+	CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error)
+	Put(p *api.KVPair, q *api.WriteOptions) (*api.WriteMeta, error)
+	Delete(key string, q *api.WriteOptions) (*api.WriteMeta, error)
+}
+
+var _ kvWriter = (*api.KV)(nil)
@@ -0,0 +1,154 @@
+package processor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+const authLoginRetry = 10 * time.Second
+
+type authLoginRequest struct {
+	AuthMethod  string
+	BearerToken string
+	Meta        map[string]string `json:",omitempty"`
+}
+
+type authLoginResponse struct {
+	AccessorID     string
+	SecretID       string
+	ExpirationTime *time.Time
+}
+
+type authLogin struct {
+	sync.RWMutex
+
+	name      string
+	tokenFile string
+	meta      map[string]string
+	secret    string
+	expires   time.Time
+	stopCh    chan struct{}
+}
+
+func newAuthLogin(c *config.Config) (*authLogin, error) {
+	if c.Consul == nil || c.Consul.AuthMethod == nil || !config.BoolVal(c.Consul.AuthMethod.Enabled) {
+		return nil, nil
+	}
+	m := c.Consul.AuthMethod
+
+	name := config.StringVal(m.Name)
+	if name == "" {
+		return nil, fmt.Errorf("processor: consul auth_method requires a name")
+	}
+
+	tokenFile := config.StringVal(m.BearerTokenFile)
+	if tokenFile == "" {
+		return nil, fmt.Errorf("processor: consul auth_method %q requires a bearer_token_file", name)
+	}
+
+	return &authLogin{
+		name:      name,
+		tokenFile: tokenFile,
+		meta:      m.Meta,
+		stopCh:    make(chan struct{}),
+	}, nil
+}
+
+func (l *authLogin) tokenFunc() func() string {
+	if l == nil {
+		return nil
+	}
+	return l.token
+}
+
+func (l *authLogin) token() string {
+	l.RLock()
+	defer l.RUnlock()
+	return l.secret
+}
+
+func (l *authLogin) login(client *api.Client) error {
+	jwt, err := ioutil.ReadFile(l.tokenFile)
+	if err != nil {
+		return fmt.Errorf("processor: consul auth method %q login failed: %s", l.name, err)
+	}
+
+	var resp authLoginResponse
+	if _, err := client.Raw().Write("/v1/acl/login", &authLoginRequest{
+		AuthMethod:  l.name,
+		BearerToken: strings.TrimSpace(string(jwt)),
+		Meta:        l.meta,
+	}, &resp, nil); err != nil {
+		return fmt.Errorf("processor: consul auth method %q login failed: %s", l.name, err)
+	}
+	if resp.SecretID == "" {
+		return fmt.Errorf("processor: consul auth method %q login failed: no token returned", l.name)
+	}
+
+	l.Lock()
+	l.secret = resp.SecretID
+	l.expires = time.Time{}
+	if resp.ExpirationTime != nil {
+		l.expires = *resp.ExpirationTime
+	}
+	l.Unlock()
+
+	log.Printf("[INFO] (processor) logged in to consul with auth method %q (accessor %s)", l.name, resp.AccessorID)
+
+	return nil
+}
+
+// renew logs in again once two thirds of the token lifetime have passed.
+// Tokens without an expiration are kept until the processor stops.
+func (l *authLogin) renew(client *api.Client) {
+	for {
+		l.RLock()
+		expires := l.expires
+		l.RUnlock()
+
+		if expires.IsZero() {
+			<-l.stopCh
+			return
+		}
+
+		wait := time.Until(expires) * 2 / 3
+		if wait < time.Second {
+			wait = time.Second
+		}
+
+		for {
+			select {
+			case <-l.stopCh:
+				return
+			case <-time.After(wait):
+			}
+
+			err := l.login(client)
+			if err == nil {
+				break
+			}
+			log.Printf("[ERR] %s, retrying in %s", err, authLoginRetry)
+			wait = authLoginRetry
+		}
+	}
+}
+
+func (l *authLogin) stop(client *api.Client) {
+	close(l.stopCh)
+
+	token := l.token()
+	if token == "" {
+		return
+	}
+
+	if _, err := client.Raw().Write("/v1/acl/logout", nil, nil, &api.WriteOptions{Token: token}); err != nil {
+		log.Printf("[WARN] (processor) could not log out of consul: %s", err)
+	}
+}
@@ -0,0 +1,97 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// validateLowMemory checks low_memory. A pass only lists the key names and
+// gets each value right before its file is written, so the options that
+// need every value, or the index and flags of every key, up front cannot be
+// used with it.
+func (p *Processor) validateLowMemory() error {
+	if p.vault || hasSyncs(&p.config) || config.BoolVal(p.config.Single) || config.BoolVal(p.config.Push) ||
+		config.StringVal(p.config.Archive) != "" || config.BoolVal(p.config.SwapDir) || config.BoolVal(p.config.EnvFile) ||
+		config.BoolVal(p.config.DedupeIdentical) || config.BoolVal(p.config.Stdout) {
+		return fmt.Errorf("processor: low_memory cannot be combined with sync, single, push, archive, swap_dir, " +
+			"env_file, dedupe_identical, stdout or a vault:// from")
+	}
+
+	if config.BoolVal(p.config.Template) || config.BoolVal(p.config.ResolveReferences) ||
+		config.IntVal(p.config.MaxTotalBytes) > 0 || config.StringVal(p.config.Manifest) != "" ||
+		config.StringVal(p.config.VersionFile) != "" {
+		return fmt.Errorf("processor: low_memory cannot be combined with template, resolve_references, " +
+			"max_total_bytes, manifest or version_file")
+	}
+
+	if config.BoolVal(p.config.Watermark) || config.BoolVal(p.config.PreserveMtime) ||
+		config.BoolVal(p.config.Wait.Enabled) || len(p.config.FlagsRoute) > 0 {
+		return fmt.Errorf("processor: low_memory cannot be combined with watermark, preserve_mtime, wait or flags_route")
+	}
+
+	return nil
+}
+
+// namesLister lists the keys below a prefix with Keys. The pairs it returns
+// only carry the key, their values are read by loadValue.
+type namesLister struct {
+	kv kvWriter
+}
+
+func (l namesLister) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	names, meta, err := l.kv.Keys(prefix, "", q)
+	if err != nil {
+		return nil, meta, err
+	}
+
+	pairs := make(api.KVPairs, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, &api.KVPair{Key: name})
+	}
+	return pairs, meta, nil
+}
+
+// streamKey gets the value of pair and writes it to file like writeKey. The
+// value is dropped once the file is written, so a pass holds no more values
+// than there are workers.
+func (p *Processor) streamKey(pass *writePass, pair *api.KVPair, filename, file string) {
+	loaded, err := p.loadValue(pair.Key)
+	if err != nil {
+		pass.Lock()
+		defer pass.Unlock()
+		if pass.err == nil {
+			pass.err = err
+		}
+		return
+	}
+	if loaded == nil {
+		return
+	}
+
+	p.writeKey(pass, loaded, filename, file)
+}
+
+// loadValue gets key and runs it through loadValues on its own. It returns
+// nil when the key was deleted since it was listed or the filter drops it.
+func (p *Processor) loadValue(key string) (*api.KVPair, error) {
+	pair, _, err := p.kv.Get(key, p.readOptions())
+	if err != nil && permissionDenied(err) {
+		return nil, NewErrAuth(fmt.Errorf("processor: consul denied reading %s: %s", key, err))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("processor: reading %s: %s", key, err)
+	}
+	if pair == nil {
+		log.Printf("[DEBUG] (processor) %s was deleted since it was listed", key)
+		return nil, nil
+	}
+
+	keys, err := p.loadValues(api.KVPairs{pair})
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	return keys[0], nil
+}
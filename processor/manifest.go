@@ -0,0 +1,57 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// writeManifest stores a JSON map of the file name of every key synced in
+// this pass to the sha256 of its content at manifest. The file is only
+// rewritten when it changes.
+func (p *Processor) writeManifest(keys api.KVPairs) error {
+	path := config.StringVal(p.config.Manifest)
+	if path == "" {
+		return nil
+	}
+
+	// A deferred pass has not written every file yet.
+	if p.cursor != "" {
+		return nil
+	}
+
+	files := p.keyFiles(keys)
+	hashes := make(map[string]string, len(files))
+	for name, content := range files {
+		hashes[name] = p.getHash(content)
+	}
+
+	manifest, err := json.MarshalIndent(hashes, "", "  ")
+	if err != nil {
+		return err
+	}
+	manifest = append(manifest, '\n')
+
+	current, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if bytes.Equal(current, manifest) {
+		log.Printf("[DEBUG] (processor) Manifest unchanged: %s", path)
+		return nil
+	}
+
+	if !p.dry {
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	return p.save(path, string(manifest))
+}
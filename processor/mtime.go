@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// mtimes tracks when each key was changed for preserve_mtime. Consul keeps
+// no modification time, only the ModifyIndex, so a key counts as changed
+// when a pass first lists it at a new ModifyIndex.
+type mtimes struct {
+	now  func() time.Time
+	keys map[string]keyChange
+}
+
+type keyChange struct {
+	index uint64
+	at    time.Time
+}
+
+func newMtimes(c *config.Config) *mtimes {
+	if !config.BoolVal(c.PreserveMtime) {
+		return nil
+	}
+
+	return &mtimes{now: time.Now, keys: make(map[string]keyChange)}
+}
+
+func (p *Processor) validatePreserveMtime() error {
+	if config.BoolVal(p.config.Push) || config.StringVal(p.config.Archive) != "" || config.BoolVal(p.config.SwapDir) ||
+		config.BoolVal(p.config.EnvFile) || config.BoolVal(p.config.DedupeIdentical) {
+		return fmt.Errorf("processor: preserve_mtime cannot be combined with push, archive, swap_dir, env_file or dedupe_identical")
+	}
+
+	return nil
+}
+
+// observe records the keys of a pass before they are written, and forgets
+// the keys no longer listed.
+func (m *mtimes) observe(keys api.KVPairs) {
+	if m == nil {
+		return
+	}
+
+	now := m.now()
+	listed := make(map[string]keyChange, len(keys))
+	for _, pair := range keys {
+		c, ok := m.keys[pair.Key]
+		if !ok || c.index != pair.ModifyIndex {
+			c = keyChange{index: pair.ModifyIndex, at: now}
+		}
+		listed[pair.Key] = c
+	}
+	m.keys = listed
+}
+
+// apply sets the mtime of file to the time key was changed.
+func (m *mtimes) apply(key, file string) {
+	if m == nil {
+		return
+	}
+
+	c, ok := m.keys[key]
+	if !ok {
+		return
+	}
+
+	if err := os.Chtimes(file, c.at, c.at); err != nil {
+		log.Printf("[WARN] (processor) could not set the mtime of %s: %s", file, err)
+	}
+}
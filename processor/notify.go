@@ -0,0 +1,124 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Assada/consul-generator/config"
+)
+
+const (
+	changeWrite = "write"
+	changePrune = "prune"
+)
+
+// changeEvent is a file written or pruned by a pass, as posted to
+// notify.url.
+type changeEvent struct {
+	Path   string    `json:"path"`
+	Key    string    `json:"key,omitempty"`
+	Action string    `json:"action"`
+	SHA256 string    `json:"sha256,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// notifier posts the changes of a pass to notify.url.
+type notifier struct {
+	url    string
+	client *http.Client
+}
+
+// newNotifier returns nil when notify.url is not set.
+func newNotifier(c *config.Config) *notifier {
+	if c.Notify == nil || config.StringVal(c.Notify.URL) == "" {
+		return nil
+	}
+
+	return &notifier{
+		url:    *c.Notify.URL,
+		client: &http.Client{Timeout: config.TimeDurationVal(c.Notify.Timeout)},
+	}
+}
+
+func validateNotify(c *config.NotifyConfig) error {
+	if c == nil || config.StringVal(c.URL) == "" {
+		return nil
+	}
+
+	u, err := url.Parse(*c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("processor: notify url must be an http or https URL, got %q", *c.URL)
+	}
+
+	if d := config.TimeDurationVal(c.Timeout); d <= 0 {
+		return fmt.Errorf("processor: notify timeout must be positive, got %s", d)
+	}
+
+	return nil
+}
+
+// recordChange adds a change to the events of the pass in progress. Dry
+// passes change nothing, so they record nothing.
+func (p *Processor) recordChange(path, key, action string, content []byte) {
+	if p.dry {
+		return
+	}
+
+	e := changeEvent{Path: path, Key: key, Action: action, Time: time.Now().UTC()}
+	if action == changeWrite {
+		e.SHA256 = p.getHash(content)
+	}
+
+	p.mu.Lock()
+	p.events = append(p.events, e)
+	p.mu.Unlock()
+}
+
+// notify posts the changes of the pass, including those of the sync
+// stanzas, as one array. Delivery is best effort: a failure is logged and
+// the pass goes on.
+func (p *Processor) notify() {
+	if p.notifier == nil {
+		return
+	}
+
+	events := p.events
+	for _, s := range p.syncs {
+		events = append(events, s.events...)
+		s.events = nil
+	}
+	p.events = nil
+	if len(events) == 0 {
+		return
+	}
+
+	if err := p.notifier.post(events); err != nil {
+		log.Printf("[WARN] (processor) could not notify %s of %d changes: %s", p.notifier.url, len(events), err)
+		return
+	}
+	log.Printf("[DEBUG] (processor) notified %s of %d changes", p.notifier.url, len(events))
+}
+
+func (n *notifier) post(events []changeEvent) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
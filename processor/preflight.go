@@ -0,0 +1,50 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"path"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+const preflightProbeKey = ".consul-generator-preflight"
+
+type preflightKV interface {
+	Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error)
+}
+
+// preflight checks the token can read From, and write below it in push mode,
+// before the first pass. The write probe is a check-and-set against an index
+// that can never match, so Consul checks the ACL but never stores anything.
+func (p *Processor) preflight(kv preflightKV) error {
+	prefix := normalizeKey(config.StringVal(p.config.From))
+
+	if _, _, err := kv.Get(prefix, nil); err != nil {
+		return preflightError("read", prefix, err)
+	}
+
+	if config.BoolVal(p.config.Push) {
+		probe := &api.KVPair{
+			Key:         normalizeKey(path.Join(prefix, preflightProbeKey)),
+			ModifyIndex: math.MaxUint64,
+		}
+		if _, _, err := kv.CAS(probe, nil); err != nil {
+			return preflightError("write", prefix, err)
+		}
+	}
+
+	log.Printf("[INFO] (processor) preflight passed for %q", prefix)
+
+	return nil
+}
+
+func preflightError(capability, prefix string, err error) error {
+	if permissionDenied(err) {
+		return NewErrPreflight(capability, prefix, err)
+	}
+	return fmt.Errorf("processor: preflight %s check on %q failed: %s", capability, prefix, err)
+}
@@ -1,7 +1,10 @@
 package processor
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
 	"fmt"
 	"io"
@@ -9,7 +12,10 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Assada/consul-generator/client"
 	"github.com/Assada/consul-generator/config"
@@ -19,38 +25,284 @@ import (
 const (
 	ExitCodeOK    int = 0
 	ExitCodeError     = 10 + iota
+	ExitCodeEmpty
+	ExitCodeStandby
+)
+
+const (
+	cacheByIndexWait = time.Second
+	watchWait        = 5 * time.Minute
+
+	// saneViewLimit is the number of keys above which polling more often
+	// than once a second is warned about.
+	saneViewLimit = 500
 )
 
 type Processor struct {
-	config config.Config
-	kv     api.KV
-	error  chan error
-	done   chan bool
-	once   bool
-	dry    bool
+	config  config.Config
+	client  *api.Client
+	login   *authLogin
+	leaf    *connectLeaf
+	kv      kvWriter
+	lister  lister
+	leader  *leader
+	filter  *filter
+	renames []renameRule
+	paths   *pathTemplate
+	folders map[string]bool
+	mark    *watermark
+	flap    *flapDetector
+	quiet   *quiescence
+	mtimes  *mtimes
+	cursor  string
+	explain io.Writer
+	out     io.Writer
+	plan    []plannedWrite
+
+	// vault is set when from is a vault:// path read through lister.
+	vault bool
+
+	// single is the name of the file in to the key from is written to,
+	// when single is set.
+	single string
+
+	// syncs do the passes of the sync stanzas, when there are any.
+	syncs []*Processor
+	// due is when the sync stanza of p runs next, when sync stanzas keep
+	// their own intervals.
+	due time.Time
+
+	// notifier posts the events of a pass, when notify.url is set.
+	notifier *notifier
+
+	// ctx is cancelled by Cancel to abort a watch query in progress.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// index is the List index of the last completed pass, used by
+	// cache_by_index. listIndex is the index of the pass in progress.
+	index, listIndex uint64
+	error            chan error
+	done             chan bool
+	once             bool
+	dry              bool
+
+	// listed is set once a pass listed from, after which require_keys no
+	// longer fails on an empty listing.
+	listed bool
+
+	// pollWarned is set once the pass warned about a short interval.
+	pollWarned bool
+
+	// settle is how long the last pass held back changed keys for wait.
+	settle time.Duration
+
+	// mu guards skipped, changed, stats, plan, flap, result, events and the
+	// explain output while the workers of a pass run.
+	mu      sync.Mutex
+	skipped int
+	result  []KeyResult
+	// changed is set once the pass in progress wrote or removed a file.
+	changed bool
+	// drift counts the files the dry pass in progress would change.
+	drift int
+	// written and bytes count the files and bytes the pass in progress
+	// wrote, for the summary logged at its end.
+	written int
+	bytes   int
+	start   time.Time
+	// wrote holds the names of the key files the pass in progress wrote,
+	// which prune may take over into its state file.
+	wrote map[string]bool
+	// events are the files written or pruned by the pass in progress.
+	events []changeEvent
+	stats  Stats
 }
 
-func (p *Processor) save(filepath string, s string) error {
+// save writes s to path. keys are the keys s comes from, so a dry run
+// redacts what it logs of them.
+func (p *Processor) save(path string, s string, keys ...string) error {
+	if skip, err := p.dirInTheWay(path); err != nil || skip {
+		return err
+	}
+
 	if p.dry {
-		log.Printf("File %s will be created with content: \n %s", filepath, s)
+		current, err := ioutil.ReadFile(path)
+		p.mu.Lock()
+		if p.plan != nil {
+			p.plan = append(p.plan, plannedWrite{Path: path, Content: s})
+		}
+		if err != nil || string(current) != s {
+			p.drift++
+		}
+		p.mu.Unlock()
+		log.Print(p.dryDiff(path, []byte(s), keys...))
 		return nil
 	}
-	fo, err := os.Create(filepath)
+	if p.nested() {
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return err
+		}
+	}
+	fo, err := p.create(path)
 	if err != nil {
 		return err
 	}
-	defer fo.Close()
+	tmp := fo.Name()
 
+	// The content is written to a temporary file next to path and renamed
+	// over it, so readers never see a partially written file.
 	_, err = io.Copy(fo, strings.NewReader(s))
+	if err == nil {
+		err = fo.Sync()
+	}
+	if cerr := fo.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
 	if err != nil {
+		os.Remove(tmp)
 		return err
 	}
 
-	log.Printf("[INFO] (processor) Saved: %s", filepath)
+	log.Printf("[INFO] (processor) Saved: %s", path)
+	p.mu.Lock()
+	p.changed = true
+	p.written++
+	p.bytes += len(s)
+	p.mu.Unlock()
 
 	return nil
 }
 
+// countWritten counts a file written for a key. The version file, manifest
+// and other files save writes are not counted.
+func (p *Processor) countWritten() {
+	p.mu.Lock()
+	p.stats.Written++
+	p.mu.Unlock()
+	writtenVar.Add(1)
+}
+
+// create opens a new temporary file next to path with the mode of path
+// already applied, so a secret is never readable with broader permissions,
+// not even while it is being written. The mode is the configured one, else
+// that of the existing file, else the umask applies as with os.Create.
+func (p *Processor) create(path string) (*os.File, error) {
+	mode, ok := p.fileMode(filepath.Base(path))
+	if !ok {
+		if stat, err := os.Stat(path); err == nil {
+			mode, ok = stat.Mode().Perm(), true
+		}
+	}
+
+	perm := os.FileMode(0666)
+	if ok {
+		perm = mode
+	}
+
+	var fo *os.File
+	for attempt := 0; ; attempt++ {
+		suffix := make([]byte, 6)
+		if _, err := rand.Read(suffix); err != nil {
+			return nil, err
+		}
+		name := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"-"+hex.EncodeToString(suffix))
+
+		var err error
+		fo, err = os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) || attempt >= 10 {
+			return nil, err
+		}
+	}
+
+	// OpenFile applies the umask, which must not narrow a configured or
+	// existing mode.
+	if ok {
+		if err := fo.Chmod(mode); err != nil {
+			fo.Close()
+			os.Remove(fo.Name())
+			return nil, err
+		}
+	}
+
+	return fo, nil
+}
+
+// dirInTheWay applies on_dir_conflict when path is a directory, for example
+// one left by a run that kept the key hierarchy. It reports whether the file
+// should be skipped.
+func (p *Processor) dirInTheWay(path string) (bool, error) {
+	stat, err := os.Lstat(path)
+	if err != nil || !stat.IsDir() {
+		return false, nil
+	}
+
+	switch config.StringVal(p.config.OnDirConflict) {
+	case config.DirConflictSkip:
+		log.Printf("[WARN] (processor) %s is a directory, skipping", path)
+		return true, nil
+	case config.DirConflictReplace:
+		if p.dry {
+			log.Printf("Directory %s will be removed", path)
+			return false, nil
+		}
+		remove := os.Remove
+		if config.BoolVal(p.config.ForceDirReplace) {
+			remove = os.RemoveAll
+		}
+		if err := remove(path); err != nil {
+			return false, fmt.Errorf("processor: could not replace directory %s, "+
+				"force_dir_replace removes non-empty ones: %s", path, err)
+		}
+		log.Printf("[WARN] (processor) removed directory %s to write a file in its place", path)
+		return false, nil
+	}
+
+	return false, fmt.Errorf("processor: %s is a directory, set on_dir_conflict to replace or skip it", path)
+}
+
+// folder handles a folder marker key (one ending in "/"). Unless
+// create_dirs_for_folders is set they are skipped, otherwise the matching
+// directory below the destination is created, even if it stays empty.
+func (p *Processor) folder(key string) error {
+	rel := p.folderPath(key)
+	if !config.BoolVal(p.config.CreateDirsForFolders) || rel == "" {
+		log.Printf("[DEBUG] (processor) Skipping folder: %s", key)
+		return nil
+	}
+
+	dir := filepath.Join(*p.config.To, filepath.FromSlash(rel))
+	if p.dry {
+		log.Printf("Directory %s will be created", dir)
+		return nil
+	}
+	return os.MkdirAll(dir, os.ModePerm)
+}
+
+// folderPath is the slash separated path of a folder marker below from.
+func (p *Processor) folderPath(key string) string {
+	rel := strings.TrimPrefix(normalizeKey(key), normalizeKey(config.StringVal(p.config.From)))
+	return strings.Trim(rel, "/")
+}
+
+func (p *Processor) fileMode(name string) (os.FileMode, bool) {
+	if mode, ok := p.config.FileModes.Mode(name); ok {
+		return mode, true
+	}
+
+	if mode := config.FileModeVal(p.config.Perms); mode != 0 {
+		return mode, true
+	}
+
+	return 0, false
+}
+
 func (p *Processor) getHash(v []byte) string {
 	hasher := sha256.New()
 	hasher.Write(v)
@@ -59,8 +311,11 @@ func (p *Processor) getHash(v []byte) string {
 	return cksum
 }
 
+// readFile is replaced by tests counting file reads.
+var readFile = ioutil.ReadFile
+
 func (p *Processor) calculateFileHash(filepath string) (string, error) {
-	f, err := ioutil.ReadFile(filepath)
+	f, err := readFile(filepath)
 
 	if err != nil {
 		return "", err
@@ -72,34 +327,460 @@ func (p *Processor) calculateFileHash(filepath string) (string, error) {
 func NewProcessor(config *config.Config, once bool, dry bool, errorCh chan error, doneCh chan bool) (*Processor, error) {
 	log.Printf("[INFO] (processor) creating new processor")
 
-	cl, err := newClientSet(config)
+	login, err := newAuthLogin(config)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf := newConnectLeaf(config)
+
+	cl, err := newClientSet(config, login.tokenFunc(), leaf.certFunc())
 	if err != nil {
-		logError(err, ExitCodeError)
+		return nil, err
+	}
+
+	if login != nil {
+		if err := login.login(cl.Consul()); err != nil {
+			return nil, NewErrAuth(err)
+		}
+		go login.renew(cl.Consul())
+	}
+
+	if leaf != nil {
+		if err := leaf.fetch(cl.Consul()); err != nil {
+			return nil, err
+		}
+		go leaf.renew(cl.Consul())
 	}
 
+	kv := cl.Consul().KV()
+	ctx, cancel := context.WithCancel(context.Background())
+	var timeout time.Duration
+	if config.Consul.QueryTimeout != nil {
+		timeout = *config.Consul.QueryTimeout
+	}
 	processor := &Processor{
-		config: *config,
-		kv:     *cl.Consul().KV(),
+		client: cl.Consul(),
+		login:  login,
+		leaf:   leaf,
+		kv:     kv,
+		lister: &retryLister{lister: kv, retry: config.Consul.Retry.RetryFunc(), timeout: timeout, ctx: ctx},
 		error:  errorCh,
 		done:   doneCh,
 		once:   once,
 		dry:    dry,
+		ctx:    ctx,
+		cancel: cancel,
 	}
 
-	processor.init()
+	if _, ok := vaultPath(*config.From); ok {
+		processor.lister = &retryLister{lister: newVaultLister(cl.Vault(), config), retry: config.Consul.Retry.RetryFunc(), timeout: timeout, ctx: ctx}
+	}
+
+	if err := processor.configure(config); err != nil {
+		return nil, err
+	}
+
+	if config.LeaderKey != nil && *config.LeaderKey != "" {
+		processor.leader = newLeader(cl.Consul(), *config.LeaderKey)
+	}
+
+	for _, m := range processor.mappings() {
+		m.init()
+	}
 
 	return processor, nil
 }
 
+// configure sets up p for c, everything but the clients and the leader.
+func (p *Processor) configure(c *config.Config) error {
+	p.config = *c
+	p.mark = newWatermark(c)
+	p.flap = newFlapDetector(c)
+	p.quiet = newQuiescence(c, p.once, p.dry)
+	p.mtimes = newMtimes(c)
+
+	if path, ok := vaultPath(*c.From); ok {
+		p.config.From = &path
+		p.vault = true
+	}
+
+	if err := p.validate(); err != nil {
+		return err
+	}
+
+	if config.BoolVal(c.Single) {
+		p.splitSingle()
+	}
+
+	var err error
+	if p.renames, err = newRenames(c); err != nil {
+		return err
+	}
+	if p.paths, err = newPathTemplate(c); err != nil {
+		return err
+	}
+
+	if p.out == nil {
+		p.out = os.Stdout
+	}
+
+	if c.Explain != nil && *c.Explain {
+		p.explain = p.out
+	}
+
+	if c.Filter != nil && *c.Filter != "" {
+		if p.filter, err = newFilter(*c.Filter); err != nil {
+			return err
+		}
+	}
+
+	if hasSyncs(c) {
+		p.syncs = p.newSyncs()
+	}
+
+	p.notifier = newNotifier(c)
+
+	if c.Preflight != nil && *c.Preflight {
+		for _, m := range p.mappings() {
+			if err := m.preflight(p.kv); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *Processor) validate() error {
+	if p.config.Consul != nil {
+		switch mode := config.StringVal(p.config.Consul.Consistency); mode {
+		case config.ConsistencyDefault, config.ConsistencyStale, config.ConsistencyConsistent:
+		default:
+			return fmt.Errorf("processor: invalid consul.consistency %q", mode)
+		}
+	}
+
+	if d := config.TimeDurationVal(p.config.Interval); d <= 0 {
+		return fmt.Errorf("processor: interval must be positive, got %s", d)
+	}
+
+	switch policy := config.StringVal(p.config.OnMissingPrefix); policy {
+	case config.MissingPrefixWarn, config.MissingPrefixError, config.MissingPrefixIgnore:
+	default:
+		return fmt.Errorf("processor: invalid on_missing_prefix %q", policy)
+	}
+
+	switch policy := config.StringVal(p.config.PushConflict); policy {
+	case config.PushConflictSkip, config.PushConflictRetry:
+	default:
+		return fmt.Errorf("processor: invalid push_conflict %q", policy)
+	}
+
+	switch policy := config.StringVal(p.config.OnWriteError); policy {
+	case config.OnWriteErrorAbort, config.OnWriteErrorContinue:
+	default:
+		return fmt.Errorf("processor: invalid on_write_error %q", policy)
+	}
+
+	switch policy := config.StringVal(p.config.OnDirConflict); policy {
+	case config.DirConflictError, config.DirConflictReplace, config.DirConflictSkip:
+	default:
+		return fmt.Errorf("processor: invalid on_dir_conflict %q", policy)
+	}
+
+	switch policy := config.StringVal(p.config.FolderWithValue); policy {
+	case config.FolderWithValueError:
+	case config.FolderWithValueIndex, config.FolderWithValueSkip, config.FolderWithValueSuffix:
+		if !config.BoolVal(p.config.PreserveStructure) {
+			return fmt.Errorf("processor: folder_with_value %q requires preserve_structure", policy)
+		}
+	default:
+		return fmt.Errorf("processor: invalid folder_with_value %q", policy)
+	}
+
+	switch compression := config.StringVal(p.config.Compression); compression {
+	case config.CompressionNone, config.CompressionGzip, config.CompressionDeflate:
+	default:
+		return fmt.Errorf("processor: invalid compression %q", compression)
+	}
+
+	switch transform := config.StringVal(p.config.CaseTransform); transform {
+	case config.CaseTransformNone, config.CaseTransformLower, config.CaseTransformUpper:
+	default:
+		return fmt.Errorf("processor: invalid case_transform %q", transform)
+	}
+
+	// Push maps file names straight back to keys, so a transformed name
+	// would be pushed as a new key next to the original.
+	if config.BoolVal(p.config.Push) && config.StringVal(p.config.CaseTransform) != config.CaseTransformNone {
+		return fmt.Errorf("processor: case_transform cannot be combined with push")
+	}
+	// Push would store the decompressed files as they are.
+	if config.BoolVal(p.config.Push) && config.StringVal(p.config.Compression) != config.CompressionNone {
+		return fmt.Errorf("processor: compression cannot be combined with push")
+	}
+	if config.BoolVal(p.config.Push) && (config.StringVal(p.config.DefaultExtension) != "" || len(p.config.ExtensionMap) > 0) {
+		return fmt.Errorf("processor: default_extension and extension_map cannot be combined with push")
+	}
+
+	if err := p.config.Renames.Validate(); err != nil {
+		return fmt.Errorf("processor: %s", err)
+	}
+	if config.BoolVal(p.config.Push) && p.config.Renames != nil && len(*p.config.Renames) > 0 {
+		return fmt.Errorf("processor: rename cannot be combined with push")
+	}
+
+	for _, pattern := range p.config.Redact {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("processor: invalid redact pattern %q: %s", pattern, err)
+		}
+	}
+
+	for _, pattern := range p.config.PrunePatterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("processor: invalid prune_patterns pattern %q: %s", pattern, err)
+		}
+	}
+
+	for _, pattern := range append(append([]string{}, p.config.Include...), p.config.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("processor: invalid include/exclude pattern %q: %s", pattern, err)
+		}
+	}
+
+	for pattern := range p.config.ExtensionMap {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("processor: invalid extension_map pattern %q: %s", pattern, err)
+		}
+	}
+
+	if config.StringVal(p.config.Archive) != "" && (config.BoolVal(p.config.Push) || config.BoolVal(p.config.SwapDir)) {
+		return fmt.Errorf("processor: archive cannot be combined with push or swap_dir")
+	}
+
+	if err := p.config.FileModes.Validate(); err != nil {
+		return fmt.Errorf("processor: %s", err)
+	}
+
+	if err := p.config.ValueFilters.Validate(); err != nil {
+		return fmt.Errorf("processor: %s", err)
+	}
+	if config.BoolVal(p.config.Push) && p.config.ValueFilters != nil && len(*p.config.ValueFilters) > 0 {
+		return fmt.Errorf("processor: value_filter cannot be combined with push")
+	}
+
+	// The other modes write the whole tree at once and leave no stale files.
+	if config.BoolVal(p.config.Prune) && (config.BoolVal(p.config.Push) || config.StringVal(p.config.Archive) != "" ||
+		config.BoolVal(p.config.SwapDir) || config.BoolVal(p.config.EnvFile) || config.BoolVal(p.config.DedupeIdentical)) {
+		return fmt.Errorf("processor: prune cannot be combined with push, archive, swap_dir, env_file or dedupe_identical")
+	}
+
+	// Env files and the dedupe store are flat by design.
+	if config.BoolVal(p.config.PreserveStructure) && (config.BoolVal(p.config.EnvFile) || config.BoolVal(p.config.DedupeIdentical)) {
+		return fmt.Errorf("processor: preserve_structure cannot be combined with env_file or dedupe_identical")
+	}
+
+	// Deduplicated files share store entries named by hash, which the
+	// file_mode patterns cannot match.
+	if config.BoolVal(p.config.DedupeIdentical) && p.config.FileModes != nil && len(*p.config.FileModes) > 0 {
+		return fmt.Errorf("processor: file_mode cannot be combined with dedupe_identical, use perms instead")
+	}
+
+	if d := config.TimeDurationVal(p.config.WriteThrottle); d < 0 {
+		return fmt.Errorf("processor: write_throttle must not be negative, got %s", d)
+	}
+
+	if d := config.TimeDurationVal(p.config.CommandSplay); d < 0 {
+		return fmt.Errorf("processor: command_splay must not be negative, got %s", d)
+	}
+
+	if err := p.config.Commands.Validate(); err != nil {
+		return fmt.Errorf("processor: %s", err)
+	}
+	// These modes replace the whole tree or a single file, and do not
+	// record which files of to changed.
+	if p.triggered() && (config.StringVal(p.config.Archive) != "" || config.BoolVal(p.config.SwapDir) ||
+		config.BoolVal(p.config.EnvFile) || config.BoolVal(p.config.DedupeIdentical)) {
+		return fmt.Errorf("processor: command trigger cannot be combined with archive, swap_dir, env_file or dedupe_identical")
+	}
+
+	if config.BoolVal(p.config.DedupeIdentical) && (config.StringVal(p.config.Archive) != "" || config.BoolVal(p.config.SwapDir)) {
+		return fmt.Errorf("processor: dedupe_identical cannot be combined with archive or swap_dir")
+	}
+
+	// The dedupe pass rewrites the whole tree at once and has none of the
+	// per-file write controls.
+	if config.BoolVal(p.config.DedupeIdentical) && (config.BoolVal(p.config.Watermark) ||
+		config.IntVal(p.config.FlapThreshold) > 0 || config.IntVal(p.config.MaxFilesPerPass) > 0 ||
+		config.TimeDurationVal(p.config.WriteThrottle) > 0 ||
+		config.StringVal(p.config.OnWriteError) == config.OnWriteErrorContinue) {
+		return fmt.Errorf("processor: dedupe_identical cannot be combined with watermark, flap_threshold, " +
+			"max_files_per_pass, write_throttle or on_write_error = \"continue\"")
+	}
+
+	// Env files and the dedupe store are not one file per key, and push
+	// writes no files.
+	if config.StringVal(p.config.Manifest) != "" && (config.BoolVal(p.config.EnvFile) ||
+		config.BoolVal(p.config.DedupeIdentical) || config.BoolVal(p.config.Push)) {
+		return fmt.Errorf("processor: manifest cannot be combined with env_file, dedupe_identical or push")
+	}
+
+	if config.BoolVal(p.config.EnvFile) && (config.BoolVal(p.config.Push) || config.StringVal(p.config.Archive) != "" ||
+		config.BoolVal(p.config.SwapDir) || config.BoolVal(p.config.DedupeIdentical)) {
+		return fmt.Errorf("processor: env_file cannot be combined with push, archive, swap_dir or dedupe_identical")
+	}
+
+	// A changed reference target does not bump the index of the keys
+	// pointing at it, so the watermark would skip them.
+	if config.BoolVal(p.config.ResolveReferences) && config.BoolVal(p.config.Watermark) {
+		return fmt.Errorf("processor: resolve_references cannot be combined with watermark")
+	}
+
+	// Pushing a resolved file would overwrite the reference with its value.
+	if config.BoolVal(p.config.ResolveReferences) && config.BoolVal(p.config.Push) {
+		return fmt.Errorf("processor: resolve_references cannot be combined with push")
+	}
+
+	if (config.StringVal(p.config.TemplateLeftDelim) == "") != (config.StringVal(p.config.TemplateRightDelim) == "") {
+		return fmt.Errorf("processor: template_left_delim and template_right_delim must be set together")
+	}
+
+	// A rendered value changes with the environment and the keys it reads,
+	// neither of which bumps the index of the key, and push would store the
+	// rendered file over the template.
+	if config.BoolVal(p.config.Template) && (config.BoolVal(p.config.Watermark) || config.BoolVal(p.config.Push)) {
+		return fmt.Errorf("processor: template cannot be combined with watermark or push")
+	}
+
+	if config.BoolVal(p.config.CacheByIndex) && (config.BoolVal(p.config.FlapHold) || config.BoolVal(p.config.ResolveReferences)) {
+		return fmt.Errorf("processor: cache_by_index cannot be combined with flap_hold or resolve_references")
+	}
+
+	// watch skips unchanged indexes like cache_by_index, and the runner
+	// would rerun a push without waiting.
+	if config.BoolVal(p.config.Watch) && (config.BoolVal(p.config.FlapHold) || config.BoolVal(p.config.ResolveReferences) ||
+		config.BoolVal(p.config.Push)) {
+		return fmt.Errorf("processor: watch cannot be combined with flap_hold, resolve_references or push")
+	}
+
+	if n := config.IntVal(p.config.Concurrency); n < 1 {
+		return fmt.Errorf("processor: concurrency must be at least 1, got %d", n)
+	} else if n > 1 && (config.TimeDurationVal(p.config.WriteThrottle) > 0 || config.IntVal(p.config.MaxFilesPerPass) > 0) {
+		// Both pace writes in key order, which parallel writes do not have.
+		return fmt.Errorf("processor: concurrency cannot be combined with write_throttle or max_files_per_pass")
+	}
+
+	if config.BoolVal(p.config.Wait.Enabled) {
+		if min, max := config.TimeDurationVal(p.config.Wait.Min), config.TimeDurationVal(p.config.Wait.Max); min <= 0 || max < min {
+			return fmt.Errorf("processor: wait requires 0 < min <= max, got min %s and max %s", min, max)
+		}
+	}
+
+	// Vault has no index to block on or to compare against, and push,
+	// preflight and watermark only know how to talk to Consul.
+	if p.vault {
+		if normalizeKey(config.StringVal(p.config.From)) == "" {
+			return fmt.Errorf("processor: vault:// from requires a path")
+		}
+		if v := config.IntVal(p.config.Vault.KVVersion); v != 1 && v != 2 {
+			return fmt.Errorf("processor: invalid vault kv_version %d, must be 1 or 2", v)
+		}
+		if config.BoolVal(p.config.Push) || config.BoolVal(p.config.Watch) || config.BoolVal(p.config.CacheByIndex) ||
+			config.BoolVal(p.config.Preflight) || config.BoolVal(p.config.Watermark) {
+			return fmt.Errorf("processor: vault:// from cannot be combined with push, watch, cache_by_index, preflight or watermark")
+		}
+	}
+
+	if hasSyncs(&p.config) {
+		if err := p.validateSyncs(); err != nil {
+			return err
+		}
+	}
+
+	if config.BoolVal(p.config.Single) {
+		if err := p.validateSingle(); err != nil {
+			return err
+		}
+	}
+
+	if config.BoolVal(p.config.Stdout) {
+		if err := p.validateStdout(); err != nil {
+			return err
+		}
+	}
+
+	if config.BoolVal(p.config.PreserveMtime) {
+		if err := p.validatePreserveMtime(); err != nil {
+			return err
+		}
+	}
+
+	if err := p.validateOutputCompression(); err != nil {
+		return err
+	}
+
+	if config.StringVal(p.config.KeyPattern) != "" || config.StringVal(p.config.ToTemplate) != "" {
+		if err := p.validateToTemplate(); err != nil {
+			return err
+		}
+	}
+
+	if len(p.config.FlagsRoute) > 0 {
+		if err := p.validateFlagsRoute(); err != nil {
+			return err
+		}
+	}
+
+	if config.BoolVal(p.config.LowMemory) {
+		if err := p.validateLowMemory(); err != nil {
+			return err
+		}
+	}
+
+	if err := validateNotify(p.config.Notify); err != nil {
+		return err
+	}
+
+	if config.IntVal(p.config.FlapThreshold) > 0 {
+		if d := config.TimeDurationVal(p.config.FlapWindow); d <= 0 {
+			return fmt.Errorf("processor: flap_window must be positive, got %s", d)
+		}
+		// A held key is never written, so the watermark would skip it for
+		// good once it stabilizes.
+		if config.BoolVal(p.config.FlapHold) && config.BoolVal(p.config.Watermark) {
+			return fmt.Errorf("processor: flap_hold cannot be combined with watermark")
+		}
+	}
+
+	return nil
+}
+
 func (p *Processor) init() {
 
+	if config.BoolVal(p.config.Push) {
+		log.Printf("[WARN] (processor) push mode enabled, files in %s will be written to Consul under %s",
+			*p.config.To, *p.config.From)
+		return
+	}
+
+	if config.BoolVal(p.config.SwapDir) || config.StringVal(p.config.Archive) != "" || config.BoolVal(p.config.Stdout) {
+		return
+	}
+
 	if p.dry == false {
-		if _, err := os.Stat(*p.config.To); os.IsNotExist(err) {
-			log.Print("[INFO] (processor) Destination folder does not exists. Creating...\n")
-			err := os.MkdirAll(*p.config.To, os.ModePerm)
-			if err != nil {
-				p.error <- err
-				logError(err, ExitCodeError)
+		dirs := []string{*p.config.To}
+		for _, dir := range p.config.FlagsRoute {
+			dirs = append(dirs, dir)
+		}
+		for _, dir := range dirs {
+			if _, err := os.Stat(dir); os.IsNotExist(err) {
+				log.Printf("[INFO] (processor) Destination folder %s does not exists. Creating...\n", dir)
+				err := os.MkdirAll(dir, os.ModePerm)
+				if err != nil {
+					p.sendError(err)
+					logError(err, ExitCodeError)
+				}
 			}
 		}
 	} else {
@@ -108,55 +789,598 @@ func (p *Processor) init() {
 
 }
 
+// sendError reports err without blocking, so a processor can never hang on
+// a runner that already stopped listening.
+func (p *Processor) sendError(err error) {
+	p.stats.LastError = time.Now()
+	errorsVar.Add(1)
+
+	select {
+	case p.error <- err:
+	default:
+		log.Printf("[WARN] (processor) nobody is listening, dropping error: %s", err)
+	}
+}
+
 func logError(err error, status int) int {
 	log.Printf("[ERR] (processor) %s", err)
 	return status
 }
 
+// Cancel aborts a watch query in progress, so a stopping runner does not
+// wait for it. It is safe to call from another goroutine.
+func (p *Processor) Cancel() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+}
+
+func (p *Processor) Stop() {
+	p.Cancel()
+
+	if p.leader != nil {
+		p.leader.stop()
+	}
+
+	if p.login != nil {
+		p.login.stop(p.client)
+	}
+
+	if p.leaf != nil {
+		p.leaf.stop()
+	}
+}
+
+// Process runs a pass and counts it in the expvar counters.
 func (p *Processor) Process() int {
-	keys, _, err := p.kv.List(*p.config.From, nil)
+	defer countPass()
+	return p.process()
+}
+
+func (p *Processor) process() int {
+	p.skipped = 0
+	p.changed = false
+	p.drift = 0
+	p.written, p.bytes = 0, 0
+	p.wrote = nil
+	p.start = time.Now()
+	p.events = nil
+	p.result = nil
+	p.settle = 0
+	defer func() { p.stats.Skipped += uint64(p.skipped) }()
+	defer p.notify()
+
+	if p.leader != nil {
+		ok, err := p.leader.acquire()
+		if err != nil {
+			p.sendError(err)
+			return logError(err, ExitCodeError)
+		}
+		if !ok {
+			log.Printf("[INFO] (processor) Not the leader for %s, standing by", config.StringVal(p.config.LeaderKey))
+			p.finish()
+			return ExitCodeStandby
+		}
+	}
+
+	if len(p.syncs) > 0 {
+		return p.processSyncs()
+	}
+
+	if config.BoolVal(p.config.Push) {
+		return p.push()
+	}
+
+	keys, meta, err := p.passLister().List(normalizeKey(*p.config.From), p.listOptions())
+	if err != nil && p.ctx != nil && p.ctx.Err() != nil {
+		log.Printf("[DEBUG] (processor) stopped while waiting for changes to %s", *p.config.From)
+		return ExitCodeOK
+	}
+	if err != nil && permissionDenied(err) {
+		err = NewErrAuth(fmt.Errorf("processor: consul denied listing %s: %s", *p.config.From, err))
+	}
 	if err != nil {
-		p.error <- err
+		p.sendError(err)
+		return logError(err, ExitCodeError)
+	}
+
+	if (config.BoolVal(p.config.CacheByIndex) || config.BoolVal(p.config.Watch)) && meta != nil {
+		if p.index != 0 && meta.LastIndex == p.index {
+			log.Printf("[DEBUG] (processor) %s unchanged since index %d, skipping pass", *p.config.From, p.index)
+			return p.finish()
+		}
+		p.listIndex = meta.LastIndex
+	}
+
+	p.stats.Keys += uint64(len(keys))
+	p.warnPolling(keys)
+
+	if len(keys) <= 0 && config.BoolVal(p.config.RequireKeys) && !p.listed {
+		err := NewErrMissingPrefix(*p.config.From)
+		p.sendError(err)
 		return logError(err, ExitCodeError)
 	}
+	p.listed = true
 
 	if len(keys) <= 0 {
-		log.Printf("[WARNING] (processor) Consul path (%s) empty or does not exists", *p.config.From)
+		switch config.StringVal(p.config.OnMissingPrefix) {
+		case config.MissingPrefixIgnore:
+		case config.MissingPrefixError:
+			err := NewErrMissingPrefix(*p.config.From)
+			if p.once || p.dry {
+				p.sendError(err)
+			}
+			return logError(err, ExitCodeError)
+		default:
+			log.Printf("[WARNING] (processor) Consul path (%s) empty or does not exists", *p.config.From)
+		}
 	} else {
 		log.Printf("[INFO] (processor) Consul Path: %s", *p.config.From)
 	}
 
-	for _, pair := range keys {
-		parts := strings.Split(pair.Key, "/")
-		filename := parts[len(parts)-1]
-		if filename != "" {
-			file := filepath.Join(*p.config.To, filename)
-			fHash, _ := p.calculateFileHash(file)
-			sHash := p.getHash(pair.Value[:])
-
-			if fHash != sHash {
-				if err := p.save(file, string(pair.Value[:])); err != nil {
-					p.error <- err
-					return logError(err, ExitCodeError)
-				}
-			} else {
-				log.Printf("[INFO] (processor) Skipping: %s", pair.Key)
+	listed := keys
+	keys = p.filterIncluded(keys)
+	p.explainDropped(listed, keys, "excluded by include/exclude")
+
+	listed = keys
+	keys = p.filterPattern(keys)
+	p.explainDropped(listed, keys, "not matched by key_pattern")
+
+	listed = keys
+	keys = filterIgnored(keys)
+	p.explainDropped(listed, keys, "ignored by a .ignore marker")
+
+	listed = keys
+	keys = p.folderValues(keys)
+	p.explainDropped(listed, keys, "has keys below it and folder_with_value is skip")
+
+	// With low_memory the keys have no values yet, streamKey runs the
+	// value stages on each of them as it is written.
+	if !config.BoolVal(p.config.LowMemory) {
+		if keys, err = p.loadValues(keys); err != nil {
+			p.sendError(err)
+			return logError(err, ExitCodeError)
+		}
+	}
+
+	if err := p.checkDestinations(keys); err != nil {
+		p.sendError(err)
+		return logError(err, ExitCodeError)
+	}
+
+	if err := p.checkNameCollisions(keys); err != nil {
+		p.sendError(err)
+		return logError(err, ExitCodeError)
+	}
+
+	if p.settle = p.quiet.wait(keys); p.settle > 0 {
+		log.Printf("[INFO] (processor) keys changed, waiting %s for them to settle", p.settle)
+		return ExitCodeOK
+	}
+
+	if config.BoolVal(p.config.Stdout) {
+		if err := p.writeStdout(keys); err != nil {
+			p.sendError(err)
+			return logError(err, ExitCodeError)
+		}
+		return p.finishPass(keys)
+	}
+
+	if config.StringVal(p.config.Archive) != "" {
+		if err := p.writeArchive(keys); err != nil {
+			p.sendError(err)
+			return logError(err, ExitCodeError)
+		}
+		return p.finishPass(keys)
+	}
+
+	if config.BoolVal(p.config.SwapDir) {
+		if err := p.swapTree(keys); err != nil {
+			p.sendError(err)
+			return logError(err, ExitCodeError)
+		}
+		return p.finishPass(keys)
+	}
+
+	if config.BoolVal(p.config.EnvFile) {
+		if err := p.writeEnvFile(keys); err != nil {
+			p.sendError(err)
+			return logError(err, ExitCodeError)
+		}
+		return p.finishPass(keys)
+	}
+
+	if config.BoolVal(p.config.DedupeIdentical) {
+		if err := p.dedupeTree(keys); err != nil {
+			p.sendError(err)
+			return logError(err, ExitCodeError)
+		}
+		return p.finishPass(keys)
+	}
+
+	if err := p.checkFolderCollisions(keys); err != nil {
+		p.sendError(err)
+		return logError(err, ExitCodeError)
+	}
+
+	p.mark.load()
+	full := p.mark.full()
+	p.mtimes.observe(keys)
+
+	write := p.writeKey
+	if config.BoolVal(p.config.LowMemory) {
+		write = p.streamKey
+	}
+
+	pass := &writePass{limit: config.IntVal(p.config.MaxFilesPerPass)}
+	pool := newWorkers(config.IntVal(p.config.Concurrency))
+	for _, pair := range p.fromCursor(keys) {
+		filename := p.fileName(pair.Key)
+		if filename == "" {
+			if err := p.folder(pair.Key); err != nil {
+				pool.wait()
+				p.sendError(err)
+				return logError(err, ExitCodeError)
+			}
+			p.explainKey(explanation{Key: pair.Key, Decision: "skip", Reason: "folder marker"})
+			continue
+		}
+		file := filepath.Join(p.destination(pair), filepath.FromSlash(filename))
+		if !full && p.mark.skip(pair, file) {
+			log.Printf("[DEBUG] (processor) Skipping, unchanged since watermark: %s", pair.Key)
+			p.mu.Lock()
+			p.skipped++
+			p.mu.Unlock()
+			p.explainKey(explanation{Key: pair.Key, Path: file, Decision: "skip", Reason: "unchanged since watermark"})
+			continue
+		}
+
+		if pass.aborted() {
+			break
+		}
+		pair := pair
+		pool.run(func() { write(pass, pair, filename, file) })
+	}
+	pool.wait()
+
+	if pass.err != nil {
+		p.sendError(pass.err)
+		return logError(pass.err, ExitCodeError)
+	}
+
+	if len(pass.failed) > 0 {
+		sort.Strings(pass.failed)
+		err := fmt.Errorf("processor: %d of %d writes failed: %s", len(pass.failed), pass.written, strings.Join(pass.failed, ", "))
+		p.sendError(err)
+		return logError(err, ExitCodeError)
+	}
+
+	p.prune(keys)
+
+	if err := p.mark.store(keys, p.dry); err != nil {
+		log.Printf("[WARN] (processor) could not store watermark: %s", err)
+	}
+
+	if pass.deferred > 0 {
+		log.Printf("[INFO] (processor) wrote %d files, %d deferred to the next pass by max_files_per_pass", pass.written, pass.deferred)
+		return p.finishPass(keys)
+	}
+	p.cursor = ""
+
+	return p.finishPass(keys)
+}
+
+// loadValues runs the stages of a pass that work on the values of keys.
+func (p *Processor) loadValues(keys api.KVPairs) (api.KVPairs, error) {
+	listed := keys
+	keys, err := p.filter.filterKV(keys)
+	if err != nil {
+		return nil, err
+	}
+	p.explainDropped(listed, keys, fmt.Sprintf("excluded by filter %q", config.StringVal(p.config.Filter)))
+
+	if keys, err = p.decompress(keys); err != nil {
+		return nil, err
+	}
+
+	if keys, err = p.resolveReferences(keys); err != nil {
+		return nil, err
+	}
+
+	if keys, err = p.render(keys); err != nil {
+		return nil, err
+	}
+
+	keys = p.stripBOM(keys)
+	keys = p.applyValueFilters(keys)
+
+	if err := p.checkTotalSize(keys); err != nil {
+		return nil, err
+	}
+
+	return p.compressOutput(keys)
+}
+
+// writePass is the state of the write loop of a pass, shared by its
+// workers.
+type writePass struct {
+	sync.Mutex
+	limit    int
+	written  int
+	deferred int
+	failed   []string
+	err      error
+}
+
+func (w *writePass) aborted() bool {
+	w.Lock()
+	defer w.Unlock()
+	return w.err != nil
+}
+
+// writeKey writes pair to file unless the file already has its content.
+// It may run on a worker of the pass, so shared state is only touched
+// under a lock.
+func (p *Processor) writeKey(pass *writePass, pair *api.KVPair, filename, file string) {
+	fHash, _ := p.calculateFileHash(file)
+	sHash := p.getHash(pair.Value[:])
+	e := explanation{Key: pair.Key, Path: file, Source: sHash, Disk: fHash}
+
+	p.mu.Lock()
+	flapping := p.flap.observe(file, sHash)
+	p.mu.Unlock()
+	if flapping {
+		e.Decision, e.Reason = "skip", "flapping, holding the last value"
+		p.explainKey(e)
+		return
+	}
+
+	if fHash == sHash {
+		p.mark.record(pair)
+		p.logSkip(pair.Key)
+		e.Decision, e.Reason = "skip", "content unchanged"
+		p.explainKey(e)
+		return
+	}
+
+	pass.Lock()
+	if pass.limit > 0 && pass.written >= pass.limit {
+		if pass.deferred == 0 {
+			p.cursor = pair.Key
+		}
+		pass.deferred++
+		pass.Unlock()
+		e.Decision, e.Reason = "defer", "max_files_per_pass reached"
+		p.explainKey(e)
+		return
+	}
+	throttle := pass.written > 0
+	pass.written++
+	pass.Unlock()
+
+	e.Decision, e.Reason = "write", "content differs"
+	if fHash == "" {
+		e.Reason = "file missing"
+	}
+	p.explainKey(e)
+	if throttle {
+		p.throttle()
+	}
+
+	if err := p.save(file, string(pair.Value[:]), pair.Key); err != nil {
+		e.Decision, e.Reason = "error", err.Error()
+		p.explainKey(e)
+
+		pass.Lock()
+		defer pass.Unlock()
+		if config.StringVal(p.config.OnWriteError) != config.OnWriteErrorContinue {
+			if pass.err == nil {
+				pass.err = err
 			}
+			return
+		}
+		log.Printf("[ERR] (processor) could not write %s, continuing: %s", file, err)
+		pass.failed = append(pass.failed, filename)
+		return
+	}
+
+	if !p.dry {
+		p.countWritten()
+		p.mu.Lock()
+		if p.wrote == nil {
+			p.wrote = make(map[string]bool)
 		}
+		p.wrote[filename] = true
+		p.mu.Unlock()
+		p.mark.record(pair)
+		p.mtimes.apply(pair.Key, file)
+		p.recordChange(file, pair.Key, changeWrite, pair.Value)
 	}
-	if p.once || p.dry {
+}
+
+func (p *Processor) logSkip(key string) {
+	p.mu.Lock()
+	p.skipped++
+	p.mu.Unlock()
+	if config.BoolVal(p.config.QuietSkips) {
+		log.Printf("[DEBUG] (processor) Skipping: %s", key)
+		return
+	}
+	log.Printf("[INFO] (processor) Skipping: %s", key)
+}
+
+func (p *Processor) logSkipped() {
+	if config.BoolVal(p.config.QuietSkips) && p.skipped > 0 {
+		log.Printf("[INFO] (processor) skipped %d unchanged keys", p.skipped)
+	}
+}
+
+func (p *Processor) throttle() {
+	if d := config.TimeDurationVal(p.config.WriteThrottle); d > 0 && !p.dry {
+		time.Sleep(d)
+	}
+}
+
+func (p *Processor) finish() int {
+	if (p.once || p.dry) && p.done != nil {
+		if n := p.Drift(); p.dry && config.BoolVal(p.config.DetailedExitCode) && n > 0 {
+			// Not an error of the pass, so LastError is left alone.
+			select {
+			case p.error <- NewErrDrift(n):
+			default:
+				log.Printf("[WARN] (processor) nobody is listening, dropping drift of %d files", n)
+			}
+			return ExitCodeOK
+		}
 		p.done <- true
 	}
 
 	return ExitCodeOK
 }
 
-func newClientSet(c *config.Config) (*client.ClientSet, error) {
+// fromCursor orders keys to start at the first key deferred by the previous
+// pass, so max_files_per_pass works through the whole tree even when the
+// first keys keep changing.
+func (p *Processor) fromCursor(keys api.KVPairs) api.KVPairs {
+	if p.cursor == "" {
+		return keys
+	}
+
+	i := sort.Search(len(keys), func(i int) bool { return keys[i].Key >= p.cursor })
+	ordered := make(api.KVPairs, 0, len(keys))
+	ordered = append(ordered, keys[i:]...)
+	return append(ordered, keys[:i]...)
+}
+
+// listOptions turns the List into a blocking query on the index of the last
+// completed pass when cache_by_index or watch is set. For cache_by_index the
+// wait is kept short, as the runner already waits one interval between
+// passes. With watch the runner starts the next pass right away, so the
+// query waits long and is aborted by Cancel on shutdown. consul.datacenter
+// selects the datacenter listed and consul.consistency its consistency
+// mode, for blocking queries too.
+func (p *Processor) listOptions() *api.QueryOptions {
+	q := p.readOptions()
+
+	switch {
+	case p.index == 0:
+	case config.BoolVal(p.config.Watch):
+		q.WaitIndex = p.index
+		q.WaitTime = watchWait
+		if p.ctx != nil {
+			q = q.WithContext(p.ctx)
+		}
+	case config.BoolVal(p.config.CacheByIndex):
+		q.WaitIndex = p.index
+		q.WaitTime = cacheByIndexWait
+	}
+
+	if q.Datacenter == "" && q.WaitIndex == 0 && !q.AllowStale && !q.RequireConsistent {
+		return nil
+	}
+	return q
+}
+
+// readOptions selects the datacenter and consistency mode of a read.
+func (p *Processor) readOptions() *api.QueryOptions {
+	q := &api.QueryOptions{}
+	if p.config.Consul != nil {
+		q.Datacenter = config.StringVal(p.config.Consul.Datacenter)
+		switch config.StringVal(p.config.Consul.Consistency) {
+		case config.ConsistencyStale:
+			q.AllowStale = true
+		case config.ConsistencyConsistent:
+			q.RequireConsistent = true
+		}
+	}
+	return q
+}
+
+func (p *Processor) finishPass(keys api.KVPairs) int {
+	p.logSkipped()
+	log.Printf("[INFO] (processor) processed %d keys, wrote %d, skipped %d, %s written in %s",
+		len(keys), p.written, p.skipped, formatBytes(p.bytes), time.Since(p.start).Round(time.Millisecond))
+
+	if err := p.writeVersion(keys); err != nil {
+		p.sendError(err)
+		return logError(err, ExitCodeError)
+	}
+
+	if err := p.writeManifest(keys); err != nil {
+		p.sendError(err)
+		return logError(err, ExitCodeError)
+	}
+
+	if err := p.runCommand(); err != nil {
+		p.sendError(err)
+		return logError(err, ExitCodeError)
+	}
+
+	p.quiet.apply()
+
+	// Deferred keys still have to be written by the next pass.
+	if p.cursor == "" {
+		p.index = p.listIndex
+	}
+
+	code := p.finish()
+	if len(keys) == 0 {
+		return ExitCodeEmpty
+	}
+
+	return code
+}
+
+// warnPolling warns once when an interval below a second polls more than
+// saneViewLimit keys, as every pass lists all of them.
+func (p *Processor) warnPolling(keys api.KVPairs) {
+	if p.pollWarned || p.once || config.BoolVal(p.config.Watch) || len(keys) <= saneViewLimit {
+		return
+	}
+
+	if d := config.TimeDurationVal(p.config.Interval); d < time.Second {
+		log.Printf("[WARN] (processor) polling %d keys every %s; consider a longer interval or watch", len(keys), d)
+		p.pollWarned = true
+	}
+}
+
+func (p *Processor) checkTotalSize(keys api.KVPairs) error {
+	max := config.IntVal(p.config.MaxTotalBytes)
+	if max <= 0 {
+		return nil
+	}
+
+	total := 0
+	for _, pair := range keys {
+		if keyFileName(pair.Key) != "" {
+			total += len(pair.Value)
+		}
+	}
+
+	if total > max {
+		return fmt.Errorf("processor: total output size of %d bytes exceeds max_total_bytes (%d), refusing to write", total, max)
+	}
+
+	return nil
+}
+
+func newClientSet(c *config.Config, tokenFunc func() string, certFunc func() *tls.Certificate) (*client.ClientSet, error) {
 	clients := client.NewClientSet()
 
+	token := config.StringVal(c.Consul.Token)
+	if tokenFunc != nil && token != "" {
+		log.Printf("[WARN] (processor) consul token is ignored, using the token from auth_method")
+		token = ""
+	}
+
 	if err := clients.CreateConsulClient(&client.CreateConsulClientInput{
 		Address:                      config.StringVal(c.Consul.Address),
-		Token:                        config.StringVal(c.Consul.Token),
+		Scheme:                       config.StringVal(c.Consul.Scheme),
+		Token:                        token,
+		Headers:                      c.Consul.Headers,
+		TokenFunc:                    tokenFunc,
+		Datacenter:                   config.StringVal(c.Consul.Datacenter),
+		Namespace:                    config.StringVal(c.Consul.Namespace),
 		AuthEnabled:                  config.BoolVal(c.Consul.Auth.Enabled),
 		AuthUsername:                 config.StringVal(c.Consul.Auth.Username),
 		AuthPassword:                 config.StringVal(c.Consul.Auth.Password),
@@ -164,9 +1388,12 @@ func newClientSet(c *config.Config) (*client.ClientSet, error) {
 		SSLVerify:                    config.BoolVal(c.Consul.SSL.Verify),
 		SSLCert:                      config.StringVal(c.Consul.SSL.Cert),
 		SSLKey:                       config.StringVal(c.Consul.SSL.Key),
+		SSLCertPEM:                   config.StringVal(c.Consul.SSL.CertPEM),
+		SSLKeyPEM:                    config.StringVal(c.Consul.SSL.KeyPEM),
 		SSLCACert:                    config.StringVal(c.Consul.SSL.CaCert),
 		SSLCAPath:                    config.StringVal(c.Consul.SSL.CaPath),
 		ServerName:                   config.StringVal(c.Consul.SSL.ServerName),
+		ClientCertFunc:               certFunc,
 		TransportDialKeepAlive:       config.TimeDurationVal(c.Consul.Transport.DialKeepAlive),
 		TransportDialTimeout:         config.TimeDurationVal(c.Consul.Transport.DialTimeout),
 		TransportDisableKeepAlives:   config.BoolVal(c.Consul.Transport.DisableKeepAlives),
@@ -178,5 +1405,31 @@ func newClientSet(c *config.Config) (*client.ClientSet, error) {
 		return nil, fmt.Errorf("runner: %s", err)
 	}
 
+	if _, ok := vaultPath(config.StringVal(c.From)); ok {
+		if err := clients.CreateVaultClient(&client.CreateVaultClientInput{
+			Address:                      config.StringVal(c.Vault.Address),
+			Token:                        config.StringVal(c.Vault.Token),
+			UnwrapToken:                  config.BoolVal(c.Vault.UnwrapToken),
+			SSLEnabled:                   config.BoolVal(c.Vault.SSL.Enabled),
+			SSLVerify:                    config.BoolVal(c.Vault.SSL.Verify),
+			SSLCert:                      config.StringVal(c.Vault.SSL.Cert),
+			SSLKey:                       config.StringVal(c.Vault.SSL.Key),
+			SSLCertPEM:                   config.StringVal(c.Vault.SSL.CertPEM),
+			SSLKeyPEM:                    config.StringVal(c.Vault.SSL.KeyPEM),
+			SSLCACert:                    config.StringVal(c.Vault.SSL.CaCert),
+			SSLCAPath:                    config.StringVal(c.Vault.SSL.CaPath),
+			ServerName:                   config.StringVal(c.Vault.SSL.ServerName),
+			TransportDialKeepAlive:       config.TimeDurationVal(c.Vault.Transport.DialKeepAlive),
+			TransportDialTimeout:         config.TimeDurationVal(c.Vault.Transport.DialTimeout),
+			TransportDisableKeepAlives:   config.BoolVal(c.Vault.Transport.DisableKeepAlives),
+			TransportIdleConnTimeout:     config.TimeDurationVal(c.Vault.Transport.IdleConnTimeout),
+			TransportMaxIdleConns:        config.IntVal(c.Vault.Transport.MaxIdleConns),
+			TransportMaxIdleConnsPerHost: config.IntVal(c.Vault.Transport.MaxIdleConnsPerHost),
+			TransportTLSHandshakeTimeout: config.TimeDurationVal(c.Vault.Transport.TLSHandshakeTimeout),
+		}); err != nil {
+			return nil, fmt.Errorf("runner: %s", err)
+		}
+	}
+
 	return clients, nil
 }
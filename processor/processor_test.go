@@ -0,0 +1,5749 @@
+package processor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Assada/consul-generator/client"
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestNormalizeKey(t *testing.T) {
+	cases := []struct {
+		name string
+		key  string
+		e    string
+	}{
+		{
+			"plain",
+			"app/config/db",
+			"app/config/db",
+		},
+		{
+			"duplicate_slashes",
+			"app//config///db",
+			"app/config/db",
+		},
+		{
+			"leading_slash",
+			"/app/config",
+			"app/config",
+		},
+		{
+			"folder",
+			"app/config//",
+			"app/config/",
+		},
+		{
+			"root",
+			"/",
+			"",
+		},
+		{
+			"empty",
+			"",
+			"",
+		},
+		{
+			"whitespace",
+			" app/config ",
+			"app/config",
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			if r := normalizeKey(tc.key); r != tc.e {
+				t.Errorf("\nexp: %q\nact: %q", tc.e, r)
+			}
+		})
+	}
+}
+
+func TestKeyFileName(t *testing.T) {
+	cases := []struct {
+		name string
+		key  string
+		e    string
+	}{
+		{
+			"nested",
+			"app/config/db",
+			"db",
+		},
+		{
+			"top_level",
+			"db",
+			"db",
+		},
+		{
+			"duplicate_slashes",
+			"app//db",
+			"db",
+		},
+		{
+			"folder",
+			"app/config/",
+			"",
+		},
+		{
+			"folder_duplicate_slashes",
+			"app/config//",
+			"",
+		},
+		{
+			"root",
+			"//",
+			"",
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			if r := keyFileName(tc.key); r != tc.e {
+				t.Errorf("\nexp: %q\nact: %q", tc.e, r)
+			}
+		})
+	}
+}
+
+func TestFileName_extension(t *testing.T) {
+	cases := []struct {
+		name      string
+		key       string
+		dflt      string
+		m         map[string]string
+		transform string
+		e         string
+	}{
+		{"unchanged", "app/nginx", "", nil, "", "nginx"},
+		{"default", "app/nginx", ".txt", nil, "", "nginx.txt"},
+		{"default_without_dot", "app/nginx", "txt", nil, "", "nginx.txt"},
+		{"existing_extension", "app/site.yml", ".txt", nil, "", "site.yml"},
+		{"map_wins", "app/nginx", ".txt", map[string]string{"nginx*": ".conf"}, "", "nginx.conf"},
+		{"map_no_match", "app/redis", ".txt", map[string]string{"nginx*": ".conf"}, "", "redis.txt"},
+		{"map_sorted", "app/nginx", "", map[string]string{"n*": ".a", "nginx": ".b"}, "", "nginx.a"},
+		{"after_case_transform", "app/Nginx", ".Conf", nil, config.CaseTransformLower, "nginx.Conf"},
+		{"folder", "app/sub/", ".txt", nil, "", ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &Processor{config: config.Config{
+				DefaultExtension: config.String(tc.dflt),
+				ExtensionMap:     tc.m,
+				CaseTransform:    config.String(tc.transform),
+			}}
+			if a := p.fileName(tc.key); a != tc.e {
+				t.Errorf("\nexp: %q\nact: %q", tc.e, a)
+			}
+		})
+	}
+}
+
+func TestFileName_rename(t *testing.T) {
+	cases := []struct {
+		name      string
+		key       string
+		renames   config.RenameConfigs
+		structure bool
+		e         string
+	}{
+		{"none", "services/api.json.tmpl", nil, false, "api.json.tmpl"},
+		{"strip", "services/api.json.tmpl", config.RenameConfigs{
+			{Match: config.String(`\.tmpl$`), Replace: config.String("")},
+		}, false, "api.json"},
+		{"no_match", "services/api.json", config.RenameConfigs{
+			{Match: config.String(`\.tmpl$`), Replace: config.String("")},
+		}, false, "api.json"},
+		{"in_order", "services/api.yml.tmpl", config.RenameConfigs{
+			{Match: config.String(`\.tmpl$`), Replace: config.String("")},
+			{Match: config.String(`^(.*)\.yml$`), Replace: config.String("$1.yaml")},
+		}, false, "api.yaml"},
+		{"structure", "services/sub/api.json.tmpl", config.RenameConfigs{
+			{Match: config.String(`\.tmpl$`), Replace: config.String("")},
+		}, true, "sub/api.json"},
+		{"empty", "services/api.tmpl", config.RenameConfigs{
+			{Match: config.String(`.*`), Replace: config.String("")},
+		}, false, ""},
+		{"path", "services/api", config.RenameConfigs{
+			{Match: config.String(`^`), Replace: config.String("../")},
+		}, false, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := config.Config{
+				From:              config.String("services/"),
+				Renames:           &tc.renames,
+				PreserveStructure: config.Bool(tc.structure),
+			}
+			renames, err := newRenames(&c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := &Processor{config: c, renames: renames}
+			if a := p.fileName(tc.key); a != tc.e {
+				t.Errorf("\nexp: %q\nact: %q", tc.e, a)
+			}
+		})
+	}
+}
+
+func TestCheckNameCollisions(t *testing.T) {
+	cases := []struct {
+		name string
+		c    config.Config
+		keys []string
+		err  bool
+	}{
+		{"none", config.Config{}, []string{"app/a", "app/A"}, false},
+		{"lower", config.Config{CaseTransform: config.String(config.CaseTransformLower)}, []string{"app/a", "app/A"}, true},
+		{"extension", config.Config{DefaultExtension: config.String(".conf")}, []string{"app/a", "app/a.conf"}, true},
+		{"same_leaf", config.Config{DefaultExtension: config.String(".conf")}, []string{"app/x/a", "app/y/a"}, false},
+		{"nested_inside", config.Config{From: config.String("app/"), PreserveStructure: config.Bool(true)}, []string{"app/x/../y/a"}, false},
+		{"nested_escape", config.Config{From: config.String("app/"), PreserveStructure: config.Bool(true)}, []string{"app/x/../../../etc/a"}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var keys api.KVPairs
+			for _, k := range tc.keys {
+				keys = append(keys, &api.KVPair{Key: k})
+			}
+			p := &Processor{config: tc.c}
+			if err := p.checkNameCollisions(keys); (err != nil) != tc.err {
+				t.Errorf("expected error %t, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestCheckDestinations(t *testing.T) {
+	outside, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+
+	cases := []struct {
+		name string
+		c    config.Config
+		keys []string
+		err  bool
+	}{
+		{"flat", config.Config{}, []string{"app/a", "app/b/c"}, false},
+		{"flat_parent", config.Config{}, []string{"app/..", "app/."}, false},
+		{"folder_parent", config.Config{CreateDirsForFolders: config.Bool(true)}, []string{"app/.."}, true},
+		{"nested", config.Config{PreserveStructure: config.Bool(true)}, []string{"app/x/y/a", "app/x/../y/a"}, false},
+		{"nested_passwd", config.Config{PreserveStructure: config.Bool(true)}, []string{"app/../../etc/passwd"}, true},
+		{"nested_escape", config.Config{PreserveStructure: config.Bool(true)}, []string{"app/a/../../b"}, true},
+		{"nested_symlink_inside", config.Config{PreserveStructure: config.Bool(true)}, []string{"app/inside/a"}, false},
+		{"nested_symlink_outside", config.Config{PreserveStructure: config.Bool(true)}, []string{"app/outside/a"}, true},
+		{"folder_escape", config.Config{CreateDirsForFolders: config.Bool(true)}, []string{"app/../../etc/"}, true},
+		{"folder_symlink_outside", config.Config{CreateDirsForFolders: config.Bool(true)}, []string{"app/outside/x/"}, true},
+		{"env_file", config.Config{EnvFile: config.Bool(true)}, []string{"app/.."}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			if err := os.Mkdir(filepath.Join(dir, "y"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(filepath.Join(dir, "y"), filepath.Join(dir, "inside")); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(outside, filepath.Join(dir, "outside")); err != nil {
+				t.Fatal(err)
+			}
+
+			var keys api.KVPairs
+			for _, k := range tc.keys {
+				keys = append(keys, &api.KVPair{Key: k})
+			}
+			c := tc.c
+			c.From = config.String("app/")
+			c.To = config.String(dir)
+			p := &Processor{config: c}
+			err = p.checkDestinations(keys)
+			if (err != nil) != tc.err {
+				t.Errorf("expected error %t, got %v", tc.err, err)
+			}
+			if e, ok := err.(*ErrUnsafePath); err != nil && (!ok || e.ExitStatus() != ExitCodeUnsafePath) {
+				t.Errorf("expected an ErrUnsafePath, got %#v", err)
+			}
+		})
+	}
+}
+
+func TestCheckFolderCollisions(t *testing.T) {
+	cases := []struct {
+		name    string
+		folders bool
+		nested  bool
+		keys    []string
+		err     bool
+	}{
+		{"skipped_folders", false, false, []string{"app/x/", "app/other/x"}, false},
+		{"distinct", true, false, []string{"app/x/", "app/other/y"}, false},
+		{"file_over_folder", true, false, []string{"app/x/", "app/other/x"}, true},
+		{"file_over_parent", true, false, []string{"app/x/y/", "app/x"}, true},
+		{"nested_distinct", false, true, []string{"app/db/password", "app/web/password"}, false},
+		{"nested_file_over_parent", false, true, []string{"app/db", "app/db/password"}, true},
+		{"nested_file_over_folder", true, true, []string{"app/db/", "app/other/x", "app/db"}, true},
+		{"nested_folder_below", true, true, []string{"app/db/x/", "app/x"}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var keys api.KVPairs
+			for _, k := range tc.keys {
+				keys = append(keys, &api.KVPair{Key: k})
+			}
+			p := &Processor{config: config.Config{
+				From:                 config.String("app/"),
+				CreateDirsForFolders: config.Bool(tc.folders),
+				PreserveStructure:    config.Bool(tc.nested),
+			}}
+			if err := p.checkFolderCollisions(keys); (err != nil) != tc.err {
+				t.Errorf("expected error %t, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestFilterIgnored(t *testing.T) {
+	cases := []struct {
+		name string
+		keys api.KVPairs
+		e    []string
+	}{
+		{
+			"no_markers",
+			api.KVPairs{
+				{Key: "app/a"},
+				{Key: "app/b"},
+			},
+			[]string{"app/a", "app/b"},
+		},
+		{
+			"marker_true",
+			api.KVPairs{
+				{Key: "app/a"},
+				{Key: "app/a.ignore", Value: []byte("true")},
+				{Key: "app/b"},
+			},
+			[]string{"app/b"},
+		},
+		{
+			"marker_case_and_space",
+			api.KVPairs{
+				{Key: "app/a"},
+				{Key: "app/a.ignore", Value: []byte(" TRUE\n")},
+			},
+			[]string{},
+		},
+		{
+			"marker_false",
+			api.KVPairs{
+				{Key: "app/a"},
+				{Key: "app/a.ignore", Value: []byte("false")},
+			},
+			[]string{"app/a"},
+		},
+		{
+			"marker_without_key",
+			api.KVPairs{
+				{Key: "app/b.ignore", Value: []byte("true")},
+				{Key: "app/a"},
+			},
+			[]string{"app/a"},
+		},
+		{
+			"marker_before_key",
+			api.KVPairs{
+				{Key: "app/a.ignore", Value: []byte("true")},
+				{Key: "/app//a"},
+			},
+			[]string{},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			r := filterIgnored(tc.keys)
+			a := make([]string, 0, len(r))
+			for _, pair := range r {
+				a = append(a, pair.Key)
+			}
+			if !reflect.DeepEqual(tc.e, a) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.e, a)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	pair := &api.KVPair{
+		Key:   "app/config/db.conf",
+		Value: []byte("host=db1"),
+		Flags: 42,
+	}
+
+	cases := []struct {
+		name string
+		expr string
+		e    bool
+		err  bool
+	}{
+		{"equal", `Key == "app/config/db.conf"`, true, false},
+		{"not_equal", `Key != "app/config/db.conf"`, false, false},
+		{"contains", `Value contains "db1"`, true, false},
+		{"not_contains", `Value not contains "db1"`, false, false},
+		{"in", `"config" in Key`, true, false},
+		{"not_in", `"secret" not in Key`, true, false},
+		{"matches", `Key matches "\\.conf$"`, true, false},
+		{"not_matches", "Key not matches `^app/`", false, false},
+		{"is_empty", `Value is empty`, false, false},
+		{"is_not_empty", `Value is not empty`, true, false},
+		{"flags", `Flags == 42`, true, false},
+		{"and", `Key contains "db" and Flags == 1`, false, false},
+		{"or", `Key contains "web" or Flags == 42`, true, false},
+		{"not", `not Key contains "web"`, true, false},
+		{"parens", `not (Key contains "web" or Key contains "cache") and Value is not empty`, true, false},
+		{"unknown_selector", `Session == "x"`, false, true},
+		{"missing_operator", `Key "x"`, false, true},
+		{"unterminated", `Key == "x`, false, true},
+		{"unbalanced", `(Key == "x"`, false, true},
+		{"trailing", `Key == "x" )`, false, true},
+		{"bad_regexp", `Key matches "("`, false, true},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			f, err := newFilter(tc.expr)
+			var a bool
+			if err == nil {
+				a, err = f.match(kvSelector(pair))
+			}
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if a != tc.e {
+				t.Errorf("\nexp: %t\nact: %t", tc.e, a)
+			}
+		})
+	}
+}
+
+func TestWriteArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keys := api.KVPairs{
+		{Key: "app/"},
+		{Key: "app/a.conf", Value: []byte("a")},
+		{Key: "app/b.conf", Value: []byte("bb")},
+	}
+
+	for _, name := range []string{"bundle.tar.gz", "bundle.zip"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			p := &Processor{config: config.Config{Archive: config.String(path)}}
+
+			if err := p.writeArchive(keys); err != nil {
+				t.Fatal(err)
+			}
+
+			a, err := readArchive(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := map[string][]byte{
+				"a.conf": []byte("a"),
+				"b.conf": []byte("bb"),
+			}
+			if !reflect.DeepEqual(e, a) {
+				t.Errorf("\nexp: %#v\nact: %#v", e, a)
+			}
+
+			before, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := p.writeArchive(keys); err != nil {
+				t.Fatal(err)
+			}
+			after, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !os.SameFile(before, after) {
+				t.Error("expected unchanged archive not to be rewritten")
+			}
+
+			if err := ioutil.WriteFile(path, []byte("truncated"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.writeArchive(keys); err != nil {
+				t.Fatalf("expected a corrupt archive to be rewritten, got %s", err)
+			}
+			if a, err = readArchive(path); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(e, a) {
+				t.Errorf("\nexp: %#v\nact: %#v", e, a)
+			}
+		})
+	}
+}
+
+func TestSwapTree(t *testing.T) {
+	keys := api.KVPairs{
+		{Key: "app/a.conf", Value: []byte("a")},
+		{Key: "app/b.conf", Value: []byte("bb")},
+	}
+	e := map[string][]byte{
+		"a.conf": []byte("a"),
+		"b.conf": []byte("bb"),
+	}
+
+	cases := []struct {
+		name     string
+		existing bool
+	}{
+		{"absent", false},
+		{"existing_directory", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			parent, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(parent)
+
+			to := filepath.Join(parent, "current")
+			if tc.existing {
+				if err := os.Mkdir(to, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(filepath.Join(to, "old.conf"), []byte("old"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c := config.DefaultConfig()
+			c.To = config.String(to)
+			c.SwapDir = config.Bool(true)
+			c.VersionFile = config.String(filepath.Join(to, "VERSION"))
+			c.Manifest = config.String(filepath.Join(to, "MANIFEST.json"))
+			c.Finalize()
+			p := &Processor{config: *c}
+
+			if err := p.swapTree(keys); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.writeVersion(keys); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.writeManifest(keys); err != nil {
+				t.Fatal(err)
+			}
+
+			first, err := os.Readlink(to)
+			if err != nil {
+				t.Fatalf("expected %s to be a symlink: %s", to, err)
+			}
+			a, err := readTree(to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			delete(a, "VERSION")
+			delete(a, "MANIFEST.json")
+			if !reflect.DeepEqual(e, a) {
+				t.Errorf("\nexp: %#v\nact: %#v", e, a)
+			}
+
+			if tc.existing {
+				if _, err := os.Stat(filepath.Join(to+".orig", "old.conf")); err != nil {
+					t.Errorf("expected the existing directory to be moved aside: %s", err)
+				}
+			}
+
+			if err := p.swapTree(keys); err != nil {
+				t.Fatal(err)
+			}
+			second, err := os.Readlink(to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first != second {
+				t.Errorf("expected an unchanged tree not to be swapped, %s became %s", first, second)
+			}
+		})
+	}
+}
+
+func TestDedupeTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := &Processor{config: config.Config{To: config.String(dir)}}
+
+	keys := api.KVPairs{
+		{Key: "app/a.pem", Value: []byte("cert")},
+		{Key: "app/b.pem", Value: []byte("cert")},
+		{Key: "app/c.conf", Value: []byte("other")},
+	}
+	if err := p.dedupeTree(keys); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pair := range keys {
+		file := filepath.Join(dir, keyFileName(pair.Key))
+		link, err := os.Readlink(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e := filepath.Join(dedupeStoreDir, p.getHash(pair.Value)); link != e {
+			t.Errorf("\nexp: %q\nact: %q", e, link)
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != string(pair.Value) {
+			t.Errorf("\nexp: %q\nact: %q", pair.Value, content)
+		}
+	}
+
+	infos, err := ioutil.ReadDir(filepath.Join(dir, dedupeStoreDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Errorf("expected 2 store entries, got %d", len(infos))
+	}
+
+	keys[2].Value = []byte("cert")
+	if err := p.dedupeTree(keys); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err = ioutil.ReadDir(filepath.Join(dir, dedupeStoreDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		t.Errorf("expected unused store entry to be removed, got %d entries", len(infos))
+	}
+}
+
+type fakeLister struct {
+	pairs api.KVPairs
+}
+
+func (f *fakeLister) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	var pairs api.KVPairs
+	for _, pair := range f.pairs {
+		if strings.HasPrefix(pair.Key, prefix) {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs, &api.QueryMeta{}, nil
+}
+
+func TestRenderEnvFile(t *testing.T) {
+	keys := api.KVPairs{
+		{Key: "app/"},
+		{Key: "app/db.host", Value: []byte("localhost")},
+		{Key: "app/sub/port", Value: []byte("5432")},
+		{Key: "app/9lives", Value: []byte("")},
+		{Key: "app/motd", Value: []byte("hello \"you\"\nbye $USER")},
+		{Key: "other/db-host", Value: []byte("dup")},
+	}
+
+	e := "DB_HOST=localhost\n" +
+		"PORT=5432\n" +
+		"_9LIVES=\n" +
+		"MOTD=\"hello \\\"you\\\"\\nbye \\$USER\"\n"
+	if a := string(renderEnvFile(keys)); a != e {
+		t.Errorf("\nexp: %q\nact: %q", e, a)
+	}
+}
+
+func TestProcess_envFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.EnvFile = config.Bool(true)
+	c.Finalize()
+
+	p := &Processor{
+		config: *c,
+		lister: &fakeLister{pairs: api.KVPairs{
+			{Key: "app/a", Value: []byte("1")},
+			{Key: "app/b", Value: []byte("two words")},
+		}},
+		error: make(chan error, 1),
+	}
+
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+	}
+
+	a, err := readTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := map[string][]byte{
+		envFileName: []byte("A=1\nB=\"two words\"\n"),
+	}
+	if !reflect.DeepEqual(e, a) {
+		t.Errorf("\nexp: %#v\nact: %#v", e, a)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		config *config.Config
+		err    bool
+	}{
+		{
+			"defaults",
+			&config.Config{},
+			false,
+		},
+		{
+			"dedupe_identical",
+			&config.Config{DedupeIdentical: config.Bool(true)},
+			false,
+		},
+		{
+			"dedupe_identical_watermark",
+			&config.Config{DedupeIdentical: config.Bool(true), Watermark: config.Bool(true)},
+			true,
+		},
+		{
+			"dedupe_identical_max_files_per_pass",
+			&config.Config{DedupeIdentical: config.Bool(true), MaxFilesPerPass: config.Int(10)},
+			true,
+		},
+		{
+			"push_case_transform",
+			&config.Config{Push: config.Bool(true), CaseTransform: config.String(config.CaseTransformLower)},
+			true,
+		},
+		{
+			"push_default_extension",
+			&config.Config{Push: config.Bool(true), DefaultExtension: config.String(".json")},
+			true,
+		},
+		{
+			"push_extension_map",
+			&config.Config{Push: config.Bool(true), ExtensionMap: map[string]string{"*": ".json"}},
+			true,
+		},
+		{
+			"push_resolve_references",
+			&config.Config{Push: config.Bool(true), ResolveReferences: config.Bool(true)},
+			true,
+		},
+		{
+			"push_value_filter",
+			&config.Config{
+				Push: config.Bool(true),
+				ValueFilters: &config.ValueFilterConfigs{
+					&config.ValueFilterConfig{Pattern: config.String("*.json"), Command: []string{"jq", "."}},
+				},
+			},
+			true,
+		},
+		{
+			"prune_swap_dir",
+			&config.Config{Prune: config.Bool(true), SwapDir: config.Bool(true)},
+			true,
+		},
+		{
+			"preserve_structure_env_file",
+			&config.Config{PreserveStructure: config.Bool(true), EnvFile: config.Bool(true)},
+			true,
+		},
+		{
+			"value_filter_missing_command",
+			&config.Config{
+				ValueFilters: &config.ValueFilterConfigs{
+					&config.ValueFilterConfig{Pattern: config.String("*.json")},
+				},
+			},
+			true,
+		},
+		{
+			"dedupe_identical_file_mode",
+			&config.Config{
+				DedupeIdentical: config.Bool(true),
+				FileModes: &config.FileModeConfigs{
+					&config.FileModeConfig{Pattern: config.String("*.key"), Mode: config.FileMode(0600)},
+				},
+			},
+			true,
+		},
+		{
+			"compression",
+			&config.Config{Compression: config.String("bzip2")},
+			true,
+		},
+		{
+			"push_compression",
+			&config.Config{Push: config.Bool(true), Compression: config.String(config.CompressionGzip)},
+			true,
+		},
+		{
+			"concurrency",
+			&config.Config{Concurrency: config.Int(0)},
+			true,
+		},
+		{
+			"concurrency_write_throttle",
+			&config.Config{Concurrency: config.Int(4), WriteThrottle: config.TimeDuration(time.Second)},
+			true,
+		},
+		{
+			"concurrency_max_files_per_pass",
+			&config.Config{Concurrency: config.Int(4), MaxFilesPerPass: config.Int(10)},
+			true,
+		},
+		{
+			"concurrency_max_files_per_pass_sequential",
+			&config.Config{Concurrency: config.Int(1), MaxFilesPerPass: config.Int(10)},
+			false,
+		},
+		{
+			"wait",
+			&config.Config{Wait: &config.WaitConfig{Min: config.TimeDuration(2 * time.Second)}},
+			false,
+		},
+		{
+			"wait_max_below_min",
+			&config.Config{Wait: &config.WaitConfig{Min: config.TimeDuration(2 * time.Second), Max: config.TimeDuration(time.Second)}},
+			true,
+		},
+		{
+			"wait_enabled_without_min",
+			&config.Config{Wait: &config.WaitConfig{Enabled: config.Bool(true)}},
+			true,
+		},
+		{
+			"sync",
+			&config.Config{Syncs: &config.SyncConfigs{{From: config.String("a/"), To: config.String("a")}}},
+			false,
+		},
+		{
+			"sync_missing_to",
+			&config.Config{Syncs: &config.SyncConfigs{{From: config.String("a/")}}},
+			true,
+		},
+		{
+			"sync_duplicate_to",
+			&config.Config{Syncs: &config.SyncConfigs{
+				{From: config.String("a/"), To: config.String("out")},
+				{From: config.String("b/"), To: config.String("out")},
+			}},
+			true,
+		},
+		{
+			"sync_vault_from",
+			&config.Config{Syncs: &config.SyncConfigs{{From: config.String("vault://secret/a"), To: config.String("a")}}},
+			true,
+		},
+		{
+			"sync_watch",
+			&config.Config{Watch: config.Bool(true), Syncs: &config.SyncConfigs{{From: config.String("a/"), To: config.String("a")}}},
+			true,
+		},
+		{
+			"template_watermark",
+			&config.Config{Template: config.Bool(true), Watermark: config.Bool(true)},
+			true,
+		},
+		{
+			"template_left_delim_only",
+			&config.Config{Template: config.Bool(true), TemplateLeftDelim: config.String("[[")},
+			true,
+		},
+		{
+			"template_push",
+			&config.Config{Template: config.Bool(true), Push: config.Bool(true)},
+			true,
+		},
+		{
+			"rename",
+			&config.Config{Renames: &config.RenameConfigs{{Match: config.String(`\.tmpl$`)}}},
+			false,
+		},
+		{
+			"rename_invalid",
+			&config.Config{Renames: &config.RenameConfigs{{Match: config.String(`(\.tmpl$`)}}},
+			true,
+		},
+		{
+			"rename_push",
+			&config.Config{Push: config.Bool(true), Renames: &config.RenameConfigs{{Match: config.String(`\.tmpl$`)}}},
+			true,
+		},
+		{
+			"include_invalid",
+			&config.Config{Include: []string{"[app"}},
+			true,
+		},
+		{
+			"exclude_invalid",
+			&config.Config{Exclude: []string{"[app"}},
+			true,
+		},
+		{
+			"manifest_env_file",
+			&config.Config{Manifest: config.String("/tmp/manifest.json"), EnvFile: config.Bool(true)},
+			true,
+		},
+		{
+			"single",
+			&config.Config{Single: config.Bool(true), From: config.String("app/db"), To: config.String("/etc/app/db.ini")},
+			false,
+		},
+		{
+			"single_folder",
+			&config.Config{Single: config.Bool(true), From: config.String("app/"), To: config.String("/etc/app/db.ini")},
+			true,
+		},
+		{
+			"single_to_root",
+			&config.Config{Single: config.Bool(true), From: config.String("app/db"), To: config.String("/")},
+			true,
+		},
+		{
+			"single_prune",
+			&config.Config{Single: config.Bool(true), From: config.String("app/db"), To: config.String("db.ini"), Prune: config.Bool(true)},
+			true,
+		},
+		{
+			"notify_url",
+			&config.Config{Notify: &config.NotifyConfig{URL: config.String("https://example.com/hook")}},
+			false,
+		},
+		{
+			"notify_url_scheme",
+			&config.Config{Notify: &config.NotifyConfig{URL: config.String("example.com/hook")}},
+			true,
+		},
+		{
+			"notify_timeout",
+			&config.Config{Notify: &config.NotifyConfig{URL: config.String("https://example.com/hook"), Timeout: config.TimeDuration(0)}},
+			true,
+		},
+		{
+			"preserve_mtime_swap_dir",
+			&config.Config{PreserveMtime: config.Bool(true), SwapDir: config.Bool(true)},
+			true,
+		},
+		{
+			"stdout",
+			&config.Config{Stdout: config.Bool(true)},
+			false,
+		},
+		{
+			"stdout_prune",
+			&config.Config{Stdout: config.Bool(true), Prune: config.Bool(true)},
+			true,
+		},
+		{
+			"command_splay_negative",
+			&config.Config{CommandSplay: config.TimeDuration(-time.Second)},
+			true,
+		},
+		{
+			"flags_route",
+			&config.Config{FlagsRoute: map[string]string{"1": "/etc/a", "2": "/etc/b"}},
+			false,
+		},
+		{
+			"flags_route_not_a_number",
+			&config.Config{FlagsRoute: map[string]string{"one": "/etc/a"}},
+			true,
+		},
+		{
+			"flags_route_empty_dir",
+			&config.Config{FlagsRoute: map[string]string{"1": ""}},
+			true,
+		},
+		{
+			"flags_route_stdout",
+			&config.Config{FlagsRoute: map[string]string{"1": "/etc/a"}, Stdout: config.Bool(true)},
+			true,
+		},
+		{
+			"interval_zero",
+			&config.Config{Interval: config.TimeDuration(0)},
+			true,
+		},
+		{
+			"interval_negative",
+			&config.Config{Interval: config.TimeDuration(-time.Second)},
+			true,
+		},
+		{
+			"output_compression_gzip",
+			&config.Config{OutputCompression: config.String(config.CompressionGzip)},
+			false,
+		},
+		{
+			"output_compression_deflate",
+			&config.Config{OutputCompression: config.String(config.CompressionDeflate)},
+			true,
+		},
+		{
+			"output_compression_env_file",
+			&config.Config{OutputCompression: config.String(config.CompressionGzip), EnvFile: config.Bool(true)},
+			true,
+		},
+		{
+			"consul_consistency_stale",
+			&config.Config{Consul: &config.ConsulConfig{Consistency: config.String(config.ConsistencyStale)}},
+			false,
+		},
+		{
+			"consul_consistency_invalid",
+			&config.Config{Consul: &config.ConsulConfig{Consistency: config.String("eventual")}},
+			true,
+		},
+		{
+			"to_template",
+			&config.Config{KeyPattern: config.String("(?P<team>[^/]+)/(?P<file>.+)"), ToTemplate: config.String("/etc/{{.team}}/{{.file}}")},
+			false,
+		},
+		{
+			"to_template_without_key_pattern",
+			&config.Config{ToTemplate: config.String("/etc/{{.team}}")},
+			true,
+		},
+		{
+			"to_template_invalid_key_pattern",
+			&config.Config{KeyPattern: config.String("(?P<team>"), ToTemplate: config.String("/etc/{{.team}}")},
+			true,
+		},
+		{
+			"to_template_unknown_group",
+			&config.Config{KeyPattern: config.String("(?P<team>.+)"), ToTemplate: config.String("/etc/{{.file}}")},
+			true,
+		},
+		{
+			"to_template_preserve_structure",
+			&config.Config{KeyPattern: config.String("(?P<file>.+)"), ToTemplate: config.String("{{.file}}"), PreserveStructure: config.Bool(true)},
+			true,
+		},
+		{
+			"low_memory",
+			&config.Config{LowMemory: config.Bool(true), Filter: config.String(`Value == "on"`)},
+			false,
+		},
+		{
+			"low_memory_template",
+			&config.Config{LowMemory: config.Bool(true), Template: config.Bool(true)},
+			true,
+		},
+		{
+			"low_memory_archive",
+			&config.Config{LowMemory: config.Bool(true), Archive: config.String("/tmp/out.tar.gz")},
+			true,
+		},
+		{
+			"low_memory_watermark",
+			&config.Config{LowMemory: config.Bool(true), Watermark: config.Bool(true)},
+			true,
+		},
+		{
+			"folder_with_value_invalid",
+			&config.Config{PreserveStructure: config.Bool(true), FolderWithValue: config.String("merge")},
+			true,
+		},
+		{
+			"folder_with_value_flat",
+			&config.Config{FolderWithValue: config.String(config.FolderWithValueIndex)},
+			true,
+		},
+		{
+			"prune_patterns_invalid",
+			&config.Config{Prune: config.Bool(true), PrunePatterns: []string{"["}},
+			true,
+		},
+		{
+			"command_trigger_swap_dir",
+			&config.Config{
+				SwapDir:  config.Bool(true),
+				Commands: &config.CommandConfigs{{Trigger: config.String("nginx/*"), Exec: config.String("true")}},
+			},
+			true,
+		},
+		{
+			"command_missing_exec",
+			&config.Config{Commands: &config.CommandConfigs{{Trigger: config.String("nginx/*")}}},
+			true,
+		},
+		{
+			"dedupe_identical_on_write_error",
+			&config.Config{DedupeIdentical: config.Bool(true), OnWriteError: config.String(config.OnWriteErrorContinue)},
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := config.DefaultConfig().Merge(tc.config)
+			c.Finalize()
+
+			p := &Processor{config: *c}
+			if err := p.validate(); (err != nil) != tc.err {
+				t.Errorf("expected error %t, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestProcess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.Finalize()
+
+	p := &Processor{
+		config: *c,
+		lister: &fakeLister{pairs: api.KVPairs{
+			{Key: "app/"},
+			{Key: "app/a.conf", Value: []byte("a")},
+			{Key: "app/b.conf", Value: []byte("b")},
+			{Key: "other/c.conf", Value: []byte("c")},
+		}},
+		error: make(chan error, 1),
+	}
+
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+	}
+
+	a, err := readTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := map[string][]byte{
+		"a.conf": []byte("a"),
+		"b.conf": []byte("b"),
+	}
+	if !reflect.DeepEqual(e, a) {
+		t.Errorf("\nexp: %#v\nact: %#v", e, a)
+	}
+
+	p.lister = &fakeLister{}
+	if code := p.Process(); code != ExitCodeEmpty {
+		t.Errorf("expected exit code %d for an empty prefix, got %d", ExitCodeEmpty, code)
+	}
+}
+
+func TestProcess_onMissingPrefix(t *testing.T) {
+	cases := []struct {
+		policy string
+		code   int
+		warn   bool
+		err    bool
+	}{
+		{config.MissingPrefixWarn, ExitCodeEmpty, true, false},
+		{config.MissingPrefixIgnore, ExitCodeEmpty, false, false},
+		{config.MissingPrefixError, ExitCodeError, false, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.policy, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c := config.DefaultConfig()
+			c.From = config.String("app/")
+			c.To = config.String(dir)
+			c.OnMissingPrefix = config.String(tc.policy)
+			c.Finalize()
+
+			p := &Processor{
+				config: *c,
+				lister: &fakeLister{},
+				error:  make(chan error, 1),
+				done:   make(chan bool, 1),
+				once:   true,
+			}
+
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defer log.SetOutput(os.Stderr)
+
+			if code := p.Process(); code != tc.code {
+				t.Errorf("expected exit code %d, got %d", tc.code, code)
+			}
+
+			if warned := strings.Contains(buf.String(), "[WARNING]"); warned != tc.warn {
+				t.Errorf("expected warning %t, got log:\n%s", tc.warn, buf.String())
+			}
+
+			select {
+			case err := <-p.error:
+				if _, ok := err.(*ErrMissingPrefix); !tc.err || !ok {
+					t.Errorf("unexpected error %v", err)
+				}
+			default:
+				if tc.err {
+					t.Error("expected an ErrMissingPrefix")
+				}
+			}
+		})
+	}
+}
+
+func TestProcess_maxTotalBytes(t *testing.T) {
+	cases := []struct {
+		name string
+		max  int
+		code int
+	}{
+		{"at_limit", 3, ExitCodeOK},
+		{"over_limit", 2, ExitCodeError},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c := config.DefaultConfig()
+			c.From = config.String("app/")
+			c.To = config.String(dir)
+			c.MaxTotalBytes = config.Int(tc.max)
+			c.Finalize()
+
+			p := &Processor{
+				config: *c,
+				lister: &fakeLister{pairs: api.KVPairs{
+					{Key: "app/a.conf", Value: []byte("a")},
+					{Key: "app/b.conf", Value: []byte("bc")},
+				}},
+				error: make(chan error, 1),
+			}
+
+			if code := p.Process(); code != tc.code {
+				t.Fatalf("expected exit code %d, got %d", tc.code, code)
+			}
+
+			a, err := readTree(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.code == ExitCodeError && len(a) != 0 {
+				t.Errorf("expected nothing written over the limit, got %v", a)
+			}
+			if tc.code == ExitCodeOK && len(a) != 2 {
+				t.Errorf("expected both files written at the limit, got %v", a)
+			}
+		})
+	}
+}
+
+func TestProcess_folders(t *testing.T) {
+	cases := []struct {
+		name   string
+		create bool
+		exp    []string
+	}{
+		{"skip", false, []string{"a.conf"}},
+		{"create", true, []string{"a.conf", "empty", "sub"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c := config.DefaultConfig()
+			c.From = config.String("app/")
+			c.To = config.String(dir)
+			c.CreateDirsForFolders = config.Bool(tc.create)
+			c.Finalize()
+
+			p := &Processor{
+				config: *c,
+				lister: &fakeLister{pairs: api.KVPairs{
+					{Key: "app/"},
+					{Key: "app/a.conf", Value: []byte("a")},
+					{Key: "app/empty/"},
+					{Key: "app/sub/"},
+				}},
+				error: make(chan error, 1),
+			}
+
+			if code := p.Process(); code != ExitCodeOK {
+				t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+			}
+
+			infos, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var a []string
+			for _, info := range infos {
+				a = append(a, info.Name())
+			}
+			if !reflect.DeepEqual(tc.exp, a) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, a)
+			}
+		})
+	}
+}
+
+func TestProcess_versionFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "meta", "version")
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(filepath.Join(dir, "out"))
+	c.VersionFile = config.String(path)
+	c.Finalize()
+
+	lister := &fakeLister{pairs: api.KVPairs{
+		{Key: "app/a.conf", Value: []byte("a")},
+		{Key: "app/b.conf", Value: []byte("b")},
+	}}
+	p := &Processor{
+		config: *c,
+		lister: lister,
+		error:  make(chan error, 1),
+	}
+	if err := os.MkdirAll(*c.To, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	version := func() string {
+		if code := p.Process(); code != ExitCodeOK && code != ExitCodeEmpty {
+			t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	first := version()
+	if second := version(); second != first {
+		t.Errorf("expected an unchanged tree to keep version %q, got %q", first, second)
+	}
+
+	lister.pairs[1].Value = []byte("changed")
+	third := version()
+	if third == first {
+		t.Errorf("expected a changed value to bump version %q", first)
+	}
+
+	lister.pairs = nil
+	if empty := version(); empty == third {
+		t.Errorf("expected deleting every key to bump version %q", third)
+	}
+}
+
+func TestProcess_manifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "meta", "manifest.json")
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(filepath.Join(dir, "out"))
+	c.Manifest = config.String(path)
+	c.Finalize()
+
+	lister := &fakeLister{pairs: api.KVPairs{
+		{Key: "app/a.conf", Value: []byte("a")},
+		{Key: "app/b.conf", Value: []byte("b")},
+	}}
+	p := &Processor{
+		config: *c,
+		lister: lister,
+		error:  make(chan error, 1),
+		dry:    true,
+		done:   make(chan bool, 1),
+	}
+
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected a dry run not to write the manifest, got %v", err)
+	}
+
+	p.dry = false
+	p.done = nil
+	if err := os.MkdirAll(*c.To, 0755); err != nil {
+		t.Fatal(err)
+	}
+	manifest := func() map[string]string {
+		if code := p.Process(); code != ExitCodeOK {
+			t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m map[string]string
+		if err := json.Unmarshal(content, &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	e := map[string]string{"a.conf": p.getHash([]byte("a")), "b.conf": p.getHash([]byte("b"))}
+	if m := manifest(); !reflect.DeepEqual(e, m) {
+		t.Errorf("\nexp: %v\nact: %v", e, m)
+	}
+
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, past, past); err != nil {
+		t.Fatal(err)
+	}
+	manifest()
+	if info, err := os.Stat(path); err != nil || !info.ModTime().Equal(past) {
+		t.Errorf("expected an unchanged manifest not to be rewritten, got %v %v", info.ModTime(), err)
+	}
+
+	lister.pairs[1].Value = []byte("changed")
+	e["b.conf"] = p.getHash([]byte("changed"))
+	if m := manifest(); !reflect.DeepEqual(e, m) {
+		t.Errorf("\nexp: %v\nact: %v", e, m)
+	}
+}
+
+func TestProcess_onWriteError(t *testing.T) {
+	cases := []struct {
+		policy  string
+		written bool
+	}{
+		{config.OnWriteErrorAbort, false},
+		{config.OnWriteErrorContinue, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.policy, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			// A directory in place of b.conf makes its write fail.
+			if err := os.Mkdir(filepath.Join(dir, "b.conf"), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			c := config.DefaultConfig()
+			c.From = config.String("app/")
+			c.To = config.String(dir)
+			c.OnWriteError = config.String(tc.policy)
+			c.Finalize()
+
+			errCh := make(chan error, 1)
+			p := &Processor{
+				config: *c,
+				lister: &fakeLister{pairs: api.KVPairs{
+					{Key: "app/a.conf", Value: []byte("a")},
+					{Key: "app/b.conf", Value: []byte("b")},
+					{Key: "app/c.conf", Value: []byte("c")},
+				}},
+				error: errCh,
+			}
+
+			if code := p.Process(); code != ExitCodeError {
+				t.Fatalf("expected exit code %d, got %d", ExitCodeError, code)
+			}
+			select {
+			case <-errCh:
+			default:
+				t.Error("expected the write error to be reported")
+			}
+
+			_, err = os.Stat(filepath.Join(dir, "c.conf"))
+			if written := err == nil; written != tc.written {
+				t.Errorf("expected c.conf written to be %t", tc.written)
+			}
+		})
+	}
+}
+
+func TestProcess_maxFilesPerPass(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.MaxFilesPerPass = config.Int(2)
+	c.Finalize()
+
+	lister := &fakeLister{pairs: api.KVPairs{
+		{Key: "app/a", Value: []byte("a")},
+		{Key: "app/b", Value: []byte("b")},
+		{Key: "app/c", Value: []byte("c")},
+		{Key: "app/d", Value: []byte("d")},
+		{Key: "app/e", Value: []byte("e")},
+	}}
+	p := &Processor{
+		config: *c,
+		lister: lister,
+		error:  make(chan error, 1),
+	}
+
+	names := func() []string {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		return names
+	}
+
+	p.Process()
+	if e, a := []string{"a", "b"}, names(); !reflect.DeepEqual(e, a) {
+		t.Errorf("\nexp: %#v\nact: %#v", e, a)
+	}
+
+	// a keeps changing, the cursor still moves on to c and d.
+	lister.pairs[0].Value = []byte("a2")
+	p.Process()
+	if e, a := []string{"a", "b", "c", "d"}, names(); !reflect.DeepEqual(e, a) {
+		t.Errorf("\nexp: %#v\nact: %#v", e, a)
+	}
+
+	p.Process()
+	if e, a := []string{"a", "b", "c", "d", "e"}, names(); !reflect.DeepEqual(e, a) {
+		t.Errorf("\nexp: %#v\nact: %#v", e, a)
+	}
+	if p.cursor != "" {
+		t.Errorf("expected the cursor to reset after a full pass, got %q", p.cursor)
+	}
+}
+
+func TestProcess_explain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.Filter = config.String(`Key != "app/b.conf"`)
+	c.Finalize()
+
+	f, err := newFilter(*c.Filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	p := &Processor{
+		config: *c,
+		lister: &fakeLister{pairs: api.KVPairs{
+			{Key: "app/a.conf", Value: []byte("a")},
+			{Key: "app/b.conf", Value: []byte("b")},
+		}},
+		filter:  f,
+		explain: &out,
+		error:   make(chan error, 1),
+	}
+
+	p.Process()
+	p.Process()
+
+	for _, e := range []string{
+		"key app/b.conf\n  decision: skip (excluded by filter \"Key != \\\"app/b.conf\\\"\")\n",
+		"  path:     " + filepath.Join(dir, "a.conf") + "\n",
+		"  disk:     (missing)\n  decision: write (file missing)\n",
+		"  decision: skip (content unchanged)\n",
+	} {
+		if !strings.Contains(out.String(), e) {
+			t.Errorf("expected %q in:\n%s", e, out.String())
+		}
+	}
+}
+
+func TestProcess_caseTransform(t *testing.T) {
+	cases := []struct {
+		name      string
+		transform string
+		keys      api.KVPairs
+		exp       map[string][]byte
+		err       bool
+	}{
+		{
+			"none",
+			config.CaseTransformNone,
+			api.KVPairs{
+				{Key: "app/App.conf", Value: []byte("a")},
+				{Key: "app/app.conf", Value: []byte("b")},
+			},
+			map[string][]byte{"App.conf": []byte("a"), "app.conf": []byte("b")},
+			false,
+		},
+		{
+			"lower",
+			config.CaseTransformLower,
+			api.KVPairs{{Key: "app/App.Conf", Value: []byte("a")}},
+			map[string][]byte{"app.conf": []byte("a")},
+			false,
+		},
+		{
+			"upper",
+			config.CaseTransformUpper,
+			api.KVPairs{{Key: "app/App.Conf", Value: []byte("a")}},
+			map[string][]byte{"APP.CONF": []byte("a")},
+			false,
+		},
+		{
+			"collision",
+			config.CaseTransformLower,
+			api.KVPairs{
+				{Key: "app/App.conf", Value: []byte("a")},
+				{Key: "app/app.conf", Value: []byte("b")},
+			},
+			map[string][]byte{},
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c := config.DefaultConfig()
+			c.From = config.String("app/")
+			c.To = config.String(dir)
+			c.CaseTransform = config.String(tc.transform)
+			c.Finalize()
+
+			errCh := make(chan error, 1)
+			p := &Processor{
+				config: *c,
+				lister: &fakeLister{pairs: tc.keys},
+				error:  errCh,
+			}
+
+			p.Process()
+			select {
+			case err := <-errCh:
+				if !tc.err {
+					t.Fatal(err)
+				}
+			default:
+				if tc.err {
+					t.Fatal("expected a collision error")
+				}
+			}
+
+			a, err := readTree(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tc.exp, a) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, a)
+			}
+		})
+	}
+}
+
+func TestProcess_stripBOM(t *testing.T) {
+	cases := []struct {
+		name  string
+		strip bool
+		value []byte
+		exp   []byte
+	}{
+		{"off", false, []byte("\xEF\xBB\xBFkey=value"), []byte("\xEF\xBB\xBFkey=value")},
+		{"text", true, []byte("\xEF\xBB\xBFkey=value"), []byte("key=value")},
+		{"no_bom", true, []byte("key=value"), []byte("key=value")},
+		{"binary", true, []byte("\xEF\xBB\xBF\xff\xfe"), []byte("\xEF\xBB\xBF\xff\xfe")},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c := config.DefaultConfig()
+			c.From = config.String("app/")
+			c.To = config.String(dir)
+			c.StripBOM = config.Bool(tc.strip)
+			c.Finalize()
+
+			value := append([]byte(nil), tc.value...)
+			p := &Processor{
+				config: *c,
+				lister: &fakeLister{pairs: api.KVPairs{{Key: "app/a.conf", Value: value}}},
+				error:  make(chan error, 1),
+			}
+
+			for i := 0; i < 2; i++ {
+				p.Process()
+			}
+
+			a, err := ioutil.ReadFile(filepath.Join(dir, "a.conf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(tc.exp, a) {
+				t.Errorf("\nexp: %q\nact: %q", tc.exp, a)
+			}
+			if !bytes.Equal(tc.value, value) {
+				t.Errorf("expected the listed value to stay untouched, got %q", value)
+			}
+		})
+	}
+}
+
+func TestResolveReferences(t *testing.T) {
+	cases := []struct {
+		name  string
+		pairs api.KVPairs
+		exp   map[string]string
+		err   string
+	}{
+		{
+			"simple",
+			api.KVPairs{
+				{Key: "app/a.conf", Value: []byte("@consul:shared/db")},
+				{Key: "app/b.conf", Value: []byte("b")},
+				{Key: "shared/db", Value: []byte("db")},
+			},
+			map[string]string{"app/a.conf": "db", "app/b.conf": "b"},
+			"",
+		},
+		{
+			"chain",
+			api.KVPairs{
+				{Key: "app/a.conf", Value: []byte("@consul:app/b.conf")},
+				{Key: "app/b.conf", Value: []byte("@consul: shared/db")},
+				{Key: "shared/db", Value: []byte("db")},
+			},
+			map[string]string{"app/a.conf": "db", "app/b.conf": "db"},
+			"",
+		},
+		{
+			"cycle",
+			api.KVPairs{
+				{Key: "app/a.conf", Value: []byte("@consul:app/b.conf")},
+				{Key: "app/b.conf", Value: []byte("@consul:app/a.conf")},
+			},
+			nil,
+			"reference cycle app/a.conf -> app/b.conf -> app/a.conf",
+		},
+		{
+			"missing",
+			api.KVPairs{
+				{Key: "app/a.conf", Value: []byte("@consul:shared/none")},
+			},
+			nil,
+			"app/a.conf references missing key shared/none",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			lister := &fakeLister{pairs: tc.pairs}
+			p := &Processor{
+				config: config.Config{ResolveReferences: config.Bool(true)},
+				lister: lister,
+			}
+
+			keys, _, _ := lister.List("app/", nil)
+			resolved, err := p.resolveReferences(keys)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			a := make(map[string]string)
+			for _, pair := range resolved {
+				a[pair.Key] = string(pair.Value)
+			}
+			if !reflect.DeepEqual(tc.exp, a) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, a)
+			}
+			if string(tc.pairs[0].Value[:len(referencePrefix)]) != referencePrefix {
+				t.Error("expected the listed value to stay untouched")
+			}
+		})
+	}
+}
+
+type indexLister struct {
+	pairs   api.KVPairs
+	index   uint64
+	queries []*api.QueryOptions
+}
+
+func (l *indexLister) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	l.queries = append(l.queries, q)
+	return l.pairs, &api.QueryMeta{LastIndex: l.index}, nil
+}
+
+func TestProcess_cacheByIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.CacheByIndex = config.Bool(true)
+	c.Finalize()
+
+	lister := &indexLister{
+		pairs: api.KVPairs{{Key: "app/a.conf", Value: []byte("a")}},
+		index: 5,
+	}
+	p := &Processor{
+		config: *c,
+		lister: lister,
+		error:  make(chan error, 1),
+	}
+
+	p.Process()
+	if lister.queries[0] != nil {
+		t.Errorf("expected the first pass not to block, got %#v", lister.queries[0])
+	}
+
+	// Unchanged index: the pass is skipped, a local edit is not reverted.
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.conf"), []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := p.Process(); code != ExitCodeOK {
+		t.Errorf("expected exit code %d, got %d", ExitCodeOK, code)
+	}
+	if q := lister.queries[1]; q == nil || q.WaitIndex != 5 || q.WaitTime != cacheByIndexWait {
+		t.Errorf("expected a short blocking query on index 5, got %#v", q)
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(dir, "a.conf")); string(content) != "local" {
+		t.Errorf("expected the pass to be skipped, got %q", content)
+	}
+
+	// An index reset is a change too.
+	lister.index = 2
+	p.Process()
+	if content, _ := ioutil.ReadFile(filepath.Join(dir, "a.conf")); string(content) != "a" {
+		t.Errorf("expected the pass to run after an index reset, got %q", content)
+	}
+	if p.index != 2 {
+		t.Errorf("expected index 2, got %d", p.index)
+	}
+}
+
+func TestProcess_writeThrottle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.WriteThrottle = config.TimeDuration(20 * time.Millisecond)
+	c.Finalize()
+
+	p := &Processor{
+		config: *c,
+		lister: &fakeLister{pairs: api.KVPairs{
+			{Key: "app/a.conf", Value: []byte("a")},
+			{Key: "app/b.conf", Value: []byte("b")},
+			{Key: "app/c.conf", Value: []byte("c")},
+		}},
+		error: make(chan error, 1),
+	}
+
+	start := time.Now()
+	p.Process()
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("expected writes to be throttled, took %s", elapsed)
+	}
+}
+
+func TestProcess_watermark(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.Watermark = config.Bool(true)
+	c.Finalize()
+
+	keys := api.KVPairs{
+		{Key: "app/a.conf", Value: []byte("a"), ModifyIndex: 5},
+		{Key: "app/b.conf", Value: []byte("b"), ModifyIndex: 7},
+	}
+
+	newProcessor := func() *Processor {
+		return &Processor{
+			config: *c,
+			lister: &fakeLister{pairs: keys},
+			mark:   newWatermark(c),
+			error:  make(chan error, 1),
+		}
+	}
+
+	newProcessor().Process()
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, watermarkFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := "5 app/a.conf\n7 app/b.conf\n"; string(content) != e {
+		t.Errorf("\nexp: %q\nact: %q", e, content)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.conf"), []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keys[1].Value = []byte("changed")
+	keys[1].ModifyIndex = 9
+
+	var read []string
+	readFile = func(name string) ([]byte, error) {
+		read = append(read, filepath.Base(name))
+		return ioutil.ReadFile(name)
+	}
+	defer func() { readFile = ioutil.ReadFile }()
+
+	newProcessor().Process()
+
+	if e := []string{"b.conf"}; !reflect.DeepEqual(e, read) {
+		t.Errorf("expected only the changed key to be read, got %q", read)
+	}
+
+	e := map[string][]byte{
+		"a.conf":          []byte("local"),
+		"b.conf":          []byte("changed"),
+		watermarkFileName: []byte("5 app/a.conf\n9 app/b.conf\n"),
+	}
+	a, err := readTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(e, a) {
+		t.Errorf("\nexp: %#v\nact: %#v", e, a)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, watermarkFileName), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	newProcessor().Process()
+
+	a, err = readTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a["a.conf"]) != "a" {
+		t.Errorf("expected a corrupt watermark to force a full scan, got %q", a["a.conf"])
+	}
+}
+
+func TestFlapDetector(t *testing.T) {
+	cases := []struct {
+		name      string
+		threshold int
+		values    []string
+		exp       bool
+	}{
+		{"single_change", 2, []string{"a", "b"}, false},
+		{"oscillating", 2, []string{"a", "b", "a"}, true},
+		{"oscillating_below_threshold", 3, []string{"a", "b", "a", "b", "a", "b"}, false},
+		{"distinct", 3, []string{"a", "b", "c", "d"}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFlapDetector(&config.Config{
+				FlapThreshold: config.Int(tc.threshold),
+				FlapWindow:    config.TimeDuration(time.Minute),
+				FlapHold:      config.Bool(true),
+			})
+
+			var held bool
+			for _, v := range tc.values {
+				held = f.observe("a.conf", v)
+			}
+			if held != tc.exp {
+				t.Errorf("expected held %t, got %t", tc.exp, held)
+			}
+		})
+	}
+}
+
+func TestProcess_flapHold(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.FlapThreshold = config.Int(2)
+	c.FlapWindow = config.TimeDuration(time.Minute)
+	c.FlapHold = config.Bool(true)
+	c.Finalize()
+
+	now := time.Now()
+	lister := &fakeLister{}
+	p := &Processor{
+		config: *c,
+		lister: lister,
+		flap:   newFlapDetector(c),
+		error:  make(chan error, 1),
+	}
+	p.flap.now = func() time.Time { return now }
+
+	for i, tc := range []struct {
+		value string
+		after time.Duration
+		exp   string
+	}{
+		{"a", 0, "a"},
+		{"b", time.Second, "b"},
+		{"a", time.Second, "b"},
+		{"b", time.Second, "b"},
+		{"c", 2 * time.Minute, "c"},
+	} {
+		now = now.Add(tc.after)
+		lister.pairs = api.KVPairs{{Key: "app/a.conf", Value: []byte(tc.value)}}
+		p.Process()
+
+		content, err := ioutil.ReadFile(filepath.Join(dir, "a.conf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != tc.exp {
+			t.Errorf("pass %d: expected %q, got %q", i, tc.exp, content)
+		}
+	}
+}
+
+func TestSave_fileMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := &Processor{config: config.Config{
+		Perms: config.FileMode(0640),
+		FileModes: &config.FileModeConfigs{
+			&config.FileModeConfig{Pattern: config.String("*.key"), Mode: config.FileMode(0600)},
+		},
+	}}
+
+	cases := []struct {
+		file string
+		mode os.FileMode
+	}{
+		{"server.key", 0600},
+		{"app.conf", 0640},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.file, func(t *testing.T) {
+			path := filepath.Join(dir, tc.file)
+			if err := p.save(path, "content"); err != nil {
+				t.Fatal(err)
+			}
+			stat, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stat.Mode().Perm() != tc.mode {
+				t.Errorf("\nexp: %o\nact: %o", tc.mode, stat.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestSave_dirConflict(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy string
+		force  bool
+		full   bool
+		err    bool
+		file   bool
+	}{
+		{"error", config.DirConflictError, false, false, true, false},
+		{"skip", config.DirConflictSkip, false, false, false, false},
+		{"replace_empty", config.DirConflictReplace, false, false, false, true},
+		{"replace_non_empty", config.DirConflictReplace, false, true, true, false},
+		{"replace_non_empty_force", config.DirConflictReplace, true, true, false, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "app.conf")
+			if err := os.Mkdir(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if tc.full {
+				if err := ioutil.WriteFile(filepath.Join(path, "x"), []byte("x"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c := config.DefaultConfig()
+			c.OnDirConflict = config.String(tc.policy)
+			c.ForceDirReplace = config.Bool(tc.force)
+			c.Finalize()
+			p := &Processor{config: *c}
+
+			if err := p.save(path, "content"); (err != nil) != tc.err {
+				t.Fatalf("expected error %t, got %v", tc.err, err)
+			}
+
+			stat, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if file := stat.Mode().IsRegular(); file != tc.file {
+				t.Errorf("expected a file %t, got mode %s", tc.file, stat.Mode())
+			}
+		})
+	}
+}
+
+func TestCreate_fileMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := &Processor{config: config.Config{
+		FileModes: &config.FileModeConfigs{
+			&config.FileModeConfig{Pattern: config.String("*.key"), Mode: config.FileMode(0600)},
+		},
+	}}
+
+	existing := filepath.Join(dir, "existing.key")
+	if err := ioutil.WriteFile(existing, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{filepath.Join(dir, "new.key"), existing} {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			fo, err := p.create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fo.Close()
+
+			// Nothing has been written yet.
+			stat, err := fo.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stat.Size() != 0 {
+				t.Errorf("expected an empty file, got %d bytes", stat.Size())
+			}
+			if stat.Mode().Perm() != 0600 {
+				t.Errorf("\nexp: %o\nact: %o", 0600, stat.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestAuthLogin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("jwt\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var req authLoginRequest
+	var kvToken string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/acl/login":
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.BearerToken != "jwt" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"AccessorID":"accessor","SecretID":"secret"}`)
+		default:
+			kvToken = r.Header.Get("X-Consul-Token")
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	c := config.DefaultConfig()
+	c.Consul.Address = config.String(ts.URL)
+	c.Consul.AuthMethod = &config.AuthMethodConfig{
+		Name:            config.String("k8s"),
+		BearerTokenFile: config.String(tokenFile),
+		Meta:            map[string]string{"pod": "web-1"},
+	}
+	c.Finalize()
+
+	login, err := newAuthLogin(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, err := newClientSet(c, login.tokenFunc(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := login.login(cl.Consul()); err != nil {
+		t.Fatal(err)
+	}
+
+	e := authLoginRequest{
+		AuthMethod:  "k8s",
+		BearerToken: "jwt",
+		Meta:        map[string]string{"pod": "web-1"},
+	}
+	if !reflect.DeepEqual(e, req) {
+		t.Errorf("\nexp: %#v\nact: %#v", e, req)
+	}
+
+	if _, _, err := cl.Consul().KV().Get("foo", nil); err != nil {
+		t.Fatal(err)
+	}
+	if kvToken != "secret" {
+		t.Errorf("\nexp: %q\nact: %q", "secret", kvToken)
+	}
+
+	if err := ioutil.WriteFile(tokenFile, []byte("wrong"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := login.login(cl.Consul()); err == nil || !strings.Contains(err.Error(), `auth method "k8s" login failed`) {
+		t.Errorf("expected a login failure, got %v", err)
+	}
+}
+
+func TestConnectLeaf(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "web"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fail := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/agent/connect/ca/leaf/web" || fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(&api.LeafCert{
+			SerialNumber:  "01",
+			CertPEM:       string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+			PrivateKeyPEM: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+			ValidAfter:    tmpl.NotBefore,
+			ValidBefore:   tmpl.NotAfter,
+		})
+	}))
+	defer ts.Close()
+
+	c := config.DefaultConfig()
+	c.Consul.Address = config.String(ts.URL)
+	c.Consul.SSL.UseConnectLeaf = config.Bool(true)
+	c.Consul.SSL.ConnectService = config.String("web")
+	c.Finalize()
+
+	leaf := newConnectLeaf(c)
+	cl, err := newClientSet(c, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.fetch(cl.Consul()); err != nil {
+		t.Fatal(err)
+	}
+
+	cert := leaf.certFunc()()
+	if cert == nil || !bytes.Equal(cert.Certificate[0], der) {
+		t.Fatal("expected the leaf certificate to be in use")
+	}
+
+	fail = true
+	if err := leaf.fetch(cl.Consul()); err == nil {
+		t.Fatal("expected the fetch to fail")
+	}
+	if leaf.certificate() != cert {
+		t.Error("expected a failed renewal to keep the current certificate")
+	}
+}
+
+type fakeSession struct {
+	sync.Mutex
+	created int
+	renewed chan struct{}
+}
+
+func (f *fakeSession) Create(*api.SessionEntry, *api.WriteOptions) (string, *api.WriteMeta, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.created++
+	return fmt.Sprintf("session-%d", f.created), nil, nil
+}
+
+func (f *fakeSession) RenewPeriodic(_, _ string, _ *api.WriteOptions, doneCh <-chan struct{}) error {
+	<-doneCh
+	f.renewed <- struct{}{}
+	return nil
+}
+
+type fakeLeaderKV struct {
+	holder string
+}
+
+func (f *fakeLeaderKV) Acquire(pair *api.KVPair, _ *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	if f.holder != "" && f.holder != pair.Session {
+		return false, nil, nil
+	}
+	f.holder = pair.Session
+	return true, nil, nil
+}
+
+func TestLeader(t *testing.T) {
+	session := &fakeSession{renewed: make(chan struct{}, 1)}
+	kv := &fakeLeaderKV{}
+	l := &leader{kv: kv, session: session, key: "service/leader", ttl: "15s"}
+
+	for i := 0; i < 2; i++ {
+		ok, err := l.acquire()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatalf("expected pass %d to hold the lock", i)
+		}
+	}
+	if session.created != 1 {
+		t.Errorf("expected the session to be reused, created %d", session.created)
+	}
+
+	l.stop()
+	select {
+	case <-session.renewed:
+	case <-time.After(time.Second):
+		t.Fatal("expected stop to end the session renewal")
+	}
+
+	// The lock is still held by the first session until it expires.
+	ok, err := l.acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("expected a new session to stand by while the lock is held")
+	}
+	if session.created != 2 {
+		t.Errorf("expected a new session after stop, created %d", session.created)
+	}
+	l.stop()
+}
+
+func TestProcess_standby(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.Finalize()
+
+	p := &Processor{
+		config: *c,
+		lister: &fakeLister{pairs: api.KVPairs{
+			{Key: "app/a.conf", Value: []byte("a")},
+		}},
+		leader: &leader{
+			kv:      &fakeLeaderKV{holder: "other"},
+			session: &fakeSession{renewed: make(chan struct{}, 1)},
+			key:     "service/leader",
+		},
+		error: make(chan error, 1),
+	}
+	defer p.Stop()
+
+	if code := p.Process(); code != ExitCodeStandby {
+		t.Errorf("expected exit code %d, got %d", ExitCodeStandby, code)
+	}
+
+	a, err := readTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 0 {
+		t.Errorf("expected a standby not to write, got %v", a)
+	}
+}
+
+func TestSelfTest_requiresPrefix(t *testing.T) {
+	c := config.DefaultConfig()
+	c.Finalize()
+
+	if err := SelfTest(c); err == nil || !strings.Contains(err.Error(), "self_test_prefix") {
+		t.Errorf("expected missing prefix error, got %v", err)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	var mu sync.Mutex
+	kv := make(map[string][]byte)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		switch r.Method {
+		case "PUT":
+			body, _ := ioutil.ReadAll(r.Body)
+			kv[key] = body
+			fmt.Fprint(w, "true")
+		case "DELETE":
+			delete(kv, key)
+			fmt.Fprint(w, "true")
+		default:
+			var pairs api.KVPairs
+			for k, v := range kv {
+				if strings.HasPrefix(k, key) {
+					pairs = append(pairs, &api.KVPair{Key: k, Value: v})
+				}
+			}
+			if len(pairs) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(pairs)
+		}
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	versionFile := filepath.Join(dir, "version")
+	manifest := filepath.Join(dir, "manifest.json")
+	ran := filepath.Join(dir, "ran")
+
+	cases := []struct {
+		name string
+		c    *config.Config
+	}{
+		{"defaults", &config.Config{}},
+		{"case_transform", &config.Config{CaseTransform: config.String(config.CaseTransformUpper)}},
+		{"default_extension", &config.Config{DefaultExtension: config.String(".txt")}},
+		{"include", &config.Config{Include: []string{"app/*"}}},
+		{"compression", &config.Config{Compression: config.String(config.CompressionGzip)}},
+		{"outputs", &config.Config{
+			VersionFile: config.String(versionFile),
+			Manifest:    config.String(manifest),
+			Commands:    &config.CommandConfigs{{Exec: config.String("touch " + ran)}},
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := config.DefaultConfig().Merge(tc.c)
+			c.Consul.Address = config.String(ts.URL)
+			c.SelfTestPrefix = config.String("smoke/")
+			c.Finalize()
+
+			if err := SelfTest(c); err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(kv) != 0 {
+				t.Errorf("expected probe key to be removed, got %v", kv)
+			}
+
+			for _, file := range []string{versionFile, manifest, ran} {
+				if _, err := os.Stat(file); !os.IsNotExist(err) {
+					t.Errorf("expected the self-test not to touch %s, got %v", file, err)
+				}
+			}
+		})
+	}
+}
+
+type errLister struct{}
+
+func (errLister) List(string, *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	return nil, nil, fmt.Errorf("connection refused")
+}
+
+type countLister struct {
+	calls int
+}
+
+func (l *countLister) List(string, *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	l.calls++
+	return nil, nil, fmt.Errorf("connection refused")
+}
+
+func TestRetryLister_maxDuration(t *testing.T) {
+	retry := &config.RetryConfig{
+		Attempts:    config.Int(0),
+		Backoff:     config.TimeDuration(5 * time.Millisecond),
+		MaxBackoff:  config.TimeDuration(5 * time.Millisecond),
+		MaxDuration: config.TimeDuration(50 * time.Millisecond),
+	}
+	retry.Finalize()
+
+	inner := &countLister{}
+	l := &retryLister{lister: inner, retry: retry.RetryFunc()}
+
+	start := time.Now()
+	if _, _, err := l.List("app/", nil); err == nil {
+		t.Fatal("expected the last error once retries stop")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected retries to stop after max_duration, took %s", elapsed)
+	}
+	if inner.calls < 2 {
+		t.Errorf("expected the list to be retried, got %d calls", inner.calls)
+	}
+}
+
+func TestRetryLister_cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &retryLister{
+		lister: &countLister{},
+		retry:  func(int) (bool, time.Duration) { return true, time.Hour },
+		ctx:    ctx,
+	}
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	start := time.Now()
+	if _, _, err := l.List("app/", nil); err == nil {
+		t.Fatal("expected the last error once cancelled")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected cancel to interrupt the backoff, took %s", elapsed)
+	}
+}
+
+func TestProcess_retryList(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(api.KVPairs{{Key: "app/a.conf", Value: []byte("a")}})
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := config.DefaultConfig()
+	c.Consul.Address = config.String(ts.URL)
+	c.Consul.Retry.Backoff = config.TimeDuration(time.Millisecond)
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.Finalize()
+
+	p, err := NewProcessor(c, false, false, make(chan error, 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("expected 3 requests, got %d", n)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "a.conf")); err != nil || string(b) != "a" {
+		t.Errorf("expected a.conf to be written, got %q %v", b, err)
+	}
+}
+
+func TestProcess_queryTimeout(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+			return
+		}
+		json.NewEncoder(w).Encode(api.KVPairs{{Key: "app/a.conf", Value: []byte("a")}})
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := config.DefaultConfig()
+	c.Consul.Address = config.String(ts.URL)
+	c.Consul.QueryTimeout = config.TimeDuration(50 * time.Millisecond)
+	c.Consul.Retry.Backoff = config.TimeDuration(time.Millisecond)
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.Finalize()
+
+	p, err := NewProcessor(c, false, false, make(chan error, 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	// The hanging request times out and the retry succeeds.
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("expected 2 requests, got %d", n)
+	}
+}
+
+func TestRetryLister_timeout(t *testing.T) {
+	cases := []struct {
+		name   string
+		cancel bool
+		err    string
+	}{
+		{"timeout", false, "processor: listing app/ timed out after 20ms"},
+		{"cancel", true, "context canceled"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			l := &retryLister{
+				lister:  hangingLister{},
+				retry:   func(int) (bool, time.Duration) { return false, 0 },
+				timeout: 20 * time.Millisecond,
+				ctx:     ctx,
+			}
+			if tc.cancel {
+				l.timeout = time.Hour
+				time.AfterFunc(10*time.Millisecond, cancel)
+			}
+
+			_, _, err := l.List("app/", nil)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+// hangingLister waits for the context of the query to be done.
+type hangingLister struct{}
+
+func (hangingLister) List(_ string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	<-q.Context().Done()
+	return nil, nil, q.Context().Err()
+}
+
+func TestInteractive(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(api.KVPairs{
+			{Key: "app/a.conf", Value: []byte("a")},
+			{Key: "app/b.conf", Value: []byte("b")},
+		})
+	}))
+	defer ts.Close()
+
+	cases := []struct {
+		name    string
+		answer  string
+		applied bool
+	}{
+		{"yes", "y\n", true},
+		{"no", "n\n", false},
+		{"eof", "", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c := config.DefaultConfig()
+			c.Consul.Address = config.String(ts.URL)
+			c.From = config.String("app/")
+			c.To = config.String(filepath.Join(dir, "out"))
+			c.Finalize()
+
+			var out bytes.Buffer
+			if err := Interactive(c, strings.NewReader(tc.answer), &out); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out.String(), "write "+filepath.Join(dir, "out", "a.conf")+" (1 bytes)") {
+				t.Errorf("expected the plan in:\n%s", out.String())
+			}
+
+			a, err := readTree(*c.To)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if applied := len(a) == 2; applied != tc.applied {
+				t.Errorf("expected applied to be %t, got %#v", tc.applied, a)
+			}
+		})
+	}
+}
+
+func TestProcess_errorWithoutListener(t *testing.T) {
+	c := config.DefaultConfig()
+	c.Finalize()
+
+	p := &Processor{
+		config: *c,
+		lister: errLister{},
+		error:  make(chan error),
+	}
+
+	doneCh := make(chan int, 1)
+	go func() {
+		doneCh <- p.Process()
+	}()
+
+	select {
+	case code := <-doneCh:
+		if code != ExitCodeError {
+			t.Errorf("\nexp: %d\nact: %d", ExitCodeError, code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("processor blocked sending an error nobody reads")
+	}
+}
+
+func TestProcess_quietSkips(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.QuietSkips = config.Bool(true)
+	c.Finalize()
+
+	p := &Processor{
+		config: *c,
+		lister: &fakeLister{pairs: api.KVPairs{
+			{Key: "app/a.conf", Value: []byte("a")},
+			{Key: "app/b.conf", Value: []byte("b")},
+		}},
+		error: make(chan error, 1),
+	}
+	p.Process()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	p.Process()
+
+	out := buf.String()
+	if strings.Contains(out, "[INFO] (processor) Skipping") {
+		t.Errorf("expected per-key skip logs at DEBUG only, got:\n%s", out)
+	}
+	if !strings.Contains(out, "skipped 2 unchanged keys") {
+		t.Errorf("expected an aggregate skip line, got:\n%s", out)
+	}
+}
+
+func TestProcess_summary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.conf"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.Finalize()
+
+	p := &Processor{
+		config: *c,
+		lister: &fakeLister{pairs: api.KVPairs{
+			{Key: "app/a.conf", Value: []byte("a")},
+			{Key: "app/b.conf", Value: bytes.Repeat([]byte("b"), 2048)},
+		}},
+		error: make(chan error, 1),
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	p.Process()
+
+	if out := buf.String(); !strings.Contains(out, "[INFO] (processor) processed 2 keys, wrote 1, skipped 1, 2.0KB written in ") {
+		t.Errorf("expected a summary line, got:\n%s", out)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	cases := []struct {
+		n int
+		e string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1024, "1.0KB"},
+		{12698, "12.4KB"},
+		{5 << 20, "5.0MB"},
+		{1 << 30, "1.0GB"},
+	}
+
+	for _, tc := range cases {
+		if a := formatBytes(tc.n); a != tc.e {
+			t.Errorf("formatBytes(%d): expected %q, got %q", tc.n, tc.e, a)
+		}
+	}
+}
+
+func TestProcess_redact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.Redact = []string{"app/*.key", "*/password"}
+	// The file of app/password is renamed, the pattern still matches its key.
+	c.DefaultExtension = config.String(".txt")
+	c.Finalize()
+
+	p := &Processor{
+		config: *c,
+		lister: &fakeLister{pairs: api.KVPairs{
+			{Key: "app/tls.key", Value: []byte("s3cr3t-value")},
+			{Key: "app/password", Value: []byte("hunter2-value")},
+			{Key: "app/app.conf", Value: []byte("plain-value")},
+		}},
+		error: make(chan error, 1),
+		done:  make(chan bool, 1),
+		dry:   true,
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	p.Process()
+
+	out := buf.String()
+	if strings.Contains(out, "s3cr3t-value") {
+		t.Errorf("expected app/tls.key to be redacted, got:\n%s", out)
+	}
+	if strings.Contains(out, "hunter2-value") {
+		t.Errorf("expected app/password to be redacted, got:\n%s", out)
+	}
+	if !strings.Contains(out, "<redacted, 12 bytes, sha256:") {
+		t.Errorf("expected a redaction marker, got:\n%s", out)
+	}
+	if !strings.Contains(out, "plain-value") {
+		t.Errorf("expected app.conf to be logged, got:\n%s", out)
+	}
+}
+
+func TestProcess_valueFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.ValueFilters = &config.ValueFilterConfigs{
+		{Pattern: config.String("*.txt"), Command: []string{"tr", "a-z", "A-Z"}},
+		{Pattern: config.String("*.fail"), Command: []string{"false"}},
+		{Pattern: config.String("*.slow"), Command: []string{"sleep", "5"}, Timeout: config.TimeDuration(100 * time.Millisecond)},
+	}
+	c.Finalize()
+
+	p := &Processor{
+		config: *c,
+		lister: &fakeLister{pairs: api.KVPairs{
+			{Key: "app/a.txt", Value: []byte("hello")},
+			{Key: "app/b.fail", Value: []byte("dropped")},
+			{Key: "app/c.slow", Value: []byte("dropped")},
+			{Key: "app/d.conf", Value: []byte("plain")},
+		}},
+		error: make(chan error, 1),
+		done:  make(chan bool, 1),
+		once:  true,
+	}
+
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+	}
+
+	cases := []struct {
+		file string
+		exp  string
+	}{
+		{"a.txt", "HELLO"},
+		{"d.conf", "plain"},
+	}
+	for _, tc := range cases {
+		b, err := ioutil.ReadFile(filepath.Join(dir, tc.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tc.exp {
+			t.Errorf("%s: expected %q, got %q", tc.file, tc.exp, b)
+		}
+	}
+
+	for _, file := range []string{"b.fail", "c.slow"} {
+		if _, err := os.Stat(filepath.Join(dir, file)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be skipped, got %v", file, err)
+		}
+	}
+}
+
+func TestValidate_vault(t *testing.T) {
+	cases := []struct {
+		name   string
+		config *config.Config
+		err    bool
+	}{
+		{
+			"defaults",
+			&config.Config{},
+			false,
+		},
+		{
+			"no_path",
+			&config.Config{From: config.String("/")},
+			true,
+		},
+		{
+			"kv_version",
+			&config.Config{Vault: &config.VaultConfig{KVVersion: config.Int(3)}},
+			true,
+		},
+		{
+			"push",
+			&config.Config{Push: config.Bool(true)},
+			true,
+		},
+		{
+			"watch",
+			&config.Config{Watch: config.Bool(true)},
+			true,
+		},
+		{
+			"cache_by_index",
+			&config.Config{CacheByIndex: config.Bool(true)},
+			true,
+		},
+		{
+			"watermark",
+			&config.Config{Watermark: config.Bool(true)},
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := config.DefaultConfig().Merge(&config.Config{From: config.String("secret/app/")}).Merge(tc.config)
+			c.Finalize()
+
+			p := &Processor{config: *c, vault: true}
+			if err := p.validate(); (err != nil) != tc.err {
+				t.Errorf("expected error %t, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestProcess_vault(t *testing.T) {
+	secrets := map[string]string{
+		"secret/data/app/db":         `{"data":{"data":{"value":"abcd"}}}`,
+		"secret/data/app/nested/api": `{"data":{"data":{"user":"u","port":8080}}}`,
+		"kv/app/db":                  `{"data":{"value":"abcd"}}`,
+		"kv/app/nested/api":          `{"data":{"user":"u","port":8080}}`,
+	}
+	listings := map[string]string{
+		"secret/metadata/app":        `{"data":{"keys":["db","gone","nested/"]}}`,
+		"secret/metadata/app/nested": `{"data":{"keys":["api"]}}`,
+		"kv/app":                     `{"data":{"keys":["db","gone","nested/"]}}`,
+		"kv/app/nested":              `{"data":{"keys":["api"]}}`,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/v1/")
+		body, ok := secrets[path]
+		if r.Method == "LIST" {
+			body, ok = listings[path]
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	clients := client.NewClientSet()
+	if err := clients.CreateVaultClient(&client.CreateVaultClientInput{Address: ts.URL}); err != nil {
+		t.Fatal(err)
+	}
+	defer clients.Stop()
+
+	for _, tc := range []struct {
+		from      string
+		kvVersion int
+	}{
+		{"secret/app/", 2},
+		{"kv/app/", 1},
+	} {
+		t.Run(tc.from, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c := config.DefaultConfig()
+			c.From = config.String(tc.from)
+			c.To = config.String(dir)
+			c.PreserveStructure = config.Bool(true)
+			c.Finalize()
+
+			p := &Processor{
+				config: *c,
+				lister: &vaultLister{client: clients.Vault(), kvVersion: tc.kvVersion},
+				error:  make(chan error, 1),
+				done:   make(chan bool, 1),
+				once:   true,
+				vault:  true,
+			}
+
+			if code := p.Process(); code != ExitCodeOK {
+				t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+			}
+
+			cases := []struct {
+				file string
+				exp  string
+			}{
+				{"db", "abcd"},
+				{"nested/api", `{"port":8080,"user":"u"}`},
+			}
+			for _, f := range cases {
+				b, err := ioutil.ReadFile(filepath.Join(dir, f.file))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(b) != f.exp {
+					t.Errorf("%s: expected %q, got %q", f.file, f.exp, b)
+				}
+			}
+
+			if _, err := os.Stat(filepath.Join(dir, "gone")); !os.IsNotExist(err) {
+				t.Errorf("expected the deleted secret to be skipped, got %v", err)
+			}
+		})
+	}
+}
+
+func TestProcess_compression(t *testing.T) {
+	var gz, zl bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("gzipped"))
+	w.Close()
+	zw := zlib.NewWriter(&zl)
+	zw.Write([]byte("deflated"))
+	zw.Close()
+
+	cases := []struct {
+		name        string
+		compression string
+		value       []byte
+		exp         string
+		err         bool
+	}{
+		{"gzip", config.CompressionGzip, gz.Bytes(), "gzipped", false},
+		{"deflate", config.CompressionDeflate, zl.Bytes(), "deflated", false},
+		{"gzip_plain", config.CompressionGzip, []byte("plain"), "", true},
+		{"deflate_gzip", config.CompressionDeflate, gz.Bytes(), "", true},
+		{"none", config.CompressionNone, []byte("plain"), "plain", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c := config.DefaultConfig()
+			c.From = config.String("app/")
+			c.To = config.String(dir)
+			c.Compression = config.String(tc.compression)
+			c.Finalize()
+
+			p := &Processor{
+				config: *c,
+				lister: &fakeLister{pairs: api.KVPairs{
+					{Key: "app/"},
+					{Key: "app/a.json", Value: tc.value},
+				}},
+				error: make(chan error, 1),
+				done:  make(chan bool, 1),
+				once:  true,
+			}
+
+			code := p.Process()
+			if tc.err {
+				if code != ExitCodeError {
+					t.Fatalf("expected exit code %d, got %d", ExitCodeError, code)
+				}
+				select {
+				case err := <-p.error:
+					if !strings.Contains(err.Error(), "app/a.json is not "+tc.compression+" compressed") {
+						t.Errorf("unexpected error: %s", err)
+					}
+				default:
+					t.Error("expected an error on the error channel")
+				}
+				if _, err := os.Stat(filepath.Join(dir, "a.json")); !os.IsNotExist(err) {
+					t.Errorf("expected no file, got %v", err)
+				}
+				return
+			}
+
+			if code != ExitCodeOK {
+				t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+			}
+			b, err := ioutil.ReadFile(filepath.Join(dir, "a.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tc.exp {
+				t.Errorf("expected %q, got %q", tc.exp, b)
+			}
+		})
+	}
+}
+
+func TestProcess_concurrency(t *testing.T) {
+	cases := []struct {
+		name         string
+		onWriteError string
+		code         int
+	}{
+		{"ok", "", ExitCodeOK},
+		{"abort", config.OnWriteErrorAbort, ExitCodeError},
+		{"continue", config.OnWriteErrorContinue, ExitCodeError},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			var pairs api.KVPairs
+			for i := 0; i < 50; i++ {
+				pairs = append(pairs, &api.KVPair{Key: fmt.Sprintf("app/%02d", i), Value: []byte(fmt.Sprint(i))})
+			}
+			if tc.onWriteError != "" {
+				// A non-empty directory in the way fails the write of 25.
+				if err := os.MkdirAll(filepath.Join(dir, "25", "x"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c := config.DefaultConfig()
+			c.From = config.String("app/")
+			c.To = config.String(dir)
+			c.Concurrency = config.Int(8)
+			if tc.onWriteError != "" {
+				c.OnWriteError = config.String(tc.onWriteError)
+				c.OnDirConflict = config.String(config.DirConflictReplace)
+			}
+			c.Finalize()
+
+			p := &Processor{
+				config: *c,
+				lister: &fakeLister{pairs: pairs},
+				error:  make(chan error, 1),
+				done:   make(chan bool, 1),
+				once:   true,
+			}
+
+			if code := p.Process(); code != tc.code {
+				t.Fatalf("expected exit code %d, got %d", tc.code, code)
+			}
+
+			if tc.code != ExitCodeOK {
+				select {
+				case err := <-p.error:
+					if !strings.Contains(err.Error(), "25") {
+						t.Errorf("expected the error to name 25, got %s", err)
+					}
+				default:
+					t.Error("expected an error on the error channel")
+				}
+			}
+
+			if tc.onWriteError == config.OnWriteErrorAbort {
+				return
+			}
+
+			for i := 0; i < 50; i++ {
+				if i == 25 && tc.onWriteError != "" {
+					continue
+				}
+				b, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("%02d", i)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(b) != fmt.Sprint(i) {
+					t.Errorf("%02d: expected %d, got %q", i, i, b)
+				}
+			}
+			if tc.code == ExitCodeOK && p.stats.Written != 50 {
+				t.Errorf("expected 50 writes, got %d", p.stats.Written)
+			}
+		})
+	}
+}
+
+func TestProcess_result(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "b.conf"), []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.Finalize()
+
+	p := &Processor{
+		config: *c,
+		lister: &fakeLister{pairs: api.KVPairs{
+			{Key: "app/.ignore"},
+			{Key: "app/a.conf", Value: []byte("a")},
+			{Key: "app/b.conf", Value: []byte("b")},
+		}},
+		error: make(chan error, 1),
+	}
+
+	cases := [][]KeyResult{
+		{
+			{Key: "app/.ignore", Decision: "skip", Reason: "ignored by a .ignore marker"},
+			{Key: "app/a.conf", Path: filepath.Join(dir, "a.conf"), Decision: "write", Reason: "file missing"},
+			{Key: "app/b.conf", Path: filepath.Join(dir, "b.conf"), Decision: "skip", Reason: "content unchanged"},
+		},
+		{
+			{Key: "app/.ignore", Decision: "skip", Reason: "ignored by a .ignore marker"},
+			{Key: "app/a.conf", Path: filepath.Join(dir, "a.conf"), Decision: "skip", Reason: "content unchanged"},
+			{Key: "app/b.conf", Path: filepath.Join(dir, "b.conf"), Decision: "skip", Reason: "content unchanged"},
+		},
+	}
+	for i, e := range cases {
+		if code := p.Process(); code != ExitCodeOK {
+			t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+		}
+		if a := p.Result().Keys; !reflect.DeepEqual(e, a) {
+			t.Errorf("pass %d\nexp: %#v\nact: %#v", i, e, a)
+		}
+	}
+}
+
+func TestQuiescence(t *testing.T) {
+	a := api.KVPairs{{Key: "app/a", Value: []byte("1")}}
+	b := api.KVPairs{{Key: "app/a", Value: []byte("2")}}
+	c := api.KVPairs{{Key: "app/a", Value: []byte("3")}}
+
+	now := time.Now()
+	q := &quiescence{min: 2 * time.Second, max: 5 * time.Second, now: func() time.Time { return now }}
+
+	steps := []struct {
+		after time.Duration
+		keys  api.KVPairs
+		exp   time.Duration
+	}{
+		// The first pass is written right away.
+		{0, a, 0},
+		{time.Second, a, 0},
+		// A change waits min, every further change restarts it.
+		{0, b, 2 * time.Second},
+		{time.Second, b, time.Second},
+		{0, c, 2 * time.Second},
+		{1500 * time.Millisecond, b, 2 * time.Second},
+		// max since the first change caps the wait.
+		{2 * time.Second, c, 500 * time.Millisecond},
+		{500 * time.Millisecond, c, 0},
+		// Back to what was written.
+		{0, c, 0},
+		{0, b, 2 * time.Second},
+		{0, c, 0},
+	}
+
+	for i, step := range steps {
+		now = now.Add(step.after)
+		d := q.wait(step.keys)
+		if d != step.exp {
+			t.Errorf("step %d: expected %s, got %s", i, step.exp, d)
+		}
+		if d == 0 {
+			q.apply()
+		}
+	}
+}
+
+func TestProcess_wait(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.Wait = &config.WaitConfig{Min: config.TimeDuration(2 * time.Second)}
+	c.Finalize()
+
+	lister := &fakeLister{pairs: api.KVPairs{{Key: "app/a.conf", Value: []byte("1")}}}
+	p := &Processor{
+		config: *c,
+		lister: lister,
+		quiet:  newQuiescence(c, false, false),
+		error:  make(chan error, 1),
+	}
+	now := time.Now()
+	p.quiet.now = func() time.Time { return now }
+
+	steps := []struct {
+		after  time.Duration
+		value  string
+		settle time.Duration
+		file   string
+	}{
+		{0, "1", 0, "1"},
+		{0, "2", 2 * time.Second, "1"},
+		{time.Second, "3", 2 * time.Second, "1"},
+		{2 * time.Second, "3", 0, "3"},
+	}
+
+	for i, step := range steps {
+		now = now.Add(step.after)
+		lister.pairs[0].Value = []byte(step.value)
+		if code := p.Process(); code != ExitCodeOK {
+			t.Fatalf("step %d: expected exit code %d, got %d", i, ExitCodeOK, code)
+		}
+		if p.Wait() != step.settle {
+			t.Errorf("step %d: expected to wait %s, got %s", i, step.settle, p.Wait())
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, "a.conf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != step.file {
+			t.Errorf("step %d: expected %q, got %q", i, step.file, b)
+		}
+	}
+
+	if newQuiescence(c, true, false) != nil || newQuiescence(c, false, true) != nil {
+		t.Error("expected once and dry passes not to wait")
+	}
+}
+
+func TestProcess_sync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pairs := api.KVPairs{
+		{Key: "app/a", Value: []byte("1"), ModifyIndex: 1},
+		{Key: "web/b", Value: []byte("2"), ModifyIndex: 1},
+		{Key: "other/c", Value: []byte("3"), ModifyIndex: 1},
+	}
+
+	c := config.DefaultConfig()
+	c.Syncs = &config.SyncConfigs{
+		{From: config.String("app/"), To: config.String(filepath.Join(dir, "app"))},
+		{From: config.String("web/"), To: config.String(filepath.Join(dir, "web")), Perms: config.FileMode(0600)},
+	}
+	c.Finalize()
+
+	p := &Processor{
+		config: *c,
+		lister: &fakeLister{pairs: pairs},
+		error:  make(chan error, 1),
+	}
+	if err := p.validate(); err != nil {
+		t.Fatal(err)
+	}
+	p.syncs = p.newSyncs()
+	for _, m := range p.mappings() {
+		m.init()
+	}
+
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+	}
+
+	for path, e := range map[string]string{"app/a": "1", "web/b": "2"} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != e {
+			t.Errorf("expected %s to contain %q, got %q", path, e, content)
+		}
+	}
+
+	if info, err := os.Stat(filepath.Join(dir, "web", "b")); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("expected web/b to have mode 0600, got %s", info.Mode().Perm())
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "c")); !os.IsNotExist(err) {
+		t.Errorf("expected keys outside the sync prefixes to be ignored, got %v", err)
+	}
+
+	if s := p.Stats(); s.Keys != 2 || s.Written != 2 {
+		t.Errorf("expected 2 keys and 2 writes, got %#v", s)
+	}
+
+	if r := p.Result(); len(r.Keys) != 2 {
+		t.Errorf("expected a result per mapped key, got %#v", r.Keys)
+	}
+
+	// The pass is only empty when every mapping lists nothing.
+	p.syncs[1].lister = &fakeLister{}
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+	}
+	p.syncs[0].lister = &fakeLister{}
+	if code := p.Process(); code != ExitCodeEmpty {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeEmpty, code)
+	}
+}
+
+func TestProcess_syncInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pairs := api.KVPairs{
+		{Key: "app/a", Value: []byte("1"), ModifyIndex: 1},
+		{Key: "web/b", Value: []byte("2"), ModifyIndex: 1},
+	}
+
+	c := config.DefaultConfig()
+	c.Syncs = &config.SyncConfigs{
+		{From: config.String("app/"), To: config.String(filepath.Join(dir, "app")), Interval: config.TimeDuration(time.Hour)},
+		{From: config.String("web/"), To: config.String(filepath.Join(dir, "web"))},
+	}
+	c.Finalize()
+
+	p := &Processor{
+		config: *c,
+		lister: &fakeLister{pairs: pairs},
+		error:  make(chan error, 1),
+	}
+	if err := p.validate(); err != nil {
+		t.Fatal(err)
+	}
+	p.syncs = p.newSyncs()
+	for _, m := range p.mappings() {
+		m.init()
+	}
+
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+	}
+	if s := p.Stats(); s.Written != 2 {
+		t.Errorf("expected both mappings to run, got %#v", s)
+	}
+
+	d, ok := p.Next()
+	if !ok {
+		t.Fatal("expected the passes to be scheduled")
+	}
+	if d <= 0 || d > config.TimeDurationVal(c.Interval) {
+		t.Errorf("expected the next pass within %s, got %s", config.TimeDurationVal(c.Interval), d)
+	}
+
+	// Nothing is due right after a pass.
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+	}
+
+	// Once web is due again only it runs; app waits for its hour.
+	due := p.syncs[0].due
+	p.syncs[1].lister = &fakeLister{}
+	p.syncs[1].due = time.Time{}
+	if code := p.Process(); code != ExitCodeEmpty {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeEmpty, code)
+	}
+	if !p.syncs[0].due.Equal(due) {
+		t.Errorf("expected app to stay scheduled at %s, got %s", due, p.syncs[0].due)
+	}
+	if d, _ := p.Next(); d <= 0 {
+		t.Errorf("expected nothing to be due, got %s", d)
+	}
+}
+
+func TestProcess_template(t *testing.T) {
+	cases := []struct {
+		name   string
+		delims []string
+		value  string
+		exp    string
+		err    string
+	}{
+		{"env", nil, `region={{ env "REGION" }}`, "region=eu", ""},
+		{"missing_env", nil, `region={{ env "ZONE" }}`, "region=", ""},
+		{"key", nil, `db={{ key "app/db" }}`, "db=localhost", ""},
+		{"missing_key", nil, `db={{ key "app/other" }}`, "", "no key app/other"},
+		{"parse", nil, `{{ env "REGION" `, "", "template app/a.conf"},
+		{"plain", nil, "plain", "plain", ""},
+		{"delims", []string{"[[", "]]"}, `region=[[ env "REGION" ]] name={{ .Name }}`, "region=eu name={{ .Name }}", ""},
+		{"delims_parse", []string{"[[", "]]"}, `[[ env "REGION" `, "", "template app/a.conf"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c := config.DefaultConfig()
+			c.From = config.String("app/")
+			c.To = config.String(dir)
+			c.Template = config.Bool(true)
+			c.Env = &config.EnvConfig{Pristine: config.Bool(true), Custom: []string{"REGION=eu"}}
+			if tc.delims != nil {
+				c.TemplateLeftDelim, c.TemplateRightDelim = config.String(tc.delims[0]), config.String(tc.delims[1])
+			}
+			c.Finalize()
+
+			p := &Processor{
+				config: *c,
+				lister: &fakeLister{pairs: api.KVPairs{
+					{Key: "app/a.conf", Value: []byte(tc.value)},
+					{Key: "app/db", Value: []byte("localhost")},
+				}},
+				error: make(chan error, 1),
+			}
+
+			code := p.Process()
+			if tc.err != "" {
+				if code != ExitCodeError {
+					t.Fatalf("expected exit code %d, got %d", ExitCodeError, code)
+				}
+				select {
+				case err := <-p.error:
+					if !strings.Contains(err.Error(), tc.err) {
+						t.Errorf("expected the error to contain %q, got %s", tc.err, err)
+					}
+				default:
+					t.Error("expected an error on the error channel")
+				}
+				if _, err := os.Stat(filepath.Join(dir, "a.conf")); !os.IsNotExist(err) {
+					t.Errorf("expected the raw template not to be written, got %v", err)
+				}
+				return
+			}
+
+			if code != ExitCodeOK {
+				t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+			}
+			b, err := ioutil.ReadFile(filepath.Join(dir, "a.conf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tc.exp {
+				t.Errorf("expected %q, got %q", tc.exp, b)
+			}
+		})
+	}
+
+	t.Run("rerender", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		c := config.DefaultConfig()
+		c.From = config.String("app/")
+		c.To = config.String(dir)
+		c.Template = config.Bool(true)
+		c.Env = &config.EnvConfig{Pristine: config.Bool(true), Custom: []string{"REGION=eu"}}
+		c.Finalize()
+
+		p := &Processor{
+			config: *c,
+			lister: &fakeLister{pairs: api.KVPairs{{Key: "app/a.conf", Value: []byte(`{{ env "REGION" }}`)}}},
+			error:  make(chan error, 1),
+		}
+
+		for _, region := range []string{"eu", "eu", "us"} {
+			p.config.Env.Custom = []string{"REGION=" + region}
+			if code := p.Process(); code != ExitCodeOK {
+				t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+			}
+			b, err := ioutil.ReadFile(filepath.Join(dir, "a.conf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != region {
+				t.Errorf("expected %q, got %q", region, b)
+			}
+		}
+
+		// The unchanged environment of the second pass must not rewrite.
+		if s := p.Stats(); s.Written != 2 {
+			t.Errorf("expected 2 writes, got %d", s.Written)
+		}
+	})
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b string
+		exp  string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{
+			"change",
+			"a\nb\nc\n",
+			"a\nB\nc\n",
+			"--- f\n+++ f\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			"append",
+			"a\n",
+			"a\nb\n",
+			"--- f\n+++ f\n@@ -1 +1,2 @@\n a\n+b\n",
+		},
+		{
+			"from_empty",
+			"",
+			"a\n",
+			"--- f\n+++ f\n@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			"no_newline",
+			"a\nb",
+			"a\nb\n",
+			"--- f\n+++ f\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			"hunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			"0\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n13\n",
+			"--- f\n+++ f\n@@ -1,4 +1,4 @@\n-1\n+0\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+13\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if act := unifiedDiff("f", splitLines(tc.a), splitLines(tc.b)); act != tc.exp {
+				t.Errorf("\nexp:\n%s\nact:\n%s", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestProcess_dryDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.conf"), []byte("host=a\nport=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	for i := 0; i < 15; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.Finalize()
+
+	p := &Processor{
+		config: *c,
+		lister: &fakeLister{pairs: api.KVPairs{
+			{Key: "app/a.conf", Value: []byte("host=a\nport=2\n")},
+			{Key: "app/b.conf", Value: []byte(strings.Join(lines, "\n") + "\n")},
+		}},
+		error: make(chan error, 1),
+		done:  make(chan bool, 1),
+		dry:   true,
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	p.Process()
+
+	out := buf.String()
+	for _, e := range []string{
+		"File " + filepath.Join(dir, "a.conf") + " will be changed:",
+		"@@ -1,2 +1,2 @@\n host=a\n-port=1\n+port=2\n",
+		"File " + filepath.Join(dir, "b.conf") + " (new file):\nline 0\n",
+		"line 9\n... (5 more lines)\n",
+	} {
+		if !strings.Contains(out, e) {
+			t.Errorf("expected the log to contain %q, got:\n%s", e, out)
+		}
+	}
+	if strings.Contains(out, "line 10") {
+		t.Errorf("expected only the first lines of a new file, got:\n%s", out)
+	}
+}
+
+func TestValidate_destination(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		config *config.Config
+		err    bool
+	}{
+		{"existing", &config.Config{To: config.String(dir)}, false},
+		{"missing", &config.Config{To: config.String(filepath.Join(dir, "a", "b"))}, false},
+		{"file", &config.Config{To: config.String(file)}, true},
+		{"below_file", &config.Config{To: config.String(filepath.Join(file, "a"))}, true},
+		{"archive", &config.Config{To: config.String(file), Archive: config.String(filepath.Join(dir, "out.tar.gz"))}, false},
+		{"push", &config.Config{To: config.String(file), Push: config.Bool(true)}, false},
+		{"sync", &config.Config{Syncs: &config.SyncConfigs{
+			{From: config.String("a/"), To: config.String(dir)},
+			{From: config.String("b/"), To: config.String(file)},
+		}}, true},
+		{"invalid", &config.Config{To: config.String(dir), Concurrency: config.Int(0)}, true},
+		{"single", &config.Config{To: config.String(file), From: config.String("app/db"), Single: config.Bool(true)}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := config.DefaultConfig().Merge(tc.config)
+			c.Finalize()
+
+			if err := Validate(c); (err != nil) != tc.err {
+				t.Errorf("expected error %t, got %v", tc.err, err)
+			}
+		})
+	}
+
+	if entries, err := ioutil.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("expected validate to leave no files behind, got %v %v", entries, err)
+	}
+}
+
+func TestProcess_includeExclude(t *testing.T) {
+	cases := []struct {
+		name    string
+		include []string
+		exclude []string
+		e       []string
+	}{
+		{"none", nil, nil, []string{"a", "b", "c"}},
+		{"include", []string{"app/web/*"}, nil, []string{"a"}},
+		{"exclude", nil, []string{"*/secrets/*"}, []string{"a", "c"}},
+		{"both", []string{"app/web/*", "app/secrets/*"}, []string{"*/secrets/*"}, []string{"a"}},
+		{"no_match", []string{"other/*"}, nil, nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c := config.DefaultConfig()
+			c.From = config.String("app/")
+			c.To = config.String(dir)
+			c.Include = tc.include
+			c.Exclude = tc.exclude
+			c.Finalize()
+
+			p := &Processor{
+				config: *c,
+				lister: &fakeLister{pairs: api.KVPairs{
+					{Key: "app/web/a", Value: []byte("a")},
+					{Key: "app/secrets/b", Value: []byte("b")},
+					{Key: "app/db/c", Value: []byte("c")},
+				}},
+				error: make(chan error, 1),
+			}
+			p.Process()
+
+			entries, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			if !reflect.DeepEqual(tc.e, names) {
+				t.Errorf("\nexp: %q\nact: %q", tc.e, names)
+			}
+		})
+	}
+}
+
+func TestListOptions(t *testing.T) {
+	stale := &config.ConsulConfig{Consistency: config.String(config.ConsistencyStale)}
+	consistent := &config.ConsulConfig{Consistency: config.String(config.ConsistencyConsistent)}
+
+	cases := []struct {
+		name       string
+		c          *config.Config
+		index      uint64
+		wait       uint64
+		stale      bool
+		consistent bool
+	}{
+		{"first", &config.Config{}, 0, 0, false, false},
+		{"watch", &config.Config{Watch: config.Bool(true)}, 5, 5, false, false},
+		{"cache_by_index", &config.Config{CacheByIndex: config.Bool(true)}, 5, 5, false, false},
+		{"poll", &config.Config{}, 5, 0, false, false},
+		{"stale", &config.Config{Consul: stale}, 5, 0, true, false},
+		{"stale_watch", &config.Config{Consul: stale, Watch: config.Bool(true)}, 5, 5, true, false},
+		{"consistent", &config.Config{Consul: consistent}, 0, 0, false, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := config.DefaultConfig().Merge(tc.c)
+			c.Consul.Datacenter = config.String("dc2")
+			c.Finalize()
+
+			p := &Processor{config: *c, index: tc.index}
+			q := p.listOptions()
+			if q.Datacenter != "dc2" {
+				t.Errorf("expected datacenter %q, got %q", "dc2", q.Datacenter)
+			}
+			if q.WaitIndex != tc.wait {
+				t.Errorf("expected wait index %d, got %d", tc.wait, q.WaitIndex)
+			}
+			if q.AllowStale != tc.stale || q.RequireConsistent != tc.consistent {
+				t.Errorf("expected stale %t and consistent %t, got %t and %t",
+					tc.stale, tc.consistent, q.AllowStale, q.RequireConsistent)
+			}
+		})
+	}
+}
+
+func TestProcessor_reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(filepath.Join(dir, "a"))
+	c.Finalize()
+
+	p := &Processor{
+		lister: &fakeLister{pairs: api.KVPairs{{Key: "app/x.conf", Value: []byte("x"), ModifyIndex: 7}}},
+		error:  make(chan error, 1),
+	}
+	if err := p.configure(c); err != nil {
+		t.Fatal(err)
+	}
+	p.index = 7
+
+	invalid := c.Copy()
+	invalid.To = config.String(filepath.Join(dir, "b"))
+	invalid.OnMissingPrefix = config.String("explode")
+	if err := p.Reload(invalid); err == nil {
+		t.Fatal("expected an invalid config to be rejected")
+	}
+	if to := config.StringVal(p.config.To); to != filepath.Join(dir, "a") || p.index != 7 {
+		t.Fatalf("expected a failed reload to keep the processor, got to %s and index %d", to, p.index)
+	}
+
+	reloaded := c.Copy()
+	reloaded.To = config.String(filepath.Join(dir, "b"))
+	if err := p.Reload(reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if p.index != 0 {
+		t.Errorf("expected the index to be reset, got %d", p.index)
+	}
+
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b", "x.conf")); err != nil {
+		t.Errorf("expected the reloaded destination to be written: %s", err)
+	}
+}
+
+func TestSameConnection(t *testing.T) {
+	cases := []struct {
+		name   string
+		change func(*config.Config)
+		same   bool
+	}{
+		{"to", func(c *config.Config) { c.To = config.String("/tmp/other") }, true},
+		{"interval", func(c *config.Config) { c.Interval = config.TimeDuration(time.Hour) }, true},
+		{"vault_unused", func(c *config.Config) { c.Vault.Address = config.String("vault:8200") }, true},
+		{"address", func(c *config.Config) { c.Consul.Address = config.String("consul:8500") }, false},
+		{"token", func(c *config.Config) { c.Consul.Token = config.String("secret") }, false},
+		{"ssl", func(c *config.Config) { c.Consul.SSL.Enabled = config.Bool(true) }, false},
+		{"transport", func(c *config.Config) { c.Consul.Transport.MaxIdleConns = config.Int(3) }, false},
+		{"vault_from", func(c *config.Config) { c.From = config.String("vault://secret/app") }, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := config.DefaultConfig()
+			a.Finalize()
+			b := config.DefaultConfig()
+			b.Finalize()
+			tc.change(b)
+
+			if same := SameConnection(a, b); same != tc.same {
+				t.Errorf("expected %t, got %t", tc.same, same)
+			}
+		})
+	}
+}
+
+func TestProcess_detailedExitCode(t *testing.T) {
+	cases := []struct {
+		name     string
+		detailed bool
+		current  string
+		drift    int
+	}{
+		{"unchanged", true, "a", 0},
+		{"changed", true, "old", 1},
+		{"missing", true, "", 1},
+		{"not_detailed", false, "old", 1},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			if tc.current != "" {
+				if err := ioutil.WriteFile(filepath.Join(dir, "a.conf"), []byte(tc.current), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c := config.DefaultConfig()
+			c.From = config.String("app/")
+			c.To = config.String(dir)
+			c.DetailedExitCode = config.Bool(tc.detailed)
+			c.Finalize()
+
+			p := &Processor{
+				config: *c,
+				lister: &fakeLister{pairs: api.KVPairs{{Key: "app/a.conf", Value: []byte("a")}}},
+				error:  make(chan error, 1),
+				done:   make(chan bool, 1),
+				once:   true,
+				dry:    true,
+			}
+
+			if code := p.Process(); code != ExitCodeOK {
+				t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+			}
+			if p.Drift() != tc.drift {
+				t.Errorf("expected drift %d, got %d", tc.drift, p.Drift())
+			}
+
+			select {
+			case err := <-p.error:
+				if !tc.detailed || tc.drift == 0 {
+					t.Fatalf("expected done, got %s", err)
+				}
+				if e, ok := err.(*ErrDrift); !ok || e.ExitStatus() != ExitCodeDrift {
+					t.Errorf("expected a drift error, got %#v", err)
+				}
+			case <-p.done:
+				if tc.detailed && tc.drift > 0 {
+					t.Fatal("expected a drift error, got done")
+				}
+			}
+		})
+	}
+}
+
+func TestProcess_single(t *testing.T) {
+	cases := []struct {
+		name string
+		from string
+		code int
+	}{
+		{"key", "app/db", ExitCodeOK},
+		{"missing", "app/missing", ExitCodeError},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			kv := &fakeKV{pairs: map[string]*api.KVPair{}}
+			kv.set("app/db", []byte("x"))
+			kv.set("app/db/child", []byte("y"))
+			kv.set("app/dbx", []byte("z"))
+
+			c := config.DefaultConfig()
+			c.Single = config.Bool(true)
+			c.From = config.String(tc.from)
+			c.To = config.String(filepath.Join(dir, "conf", "db.ini"))
+			c.OnMissingPrefix = config.String(config.MissingPrefixError)
+			c.Finalize()
+
+			p := &Processor{kv: kv, lister: kv, error: make(chan error, 1)}
+			if err := p.configure(c); err != nil {
+				t.Fatal(err)
+			}
+			p.init()
+
+			if code := p.Process(); code != tc.code {
+				t.Fatalf("expected exit code %d, got %d", tc.code, code)
+			}
+			if tc.code != ExitCodeOK {
+				return
+			}
+
+			files, err := ioutil.ReadDir(filepath.Join(dir, "conf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 || files[0].Name() != "db.ini" {
+				t.Fatalf("expected only db.ini to be written, got %v", files)
+			}
+			if b, err := ioutil.ReadFile(filepath.Join(dir, "conf", "db.ini")); err != nil || string(b) != "x" {
+				t.Errorf("expected the value of app/db, got %q %v", b, err)
+			}
+		})
+	}
+}
+
+func TestProcess_notify(t *testing.T) {
+	cases := []struct {
+		name   string
+		dry    bool
+		status int
+		posts  int
+	}{
+		{"posts", false, http.StatusOK, 2},
+		{"dry", true, http.StatusOK, 0},
+		{"failing", false, http.StatusInternalServerError, 2},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var posts [][]changeEvent
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var events []changeEvent
+				if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+					t.Error(err)
+				}
+				mu.Lock()
+				posts = append(posts, events)
+				mu.Unlock()
+				w.WriteHeader(tc.status)
+			}))
+			defer ts.Close()
+
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			kv := &fakeKV{pairs: map[string]*api.KVPair{}}
+			kv.set("app/a", []byte("a"))
+			kv.set("app/b", []byte("b"))
+
+			c := config.DefaultConfig()
+			c.From = config.String("app")
+			c.To = config.String(dir)
+			c.Prune = config.Bool(true)
+			c.Notify = &config.NotifyConfig{URL: config.String(ts.URL)}
+			c.Finalize()
+
+			p := &Processor{kv: kv, lister: kv, dry: tc.dry, error: make(chan error, 1)}
+			if err := p.configure(c); err != nil {
+				t.Fatal(err)
+			}
+			p.init()
+
+			if code := p.Process(); code != ExitCodeOK {
+				t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+			}
+			delete(kv.pairs, "app/b")
+			if code := p.Process(); code != ExitCodeOK {
+				t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(posts) != tc.posts {
+				t.Fatalf("expected %d posts, got %d: %v", tc.posts, len(posts), posts)
+			}
+			if tc.posts == 0 {
+				return
+			}
+
+			written := posts[0]
+			sort.Slice(written, func(i, j int) bool { return written[i].Key < written[j].Key })
+			if len(written) != 2 || written[0].Key != "app/a" || written[0].Action != changeWrite ||
+				written[0].Path != filepath.Join(dir, "a") || written[0].SHA256 != p.getHash([]byte("a")) {
+				t.Errorf("unexpected write events %+v", written)
+			}
+
+			pruned := posts[1]
+			if len(pruned) != 1 || pruned[0].Action != changePrune || pruned[0].Path != filepath.Join(dir, "b") {
+				t.Errorf("unexpected prune events %+v", pruned)
+			}
+		})
+	}
+}
+
+func TestProcess_preserveMtime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kv := &fakeKV{pairs: map[string]*api.KVPair{}}
+	kv.set("app/a", []byte("a"))
+
+	c := config.DefaultConfig()
+	c.From = config.String("app")
+	c.To = config.String(dir)
+	c.PreserveMtime = config.Bool(true)
+	c.Finalize()
+
+	p := &Processor{kv: kv, lister: kv, error: make(chan error, 1)}
+	if err := p.configure(c); err != nil {
+		t.Fatal(err)
+	}
+	p.init()
+
+	var now time.Time
+	p.mtimes.now = func() time.Time { return now }
+	file := filepath.Join(dir, "a")
+
+	cases := []struct {
+		name   string
+		change func()
+		now    time.Time
+		mtime  time.Time
+	}{
+		{"written", func() {}, time.Unix(1500000000, 0), time.Unix(1500000000, 0)},
+		{"rewritten_unchanged_key", func() {
+			if err := ioutil.WriteFile(file, []byte("edited"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}, time.Unix(1500000050, 0), time.Unix(1500000000, 0)},
+		{"changed_key", func() { kv.set("app/a", []byte("b")) }, time.Unix(1500000100, 0), time.Unix(1500000100, 0)},
+	}
+
+	for _, tc := range cases {
+		tc.change()
+		now = tc.now
+
+		if code := p.Process(); code != ExitCodeOK {
+			t.Fatalf("%s: expected exit code %d, got %d", tc.name, ExitCodeOK, code)
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(tc.mtime) {
+			t.Errorf("%s: expected mtime %s, got %s", tc.name, tc.mtime, info.ModTime())
+		}
+	}
+}
+
+func TestProcess_stdout(t *testing.T) {
+	cases := []struct {
+		name      string
+		separator *string
+		e         string
+	}{
+		{"default", nil, "# key: app/a\na\n# key: app/b/c\nc\n\n"},
+		{"custom", config.String("--- {key} ---"), "--- app/a ---\na\n--- app/b/c ---\nc\n\n"},
+		{"none", config.String(""), "a\nc\n\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			kv := &fakeKV{pairs: map[string]*api.KVPair{}}
+			kv.set("app/a", []byte("a"))
+			kv.set("app/b/", nil)
+			kv.set("app/b/c", []byte("c\n\n"))
+
+			c := config.DefaultConfig()
+			c.From = config.String("app")
+			c.To = config.String(filepath.Join(dir, "out"))
+			c.Stdout = config.Bool(true)
+			c.Separator = tc.separator
+			c.Finalize()
+
+			var out bytes.Buffer
+			p := &Processor{kv: kv, lister: kv, out: &out, error: make(chan error, 1)}
+			if err := p.configure(c); err != nil {
+				t.Fatal(err)
+			}
+			p.init()
+
+			if code := p.Process(); code != ExitCodeOK {
+				t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+			}
+			if out.String() != tc.e {
+				t.Errorf("\nexp: %q\nact: %q", tc.e, out.String())
+			}
+			if _, err := os.Stat(filepath.Join(dir, "out")); !os.IsNotExist(err) {
+				t.Errorf("expected no files to be written, got %v", err)
+			}
+		})
+	}
+}
+
+func TestProcess_requireKeys(t *testing.T) {
+	cases := []struct {
+		name    string
+		require bool
+		keys    []bool
+		codes   []int
+		err     bool
+	}{
+		{"first_empty", true, []bool{false}, []int{ExitCodeError}, true},
+		{"later_empty", true, []bool{true, false}, []int{ExitCodeOK, ExitCodeEmpty}, false},
+		{"not_required", false, []bool{false}, []int{ExitCodeEmpty}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			kv := &fakeKV{pairs: map[string]*api.KVPair{}}
+
+			c := config.DefaultConfig()
+			c.From = config.String("app")
+			c.To = config.String(dir)
+			c.RequireKeys = config.Bool(tc.require)
+			c.Finalize()
+
+			errCh := make(chan error, 1)
+			p := &Processor{kv: kv, lister: kv, error: errCh}
+			if err := p.configure(c); err != nil {
+				t.Fatal(err)
+			}
+			p.init()
+
+			for i, keys := range tc.keys {
+				if keys {
+					kv.set("app/a", []byte("a"))
+				} else {
+					delete(kv.pairs, "app/a")
+				}
+				if code := p.Process(); code != tc.codes[i] {
+					t.Fatalf("pass %d: expected exit code %d, got %d", i, tc.codes[i], code)
+				}
+			}
+
+			select {
+			case err := <-errCh:
+				if !tc.err {
+					t.Errorf("expected no error, got %v", err)
+				} else if _, ok := err.(*ErrMissingPrefix); !ok {
+					t.Errorf("expected ErrMissingPrefix, got %v", err)
+				}
+			default:
+				if tc.err {
+					t.Error("expected an error")
+				}
+			}
+		})
+	}
+}
+
+func TestProcessor_splay(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cases := []struct {
+		name  string
+		splay time.Duration
+		ctx   context.Context
+		ok    bool
+	}{
+		{"none", 0, nil, true},
+		{"short", 20 * time.Millisecond, nil, true},
+		{"stopped", time.Hour, cancelled, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := config.DefaultConfig()
+			c.CommandSplay = config.TimeDuration(tc.splay)
+			c.Finalize()
+
+			p := &Processor{config: *c, ctx: tc.ctx}
+			start := time.Now()
+			if ok := p.splay(); ok != tc.ok {
+				t.Errorf("expected %t, got %t", tc.ok, ok)
+			}
+			if d := time.Since(start); tc.ok && tc.splay > 0 && d > tc.splay+time.Second {
+				t.Errorf("expected to wait less than %s, waited %s", tc.splay, d)
+			}
+		})
+	}
+}
+
+func TestProcess_flagsRoute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	to := filepath.Join(dir, "to")
+	routed := filepath.Join(dir, "routed")
+
+	kv := &fakeKV{pairs: map[string]*api.KVPair{}}
+	kv.set("app/a", []byte("a"))
+	kv.set("app/b", []byte("b"))
+	kv.set("app/c", []byte("c"))
+	kv.pairs["app/c"].Flags = 2
+
+	c := config.DefaultConfig()
+	c.From = config.String("app")
+	c.To = config.String(to)
+	c.Prune = config.Bool(true)
+	c.FlagsRoute = map[string]string{"1": routed}
+	c.Finalize()
+
+	p := &Processor{kv: kv, lister: kv, error: make(chan error, 1)}
+	if err := p.configure(c); err != nil {
+		t.Fatal(err)
+	}
+	p.init()
+
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := os.Stat(filepath.Join(to, name)); err != nil {
+			t.Errorf("expected %s in to: %s", name, err)
+		}
+	}
+
+	// Routing b away from to writes it to the routed directory and prunes
+	// its old file.
+	kv.set("app/b", []byte("b"))
+	kv.pairs["app/b"].Flags = 1
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(routed, "b")); err != nil || string(content) != "b" {
+		t.Errorf("expected b in the routed directory, got %q, %v", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(to, "b")); !os.IsNotExist(err) {
+		t.Errorf("expected b to be pruned from to, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(routed, "c")); !os.IsNotExist(err) {
+		t.Errorf("expected c without a route to stay in to, got %v", err)
+	}
+}
+
+func TestProcessor_warnPolling(t *testing.T) {
+	cases := []struct {
+		name     string
+		interval time.Duration
+		watch    bool
+		keys     int
+		warn     bool
+	}{
+		{"many_keys_fast", 500 * time.Millisecond, false, saneViewLimit + 1, true},
+		{"few_keys_fast", 500 * time.Millisecond, false, saneViewLimit, false},
+		{"many_keys_slow", time.Second, false, saneViewLimit + 1, false},
+		{"many_keys_watch", 500 * time.Millisecond, true, saneViewLimit + 1, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defer log.SetOutput(os.Stderr)
+
+			keys := make(api.KVPairs, tc.keys)
+			p := &Processor{config: config.Config{
+				Interval: config.TimeDuration(tc.interval),
+				Watch:    config.Bool(tc.watch),
+			}}
+			p.warnPolling(keys)
+			p.warnPolling(keys)
+
+			if n := strings.Count(buf.String(), "[WARN]"); (n == 1) != tc.warn || n > 1 {
+				t.Errorf("expected warning %t, got:\n%s", tc.warn, buf.String())
+			}
+		})
+	}
+}
+
+func TestProcess_outputCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kv := &fakeKV{pairs: map[string]*api.KVPair{}}
+	kv.set("app/a", []byte("value of a"))
+
+	c := config.DefaultConfig()
+	c.From = config.String("app")
+	c.To = config.String(dir)
+	c.OutputCompression = config.String(config.CompressionGzip)
+	c.Finalize()
+
+	// Every pass, including the first of a restarted generator, compares
+	// the compressed bytes with the file and leaves it alone.
+	for i := 0; i < 3; i++ {
+		p := &Processor{kv: kv, lister: kv, error: make(chan error, 1)}
+		if err := p.configure(c); err != nil {
+			t.Fatal(err)
+		}
+		p.init()
+
+		if code := p.Process(); code != ExitCodeOK {
+			t.Fatalf("pass %d: expected exit code %d, got %d", i, ExitCodeOK, code)
+		}
+		if written := p.written; (i == 0) != (written == 1) {
+			t.Errorf("pass %d: expected %t writes, got %d", i, i == 0, written)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(dir, "a.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadAll(r); err != nil || string(content) != "value of a" {
+		t.Errorf("expected the decompressed file to hold the value, got %q, %v", content, err)
+	}
+	if r.Name != "" || !r.ModTime.IsZero() {
+		t.Errorf("expected no name and no modification time in the header, got %q, %s", r.Name, r.ModTime)
+	}
+}
+
+type deniedLister struct{}
+
+func (deniedLister) List(string, *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	return nil, nil, fmt.Errorf("Unexpected response code: 403 (Permission denied)")
+}
+
+func TestProcess_authError(t *testing.T) {
+	cases := []struct {
+		name   string
+		lister lister
+		auth   bool
+	}{
+		{"denied", deniedLister{}, true},
+		{"unreachable", errLister{}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := config.DefaultConfig()
+			c.From = config.String("app/")
+			c.Finalize()
+
+			errCh := make(chan error, 1)
+			p := &Processor{config: *c, lister: tc.lister, error: errCh}
+			if code := p.Process(); code != ExitCodeError {
+				t.Fatalf("expected exit code %d, got %d", ExitCodeError, code)
+			}
+
+			err := <-errCh
+			e, ok := err.(*ErrAuth)
+			if ok != tc.auth {
+				t.Fatalf("expected auth error %t, got %#v", tc.auth, err)
+			}
+			if ok && e.ExitStatus() != ExitCodeAuth {
+				t.Errorf("expected exit status %d, got %d", ExitCodeAuth, e.ExitStatus())
+			}
+		})
+	}
+}
+
+func TestNewProcessor_loginError(t *testing.T) {
+	c := config.DefaultConfig()
+	c.Consul.AuthMethod.Enabled = config.Bool(true)
+	c.Consul.AuthMethod.Name = config.String("kubernetes")
+	c.Consul.AuthMethod.BearerTokenFile = config.String("/nonexistent/token")
+	c.Finalize()
+
+	_, err := NewProcessor(c, true, false, make(chan error, 1), make(chan bool, 1))
+	if e, ok := err.(*ErrAuth); !ok || e.ExitStatus() != ExitCodeAuth {
+		t.Fatalf("expected an ErrAuth, got %#v", err)
+	}
+}
+
+func TestPathTemplate_name(t *testing.T) {
+	cases := []struct {
+		name    string
+		pattern string
+		tmpl    string
+		key     string
+		root    string
+		file    string
+	}{
+		{"absolute", "teams/(?P<team>[^/]+)/(?P<file>.+)", "/etc/app/{{.team}}/{{.file}}", "teams/payments/config.yaml", "/etc/app", "payments/config.yaml"},
+		{"relative", "teams/(?P<team>[^/]+)/(?P<file>.+)", "{{.team}}/{{.file}}", "/teams/payments/a/b", "to", "payments/a/b"},
+		{"prefix_in_segment", "(?P<team>[^/]+)/x", "/etc/app-{{.team}}/x", "payments/x", "/etc", "app-payments/x"},
+		{"no_match", "teams/(?P<team>[^/]+)/(?P<file>.+)", "/etc/app/{{.team}}/{{.file}}", "other/config.yaml", "/etc/app", ""},
+		{"partial_match", "teams/(?P<team>[^/]+)", "/etc/app/{{.team}}", "teams/payments/config.yaml", "/etc/app", ""},
+		{"escape", "teams/(?P<team>[^/]+)/(?P<file>.+)", "/etc/app/{{.team}}/{{.file}}", "teams/../evil", "/etc/app", "../evil"},
+		{"root", "(?P<team>[^/]*)", "/etc/app/{{.team}}", "", "/etc/app", ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pt, err := newPathTemplate(&config.Config{
+				To:         config.String("to"),
+				KeyPattern: config.String(tc.pattern),
+				ToTemplate: config.String(tc.tmpl),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pt.root != filepath.FromSlash(tc.root) {
+				t.Errorf("expected root %q, got %q", tc.root, pt.root)
+			}
+			if name := pt.name(tc.key); name != tc.file {
+				t.Errorf("expected %q, got %q", tc.file, name)
+			}
+		})
+	}
+}
+
+func TestProcess_toTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kv := &fakeKV{pairs: map[string]*api.KVPair{}}
+	kv.set("teams/payments/config.yaml", []byte("payments"))
+	kv.set("teams/search/conf/app.yaml", []byte("search"))
+	kv.set("teams/README", []byte("skipped"))
+
+	c := config.DefaultConfig()
+	c.From = config.String("teams/")
+	c.To = config.String(filepath.Join(dir, "to"))
+	c.KeyPattern = config.String("teams/(?P<team>[^/]+)/(?P<file>.+)")
+	c.ToTemplate = config.String(filepath.ToSlash(dir) + "/app/{{.team}}/{{.file}}")
+	c.Finalize()
+
+	errCh := make(chan error, 1)
+	p := &Processor{kv: kv, lister: kv, error: errCh}
+	if err := p.configure(c); err != nil {
+		t.Fatal(err)
+	}
+	p.init()
+
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d: %v", ExitCodeOK, code, <-errCh)
+	}
+	for file, value := range map[string]string{
+		"app/payments/config.yaml": "payments",
+		"app/search/conf/app.yaml": "search",
+	} {
+		if content, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(file))); err != nil || string(content) != value {
+			t.Errorf("expected %s to hold %q, got %q, %v", file, value, content, err)
+		}
+	}
+	if files, _ := ioutil.ReadDir(filepath.Join(dir, "to")); len(files) != 0 {
+		t.Errorf("expected nothing written to to, got %d files", len(files))
+	}
+
+	// A capture with ".." cannot lead outside the directory of the template.
+	kv.set("teams/../evil", []byte("evil"))
+	if code := p.Process(); code != ExitCodeError {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeError, code)
+	}
+	if err := <-errCh; err == nil {
+		t.Error("expected an error")
+	} else if _, ok := err.(*ErrUnsafePath); !ok {
+		t.Errorf("expected an ErrUnsafePath, got %#v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil")); !os.IsNotExist(err) {
+		t.Errorf("expected no file outside of the template directory, got %v", err)
+	}
+}
+
+func TestProcess_vars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kv := &fakeKV{pairs: map[string]*api.KVPair{}}
+	kv.set("app/a", []byte("a"))
+	kv.set("app/b", []byte("b"))
+
+	// The version file and manifest are not key files and not counted.
+	c := config.DefaultConfig()
+	c.From = config.String("app")
+	c.To = config.String(filepath.Join(dir, "to"))
+	c.VersionFile = config.String(filepath.Join(dir, "VERSION"))
+	c.Manifest = config.String(filepath.Join(dir, "MANIFEST.json"))
+	c.Finalize()
+
+	p := &Processor{kv: kv, lister: kv, error: make(chan error, 1)}
+	if err := p.configure(c); err != nil {
+		t.Fatal(err)
+	}
+	p.init()
+
+	passes, written, errors := passesVar.Value(), writtenVar.Value(), errorsVar.Value()
+	start := time.Now().Unix()
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+	}
+	if n := passesVar.Value() - passes; n != 1 {
+		t.Errorf("expected 1 pass counted, got %d", n)
+	}
+	if n := writtenVar.Value() - written; n != 2 {
+		t.Errorf("expected 2 writes counted, got %d", n)
+	}
+	if n := errorsVar.Value() - errors; n != 0 {
+		t.Errorf("expected no errors counted, got %d", n)
+	}
+	if last := lastPassVar.Value(); last < start {
+		t.Errorf("expected the last pass at or after %d, got %d", start, last)
+	}
+	if s := p.Stats(); s.Written != 2 {
+		t.Errorf("expected 2 writes in the stats, got %d", s.Written)
+	}
+
+	// Nothing changed, nothing written.
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+	}
+	if n := writtenVar.Value() - written; n != 2 {
+		t.Errorf("expected no writes counted for an unchanged pass, got %d", n-2)
+	}
+
+	p.lister = errLister{}
+	if code := p.Process(); code != ExitCodeError {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeError, code)
+	}
+	if n := errorsVar.Value() - errors; n != 1 {
+		t.Errorf("expected 1 error counted, got %d", n)
+	}
+	if n := passesVar.Value() - passes; n != 3 {
+		t.Errorf("expected 3 passes counted, got %d", n)
+	}
+}
+
+func TestProcess_lowMemory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kv := &fakeKV{pairs: map[string]*api.KVPair{}}
+	kv.set("app/a", []byte("a"))
+	kv.set("app/b", []byte("b"))
+	kv.set("app/c", []byte("c"))
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.LowMemory = config.Bool(true)
+	c.Filter = config.String(`Value != "c"`)
+	c.Finalize()
+
+	// Listing the values would fail the pass.
+	errCh := make(chan error, 1)
+	p := &Processor{kv: kv, lister: errLister{}, error: errCh}
+	if err := p.configure(c); err != nil {
+		t.Fatal(err)
+	}
+	p.init()
+
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d: %v", ExitCodeOK, code, <-errCh)
+	}
+	for name, value := range map[string]string{"a": "a", "b": "b"} {
+		if content, err := ioutil.ReadFile(filepath.Join(dir, name)); err != nil || string(content) != value {
+			t.Errorf("expected %s to hold %q, got %q, %v", name, value, content, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "c")); !os.IsNotExist(err) {
+		t.Errorf("expected the filtered key not to be written, got %v", err)
+	}
+	if kv.gets != 3 {
+		t.Errorf("expected one Get per key, got %d", kv.gets)
+	}
+
+	// A key deleted between Keys and Get is skipped.
+	kv.set("app/d", []byte("d"))
+	kv.gone = map[string]bool{"app/d": true}
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d: %v", ExitCodeOK, code, <-errCh)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "d")); !os.IsNotExist(err) {
+		t.Errorf("expected the deleted key not to be written, got %v", err)
+	}
+}
+
+func TestProcess_folderWithValue(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy string
+		extra  string
+		files  map[string]string
+		code   int
+	}{
+		{"error", config.FolderWithValueError, "", nil, ExitCodeError},
+		{"index", config.FolderWithValueIndex, "", map[string]string{"config/index": "v", "config/x": "x", "other": "o"}, ExitCodeOK},
+		{"suffix", config.FolderWithValueSuffix, "", map[string]string{"config.value": "v", "config/x": "x", "other": "o"}, ExitCodeOK},
+		{"skip", config.FolderWithValueSkip, "", map[string]string{"config/x": "x", "other": "o"}, ExitCodeOK},
+		{"index_taken", config.FolderWithValueIndex, "app/config/index", nil, ExitCodeError},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			pairs := api.KVPairs{
+				{Key: "app/config", Value: []byte("v")},
+				{Key: "app/config/x", Value: []byte("x")},
+				{Key: "app/other", Value: []byte("o")},
+			}
+			if tc.extra != "" {
+				pairs = append(pairs, &api.KVPair{Key: tc.extra, Value: []byte("i")})
+			}
+
+			c := config.DefaultConfig()
+			c.From = config.String("app/")
+			c.To = config.String(dir)
+			c.PreserveStructure = config.Bool(true)
+			c.FolderWithValue = config.String(tc.policy)
+			c.Finalize()
+
+			p := &Processor{
+				config: *c,
+				lister: &fakeLister{pairs: pairs},
+				error:  make(chan error, 1),
+			}
+			if err := p.validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			if code := p.Process(); code != tc.code {
+				t.Fatalf("expected exit code %d, got %d", tc.code, code)
+			}
+
+			for name, e := range tc.files {
+				content, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(content) != e {
+					t.Errorf("expected %s to contain %q, got %q", name, e, content)
+				}
+			}
+			if tc.files != nil {
+				if info, err := os.Stat(filepath.Join(dir, "config")); err != nil || !info.IsDir() {
+					t.Errorf("expected config to be a directory, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+type fakePreflightKV struct {
+	getErr, casErr error
+	cas            *api.KVPair
+}
+
+func (f *fakePreflightKV) Get(string, *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	return nil, nil, f.getErr
+}
+
+func (f *fakePreflightKV) CAS(pair *api.KVPair, _ *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	f.cas = pair
+	return false, nil, f.casErr
+}
+
+func TestPreflight(t *testing.T) {
+	denied := fmt.Errorf("Unexpected response code: 403 (Permission denied)")
+
+	cases := []struct {
+		name     string
+		push     bool
+		kv       *fakePreflightKV
+		err      bool
+		exitable bool
+	}{
+		{"read_ok", false, &fakePreflightKV{}, false, false},
+		{"read_denied", false, &fakePreflightKV{getErr: denied}, true, true},
+		{"read_unreachable", false, &fakePreflightKV{getErr: fmt.Errorf("connection refused")}, true, false},
+		{"pull_ignores_write", false, &fakePreflightKV{casErr: denied}, false, false},
+		{"push_ok", true, &fakePreflightKV{}, false, false},
+		{"push_write_denied", true, &fakePreflightKV{casErr: denied}, true, true},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.name), func(t *testing.T) {
+			p := &Processor{config: config.Config{
+				From: config.String("app/"),
+				Push: config.Bool(tc.push),
+			}}
+
+			err := p.preflight(tc.kv)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if _, ok := err.(*ErrPreflight); ok != tc.exitable {
+				t.Errorf("expected exitable %t, got %#v", tc.exitable, err)
+			}
+			if tc.kv.cas != nil && tc.kv.cas.ModifyIndex == 0 {
+				t.Error("write probe must never use index 0")
+			}
+		})
+	}
+}
+
+type fakeKV struct {
+	pairs map[string]*api.KVPair
+	index uint64
+	cas   int
+	gets  int
+
+	// gone keys are still listed by Keys but deleted by the time of Get.
+	gone map[string]bool
+
+	// moved is stored right before the next CAS on its key, as if another
+	// writer got there first.
+	moved map[string][]byte
+}
+
+func (f *fakeKV) set(key string, value []byte) {
+	f.index++
+	f.pairs[key] = &api.KVPair{Key: key, Value: value, ModifyIndex: f.index}
+}
+
+func (f *fakeKV) List(prefix string, _ *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	var pairs api.KVPairs
+	for key, pair := range f.pairs {
+		if strings.HasPrefix(key, prefix) {
+			pairs = append(pairs, pair)
+		}
+	}
+	// Like Consul, list in key order.
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	return pairs, &api.QueryMeta{LastIndex: f.index}, nil
+}
+
+func (f *fakeKV) Keys(prefix, _ string, _ *api.QueryOptions) ([]string, *api.QueryMeta, error) {
+	var keys []string
+	for key := range f.pairs {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, &api.QueryMeta{LastIndex: f.index}, nil
+}
+
+func (f *fakeKV) Get(key string, _ *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	f.gets++
+	if f.gone[key] {
+		return nil, nil, nil
+	}
+	return f.pairs[key], nil, nil
+}
+
+func (f *fakeKV) CAS(pair *api.KVPair, _ *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	f.cas++
+	if value, ok := f.moved[pair.Key]; ok {
+		delete(f.moved, pair.Key)
+		f.set(pair.Key, value)
+	}
+
+	var index uint64
+	if current, ok := f.pairs[pair.Key]; ok {
+		index = current.ModifyIndex
+	}
+	if pair.ModifyIndex != index {
+		return false, nil, nil
+	}
+
+	f.set(pair.Key, pair.Value)
+	return true, nil, nil
+}
+
+func (f *fakeKV) Put(pair *api.KVPair, _ *api.WriteOptions) (*api.WriteMeta, error) {
+	f.set(pair.Key, pair.Value)
+	return nil, nil
+}
+
+func (f *fakeKV) Delete(key string, _ *api.WriteOptions) (*api.WriteMeta, error) {
+	delete(f.pairs, key)
+	return nil, nil
+}
+
+func TestProcess_push(t *testing.T) {
+	cases := []struct {
+		name     string
+		conflict string
+		existing map[string]string
+		moved    map[string]string
+		exp      string
+		cas      int
+	}{
+		{
+			"new_key",
+			config.PushConflictSkip,
+			nil,
+			nil,
+			"a",
+			1,
+		},
+		{
+			"unchanged",
+			config.PushConflictSkip,
+			map[string]string{"app/a.conf": "a"},
+			nil,
+			"a",
+			0,
+		},
+		{
+			"changed",
+			config.PushConflictSkip,
+			map[string]string{"app/a.conf": "old"},
+			nil,
+			"a",
+			1,
+		},
+		{
+			"conflict_skip",
+			config.PushConflictSkip,
+			map[string]string{"app/a.conf": "old"},
+			map[string]string{"app/a.conf": "theirs"},
+			"theirs",
+			1,
+		},
+		{
+			"conflict_retry",
+			config.PushConflictRetry,
+			map[string]string{"app/a.conf": "old"},
+			map[string]string{"app/a.conf": "theirs"},
+			"a",
+			2,
+		},
+		{
+			"conflict_retry_new_key",
+			config.PushConflictRetry,
+			nil,
+			map[string]string{"app/a.conf": "theirs"},
+			"a",
+			2,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			if err := ioutil.WriteFile(filepath.Join(dir, "a.conf"), []byte("a"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			kv := &fakeKV{pairs: make(map[string]*api.KVPair), moved: make(map[string][]byte)}
+			for key, value := range tc.existing {
+				kv.set(key, []byte(value))
+			}
+			for key, value := range tc.moved {
+				kv.moved[key] = []byte(value)
+			}
+
+			c := config.DefaultConfig()
+			c.From = config.String("app/")
+			c.To = config.String(dir)
+			c.Push = config.Bool(true)
+			c.PushConflict = config.String(tc.conflict)
+			c.Finalize()
+
+			p := &Processor{
+				config: *c,
+				lister: kv,
+				kv:     kv,
+				error:  make(chan error, 1),
+			}
+
+			if code := p.Process(); code != ExitCodeOK {
+				t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+			}
+
+			pair := kv.pairs["app/a.conf"]
+			if pair == nil || string(pair.Value) != tc.exp {
+				t.Errorf("expected app/a.conf to be %q, got %v", tc.exp, pair)
+			}
+			if kv.cas != tc.cas {
+				t.Errorf("expected %d CAS calls, got %d", tc.cas, kv.cas)
+			}
+		})
+	}
+}
+
+func TestProcess_pushPreserveStructure(t *testing.T) {
+	cases := []struct {
+		name   string
+		nested bool
+		exp    []string
+	}{
+		{"flat", false, []string{"app/a.conf"}},
+		{"nested", true, []string{"app/a.conf", "app/db/password"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			if err := os.MkdirAll(filepath.Join(dir, "db"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range map[string]string{"a.conf": "a", "db/password": "s3cr3t"} {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(value), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			kv := &fakeKV{pairs: make(map[string]*api.KVPair)}
+
+			c := config.DefaultConfig()
+			c.From = config.String("app/")
+			c.To = config.String(dir)
+			c.Push = config.Bool(true)
+			c.PreserveStructure = config.Bool(tc.nested)
+			c.Finalize()
+
+			p := &Processor{
+				config: *c,
+				lister: kv,
+				kv:     kv,
+				error:  make(chan error, 1),
+			}
+
+			if code := p.Process(); code != ExitCodeOK {
+				t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+			}
+
+			var keys []string
+			for key := range kv.pairs {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tc.exp) {
+				t.Errorf("expected keys %v, got %v", tc.exp, keys)
+			}
+		})
+	}
+}
+
+func TestProcess_preserveStructure(t *testing.T) {
+	keys := api.KVPairs{
+		{Key: "app/a.conf", Value: []byte("a")},
+		{Key: "app/db/password", Value: []byte("db")},
+		{Key: "app/web/password", Value: []byte("web")},
+	}
+	e := map[string][]byte{
+		"a.conf":       []byte("a"),
+		"db/password":  []byte("db"),
+		"web/password": []byte("web"),
+	}
+
+	cases := []struct {
+		name string
+		swap bool
+	}{
+		{"files", false},
+		{"swap_dir", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			parent, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(parent)
+
+			to := filepath.Join(parent, "current")
+			if !tc.swap {
+				if err := os.Mkdir(to, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c := config.DefaultConfig()
+			c.From = config.String("app/")
+			c.To = config.String(to)
+			c.PreserveStructure = config.Bool(true)
+			c.SwapDir = config.Bool(tc.swap)
+			c.Finalize()
+
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defer log.SetOutput(os.Stderr)
+
+			for pass := 0; pass < 2; pass++ {
+				buf.Reset()
+				p := &Processor{
+					config: *c,
+					lister: &fakeLister{pairs: keys},
+					error:  make(chan error, 1),
+					done:   make(chan bool, 1),
+					once:   true,
+				}
+				if code := p.Process(); code != ExitCodeOK {
+					t.Fatalf("pass %d: expected exit code %d, got %d", pass, ExitCodeOK, code)
+				}
+			}
+
+			a, err := readTree(to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(e, a) {
+				t.Errorf("\nexp: %#v\nact: %#v", e, a)
+			}
+
+			// The second pass compares against the nested files.
+			if out := buf.String(); strings.Contains(out, "Saved") || strings.Contains(out, "Swapped") {
+				t.Errorf("expected the second pass to skip every file, got:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestProcess_prune(t *testing.T) {
+	cases := []struct {
+		name     string
+		dry      bool
+		nested   bool
+		seeded   bool
+		patterns []string
+		exp      []string
+	}{
+		{"removes_stale", false, false, false, nil, []string{".consul-generator-state", "a.conf", "unrelated.conf"}},
+		{"dry", true, false, false, nil, []string{".consul-generator-state", "a.conf", "b.conf", "unrelated.conf"}},
+		{"nested", false, true, false, nil, []string{".consul-generator-state", "a.conf", "unrelated.conf"}},
+		// The first pass skips b.conf as identical, so it never owned it.
+		{"preexisting_identical", false, false, true, nil, []string{".consul-generator-state", "a.conf", "b.conf", "unrelated.conf"}},
+		{"patterns_match", false, false, false, []string{"*.yaml", "*.conf"}, []string{".consul-generator-state", "a.conf", "unrelated.conf"}},
+		{"patterns_miss", false, false, false, []string{"*.yaml"}, []string{".consul-generator-state", "a.conf", "b.conf", "unrelated.conf"}},
+		{"patterns_nested_base", false, true, false, []string{"*.conf"}, []string{".consul-generator-state", "a.conf", "unrelated.conf"}},
+		{"patterns_nested_path", false, true, false, []string{"web/*"}, []string{".consul-generator-state", "a.conf", "db/b.conf", "unrelated.conf"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			if err := ioutil.WriteFile(filepath.Join(dir, "unrelated.conf"), []byte("mine"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			stale := "app/b.conf"
+			if tc.nested {
+				stale = "app/db/b.conf"
+			}
+			if tc.seeded {
+				if err := ioutil.WriteFile(filepath.Join(dir, "b.conf"), []byte("b"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c := config.DefaultConfig()
+			c.From = config.String("app/")
+			c.To = config.String(dir)
+			c.Prune = config.Bool(true)
+			c.PreserveStructure = config.Bool(tc.nested)
+			c.PrunePatterns = tc.patterns
+			c.Finalize()
+
+			pass := func(dry bool, keys api.KVPairs) {
+				p := &Processor{
+					config: *c,
+					lister: &fakeLister{pairs: keys},
+					error:  make(chan error, 1),
+					done:   make(chan bool, 1),
+					once:   true,
+					dry:    dry,
+				}
+				if code := p.Process(); code != ExitCodeOK {
+					t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+				}
+			}
+
+			pass(false, api.KVPairs{
+				{Key: "app/a.conf", Value: []byte("a")},
+				{Key: stale, Value: []byte("b")},
+			})
+			pass(tc.dry, api.KVPairs{
+				{Key: "app/a.conf", Value: []byte("a")},
+			})
+
+			a, err := readTree(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for name := range a {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(tc.exp, names) {
+				t.Errorf("\nexp: %v\nact: %v", tc.exp, names)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "db")); tc.nested && tc.patterns == nil && !os.IsNotExist(err) {
+				t.Errorf("expected the emptied directory to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSave_atomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "nginx.conf")
+	if err := ioutil.WriteFile(path, []byte("old"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reader holding the old file keeps seeing all of it.
+	reader, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	p := &Processor{config: config.Config{}}
+	if err := p.save(path, "new"); err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(old) != "old" {
+		t.Errorf("expected the open file to keep %q, got %q", "old", old)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "new" {
+		t.Errorf("expected %q, got %q", "new", b)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Mode().Perm() != 0640 {
+		t.Errorf("expected the existing mode to be kept\nexp: %o\nact: %o", 0640, stat.Mode().Perm())
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		t.Errorf("expected no temporary files to be left, got %d files", len(infos))
+	}
+}
+
+func TestProcess_command(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	to := filepath.Join(dir, "to")
+	if err := os.Mkdir(to, 0755); err != nil {
+		t.Fatal(err)
+	}
+	marker := filepath.Join(dir, "ran")
+
+	cases := []struct {
+		name    string
+		command string
+		value   string
+		code    int
+		ran     bool
+	}{
+		{"changed", "touch " + marker, "a", ExitCodeOK, true},
+		{"unchanged", "touch " + marker, "a", ExitCodeOK, false},
+		{"failed", "exit 3", "b", ExitCodeError, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			os.Remove(marker)
+
+			c := config.DefaultConfig()
+			c.From = config.String("app/")
+			c.To = config.String(to)
+			c.Commands = &config.CommandConfigs{{Exec: config.String(tc.command)}}
+			c.Finalize()
+
+			p := &Processor{
+				config: *c,
+				lister: &fakeLister{pairs: api.KVPairs{{Key: "app/a.conf", Value: []byte(tc.value)}}},
+				error:  make(chan error, 1),
+				done:   make(chan bool, 1),
+				once:   true,
+			}
+
+			if code := p.Process(); code != tc.code {
+				t.Fatalf("expected exit code %d, got %d", tc.code, code)
+			}
+			if _, err := os.Stat(marker); (err == nil) != tc.ran {
+				t.Errorf("expected command to run %t, got %v", tc.ran, err)
+			}
+			if tc.code == ExitCodeError && len(p.error) == 0 {
+				t.Errorf("expected the failure on the error channel")
+			}
+		})
+	}
+}
+
+func TestProcess_commandTrigger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	to := filepath.Join(dir, "to")
+	nginx, yaml, always := filepath.Join(dir, "nginx"), filepath.Join(dir, "yaml"), filepath.Join(dir, "always")
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(to)
+	c.PreserveStructure = config.Bool(true)
+	c.Commands = &config.CommandConfigs{
+		{Trigger: config.String("nginx/*"), Exec: config.String("touch " + nginx)},
+		{Trigger: config.String("*.yaml"), Exec: config.String("touch " + yaml)},
+		{Exec: config.String("touch " + always)},
+	}
+	c.Finalize()
+
+	cases := []struct {
+		name  string
+		pairs api.KVPairs
+		ran   []string
+	}{
+		{
+			"nginx_changed",
+			api.KVPairs{
+				{Key: "app/nginx/site.conf", Value: []byte("a")},
+				{Key: "app/app.conf", Value: []byte("a")},
+			},
+			[]string{nginx, always},
+		},
+		{
+			"other_changed",
+			api.KVPairs{
+				{Key: "app/nginx/site.conf", Value: []byte("a")},
+				{Key: "app/app.conf", Value: []byte("b")},
+			},
+			[]string{always},
+		},
+		{
+			"unchanged",
+			api.KVPairs{
+				{Key: "app/nginx/site.conf", Value: []byte("a")},
+				{Key: "app/app.conf", Value: []byte("b")},
+			},
+			nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, marker := range []string{nginx, yaml, always} {
+				os.Remove(marker)
+			}
+
+			p := &Processor{
+				config: *c,
+				lister: &fakeLister{pairs: tc.pairs},
+				error:  make(chan error, 1),
+				done:   make(chan bool, 1),
+				once:   true,
+			}
+			if code := p.Process(); code != ExitCodeOK {
+				t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+			}
+
+			for _, marker := range []string{nginx, yaml, always} {
+				exp := false
+				for _, ran := range tc.ran {
+					exp = exp || ran == marker
+				}
+				if _, err := os.Stat(marker); (err == nil) != exp {
+					t.Errorf("expected %s to run %t, got %v", filepath.Base(marker), exp, err)
+				}
+			}
+		})
+	}
+}
+
+// blockingLister blocks blocking queries until their context is cancelled.
+type blockingLister struct {
+	indexLister
+}
+
+func (l *blockingLister) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	if q != nil && q.WaitIndex != 0 {
+		l.queries = append(l.queries, q)
+		<-q.Context().Done()
+		return nil, nil, q.Context().Err()
+	}
+	return l.indexLister.List(prefix, q)
+}
+
+func TestProcess_watch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := config.DefaultConfig()
+	c.From = config.String("app/")
+	c.To = config.String(dir)
+	c.Watch = config.Bool(true)
+	c.Finalize()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lister := &blockingLister{indexLister{
+		pairs: api.KVPairs{{Key: "app/a.conf", Value: []byte("a")}},
+		index: 5,
+	}}
+	p := &Processor{
+		config: *c,
+		lister: &retryLister{lister: lister, retry: func(int) (bool, time.Duration) { return true, time.Millisecond }},
+		error:  make(chan error, 1),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	if code := p.Process(); code != ExitCodeOK {
+		t.Fatalf("expected exit code %d, got %d", ExitCodeOK, code)
+	}
+
+	codeCh := make(chan int, 1)
+	go func() { codeCh <- p.Process() }()
+
+	time.Sleep(10 * time.Millisecond)
+	p.Cancel()
+
+	select {
+	case code := <-codeCh:
+		if code != ExitCodeOK {
+			t.Errorf("expected exit code %d, got %d", ExitCodeOK, code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Cancel to abort the blocking query")
+	}
+
+	// The first pass does not block and the aborted query is not retried.
+	if len(lister.queries) != 2 || lister.queries[0] != nil {
+		t.Fatalf("expected a plain and a single blocking query, got %#v", lister.queries)
+	}
+	if q := lister.queries[1]; q.WaitIndex != 5 || q.WaitTime != watchWait {
+		t.Errorf("expected a long blocking query on index 5, got %#v", q)
+	}
+	if len(p.error) != 0 {
+		t.Errorf("expected no error after Cancel, got %v", <-p.error)
+	}
+}
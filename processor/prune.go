@@ -0,0 +1,159 @@
+package processor
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+const pruneStateFileName = ".consul-generator-state"
+
+// prune removes the files of keys that disappeared from Consul. Only files
+// listed in the state file, i.e. written for a key by an earlier pass, and
+// matching prune_patterns when set, are ever removed. The state file is then
+// rewritten with the current keys whose file was listed before or written by
+// this pass, so a file that was already there with the same content is never
+// taken over.
+func (p *Processor) prune(keys api.KVPairs) {
+	if !config.BoolVal(p.config.Prune) {
+		return
+	}
+
+	// An empty listing is more likely a missing prefix or token than the
+	// removal of every key.
+	if len(keys) == 0 {
+		log.Printf("[WARN] (processor) no keys listed, not pruning %s", *p.config.To)
+		return
+	}
+
+	state := filepath.Join(*p.config.To, pruneStateFileName)
+
+	// Files routed elsewhere by flags_route are not tracked, so a key that
+	// is routed away from to has its old file pruned.
+	expected := make(map[string]bool, len(keys))
+	for _, pair := range keys {
+		if p.destination(pair) != *p.config.To {
+			continue
+		}
+		if name := p.fileName(pair.Key); name != "" {
+			expected[name] = true
+		}
+	}
+
+	previous, err := readPruneState(state)
+	if err != nil {
+		log.Printf("[WARN] (processor) could not read %s, not pruning: %s", state, err)
+		previous = nil
+	}
+
+	for _, name := range previous {
+		if expected[name] || escapesTo(name) {
+			continue
+		}
+		if !p.prunable(name) {
+			log.Printf("[DEBUG] (processor) %s does not match prune_patterns, keeping it", name)
+			expected[name] = true
+			continue
+		}
+
+		file := filepath.Join(*p.config.To, filepath.FromSlash(name))
+		if p.dry {
+			log.Printf("File %s will be deleted", file)
+			p.mu.Lock()
+			p.drift++
+			p.mu.Unlock()
+			continue
+		}
+
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.Printf("[WARN] (processor) could not prune %s: %s", file, err)
+			expected[name] = true
+			continue
+		}
+		log.Printf("[INFO] (processor) Pruned: %s", file)
+		p.changed = true
+		p.recordChange(file, "", changePrune, nil)
+		p.pruneDirs(filepath.Dir(file))
+	}
+
+	if p.dry {
+		return
+	}
+
+	owned := make(map[string]bool, len(previous))
+	for _, name := range previous {
+		owned[name] = true
+	}
+
+	names := make([]string, 0, len(expected))
+	for name := range expected {
+		if owned[name] || p.wrote[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if strings.Join(names, "\n") == strings.Join(previous, "\n") {
+		return
+	}
+
+	tmp := state + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strings.Join(names, "\n")+"\n"), 0644); err != nil {
+		log.Printf("[WARN] (processor) could not store %s: %s", state, err)
+		return
+	}
+	if err := os.Rename(tmp, state); err != nil {
+		log.Printf("[WARN] (processor) could not store %s: %s", state, err)
+	}
+}
+
+// prunable reports whether the file name, slash separated below to, matches
+// prune_patterns.
+func (p *Processor) prunable(name string) bool {
+	if len(p.config.PrunePatterns) == 0 {
+		return true
+	}
+	for _, pattern := range p.config.PrunePatterns {
+		if pathMatch(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneDirs removes dir and its parents below to as long as they are empty,
+// so pruning nested files does not leave empty directories behind.
+func (p *Processor) pruneDirs(dir string) {
+	to := filepath.Clean(*p.config.To)
+	for ; dir != to && strings.HasPrefix(dir, to+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
+
+func readPruneState(path string) ([]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			names = append(names, line)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
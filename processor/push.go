@@ -0,0 +1,142 @@
+package processor
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+const pushCASAttempts = 3
+
+func (p *Processor) push() int {
+	names, err := p.pushFiles()
+	if err != nil {
+		p.sendError(err)
+		return logError(err, ExitCodeError)
+	}
+
+	pairs, _, err := p.lister.List(normalizeKey(*p.config.From), nil)
+	if err != nil {
+		p.sendError(err)
+		return logError(err, ExitCodeError)
+	}
+
+	existing := make(map[string]*api.KVPair, len(pairs))
+	for _, pair := range pairs {
+		existing[normalizeKey(pair.Key)] = pair
+	}
+
+	for _, name := range names {
+		key := p.pushKey(name)
+		if !p.included(key) {
+			log.Printf("[DEBUG] (processor) %s excluded by include/exclude", key)
+			continue
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(*p.config.To, filepath.FromSlash(name)))
+		if err != nil {
+			p.sendError(err)
+			return logError(err, ExitCodeError)
+		}
+
+		ok, err := p.filter.match(kvSelector(&api.KVPair{Key: key, Value: content}))
+		if err != nil {
+			p.sendError(err)
+			return logError(err, ExitCodeError)
+		}
+		if !ok {
+			continue
+		}
+
+		if err := p.pushKV(key, content, existing[key]); err != nil {
+			p.sendError(err)
+			return logError(err, ExitCodeError)
+		}
+	}
+
+	p.logSkipped()
+
+	return p.finish()
+}
+
+// pushFiles lists the slash separated names of the files in to that are
+// pushed. Subdirectories are only descended into with preserve_structure.
+func (p *Processor) pushFiles() ([]string, error) {
+	var names []string
+
+	root, err := filepath.EvalSymlinks(*p.config.To)
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if file != root && !config.BoolVal(p.config.PreserveStructure) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || (filepath.Dir(file) == root && (info.Name() == watermarkFileName || info.Name() == pruneStateFileName)) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+
+	return names, err
+}
+
+func (p *Processor) pushKV(key string, content []byte, current *api.KVPair) error {
+	for attempt := 1; ; attempt++ {
+		if current != nil && p.getHash(current.Value) == p.getHash(content) {
+			p.logSkip(key)
+			return nil
+		}
+
+		if p.dry {
+			log.Printf("Key %s will be pushed with content: \n %s", key, p.loggable(content, key))
+			return nil
+		}
+
+		pair := &api.KVPair{Key: key, Value: content}
+		if current != nil {
+			pair.ModifyIndex = current.ModifyIndex
+		}
+
+		ok, _, err := p.kv.CAS(pair, nil)
+		if err != nil {
+			return err
+		}
+		if ok {
+			log.Printf("[INFO] (processor) Pushed: %s", key)
+			return nil
+		}
+
+		if config.StringVal(p.config.PushConflict) != config.PushConflictRetry || attempt >= pushCASAttempts {
+			log.Printf("[WARN] (processor) Conflict: %s was modified concurrently, skipping", key)
+			return nil
+		}
+
+		log.Printf("[WARN] (processor) Conflict: %s was modified concurrently, re-reading", key)
+		current, _, err = p.kv.Get(key, nil)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p *Processor) pushKey(filename string) string {
+	return normalizeKey(path.Join(*p.config.From, filename))
+}
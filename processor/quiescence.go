@@ -0,0 +1,95 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// quiescence holds back writing changed keys until they stopped changing
+// for min, or max passed since the first change, so a burst of updates is
+// written, and the command run, once.
+type quiescence struct {
+	min, max time.Duration
+	now      func() time.Time
+
+	// applied is the fingerprint of the keys last written, pending the one
+	// waited for since first, last changed at last.
+	applied, pending string
+	started          bool
+	first, last      time.Time
+}
+
+func newQuiescence(c *config.Config, once, dry bool) *quiescence {
+	if !config.BoolVal(c.Wait.Enabled) || once || dry {
+		return nil
+	}
+
+	return &quiescence{
+		min: config.TimeDurationVal(c.Wait.Min),
+		max: config.TimeDurationVal(c.Wait.Max),
+		now: time.Now,
+	}
+}
+
+// wait reports how long writing keys has to be held back. The first pass
+// is never held back.
+func (q *quiescence) wait(keys api.KVPairs) time.Duration {
+	if q == nil {
+		return 0
+	}
+
+	fp := fingerprint(keys)
+	if !q.started || fp == q.applied {
+		q.pending = fp
+		return 0
+	}
+
+	now := q.now()
+	if fp != q.pending {
+		if q.pending == q.applied {
+			q.first = now
+		}
+		q.pending = fp
+		q.last = now
+	}
+
+	deadline := q.last.Add(q.min)
+	if max := q.first.Add(q.max); max.Before(deadline) {
+		deadline = max
+	}
+	if d := deadline.Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// apply records that the keys passed to the last wait were written.
+func (q *quiescence) apply() {
+	if q == nil {
+		return
+	}
+
+	q.started = true
+	q.applied = q.pending
+}
+
+func fingerprint(keys api.KVPairs) string {
+	h := sha256.New()
+	for _, pair := range keys {
+		h.Write([]byte(pair.Key))
+		h.Write([]byte{0})
+		h.Write(pair.Value)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Wait returns how long the last pass held back changed keys to let them
+// settle, zero if it did not.
+func (p *Processor) Wait() time.Duration {
+	return p.settle
+}
@@ -0,0 +1,30 @@
+package processor
+
+import (
+	"fmt"
+	"path"
+)
+
+// loggable returns content as it may appear in logs. Values of keys matching
+// a redact pattern are replaced by their length and a short hash.
+func (p *Processor) loggable(content []byte, keys ...string) string {
+	if p.redacts(keys...) {
+		return fmt.Sprintf("<redacted, %d bytes, sha256:%.12s>", len(content), p.getHash(content))
+	}
+	return string(content)
+}
+
+// redacts reports whether any of keys matches a redact pattern. Patterns are
+// matched against the full key, before rename and extensions change the
+// file name.
+func (p *Processor) redacts(keys ...string) bool {
+	for _, key := range keys {
+		key = normalizeKey(key)
+		for _, pattern := range p.config.Redact {
+			if ok, _ := path.Match(pattern, key); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
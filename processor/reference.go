@@ -0,0 +1,78 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+const referencePrefix = "@consul:"
+
+// resolveReferences replaces values of the form "@consul:other/key" with the
+// value of the referenced key when resolve_references is set. References
+// may point to further references; cycles are an error. Pairs are copied so
+// the listed values are never modified.
+func (p *Processor) resolveReferences(keys api.KVPairs) (api.KVPairs, error) {
+	if !config.BoolVal(p.config.ResolveReferences) {
+		return keys, nil
+	}
+
+	known := make(map[string][]byte, len(keys))
+	for _, pair := range keys {
+		known[normalizeKey(pair.Key)] = pair.Value
+	}
+
+	resolved := make(api.KVPairs, 0, len(keys))
+	for _, pair := range keys {
+		if !bytes.HasPrefix(pair.Value, []byte(referencePrefix)) {
+			resolved = append(resolved, pair)
+			continue
+		}
+
+		value, err := p.resolveReference(pair.Key, pair.Value, known)
+		if err != nil {
+			return nil, err
+		}
+		cp := *pair
+		cp.Value = value
+		resolved = append(resolved, &cp)
+	}
+
+	return resolved, nil
+}
+
+func (p *Processor) resolveReference(key string, value []byte, known map[string][]byte) ([]byte, error) {
+	chain := []string{normalizeKey(key)}
+	for bytes.HasPrefix(value, []byte(referencePrefix)) {
+		ref := normalizeKey(strings.TrimSpace(string(value[len(referencePrefix):])))
+		for _, k := range chain {
+			if k == ref {
+				return nil, fmt.Errorf("processor: reference cycle %s -> %s", strings.Join(chain, " -> "), ref)
+			}
+		}
+		chain = append(chain, ref)
+
+		v, ok := known[ref]
+		if !ok {
+			pairs, _, err := p.lister.List(ref, nil)
+			if err != nil {
+				return nil, err
+			}
+			for _, pair := range pairs {
+				if normalizeKey(pair.Key) == ref {
+					v, ok = pair.Value, true
+				}
+			}
+			if !ok {
+				return nil, fmt.Errorf("processor: %s references missing key %s", key, ref)
+			}
+			known[ref] = v
+		}
+		value = v
+	}
+
+	return value, nil
+}
@@ -0,0 +1,78 @@
+package processor
+
+import (
+	"log"
+	"reflect"
+
+	"github.com/Assada/consul-generator/config"
+)
+
+// SameConnection reports whether a and b create the same Consul and Vault
+// clients, so a processor made for a can be reloaded with b.
+func SameConnection(a, b *config.Config) bool {
+	_, va := vaultPath(config.StringVal(a.From))
+	_, vb := vaultPath(config.StringVal(b.From))
+	if va != vb || !reflect.DeepEqual(a.Consul, b.Consul) {
+		return false
+	}
+	return !va || reflect.DeepEqual(a.Vault, b.Vault)
+}
+
+// Reload applies c to the passes to come, keeping the clients, the auth
+// login and, unless leader_key changed, the leader session. c must have the
+// same connection as the config p was made for, see SameConnection. On an
+// error p is left as it was. Everything else is set up anew, so the next
+// pass lists and compares all keys again.
+func (p *Processor) Reload(c *config.Config) error {
+	log.Printf("[INFO] (processor) reloading configuration")
+
+	n := &Processor{
+		client: p.client,
+		kv:     p.kv,
+		lister: p.lister,
+		error:  p.error,
+		done:   p.done,
+		once:   p.once,
+		dry:    p.dry,
+		out:    p.out,
+		ctx:    p.ctx,
+		cancel: p.cancel,
+	}
+	if err := n.configure(c); err != nil {
+		return err
+	}
+
+	if key := config.StringVal(c.LeaderKey); key != config.StringVal(p.config.LeaderKey) {
+		if p.leader != nil {
+			p.leader.stop()
+		}
+		p.leader = nil
+		if key != "" {
+			p.leader = newLeader(p.client, key)
+		}
+	}
+
+	p.config = n.config
+	p.vault = n.vault
+	p.single = n.single
+	p.mark = n.mark
+	p.flap = n.flap
+	p.quiet = n.quiet
+	p.mtimes = n.mtimes
+	p.renames = n.renames
+	p.paths = n.paths
+	p.explain = n.explain
+	p.filter = n.filter
+	p.syncs = n.syncs
+	p.notifier = n.notifier
+	p.cursor = ""
+	p.listed = false
+	p.pollWarned = false
+	p.index, p.listIndex = 0, 0
+
+	for _, m := range p.mappings() {
+		m.init()
+	}
+
+	return nil
+}
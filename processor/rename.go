@@ -0,0 +1,44 @@
+package processor
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Assada/consul-generator/config"
+)
+
+type renameRule struct {
+	match   *regexp.Regexp
+	replace string
+}
+
+// newRenames compiles the rename stanzas of c, in order.
+func newRenames(c *config.Config) ([]renameRule, error) {
+	if c.Renames == nil {
+		return nil, nil
+	}
+
+	rules := make([]renameRule, 0, len(*c.Renames))
+	for _, r := range *c.Renames {
+		match, err := regexp.Compile(config.StringVal(r.Match))
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, renameRule{match: match, replace: config.StringVal(r.Replace)})
+	}
+
+	return rules, nil
+}
+
+// rename applies the rename rules to the file name in order. A name that a
+// rule turns empty or into a path is dropped by returning "".
+func (p *Processor) rename(name string) string {
+	for _, r := range p.renames {
+		name = r.match.ReplaceAllString(name, r.replace)
+	}
+
+	if name == "." || name == ".." || strings.Contains(name, "/") {
+		return ""
+	}
+	return name
+}
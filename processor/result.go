@@ -0,0 +1,31 @@
+package processor
+
+// KeyResult is the decision the last pass made for a key: "write", "skip",
+// "defer" or "error", with the reason for it.
+type KeyResult struct {
+	Key      string
+	Path     string
+	Decision string
+	Reason   string
+}
+
+// Result lists the decisions of the last pass, in the order they were made.
+type Result struct {
+	Keys []KeyResult
+}
+
+// Drift returns how many files the last dry pass would have written or
+// removed.
+func (p *Processor) Drift() int {
+	n := p.drift
+	for _, s := range p.syncs {
+		n += s.drift
+	}
+	return n
+}
+
+// Result returns the decisions of the last pass. It must not be called
+// while a pass is running.
+func (p *Processor) Result() Result {
+	return Result{Keys: append([]KeyResult(nil), p.result...)}
+}
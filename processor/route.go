@@ -0,0 +1,173 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// validateFlagsRoute checks flags_route. Routing only applies to the files
+// written one per key, so the options that write anything else cannot be
+// used with it.
+func (p *Processor) validateFlagsRoute() error {
+	for flags, dir := range p.config.FlagsRoute {
+		if _, err := strconv.ParseUint(flags, 10, 64); err != nil {
+			return fmt.Errorf("processor: invalid flags_route flags %q: must be an unsigned integer", flags)
+		}
+		if dir == "" {
+			return fmt.Errorf("processor: flags_route for flags %s must name a directory", flags)
+		}
+	}
+
+	if hasSyncs(&p.config) || config.BoolVal(p.config.Single) || config.BoolVal(p.config.Push) ||
+		config.StringVal(p.config.Archive) != "" || config.BoolVal(p.config.SwapDir) || config.BoolVal(p.config.EnvFile) ||
+		config.BoolVal(p.config.DedupeIdentical) || config.BoolVal(p.config.Stdout) {
+		return fmt.Errorf("processor: flags_route cannot be combined with sync, single, push, archive, swap_dir, " +
+			"env_file, dedupe_identical or stdout")
+	}
+
+	return nil
+}
+
+// destination is the directory the file of pair is written to: the fixed
+// leading directory of to_template, the one flags_route maps its Flags to,
+// else to.
+func (p *Processor) destination(pair *api.KVPair) string {
+	if p.paths != nil {
+		return p.paths.root
+	}
+	if dir, ok := p.config.FlagsRoute[strconv.FormatUint(pair.Flags, 10)]; ok {
+		return dir
+	}
+	return *p.config.To
+}
+
+// pathTemplate renders the path of the file of a key for to_template from
+// the named groups key_pattern captures.
+type pathTemplate struct {
+	pattern *regexp.Regexp
+	tmpl    *template.Template
+	// to is the directory relative paths are rendered below, and root the
+	// directory before the first action of the template, which every
+	// rendered path must stay below.
+	to, root string
+}
+
+func newPathTemplate(c *config.Config) (*pathTemplate, error) {
+	pattern, text := config.StringVal(c.KeyPattern), config.StringVal(c.ToTemplate)
+	if pattern == "" && text == "" {
+		return nil, nil
+	}
+	if pattern == "" || text == "" {
+		return nil, fmt.Errorf("processor: key_pattern and to_template must be set together")
+	}
+
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("processor: invalid key_pattern: %s", err)
+	}
+
+	tmpl, err := template.New("to_template").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("processor: invalid to_template: %s", err)
+	}
+
+	// Every group set, the template only fails on a name the pattern
+	// does not capture.
+	groups := make(map[string]string)
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			groups[name] = name
+		}
+	}
+	if err := tmpl.Execute(ioutil.Discard, groups); err != nil {
+		return nil, fmt.Errorf("processor: invalid to_template: %s", err)
+	}
+
+	prefix := text
+	if i := strings.Index(text, "{{"); i >= 0 {
+		prefix = text[:i]
+	}
+	to := config.StringVal(c.To)
+	root := filepath.Dir(filepath.FromSlash(prefix + "x"))
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(to, root)
+	}
+
+	return &pathTemplate{pattern: re, tmpl: tmpl, to: to, root: root}, nil
+}
+
+// name returns the slash separated path of the file of key below root, or
+// "" when key does not match or the path is root itself.
+func (t *pathTemplate) name(key string) string {
+	m := t.pattern.FindStringSubmatch(normalizeKey(key))
+	if m == nil {
+		return ""
+	}
+
+	groups := make(map[string]string)
+	for i, name := range t.pattern.SubexpNames() {
+		if name != "" {
+			groups[name] = m[i]
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, groups); err != nil {
+		return ""
+	}
+
+	file := filepath.FromSlash(buf.String())
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(t.to, file)
+	}
+	rel, err := filepath.Rel(t.root, file)
+	if err != nil || rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+func (p *Processor) validateToTemplate() error {
+	if _, err := newPathTemplate(&p.config); err != nil {
+		return err
+	}
+
+	if hasSyncs(&p.config) || config.BoolVal(p.config.Single) || config.BoolVal(p.config.Push) ||
+		config.StringVal(p.config.Archive) != "" || config.BoolVal(p.config.SwapDir) || config.BoolVal(p.config.EnvFile) ||
+		config.BoolVal(p.config.DedupeIdentical) || config.BoolVal(p.config.Stdout) ||
+		config.BoolVal(p.config.PreserveStructure) || len(p.config.FlagsRoute) > 0 {
+		return fmt.Errorf("processor: to_template cannot be combined with sync, single, push, archive, swap_dir, " +
+			"env_file, dedupe_identical, stdout, preserve_structure or flags_route")
+	}
+
+	return nil
+}
+
+// filterPattern drops the keys key_pattern does not match, and those whose
+// rendered path is empty, when to_template is set.
+func (p *Processor) filterPattern(keys api.KVPairs) api.KVPairs {
+	if p.paths == nil {
+		return keys
+	}
+
+	filtered := make(api.KVPairs, 0, len(keys))
+	for _, pair := range keys {
+		if p.paths.name(pair.Key) == "" {
+			log.Printf("[DEBUG] (processor) %s does not match key_pattern", pair.Key)
+			continue
+		}
+		filtered = append(filtered, pair)
+	}
+
+	return filtered
+}
@@ -0,0 +1,95 @@
+package processor
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+const selfTestProbePrefix = "consul-generator-probe-"
+
+// SelfTest writes a probe key under self_test_prefix, runs a single pass into
+// a temporary directory and checks the probe file was written with the same
+// content. The pass uses the consul stanza of c and defaults otherwise. The
+// probe key is always removed again.
+func SelfTest(c *config.Config) (err error) {
+	prefix := config.StringVal(c.SelfTestPrefix)
+	if prefix == "" {
+		return fmt.Errorf("processor: self-test requires self_test_prefix to be set")
+	}
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	probe := hex.EncodeToString(nonce)
+
+	dir, err := ioutil.TempDir("", "consul-generator-self-test")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// Only the Consul connection is taken from c. Anything else could write
+	// outside of dir, run command or notify, or fail the probe check.
+	sc := config.DefaultConfig()
+	sc.Consul = c.Consul.Copy()
+	sc.From = config.String(prefix)
+	sc.To = config.String(dir)
+	sc.OnMissingPrefix = config.String(config.MissingPrefixError)
+	sc.Finalize()
+
+	errCh := make(chan error, 1)
+	p, err := NewProcessor(sc, false, false, errCh, make(chan bool, 1))
+	if err != nil {
+		return err
+	}
+	defer p.Stop()
+
+	key := normalizeKey(path.Join(prefix, selfTestProbePrefix+probe))
+	if _, err := p.kv.Put(&api.KVPair{Key: key, Value: []byte(probe)}, nil); err != nil {
+		return fmt.Errorf("processor: self-test could not write probe key %s: %s", key, err)
+	}
+	log.Printf("[INFO] (processor) self-test wrote probe key %s", key)
+
+	defer func() {
+		if _, derr := p.kv.Delete(key, nil); derr != nil {
+			log.Printf("[ERR] (processor) self-test could not remove probe key %s: %s", key, derr)
+			if err == nil {
+				err = fmt.Errorf("processor: self-test could not remove probe key %s: %s", key, derr)
+			}
+			return
+		}
+		log.Printf("[INFO] (processor) self-test removed probe key %s", key)
+	}()
+
+	if code := p.Process(); code != ExitCodeOK {
+		select {
+		case err := <-errCh:
+			return fmt.Errorf("processor: self-test pass failed: %s", err)
+		default:
+			return fmt.Errorf("processor: self-test pass failed with exit code %d", code)
+		}
+	}
+
+	file := filepath.Join(dir, p.fileName(key))
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("processor: self-test probe file missing: %s", err)
+	}
+	if string(content) != probe {
+		return fmt.Errorf("processor: self-test probe file %s has content %q, expected %q", file, content, probe)
+	}
+
+	log.Printf("[INFO] (processor) self-test passed")
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// validateSingle checks single. from names one key, read with Get, and to
+// the file its value is written to as it is, so the options that work on a
+// tree of files cannot be used with it.
+func (p *Processor) validateSingle() error {
+	if strings.HasSuffix(normalizeKey(config.StringVal(p.config.From)), "/") || normalizeKey(config.StringVal(p.config.From)) == "" {
+		return fmt.Errorf("processor: single requires from to name a key, not a folder")
+	}
+
+	if name := filepath.Base(config.StringVal(p.config.To)); name == "." || name == string(filepath.Separator) {
+		return fmt.Errorf("processor: single requires to to name a file")
+	}
+
+	if p.vault || hasSyncs(&p.config) || config.BoolVal(p.config.Push) || config.StringVal(p.config.Archive) != "" ||
+		config.BoolVal(p.config.SwapDir) || config.BoolVal(p.config.EnvFile) || config.BoolVal(p.config.DedupeIdentical) ||
+		config.BoolVal(p.config.Prune) || config.BoolVal(p.config.PreserveStructure) {
+		return fmt.Errorf("processor: single cannot be combined with sync, push, archive, swap_dir, env_file, " +
+			"dedupe_identical, prune, preserve_structure or a vault:// from")
+	}
+
+	return nil
+}
+
+// splitSingle makes the directory of to the destination of p and keeps the
+// file name for fileName.
+func (p *Processor) splitSingle() {
+	to := config.StringVal(p.config.To)
+	p.single = filepath.Base(to)
+	p.config.To = config.String(filepath.Dir(to))
+}
+
+// passLister returns the lister of the pass, which gets just the key from
+// when single is set and only lists the key names with low_memory, with the
+// retries of the configured lister.
+func (p *Processor) passLister() lister {
+	var l lister
+	switch {
+	case p.single != "":
+		l = keyLister{kv: p.kv}
+	case config.BoolVal(p.config.LowMemory):
+		l = namesLister{kv: p.kv}
+	default:
+		return p.lister
+	}
+
+	if r, ok := p.lister.(*retryLister); ok {
+		cp := *r
+		cp.lister = l
+		return &cp
+	}
+	return l
+}
+
+// keyLister lists exactly the key it is given with Get, rather than every
+// key below it.
+type keyLister struct {
+	kv kvWriter
+}
+
+func (l keyLister) List(key string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	pair, meta, err := l.kv.Get(key, q)
+	if err != nil || pair == nil {
+		return nil, meta, err
+	}
+	return api.KVPairs{pair}, meta, nil
+}
@@ -0,0 +1,35 @@
+package processor
+
+import (
+	"fmt"
+	"time"
+)
+
+// Stats are counters kept over the lifetime of a processor.
+type Stats struct {
+	Keys      uint64
+	Written   uint64
+	Skipped   uint64
+	LastError time.Time
+}
+
+// Stats returns the counters of all passes so far. It must not be called
+// while a pass is running.
+func (p *Processor) Stats() Stats {
+	return p.syncStats(p.stats)
+}
+
+// formatBytes formats n as a short human readable size, e.g. 12.4KB.
+func formatBytes(n int) string {
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	units := []string{"KB", "MB", "GB"}
+	size, i := float64(n)/1024, 0
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%s", size, units[i])
+}
@@ -0,0 +1,48 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+func (p *Processor) validateStdout() error {
+	if config.BoolVal(p.config.Push) || config.StringVal(p.config.Archive) != "" || config.BoolVal(p.config.SwapDir) ||
+		config.BoolVal(p.config.EnvFile) || config.BoolVal(p.config.DedupeIdentical) || config.BoolVal(p.config.Prune) ||
+		config.BoolVal(p.config.PreserveMtime) || config.StringVal(p.config.VersionFile) != "" ||
+		config.StringVal(p.config.Manifest) != "" || config.BoolVal(p.config.Interactive) {
+		return fmt.Errorf("processor: stdout cannot be combined with push, archive, swap_dir, env_file, " +
+			"dedupe_identical, prune, preserve_mtime, version_file, manifest or interactive")
+	}
+
+	return nil
+}
+
+// writeStdout writes the value of every key to the out stream, each after
+// a separator line naming the key. Folder markers have no value and are
+// left out.
+func (p *Processor) writeStdout(keys api.KVPairs) error {
+	separator := config.StringVal(p.config.Separator)
+
+	var buf bytes.Buffer
+	for _, pair := range keys {
+		if keyFileName(pair.Key) == "" {
+			continue
+		}
+
+		if separator != "" {
+			buf.WriteString(strings.Replace(separator, "{key}", pair.Key, -1))
+			buf.WriteByte('\n')
+		}
+		buf.Write(pair.Value)
+		if len(pair.Value) > 0 && pair.Value[len(pair.Value)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+
+	_, err := p.out.Write(buf.Bytes())
+	return err
+}
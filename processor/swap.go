@@ -0,0 +1,201 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+func (p *Processor) swapTree(keys api.KVPairs) error {
+	to, err := filepath.Abs(*p.config.To)
+	if err != nil {
+		return err
+	}
+
+	files := p.keyFiles(keys)
+
+	current, err := readTree(to)
+	if err != nil {
+		return err
+	}
+	for _, name := range p.ownedFiles(to) {
+		delete(current, name)
+	}
+
+	if p.treeHash(current) == p.treeHash(files) {
+		log.Printf("[INFO] (processor) Skipping swap, tree unchanged: %s", to)
+		return nil
+	}
+
+	if p.dry {
+		for _, pair := range keys {
+			if name := p.fileName(pair.Key); name != "" {
+				p.save(filepath.Join(to, filepath.FromSlash(name)), string(pair.Value), pair.Key)
+			}
+		}
+		log.Printf("[INFO] (processor) Tree %s will be swapped", to)
+		return nil
+	}
+
+	// A plain directory left by a run without swap_dir is moved aside once
+	// the new tree is ready, so switching modes needs no manual step.
+	var backup string
+	if stat, err := os.Lstat(to); err == nil && stat.Mode()&os.ModeSymlink == 0 {
+		if !stat.IsDir() {
+			return fmt.Errorf("processor: swap_dir requires %q to be a directory, a symlink or absent", to)
+		}
+		backup = to + ".orig"
+		if _, err := os.Lstat(backup); err == nil {
+			return fmt.Errorf("processor: cannot move %q aside for swap_dir, %q already exists", to, backup)
+		}
+	}
+
+	parent := filepath.Dir(to)
+	if err := os.MkdirAll(parent, os.ModePerm); err != nil {
+		return err
+	}
+
+	prefix := "." + filepath.Base(to) + "-"
+	dir, err := ioutil.TempDir(parent, prefix)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, 0755); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	for name, content := range files {
+		if err := p.save(filepath.Join(dir, filepath.FromSlash(name)), string(content)); err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+		p.countWritten()
+	}
+
+	old, _ := os.Readlink(to)
+
+	link := to + ".swap"
+	os.Remove(link)
+	if err := os.Symlink(dir, link); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	if backup != "" {
+		if err := os.Rename(to, backup); err != nil {
+			os.Remove(link)
+			os.RemoveAll(dir)
+			return err
+		}
+		log.Printf("[WARN] (processor) moved existing directory %s to %s for swap_dir", to, backup)
+	}
+	if err := os.Rename(link, to); err != nil {
+		if backup != "" {
+			os.Rename(backup, to)
+		}
+		os.Remove(link)
+		os.RemoveAll(dir)
+		return err
+	}
+
+	log.Printf("[INFO] (processor) Swapped: %s -> %s", to, dir)
+
+	if old != "" {
+		if !filepath.IsAbs(old) {
+			old = filepath.Join(parent, old)
+		}
+		if strings.HasPrefix(filepath.Base(old), prefix) {
+			if err := os.RemoveAll(old); err != nil {
+				log.Printf("[WARN] (processor) could not remove previous tree %s: %s", old, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ownedFiles lists the names of files the generator itself keeps in dir,
+// which are not part of the synced tree.
+func (p *Processor) ownedFiles(dir string) []string {
+	paths := []string{config.StringVal(p.config.VersionFile), config.StringVal(p.config.Manifest)}
+	if p.mark != nil {
+		paths = append(paths, p.mark.path)
+	}
+
+	var names []string
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(path); err == nil && filepath.Dir(abs) == dir {
+			names = append(names, filepath.Base(abs))
+		}
+	}
+	return names
+}
+
+func (p *Processor) treeHash(files map[string][]byte) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hasher := sha256.New()
+	for _, name := range names {
+		hasher.Write([]byte(name))
+		hasher.Write([]byte{0})
+		hasher.Write([]byte(p.getHash(files[name])))
+		hasher.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// readTree reads the regular files below dir, keyed by their slash
+// separated path relative to dir.
+func readTree(dir string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+
+	// to is a symlink once swapped, which Walk does not follow.
+	root, err := filepath.EvalSymlinks(dir)
+	if os.IsNotExist(err) {
+		return files, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = content
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
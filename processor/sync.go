@@ -0,0 +1,178 @@
+package processor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Assada/consul-generator/config"
+)
+
+// hasSyncs reports whether c maps several prefixes with sync stanzas, in
+// which case the top level from and to are not used.
+func hasSyncs(c *config.Config) bool {
+	return c.Syncs != nil && len(*c.Syncs) > 0
+}
+
+// validateSyncs checks the sync stanzas. Each mapping runs as its own pass
+// one after another, so options that block a pass or name a single path
+// shared by all mappings cannot be used with them.
+func (p *Processor) validateSyncs() error {
+	if err := p.config.Syncs.Validate(); err != nil {
+		return fmt.Errorf("processor: %s", err)
+	}
+
+	for _, s := range *p.config.Syncs {
+		if _, ok := vaultPath(config.StringVal(s.From)); ok {
+			return fmt.Errorf("processor: sync from %q cannot be a vault:// path", config.StringVal(s.From))
+		}
+	}
+
+	if config.BoolVal(p.config.Watch) || config.StringVal(p.config.Archive) != "" ||
+		config.StringVal(p.config.WatermarkFile) != "" || config.StringVal(p.config.EnvFilePath) != "" ||
+		config.StringVal(p.config.VersionFile) != "" || config.StringVal(p.config.Manifest) != "" || p.vault {
+		return fmt.Errorf("processor: sync cannot be combined with watch, archive, watermark_file, env_file_path, " +
+			"version_file, manifest or a vault:// from")
+	}
+
+	return nil
+}
+
+// newSyncs returns a processor per sync stanza. They share the clients,
+// filter and channels of p but keep their own watermark, flap and wait
+// state, and leave leadership and done to p.
+func (p *Processor) newSyncs() []*Processor {
+	syncs := make([]*Processor, 0, len(*p.config.Syncs))
+	for _, s := range *p.config.Syncs {
+		c := p.config
+		c.From = s.From
+		c.To = s.To
+		if s.Perms != nil {
+			c.Perms = s.Perms
+		}
+		if config.TimeDurationVal(s.Interval) > 0 {
+			c.Interval = s.Interval
+		}
+		c.Syncs = config.DefaultSyncConfigs()
+
+		syncs = append(syncs, &Processor{
+			config:  c,
+			client:  p.client,
+			kv:      p.kv,
+			lister:  p.lister,
+			filter:  p.filter,
+			renames: p.renames,
+			mark:    newWatermark(&c),
+			flap:    newFlapDetector(&c),
+			quiet:   newQuiescence(&c, p.once, p.dry),
+			mtimes:  newMtimes(&c),
+			explain: p.explain,
+			out:     p.out,
+			error:   p.error,
+			once:    p.once,
+			dry:     p.dry,
+			ctx:     p.ctx,
+			cancel:  p.cancel,
+		})
+	}
+
+	return syncs
+}
+
+// mappings returns the processors doing the passes of p.
+func (p *Processor) mappings() []*Processor {
+	if len(p.syncs) > 0 {
+		return p.syncs
+	}
+	return []*Processor{p}
+}
+
+// scheduled reports whether a sync stanza sets its own interval. Every
+// mapping then runs once its interval, else the top level one, passed since
+// its last pass, rather than on every pass.
+func (p *Processor) scheduled() bool {
+	if p.once || p.dry || !hasSyncs(&p.config) {
+		return false
+	}
+	for _, s := range *p.config.Syncs {
+		if config.TimeDurationVal(s.Interval) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns how long until the next sync stanza is due, and false when
+// the passes simply follow interval.
+func (p *Processor) Next() (time.Duration, bool) {
+	if !p.scheduled() {
+		return 0, false
+	}
+
+	var next time.Duration
+	now := time.Now()
+	for i, s := range p.syncs {
+		d := s.due.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+		if i == 0 || d < next {
+			next = d
+		}
+	}
+	return next, true
+}
+
+// processSyncs runs a pass for every sync stanza that is due. A failing
+// mapping does not stop the others; the pass fails if any of them failed and
+// is empty only if all of them were.
+func (p *Processor) processSyncs() int {
+	scheduled := p.scheduled()
+	now := time.Now()
+
+	code, ran := ExitCodeEmpty, false
+	for _, s := range p.syncs {
+		if scheduled {
+			if now.Before(s.due) {
+				continue
+			}
+			s.due = now.Add(config.TimeDurationVal(s.config.Interval))
+		}
+		ran = true
+
+		switch s.process() {
+		case ExitCodeError:
+			code = ExitCodeError
+		case ExitCodeOK:
+			if code == ExitCodeEmpty {
+				code = ExitCodeOK
+			}
+		}
+		p.result = append(p.result, s.result...)
+		if d := s.settle; d > 0 && (p.settle == 0 || d < p.settle) {
+			p.settle = d
+		}
+	}
+
+	if code == ExitCodeError {
+		return code
+	}
+	if !ran {
+		return ExitCodeOK
+	}
+	p.finish()
+
+	return code
+}
+
+// syncStats adds the counters of the sync processors to s.
+func (p *Processor) syncStats(s Stats) Stats {
+	for _, m := range p.syncs {
+		s.Keys += m.stats.Keys
+		s.Written += m.stats.Written
+		s.Skipped += m.stats.Skipped
+		if m.stats.LastError.After(s.LastError) {
+			s.LastError = m.stats.LastError
+		}
+	}
+	return s
+}
@@ -0,0 +1,79 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// render runs the value of every key through text/template when template is
+// set, so the rendered output is what is compared and written. Templates can
+// call env for a variable of the env stanza and key for the value of another
+// listed key. template_left_delim and template_right_delim replace {{ and }}
+// when set. A template that does not parse or execute fails the pass.
+// Pairs are copied so the listed values are never modified.
+func (p *Processor) render(keys api.KVPairs) (api.KVPairs, error) {
+	if !config.BoolVal(p.config.Template) {
+		return keys, nil
+	}
+
+	env := p.config.Env
+	if env == nil {
+		env = config.DefaultEnvConfig()
+	}
+	vars := make(map[string]string)
+	for _, v := range env.Env() {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) == 2 {
+			vars[kv[0]] = kv[1]
+		}
+	}
+
+	values := make(map[string]string, len(keys))
+	for _, pair := range keys {
+		values[normalizeKey(pair.Key)] = string(pair.Value)
+	}
+
+	funcs := template.FuncMap{
+		"env": func(name string) string {
+			return vars[name]
+		},
+		"key": func(name string) (string, error) {
+			value, ok := values[normalizeKey(name)]
+			if !ok {
+				return "", fmt.Errorf("no key %s", normalizeKey(name))
+			}
+			return value, nil
+		},
+	}
+
+	left, right := config.StringVal(p.config.TemplateLeftDelim), config.StringVal(p.config.TemplateRightDelim)
+
+	rendered := make(api.KVPairs, 0, len(keys))
+	for _, pair := range keys {
+		if p.fileName(pair.Key) == "" {
+			rendered = append(rendered, pair)
+			continue
+		}
+
+		tmpl, err := template.New(pair.Key).Delims(left, right).Funcs(funcs).Parse(string(pair.Value))
+		if err != nil {
+			return nil, fmt.Errorf("processor: template %s: %s", pair.Key, err)
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, nil); err != nil {
+			return nil, fmt.Errorf("processor: template %s: %s", pair.Key, err)
+		}
+
+		cp := *pair
+		cp.Value = buf.Bytes()
+		rendered = append(rendered, &cp)
+	}
+
+	return rendered, nil
+}
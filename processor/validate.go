@@ -0,0 +1,80 @@
+package processor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Assada/consul-generator/config"
+)
+
+// Validate checks c the way NewProcessor does and that the destination can
+// be written, without creating any Consul or Vault client.
+func Validate(c *config.Config) error {
+	p := &Processor{config: *c}
+	if path, ok := vaultPath(config.StringVal(c.From)); ok {
+		p.config.From = &path
+		p.vault = true
+	}
+
+	if err := p.validate(); err != nil {
+		return err
+	}
+
+	if c.Filter != nil && *c.Filter != "" {
+		if _, err := newFilter(*c.Filter); err != nil {
+			return err
+		}
+	}
+
+	// Push only reads the destination.
+	if config.BoolVal(c.Push) {
+		return nil
+	}
+
+	if archive := config.StringVal(c.Archive); archive != "" {
+		return checkWritable(filepath.Dir(archive))
+	}
+
+	if hasSyncs(c) {
+		p.syncs = p.newSyncs()
+	}
+	if config.BoolVal(c.Single) {
+		p.splitSingle()
+	}
+	for _, m := range p.mappings() {
+		if err := checkWritable(config.StringVal(m.config.To)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkWritable checks that files can be created in dir, or in its closest
+// existing parent when dir is still to be created.
+func checkWritable(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if os.IsNotExist(err) && filepath.Dir(dir) != dir {
+			dir = filepath.Dir(dir)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("processor: %s", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("processor: %s is not a directory", dir)
+		}
+		break
+	}
+
+	f, err := ioutil.TempFile(dir, ".consul-generator-validate")
+	if err != nil {
+		return fmt.Errorf("processor: %s is not writable: %s", dir, err)
+	}
+	f.Close()
+
+	return os.Remove(f.Name())
+}
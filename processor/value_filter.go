@@ -0,0 +1,78 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// applyValueFilters pipes the value of every key whose file name matches a
+// value_filter stanza through its command and keeps the command's output.
+// Keys whose command fails or times out are logged and dropped from the
+// pass, leaving their files untouched. Pairs are copied so the listed
+// values are never modified.
+func (p *Processor) applyValueFilters(keys api.KVPairs) api.KVPairs {
+	if p.config.ValueFilters == nil || len(*p.config.ValueFilters) == 0 {
+		return keys
+	}
+
+	filtered := make(api.KVPairs, 0, len(keys))
+	for _, pair := range keys {
+		name := p.fileName(pair.Key)
+		if name == "" {
+			filtered = append(filtered, pair)
+			continue
+		}
+
+		f, ok := p.config.ValueFilters.Match(path.Base(name))
+		if !ok {
+			filtered = append(filtered, pair)
+			continue
+		}
+
+		out, err := runValueFilter(f, pair.Value)
+		if err != nil {
+			log.Printf("[ERR] (processor) value_filter failed for %s, skipping: %s", pair.Key, err)
+			p.explainKey(explanation{Key: pair.Key, Decision: "skip", Reason: "value_filter failed"})
+			continue
+		}
+
+		cp := *pair
+		cp.Value = out
+		filtered = append(filtered, &cp)
+	}
+
+	return filtered
+}
+
+// runValueFilter runs the command of f with value on stdin and returns its
+// stdout.
+func runValueFilter(f *config.ValueFilterConfig, value []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.TimeDurationVal(f.Timeout))
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, f.Command[0], f.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(value)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%s timed out after %s", f.Command[0], config.TimeDurationVal(f.Timeout))
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s: %s", f.Command[0], err, msg)
+		}
+		return nil, fmt.Errorf("%s: %s", f.Command[0], err)
+	}
+
+	return stdout.Bytes(), nil
+}
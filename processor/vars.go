@@ -0,0 +1,21 @@
+package processor
+
+import (
+	"expvar"
+	"time"
+)
+
+// Counters over all processors of the process, published with expvar at
+// /debug/vars. expvar.Int is safe for concurrent use.
+var (
+	passesVar   = expvar.NewInt("consul_generator_cycles_total")
+	writtenVar  = expvar.NewInt("consul_generator_keys_written_total")
+	errorsVar   = expvar.NewInt("consul_generator_errors_total")
+	lastPassVar = expvar.NewInt("consul_generator_last_cycle_unixtime")
+)
+
+// countPass records the end of a pass.
+func countPass() {
+	passesVar.Add(1)
+	lastPassVar.Set(time.Now().Unix())
+}
@@ -0,0 +1,122 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/Assada/consul-generator/client"
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+const vaultPrefix = "vault://"
+
+// vaultPath returns the Vault path of a vault:// from.
+func vaultPath(from string) (string, bool) {
+	if !strings.HasPrefix(from, vaultPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(from, vaultPrefix), true
+}
+
+// vaultLister lists the secrets below a Vault KV path as KV pairs, so a
+// vault:// from is processed like a Consul prefix. With KV version 2 the
+// first path segment is the mount of the secrets engine.
+type vaultLister struct {
+	client    *client.VaultClient
+	kvVersion int
+}
+
+var _ lister = (*vaultLister)(nil)
+
+func newVaultLister(vault *client.VaultClient, c *config.Config) *vaultLister {
+	return &vaultLister{
+		client:    vault,
+		kvVersion: config.IntVal(c.Vault.KVVersion),
+	}
+}
+
+func (l *vaultLister) List(prefix string, _ *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	var pairs api.KVPairs
+	if err := l.walk(strings.TrimSuffix(normalizeKey(prefix), "/"), &pairs); err != nil {
+		return nil, nil, err
+	}
+	return pairs, &api.QueryMeta{}, nil
+}
+
+func (l *vaultLister) walk(dir string, pairs *api.KVPairs) error {
+	keys, err := l.client.List(l.path("metadata", dir))
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		key := dir + "/" + k
+		if strings.HasSuffix(k, "/") {
+			if err := l.walk(strings.TrimSuffix(key, "/"), pairs); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, found, err := l.read(key)
+		if err != nil {
+			return err
+		}
+		// The secret was deleted after the listing.
+		if !found {
+			continue
+		}
+		*pairs = append(*pairs, &api.KVPair{Key: key, Value: value})
+	}
+
+	return nil
+}
+
+// read returns the value of the secret at key. A secret holding nothing but
+// a string value field is written as that string, any other secret as its
+// JSON encoded data.
+func (l *vaultLister) read(key string) ([]byte, bool, error) {
+	var data map[string]json.RawMessage
+	var found bool
+	var err error
+	if l.kvVersion == 2 {
+		var secret struct {
+			Data map[string]json.RawMessage `json:"data"`
+		}
+		found, err = l.client.Read(l.path("data", key), &secret)
+		data = secret.Data
+	} else {
+		found, err = l.client.Read(key, &data)
+	}
+	if err != nil || !found || data == nil {
+		return nil, false, err
+	}
+
+	if raw, ok := data["value"]; ok && len(data) == 1 {
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			return []byte(s), true, nil
+		}
+	}
+
+	value, err := json.Marshal(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("processor: vault secret %s: %s", key, err)
+	}
+	return value, true, nil
+}
+
+// path returns the KV version 2 API path of kind for key.
+func (l *vaultLister) path(kind, key string) string {
+	if l.kvVersion != 2 {
+		return key
+	}
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) == 1 {
+		return path.Join(parts[0], kind)
+	}
+	return path.Join(parts[0], kind, parts[1])
+}
@@ -0,0 +1,41 @@
+package processor
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// writeVersion stores a hash of everything synced in this pass at
+// version_file, giving consumers a single cache-busting token. The file is
+// only rewritten when the hash changes.
+func (p *Processor) writeVersion(keys api.KVPairs) error {
+	path := config.StringVal(p.config.VersionFile)
+	if path == "" {
+		return nil
+	}
+
+	version := p.treeHash(p.keyFiles(keys))
+
+	current, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if strings.TrimSpace(string(current)) == version {
+		log.Printf("[DEBUG] (processor) Version unchanged: %s", version)
+		return nil
+	}
+
+	if !p.dry {
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	return p.save(path, version+"\n")
+}
@@ -0,0 +1,173 @@
+package processor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Assada/consul-generator/config"
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	watermarkFileName  = ".consul-generator.watermark"
+	watermarkReconcile = 10 * time.Minute
+)
+
+// watermark remembers the ModifyIndex of every key whose file was last
+// written or found up to date, so an unchanged key is skipped without
+// reading and hashing its file. The indices are kept in a state file so
+// they survive restarts.
+type watermark struct {
+	sync.Mutex
+	path      string
+	indices   map[string]uint64
+	loaded    bool
+	reconcile time.Time
+}
+
+func newWatermark(c *config.Config) *watermark {
+	if !config.BoolVal(c.Watermark) {
+		return nil
+	}
+
+	path := config.StringVal(c.WatermarkFile)
+	if path == "" {
+		path = filepath.Join(config.StringVal(c.To), watermarkFileName)
+	}
+
+	return &watermark{path: path, indices: map[string]uint64{}}
+}
+
+func (w *watermark) load() {
+	if w == nil || w.loaded {
+		return
+	}
+	w.loaded = true
+	w.reconcile = time.Now()
+
+	content, err := ioutil.ReadFile(w.path)
+	if os.IsNotExist(err) {
+		log.Printf("[DEBUG] (processor) no watermark at %s, running a full scan", w.path)
+		return
+	}
+	if err != nil {
+		log.Printf("[WARN] (processor) could not read watermark %s, running a full scan: %s", w.path, err)
+		return
+	}
+
+	indices := make(map[string]uint64)
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		index, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil || len(parts) != 2 {
+			log.Printf("[WARN] (processor) corrupt watermark %s, running a full scan: %q", w.path, line)
+			return
+		}
+		indices[parts[1]] = index
+	}
+
+	log.Printf("[INFO] (processor) loaded watermark of %d keys from %s", len(indices), w.path)
+	w.indices = indices
+}
+
+// full reports whether this pass has to hash every key. A full reconcile is
+// forced periodically so local changes to skipped files are eventually fixed.
+func (w *watermark) full() bool {
+	if w == nil || len(w.indices) == 0 {
+		return true
+	}
+
+	if time.Since(w.reconcile) >= watermarkReconcile {
+		log.Printf("[DEBUG] (processor) watermark reconcile due, running a full scan")
+		w.reconcile = time.Now()
+		return true
+	}
+
+	return false
+}
+
+// skip reports whether the file of pair was written or found up to date at
+// the same ModifyIndex and still exists.
+func (w *watermark) skip(pair *api.KVPair, file string) bool {
+	if w == nil {
+		return false
+	}
+
+	w.Lock()
+	index, ok := w.indices[pair.Key]
+	w.Unlock()
+	if !ok || index != pair.ModifyIndex {
+		return false
+	}
+
+	stat, err := os.Stat(file)
+	return err == nil && stat.Mode().IsRegular()
+}
+
+// record notes that the file of pair is up to date.
+func (w *watermark) record(pair *api.KVPair) {
+	if w == nil {
+		return
+	}
+
+	w.Lock()
+	w.indices[pair.Key] = pair.ModifyIndex
+	w.Unlock()
+}
+
+// store forgets keys that are no longer listed and writes the indices to
+// the state file.
+func (w *watermark) store(keys api.KVPairs, dry bool) error {
+	if w == nil {
+		return nil
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	listed := make(map[string]bool, len(keys))
+	for _, pair := range keys {
+		listed[pair.Key] = true
+	}
+	for key := range w.indices {
+		if !listed[key] {
+			delete(w.indices, key)
+		}
+	}
+
+	if dry {
+		return nil
+	}
+
+	names := make([]string, 0, len(w.indices))
+	for key := range w.indices {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, key := range names {
+		fmt.Fprintf(&b, "%d %s\n", w.indices[key], key)
+	}
+
+	if current, err := ioutil.ReadFile(w.path); err == nil && string(current) == b.String() {
+		return nil
+	}
+
+	tmp := w.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.path)
+}
@@ -0,0 +1,41 @@
+package processor
+
+import "sync"
+
+// workers runs functions on at most n goroutines at a time. With n of 1
+// they run synchronously on the calling goroutine.
+type workers struct {
+	n   int
+	sem chan struct{}
+	wg  sync.WaitGroup
+}
+
+func newWorkers(n int) *workers {
+	if n < 1 {
+		n = 1
+	}
+	return &workers{n: n, sem: make(chan struct{}, n)}
+}
+
+// run blocks until a worker is free and runs f on it.
+func (w *workers) run(f func()) {
+	if w.n == 1 {
+		f()
+		return
+	}
+
+	w.sem <- struct{}{}
+	w.wg.Add(1)
+	go func() {
+		defer func() {
+			<-w.sem
+			w.wg.Done()
+		}()
+		f()
+	}()
+}
+
+// wait blocks until every function passed to run has returned.
+func (w *workers) wait() {
+	w.wg.Wait()
+}